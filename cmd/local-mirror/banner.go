@@ -9,8 +9,10 @@ import (
 	"local-mirror/pkg/termstyle"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // directionLabel/transportLabel/peerLabel 供 status 与人读展示：把内部 mode +
@@ -23,11 +25,16 @@ func directionLabel() string {
 		return "receive · sink"
 	case "relay":
 		return "relay"
+	case "bidirectional":
+		return "two-way"
 	}
 	return *config.Mode
 }
 
 func transportLabel() string {
+	if config.TwoWay() {
+		return "listen + dial"
+	}
 	if config.TransportListens() {
 		return "listen"
 	}
@@ -35,12 +42,22 @@ func transportLabel() string {
 }
 
 func peerLabel() string {
-	if config.TransportListens() {
+	if config.TransportListens() && !config.TwoWay() {
 		return "inbound"
 	}
 	if config.DiscoveredAddr != "" {
 		return config.DiscoveredAddr
 	}
+	if len(config.Upstreams) > 0 {
+		peers := make([]string, len(config.Upstreams))
+		for i, u := range config.Upstreams {
+			peers[i] = fmt.Sprintf("%s → ./%s", u.Addr, filepath.ToSlash(u.Dir))
+		}
+		return strings.Join(peers, ", ")
+	}
+	if len(config.DialTargets) > 1 {
+		return strings.Join(config.DialTargets, ", ")
+	}
 	host, port := network.SplitPeer(*config.RealityIP)
 	if host == "" {
 		return "(LAN discovery)"
@@ -120,7 +137,7 @@ func printBanner() {
 	}
 
 	// 方向优先：横幅用 send/receive 说话，-m 老词汇只是别名
-	modeDescMap := map[string]string{"reality": "send · source", "mirror": "receive · sink", "relay": "relay"}
+	modeDescMap := map[string]string{"reality": "send · source", "mirror": "receive · sink", "relay": "relay", "bidirectional": "two-way"}
 	modeDesc := modeDescMap[*config.Mode]
 
 	// 字标横幅：单行 "LOCAL-MIRROR"，实与虚用亮度表达——LOCAL 亮青、
@@ -142,17 +159,33 @@ func printBanner() {
 	fmt.Println(line)
 	row("Sync root", config.StartPath)
 	// 忽略规则最多展示 4 条，其余折叠为计数（完整列表见 --help 与配置）
-	ignoreShown := config.IgnoreFileList
+	ignoreShown := config.Ignores()
 	suffix := ""
 	if len(ignoreShown) > 4 {
 		suffix = fmt.Sprintf(" %s(+%d)%s", p.Dim, len(ignoreShown)-4, p.Reset)
 		ignoreShown = ignoreShown[:4]
 	}
+	if *config.Gitignore {
+		suffix += fmt.Sprintf(" %s+ .gitignore files%s", p.Dim, p.Reset)
+	}
 	row("Ignores", strings.Join(ignoreShown, ", ")+suffix)
+	if len(config.IncludeList) > 0 {
+		row("Includes", strings.Join(config.IncludeList, ", ")+fmt.Sprintf(" %s(everything else is left alone)%s", p.Dim, p.Reset))
+	}
 	if config.SyncsFromUpstream() && !config.SinkListens {
+		// 双向同步的对端既是上游也是下游
+		peerRow := "Upstream"
+		if config.TwoWay() {
+			peerRow = "Peer"
+		}
 		switch {
+		case len(config.Upstreams) > 0:
+			for _, u := range config.Upstreams {
+				row("Upstream", fmt.Sprintf("%s%s%s %s(into ./%s)%s",
+					p.Green, u.Addr, p.Reset, p.Dim, filepath.ToSlash(u.Dir), p.Reset))
+			}
 		case config.DiscoveredAddr != "":
-			row("Upstream", fmt.Sprintf("%s%s%s %s(discovered: %s)%s",
+			row(peerRow, fmt.Sprintf("%s%s%s %s(discovered: %s)%s",
 				p.Green, config.DiscoveredAddr, p.Reset, p.Dim, config.DiscoveredAlias, p.Reset))
 		default:
 			host, port := network.SplitPeer(*config.RealityIP)
@@ -160,10 +193,10 @@ func printBanner() {
 				host = "127.0.0.1"
 			}
 			if port != 0 {
-				row("Upstream", fmt.Sprintf("%s%s%s %s(pinned port)%s",
+				row(peerRow, fmt.Sprintf("%s%s%s %s(pinned port)%s",
 					p.Green, net.JoinHostPort(host, strconv.Itoa(port)), p.Reset, p.Dim, p.Reset))
 			} else {
-				row("Upstream", fmt.Sprintf("%s%s%s %s(port scan %d-%d)%s",
+				row(peerRow, fmt.Sprintf("%s%s%s %s(port scan %d-%d)%s",
 					p.Green, host, p.Reset, p.Dim, config.DefaultPort, config.DefaultPort+config.PortScanRange-1, p.Reset))
 			}
 		}
@@ -172,14 +205,16 @@ func printBanner() {
 	if config.SinkListens {
 		row("Source", fmt.Sprintf("inbound %s(waiting for the source to dial us)%s", p.Dim, p.Reset))
 	}
-	// 源拨出格：对端是监听中的汇
+	// 源拨出格：对端是监听中的汇（扇出推送时每个汇一行）
 	if config.SourceDials {
-		host, port := network.SplitPeer(*config.RealityIP)
-		if port == 0 {
-			port = config.DefaultPort
+		for _, target := range config.DialTargets {
+			host, port := network.SplitPeer(target)
+			if port == 0 {
+				port = config.DefaultPort
+			}
+			row("Sink", fmt.Sprintf("%s%s%s %s(dialing out; the sink listens)%s",
+				p.Green, net.JoinHostPort(host, strconv.Itoa(port)), p.Reset, p.Dim, p.Reset))
 		}
-		row("Sink", fmt.Sprintf("%s%s%s %s(dialing out; the sink listens)%s",
-			p.Green, net.JoinHostPort(host, strconv.Itoa(port)), p.Reset, p.Dim, p.Reset))
 	}
 	// 监听行属于任何监听的一方：经典源、relay 下游、以及汇监听格
 	if config.TransportListens() {
@@ -190,27 +225,63 @@ func printBanner() {
 		}
 	}
 	switch {
+	case config.TLSTransport():
+		row("Encryption", fmt.Sprintf("%son%s (TLS 1.3, cert fp %s) %speers trusted by %s%s", p.Green, p.Reset,
+			network.LocalCertFingerprint()[:8], p.Dim, network.TrustSummary(), p.Reset))
+	case *config.Identity:
+		detail := fmt.Sprintf("Noise XX, identity %s", keyfile.PeerFingerprint(network.LocalPublicKey()))
+		if *config.Secret != "" {
+			detail = fmt.Sprintf("Noise XXpsk0%s, identity %s + key fp %s", stretchNote(), keyfile.PeerFingerprint(network.LocalPublicKey()), keyfile.Fingerprint(*config.Secret))
+		}
+		n := 0
+		if peers, err := keyfile.LoadPeers(config.StartPath); err == nil {
+			n = len(peers)
+		}
+		row("Encryption", fmt.Sprintf("%son%s (%s) %sauthorized peers: %d%s", p.Green, p.Reset, detail, p.Dim, n, p.Reset))
 	case *config.Secret != "" && config.SecretFromKeyFile:
-		row("Encryption", fmt.Sprintf("%son%s (Noise NNpsk0, key file, fp %s)", p.Green, p.Reset, keyfile.Fingerprint(*config.Secret)))
+		row("Encryption", fmt.Sprintf("%son%s (Noise NNpsk0, key file%s, fp %s)", p.Green, p.Reset, stretchNote(), keyfile.Fingerprint(*config.Secret)))
 	case *config.Secret != "":
-		row("Encryption", fmt.Sprintf("%son%s (Noise NNpsk0, fp %s)", p.Green, p.Reset, keyfile.Fingerprint(*config.Secret)))
+		row("Encryption", fmt.Sprintf("%son%s (Noise NNpsk0%s, fp %s)", p.Green, p.Reset, stretchNote(), keyfile.Fingerprint(*config.Secret)))
 	case *config.NoEncrypt:
 		row("Encryption", fmt.Sprintf("off %s(--no-encrypt: forced plaintext)%s", p.Dim, p.Reset))
 	default:
 		row("Encryption", fmt.Sprintf("off %s(plaintext; enable with -k or --gen-key)%s", p.Dim, p.Reset))
 	}
+	// 轮换宽限期内：旧 key 还认多久
+	if prev, until := network.PreviousKey(); prev != "" && config.TransportListens() && time.Now().Before(until) {
+		row("Old key", fmt.Sprintf("fp %s %saccepted until %s, then retired (--rotate-key)%s", keyfile.Fingerprint(prev), p.Dim, until.Format(time.DateTime), p.Reset))
+	}
 	// 仅同步方（mirror/relay）涉及删除，展示当前删除策略
 	if config.SyncsFromUpstream() {
-		if *config.AllowDelete {
+		switch {
+		case config.TwoWay() && *config.AllowDelete:
+			row("Deletion", fmt.Sprintf("%son%s %s(deletes on either end propagate)%s", p.Green, p.Reset, p.Dim, p.Reset))
+		case config.TwoWay():
+			row("Deletion", fmt.Sprintf("off %s(additive only; files deleted on the peer are kept here)%s", p.Dim, p.Reset))
+		case *config.AllowDelete:
 			row("Deletion", fmt.Sprintf("%son%s %s(faithful mirror; local extras get deleted)%s", p.Green, p.Reset, p.Dim, p.Reset))
-		} else {
+		default:
 			row("Deletion", fmt.Sprintf("off %s(additive only; local extras kept)%s", p.Dim, p.Reset))
 		}
+		if config.TwoWay() {
+			row("Conflicts", fmt.Sprintf("both kept %s(the older edit as name.conflict-<host>-<time>.ext)%s", p.Dim, p.Reset))
+		}
 		// 关键路径解锁档：提示覆盖前会快照备份
 		if config.SnapshotOverwrites {
 			row("Critical", fmt.Sprintf("%sunlocked%s %s(--allow-critical; first overwrite backed up to .local-mirror/backups)%s",
 				p.Green, p.Reset, p.Dim, p.Reset))
 		}
+		if *config.Snapshots {
+			row("Snapshots", fmt.Sprintf("%son%s %s(%s; browse .local-mirror/snapshots or `local-mirror snapshot list`)%s",
+				p.Green, p.Reset, p.Dim, *config.SnapshotRetain, p.Reset))
+		}
+		if *config.KeepVersions {
+			row("Versions", fmt.Sprintf("%skept%s %s(%s; restore with `local-mirror restore <path>`)%s",
+				p.Green, p.Reset, p.Dim, versionRetention(), p.Reset))
+		}
+	}
+	if config.Bandwidth.Enabled() {
+		row("Bandwidth", fmt.Sprintf("%s %s(--bwlimit; unlimited outside the listed windows)%s", *config.BwLimit, p.Dim, p.Reset))
 	}
 	row("Instance", fmt.Sprintf("%08x", config.InstanceID))
 	row("PID", fmt.Sprintf("%d", os.Getpid()))
@@ -221,3 +292,23 @@ func printBanner() {
 		p.Dim, config.StartPath, p.Dim, p.Reset)
 	fmt.Println()
 }
+
+// versionRetention 横幅里的版本保留策略描述
+func versionRetention() string {
+	count := "unlimited versions"
+	if *config.VersionsKeep > 0 {
+		count = fmt.Sprintf("last %d per file", *config.VersionsKeep)
+	}
+	if *config.VersionsMaxAge > 0 {
+		return fmt.Sprintf("%s, up to %d days", count, *config.VersionsMaxAge)
+	}
+	return count + ", no age limit"
+}
+
+// stretchNote 人选口令握手前经 Argon2id 拉伸（生成的 key 不需要），横幅里点明
+func stretchNote() string {
+	if keyfile.IsGenerated(*config.Secret) {
+		return ""
+	}
+	return ", Argon2id-stretched passphrase"
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"local-mirror/config"
 	"local-mirror/internal/keyfile"
 	"local-mirror/internal/network"
@@ -62,7 +66,7 @@ func resolveDirection() error {
 	set := cliFlagsSet()
 	modeGiven := set["m"] || set["mode"]
 	upstreamGiven := set["r"] || set["realityip"]
-	dirVocab := set["send"] || set["receive"] || set["connect"] || set["listen"]
+	dirVocab := set["send"] || set["receive"] || set["connect"] || set["listen"] || set["upstream"] || set["bidirectional"]
 
 	if flag.NArg() > 0 {
 		if modeGiven || upstreamGiven || dirVocab || set["p"] || set["path"] {
@@ -88,18 +92,50 @@ func resolveDirection() error {
 		dirVocab = true
 	}
 
+	// verify 子命令恒在汇端跑，不必再写 --receive
+	if config.Verify && !modeGiven && !upstreamGiven && !*config.SendFlag {
+		*config.ReceiveFlag = true
+		dirVocab = true
+	}
+
 	if !dirVocab {
 		return nil // 老词汇：-m/-r 原样生效
 	}
 	if modeGiven || upstreamGiven {
 		return fmt.Errorf("direction flags (--send/--receive/--connect/--listen) cannot be mixed with -m/-r: pick one vocabulary")
 	}
+	// 扇出推送：只有纯源能把同一棵树推给多个汇；汇与双向端各自只拨一个对端
+	if strings.Contains(*config.ConnectTo, ",") && (!*config.SendFlag || *config.ReceiveFlag || *config.Bidirectional) {
+		return fmt.Errorf("--connect takes a list only with --send alone (fan-out push to several sinks); a sink, relay or two-way end dials one peer")
+	}
+	if *config.Bidirectional {
+		// 双向同步：本端同时是源与汇，恒监听（对端拨进来取本端的改动），
+		// 另经 --connect 拨向对端取它的改动；地址留空走局域网发现
+		if *config.SendFlag || *config.ReceiveFlag {
+			return fmt.Errorf("--bidirectional already makes this end both source and sink; drop --send/--receive")
+		}
+		if *config.ListenFlag || set["upstream"] {
+			return fmt.Errorf("--bidirectional always listens and dials the one peer given by --connect; drop --listen/--upstream")
+		}
+		*config.Mode = "bidirectional"
+		*config.RealityIP = *config.ConnectTo
+		return nil
+	}
 	if !*config.SendFlag && !*config.ReceiveFlag {
-		return fmt.Errorf("--connect/--listen need a direction: add --send (this dir is the source) or --receive (this dir is the sink)")
+		return fmt.Errorf("--connect/--listen/--upstream need a direction: add --send (this dir is the source) or --receive (this dir is the sink)")
 	}
 	if *config.ConnectTo != "" && *config.ListenFlag {
 		return fmt.Errorf("--connect and --listen are mutually exclusive on one link")
 	}
+	if set["upstream"] {
+		// 多上游汇：每路上游自带地址，取代 --connect；只有纯汇能把多路树合进一个根
+		if *config.SendFlag {
+			return fmt.Errorf("--upstream merges several sources into this sink; it cannot be combined with --send")
+		}
+		if *config.ConnectTo != "" || *config.ListenFlag {
+			return fmt.Errorf("--upstream gives every source's address itself; drop --connect/--listen")
+		}
+	}
 
 	switch {
 	case *config.SendFlag && *config.ReceiveFlag:
@@ -174,6 +210,14 @@ func resolveSecret() error {
 		os.Exit(0)
 	}
 
+	// TLS 传输按证书认证，不用口令也不读密钥文件
+	if config.TLSTransport() {
+		if *config.Secret != "" || *config.GenKey || *config.RotateKey {
+			return fmt.Errorf("--transport tls authenticates with certificates; -k, --gen-key and --rotate-key belong to the Noise transport")
+		}
+		return nil
+	}
+
 	if *config.GenKey {
 		// 一次只认一个密钥来源，避免"生成了 A、实际用的却是 B"
 		if *config.Secret != "" {
@@ -210,6 +254,29 @@ func resolveSecret() error {
 		return nil
 	}
 
+	if *config.RotateKey {
+		// 轮换的是密钥文件：-k 给的 key 不归本工具管，无从轮换
+		if *config.Secret != "" {
+			return fmt.Errorf("--rotate-key rotates the key file and conflicts with -k/--secret")
+		}
+		if *config.NoEncrypt {
+			return fmt.Errorf("--rotate-key conflicts with --no-encrypt")
+		}
+		if *config.GraceDays < 1 {
+			return fmt.Errorf("--grace-days must be at least 1 (dialers need time to connect and pick up the new key)")
+		}
+		old, _ := keyfile.Load(root)
+		key, until, err := keyfile.Rotate(root, time.Duration(*config.GraceDays)*24*time.Hour, *config.Force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rotated key file: %s (mode 600)\n", keyfile.Path(root))
+		fmt.Printf("fingerprint:      %s (was %s)\n", keyfile.Fingerprint(key), keyfile.Fingerprint(old))
+		fmt.Printf("old key accepted until %s; dialers that connect before then\n", until.Format(time.DateTime))
+		fmt.Printf("are handed the new key and save it. A running instance on this root picks it up by itself\n")
+		os.Exit(0)
+	}
+
 	if *config.NoEncrypt {
 		if *config.Secret != "" {
 			return fmt.Errorf("--no-encrypt conflicts with -k/--secret")
@@ -220,13 +287,13 @@ func resolveSecret() error {
 	if *config.Secret != "" {
 		// 显式最高优先（least surprise：文件优先会让 -k newvalue 被静默忽略）。
 		// 拨号端对称持有：把 key 落进自己的密钥文件，下次启动可省 -k；
-		// 内容一致时静默跳过，落盘失败不致命（本次仍按 -k 跑）
-		if config.SyncsFromUpstream() {
+		// 内容一致时静默跳过，落盘失败不致命（本次仍按 -k 跑）。--dry-run 与 verify 不写同步根
+		if config.SyncsFromUpstream() && !*config.DryRun && !config.Verify {
 			written, err := keyfile.Save(root, *config.Secret)
 			if err != nil {
 				log.Warnf("failed to save the key file (still running with -k): %v", err)
 			} else if written {
-				fmt.Printf("key saved to %s; -k can be omitted from now on\n", keyfile.Path(root))
+				fmt.Fprintf(os.Stderr, "key saved to %s; -k can be omitted from now on\n", keyfile.Path(root))
 			}
 		}
 		return nil
@@ -241,17 +308,172 @@ func resolveSecret() error {
 	if key != "" {
 		*config.Secret = key
 		config.SecretFromKeyFile = true
+		// --rotate-key 留下的旧 key：宽限期内监听端兼收（过期的在首次握手时退役）
+		previous, until, err := keyfile.LoadPrevious(root)
+		if err != nil {
+			return err
+		}
+		network.UseKeys(key, previous, until)
 	}
 	return nil
 }
 
+// resolveIdentity 落实身份模式：处理 --show-identity/--add-peer/--revoke-peer
+// 这些改完即退出的名单命令；--identity 时读出（首次则生成）本实例密钥对交给
+// network。名单命令只改 .local-mirror 下的文本文件，不碰目录锁，常驻进程运行中
+// 也能用——它监听名单文件，吊销即断开该对端的现有会话
+func resolveIdentity() error {
+	root := config.StartPath
+	if *config.PeerLabel != "" && *config.AddPeer == "" {
+		return fmt.Errorf("--label only goes with --add-peer")
+	}
+	if *config.AddPeer != "" {
+		p, err := keyfile.AddPeer(root, *config.AddPeer, *config.PeerLabel)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("authorized %s in %s\n", p, keyfile.PeersPath(root))
+		os.Exit(0)
+	}
+	if *config.RevokePeer != "" {
+		p, err := keyfile.RevokePeer(root, *config.RevokePeer)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s; a running instance here drops its sessions now\n", p)
+		os.Exit(0)
+	}
+	if *config.ShowIdentity {
+		_, pub, _, err := keyfile.LoadIdentity(root)
+		if err != nil {
+			return err
+		}
+		peers, err := keyfile.LoadPeers(root)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("public key:  %s\n", keyfile.PublicKeyString(pub))
+		fmt.Printf("fingerprint: %s\n", keyfile.PeerFingerprint(pub))
+		fmt.Printf("\non each peer: local-mirror --add-peer %s --label <name-for-this-machine>\n", keyfile.PublicKeyString(pub))
+		if len(peers) == 0 {
+			fmt.Printf("\nno authorized peers yet (%s)\n", keyfile.PeersPath(root))
+		} else {
+			fmt.Printf("\nauthorized peers (%s):\n", keyfile.PeersPath(root))
+			for _, p := range peers {
+				fmt.Printf("  %s  %s\n", keyfile.PublicKeyString(p.Key), p)
+			}
+		}
+		os.Exit(0)
+	}
+	if !*config.Identity {
+		return nil
+	}
+	if *config.NoEncrypt {
+		return fmt.Errorf("--identity conflicts with --no-encrypt")
+	}
+	// --dry-run 与 verify 不写同步根：没有身份就谈不上被对端认可，直接报错
+	if *config.DryRun || config.Verify {
+		if _, err := os.Stat(keyfile.IdentityPath(root)); err != nil {
+			return fmt.Errorf("no identity at %s yet: run --show-identity and add it on the peer first", keyfile.IdentityPath(root))
+		}
+	}
+	priv, pub, created, err := keyfile.LoadIdentity(root)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Fprintf(os.Stderr, "generated identity %s; its public key for the peer's --add-peer:\n  %s\n",
+			keyfile.IdentityPath(root), keyfile.PublicKeyString(pub))
+	}
+	if peers, err := keyfile.LoadPeers(root); err != nil {
+		return err
+	} else if len(peers) == 0 {
+		fmt.Fprintf(os.Stderr, "no authorized peers in %s: every connection is refused until you --add-peer one\n", keyfile.PeersPath(root))
+	}
+	network.UseIdentity(priv, pub)
+	return nil
+}
+
+// resolveTLS 落实 TLS 传输：处理生成自签名证书后即退出的 --gen-cert；
+// --transport tls 时读出本端证书、CA 与钉扎指纹交给 network
+func resolveTLS() error {
+	root := config.StartPath
+	if *config.GenCert {
+		name, err := os.Hostname()
+		if err != nil || name == "" {
+			name = "local-mirror"
+		}
+		der, err := keyfile.GenerateCert(root, name, *config.Force)
+		if err != nil {
+			return err
+		}
+		fp := keyfile.CertFingerprint(der)
+		fmt.Printf("generated certificate: %s (key %s, mode 600)\n", keyfile.CertPath(root), keyfile.CertKeyPath(root))
+		fmt.Printf("fingerprint:           %s\n\n", fp)
+		fmt.Printf("on the other end, trust this one with:\n")
+		fmt.Printf("  --transport tls --tls-pin %s\n", fp)
+		os.Exit(0)
+	}
+	if !config.TLSTransport() {
+		return nil
+	}
+	if *config.Identity {
+		return fmt.Errorf("--identity is a Noise mode; with --transport tls peers authenticate by certificate")
+	}
+	if *config.NoEncrypt {
+		return fmt.Errorf("--transport tls conflicts with --no-encrypt")
+	}
+	// 不验对端的 TLS 只防窃听不防冒充，不提供这种档位
+	if *config.TLSCA == "" && *config.TLSPin == "" {
+		return fmt.Errorf("--transport tls needs a way to trust the peer: --tls-ca <bundle> and/or --tls-pin <fingerprint>")
+	}
+	certFile, keyFile := *config.TLSCert, *config.TLSKey
+	if certFile == "" {
+		certFile = keyfile.CertPath(root)
+	}
+	if keyFile == "" {
+		keyFile = keyfile.CertKeyPath(root)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("no certificate at %s: generate a self-signed one with --gen-cert, or point --tls-cert/--tls-key at yours", certFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load the TLS certificate: %w", err)
+	}
+	var roots *x509.CertPool
+	if *config.TLSCA != "" {
+		bundle, err := os.ReadFile(*config.TLSCA)
+		if err != nil {
+			return fmt.Errorf("failed to read --tls-ca: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("--tls-ca %s holds no PEM certificates", *config.TLSCA)
+		}
+	}
+	var pins []string
+	for _, raw := range strings.Split(*config.TLSPin, ",") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		fp, err := keyfile.ParseCertFingerprint(raw)
+		if err != nil {
+			return err
+		}
+		pins = append(pins, fp)
+	}
+	network.UseTLS(network.TLSSettings{Cert: cert, Roots: roots, Pins: pins})
+	return nil
+}
+
 // runDiscovery 扫描局域网服务端并确定上游地址，写入
 // config.DiscoveredAddr/DiscoveredAlias 后返回。
 // 交互终端下始终展示列表让用户确认（哪怕只发现一台，避免连错）；
 // 非终端（systemd/管道）下恰好一台才自动连接。零台 exit 1——上游可能
 // 只是还没启动（开机顺序），属可重试的暂时状态，监督进程/systemd 会
 // 退避重启再扫；多台 exit 2——配置歧义，重试无解，必须 -r 显式指定。
-// 失败路径全部在本函数内 os.Exit
+// 失败路径全部在本函数内退出（InitDB 之后调用，经 exitAfterInit 收尾状态库）
 func runDiscovery() {
 	isTTY := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
 	for {
@@ -265,7 +487,7 @@ func runDiscovery() {
 		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "local-mirror: discovery failed: %v\nspecify the upstream server with -r\n", err)
-			os.Exit(2)
+			exitAfterInit(2)
 		}
 
 		if !isTTY {
@@ -278,13 +500,13 @@ func runDiscovery() {
 			case 0:
 				fmt.Fprintf(os.Stderr, "local-mirror: no LAN server found (upstream not running yet? retry later), "+
 					"or specify one with -r\n(discovery does not cross VPNs, subnets or firewalls)\n")
-				os.Exit(1)
+				exitAfterInit(1)
 			default:
 				fmt.Fprintf(os.Stderr, "local-mirror: found %d servers; cannot pick one non-interactively, use -r:\n", len(servers))
 				for _, s := range servers {
 					fmt.Fprintf(os.Stderr, "  %-20s %-21s %s\n", s.Alias, s.Addr(), s.SyncPath)
 				}
-				os.Exit(2)
+				exitAfterInit(2)
 			}
 		}
 
@@ -295,13 +517,13 @@ func runDiscovery() {
 		idx, outcome, err := tui.Select(fmt.Sprintf("found %d local-mirror servers:", len(servers)), opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
-			os.Exit(1)
+			exitAfterInit(1)
 		}
 		switch outcome {
 		case tui.Rescan:
 			continue
 		case tui.Canceled:
-			os.Exit(130) // 128+SIGINT，用户主动取消
+			exitAfterInit(130) // 128+SIGINT，用户主动取消
 		case tui.Selected:
 			config.DiscoveredAddr = servers[idx].Addr()
 			config.DiscoveredAlias = servers[idx].Alias
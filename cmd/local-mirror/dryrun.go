@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"local-mirror/config"
+	app "local-mirror/internal"
+	"local-mirror/internal/tree"
+	"os"
+)
+
+// runDryRun --dry-run：在缓存副本上连上游对账一轮，把计划打到 stdout 后退出。
+// 不取目录锁（不打开真实 cache.db 的写句柄），常驻实例运行中也能预览；
+// 计划不完整（有目录没能对账）时退出码 1。不返回
+func runDryRun() {
+	scratch, err := os.MkdirTemp("", "local-mirror-dryrun-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(1)
+	}
+	code := dryRun(scratch)
+	os.RemoveAll(scratch)
+	os.Exit(code)
+}
+
+func dryRun(scratch string) int {
+	if err := tree.OpenScratchDB(scratch); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 1
+	}
+	// 副本不是正常退出的实例，不走 CloseJournal
+	defer tree.DB.Close()
+
+	if err := config.LoadIgnoreList(config.StartPath); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 2
+	}
+	if *config.RealityIP == "" {
+		runDiscovery()
+	}
+
+	plan, err := app.DryRun()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 1
+	}
+	if *config.PlanJSON {
+		err = plan.WriteJSON(os.Stdout)
+	} else {
+		err = plan.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 1
+	}
+	if len(plan.Unscanned) > 0 {
+		return 1
+	}
+	return 0
+}
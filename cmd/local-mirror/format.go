@@ -109,6 +109,43 @@ func memoryLine(s *status.Snapshot) string {
 	return heap
 }
 
+// compressionLine 线上压缩率：收发消息体压缩前字节 / 实际上线字节。
+// 没有任何消息被压小（对端不支持、内容不可压缩）时显示 none
+func compressionLine(s *status.Snapshot) string {
+	if s.WireBytes == 0 || s.WireRawBytes <= s.WireBytes {
+		return "none"
+	}
+	return fmt.Sprintf("%.1f×   (%s raw → %s on the wire)",
+		float64(s.WireRawBytes)/float64(s.WireBytes), humanStatusBytes(s.WireRawBytes), humanStatusBytes(s.WireBytes))
+}
+
+// dedupLine 本地去重：由本地同内容副本满足、免于下载的文件数与字节数
+func dedupLine(s *status.Snapshot) string {
+	return fmt.Sprintf("%s / %d files copied locally instead of downloaded", humanStatusBytes(s.DedupBytes), s.DedupFiles)
+}
+
+// bandwidthLine 带宽限制：此刻生效的上限 + 计划原文
+func bandwidthLine(s *status.Snapshot) string {
+	now := "unlimited now"
+	if s.BwLimitBps > 0 {
+		now = humanRate(float64(s.BwLimitBps)) + " now"
+	}
+	return fmt.Sprintf("%s   (%s)", now, s.BwSchedule)
+}
+
+// linkLine 扇出推送里一路汇的状态：连通的显已连多久，断开的显失败原因与断了多久。
+// 进程已停时一律灰显为最后已知态
+func linkLine(l status.Link, live bool, p termstyle.Palette) string {
+	switch {
+	case !live:
+		return fmt.Sprintf("%s○ %s  %s (last known)%s", p.Dim, l.Target, l.Detail, p.Reset)
+	case l.Up:
+		return fmt.Sprintf("%s●%s %s  %sup %s%s", p.Green, p.Reset, l.Target, p.Dim, humanUptime(l.SinceUnix), p.Reset)
+	default:
+		return fmt.Sprintf("%s○%s %s  %s%s · down %s%s", p.Yellow, p.Reset, l.Target, p.Dim, l.Detail, humanUptime(l.SinceUnix), p.Reset)
+	}
+}
+
 func fdLine(s *status.Snapshot) string {
 	if s.HasFDs {
 		return fmt.Sprintf("%d", s.FDs)
@@ -124,6 +161,8 @@ func dirShort(mode string) string {
 		return "recv"
 	case "relay":
 		return "relay"
+	case "bidirectional":
+		return "2way"
 	}
 	return mode
 }
@@ -138,6 +177,8 @@ func dirShortFromSnap(s *status.Snapshot) string {
 		return "recv"
 	case strings.HasPrefix(s.Direction, "relay"):
 		return "relay"
+	case strings.HasPrefix(s.Direction, "two-way"):
+		return "2way"
 	}
 	return s.Direction
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"local-mirror/config"
 	app "local-mirror/internal"
+	"local-mirror/internal/keyfile"
 	"local-mirror/internal/logger"
 	"local-mirror/internal/network"
 	"local-mirror/internal/safety"
@@ -21,6 +22,9 @@ import (
 // version 可在构建时注入: go build -ldflags "-X main.version=v1.2.3"
 var version = "dev"
 
+// singleTask 单任务 --config 模式下落进旗子的那个任务，供 YAML 热重载比对
+var singleTask *config.TaskConfig
+
 func init() {
 	config.InstanceID = utils.GenerateRandomNum()
 	config.StartTime = time.Now().Unix()
@@ -33,13 +37,24 @@ func main() {
 	restoreConsole := enableConsoleUTF8()
 	defer restoreConsole()
 
-	// 子命令分发必须在 flag.Parse() 之前，且只精确匹配 "service" 这一个词。
+	// 子命令分发必须在 flag.Parse() 之前，且只精确匹配 "service"/"restore"/"snapshot"/"verify" 这几个词。
 	// 不能用「argv[1] 不以 - 开头」来判定——位置糖 `local-mirror ./dir @peer`
 	// 里的 ./dir 同样不以 - 开头，会被误当成子命令。
-	// 代价是同步一个名为 service 的目录时要写 `-p ./service`，可接受
+	// 代价是同步一个与子命令同名的目录时要写 `-p ./service`，可接受
 	if len(os.Args) > 1 && os.Args[1] == "service" {
 		runServiceCommand(os.Args[2:]) // 不返回
 	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		runRestoreCommand(os.Args[2:]) // 不返回
+	}
+	if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+		runSnapshotCommand(os.Args[2:]) // 不返回
+	}
+	// verify 要连源、要密钥与过滤规则，复用单实例旗子：去掉子命令词后落回主流程
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		config.Verify = true
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
 
 	flag.Parse()
 
@@ -100,6 +115,7 @@ func main() {
 		// 还让 pgrep/pkill 多一个匹配目标）。见 docs/CONFIG_AND_SERVICE.md §P3
 		if len(multiCfg.Tasks) == 1 {
 			applySingleTask(multiCfg.Tasks[0])
+			singleTask = &multiCfg.Tasks[0]
 			// 落回下方单实例主流程，与命令行直接给旗子完全同路
 		} else {
 			if n := countRealityTasks(multiCfg); n > config.PortScanRange {
@@ -182,6 +198,16 @@ func main() {
 		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
 		os.Exit(2)
 	}
+	// TLS 传输的证书（或处理 --gen-cert 后退出）
+	if err := resolveTLS(); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(2)
+	}
+	// 身份模式（或处理 --show-identity/--add-peer/--revoke-peer 后退出），同样早于任何连接
+	if err := resolveIdentity(); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(2)
+	}
 
 	// 三级安全阶梯（对所有同步方生效，不再只在 --allow-delete 时检查）：
 	// 关键路径（~、/、系统目录，真实路径解引用后判定）默认连"只同步"都拒绝
@@ -198,23 +224,33 @@ func main() {
 
 	logger.InitLogger()
 
+	// --dry-run：只预览一轮拉取的计划，不取目录锁、不绑端口、不打横幅
+	if *config.DryRun {
+		runDryRun() // 不返回
+	}
+	if config.Verify {
+		runVerify() // 不返回
+	}
+
 	// 先取目录锁（bbolt 文件锁，同目录单实例互斥），再绑定端口、打印横幅。
 	// 顺序反了会出现"横幅宣布成功后才因锁退出"的误导，以及一个
 	// accept 循环永远不会启动的幽灵端口
 	tree.InitDB()
-	defer func() {
-		if tree.DB != nil {
-			if err := tree.DB.Close(); err != nil {
-				log.Errorf("error closing database: %v", err)
-			}
-		}
-	}()
+	defer closeState()
 
 	// 忽略列表：内置默认 + -i 旗子 + .local-mirror/ignore 文件合并。
 	// 必须在 InitDB 之后（状态目录已建）、BuildFileTree/watcher 启动之前
 	if err := config.LoadIgnoreList(config.StartPath); err != nil {
 		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
-		os.Exit(2)
+		exitAfterInit(2)
+	}
+
+	// 按对端限定可见子树的 ACL：写错了启动即报，而不是让每个对端都被拒
+	if config.ServesDownstream() {
+		if err := network.CheckACL(); err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+			exitAfterInit(2)
+		}
 	}
 
 	// 实例别名（服务端在局域网发现中广播）：--alias → 主机名 → 兜底
@@ -231,7 +267,7 @@ func main() {
 	// 必须在 InitDB（单实例锁）之后：否则用户选完服务器才因目录被占退出。
 	// 中继此刻自己的发现应答器尚未启动，结构上不会扫到自己。
 	// 汇监听格不拨出、源拨出格必带地址（resolveDirection 已校验），都不发现
-	if config.SyncsFromUpstream() && !config.SinkListens && *config.RealityIP == "" {
+	if config.SyncsFromUpstream() && !config.SinkListens && *config.RealityIP == "" && len(config.Upstreams) == 0 {
 		runDiscovery()
 	}
 
@@ -243,7 +279,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "local-mirror: refusing to listen in plaintext on all interfaces with no key. "+
 			"Set a key (--gen-key on this listener, then -k on the dialer) for a private link, "+
 			"or pass --no-encrypt to accept plaintext explicitly (only sane on a trusted LAN).\n")
-		os.Exit(2)
+		exitAfterInit(2)
 	}
 	// 显式选择明文监听：给一条醒目的启动告警（横幅里也有，但日志/journal 里要能一眼看到）
 	if config.TransportListens() && *config.NoEncrypt {
@@ -257,7 +293,7 @@ func main() {
 		listener, port, err := network.ListenAvailable(config.DefaultPort, config.PortScanRange)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
-			os.Exit(1)
+			exitAfterInit(1)
 		}
 		app.ServerListener = listener
 		config.ActualPort = port
@@ -271,16 +307,59 @@ func main() {
 		}
 	}
 
-	printBanner()
+	// --once 的 stdout 只留结束摘要，供脚本直接解析
+	if !*config.Once {
+		printBanner()
+	}
 	log.Infof("startup: version=%s mode=%s instance=%08x root=%s", version, *config.Mode, config.InstanceID, config.StartPath)
 
 	// 运维快照：定型 identity 段并启动后台落盘循环，供 --status 读取。
 	// 落进 .local-mirror/status.json（可弃状态，删了下次自建）
 	status.Init(config.StartPath, version, fmt.Sprintf("%08x", config.InstanceID),
-		directionLabel(), transportLabel(), peerLabel(), *config.Secret != "", config.StartTime)
+		directionLabel(), transportLabel(), peerLabel(), config.Encrypted(), config.StartTime)
+	if config.TLSTransport() {
+		status.SetTLSCert(network.LocalCertFingerprint()[:8])
+	}
+	if *config.Identity {
+		status.SetIdentity(keyfile.PeerFingerprint(network.LocalPublicKey()))
+	}
+	if config.Bandwidth.Enabled() {
+		status.SetBandwidth(*config.BwLimit, config.Bandwidth.LimitAt)
+	}
 	stopStatus := make(chan struct{})
 	go status.Run(stopStatus)
 
+	// --once：跑一轮全量扫描、打印摘要后以对应退出码退出（os.Exit 不经 defer，先收尾）
+	if *config.Once {
+		code := app.Once(os.Stdout)
+		close(stopStatus)
+		closeState()
+		restoreConsole()
+		os.Exit(code)
+	}
+
+	if singleTask != nil {
+		watchSingleTask(*singleTask)
+	}
 	app.App()
 	close(stopStatus) // 收到退出信号后停止落盘（App 返回即已收到 SIGINT/SIGTERM）
 }
+
+// closeState 收尾状态库：正常退出才把变更日志标记为完整，下次启动下游可凭游标续传
+func closeState() {
+	if tree.DB != nil {
+		if err := tree.CloseJournal(); err != nil {
+			log.Errorf("error closing the change journal: %v", err)
+		}
+		if err := tree.DB.Close(); err != nil {
+			log.Errorf("error closing database: %v", err)
+		}
+	}
+}
+
+// exitAfterInit InitDB 之后的提前退出。os.Exit 不经 defer：不先收尾，日志会停在
+// 打开状态，下次启动按崩溃处理、另起纪元，每个下游都得退回全量对账
+func exitAfterInit(code int) {
+	closeState()
+	os.Exit(code)
+}
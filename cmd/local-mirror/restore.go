@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/versions"
+)
+
+// restoreTimeLayouts --at 接受的时间写法，除 RFC 3339 外均按本地时区解释；
+// 只写日期即当天零点
+var restoreTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// runRestoreCommand 处理 `local-mirror restore <path>`，不返回。
+//
+// 取回 --keep-versions 留存的旧版本：<path> 可以是文件，也可以是整棵被删的目录
+// （逐个取回其下留有版本的文件）。纯本地操作，不需要对端在线；原位取回时
+// 被顶替的当前内容同样先留存一份，取回本身也可撤销
+func runRestoreCommand(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	root := fs.String("path", "", "sync root, defaults to the working directory")
+	fs.StringVar(root, "p", "", "alias of --path")
+	at := fs.String("at", "", "restore the content that was in place at this time (default: the newest version)")
+	to := fs.String("to", "", "write the restored file here instead of back in place")
+	list := fs.Bool("list", false, "list the kept versions instead of restoring")
+	fs.Usage = func() { printRestoreUsage(os.Stdout) }
+
+	// 与 service 一致允许旗子写在位置参数前后：逐段解析，收集位置参数
+	var targets []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		targets = append(targets, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(targets) != 1 {
+		printRestoreUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	var when time.Time
+	if *at != "" {
+		t, err := parseRestoreTime(*at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+			os.Exit(2)
+		}
+		when = t
+	}
+
+	rootAbs, rel, err := resolveRestoreTarget(*root, targets[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(2)
+	}
+	paths, err := versions.Paths(rootAbs, rel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: reading %s: %v\n", versions.Dir(rootAbs), err)
+		os.Exit(1)
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "local-mirror: no kept versions of %s under %s\n", rel, versions.Dir(rootAbs))
+		os.Exit(1)
+	}
+	// 取回时留存被顶替内容所用的保留策略：沿用默认值，与汇端未指定时一致
+	policy := versions.NewPolicy(*config.VersionsKeep, *config.VersionsMaxAge)
+	restored, failed := 0, 0
+	for _, p := range paths {
+		vs, err := versions.List(rootAbs, p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", p, err)
+			failed++
+			continue
+		}
+		if *list {
+			fmt.Println(p)
+			for _, v := range vs {
+				fmt.Printf("  %s  %10s\n", v.Time.Local().Format("2006-01-02 15:04:05.000"), humanStatusBytes(uint64(v.Size)))
+			}
+			continue
+		}
+		v, err := versions.Pick(vs, when)
+		if errors.Is(err, versions.ErrNoVersion) {
+			fmt.Printf("  %s: unchanged since %s, nothing to restore\n", p, *at)
+			continue
+		}
+		dst := filepath.Join(rootAbs, p)
+		if *to != "" {
+			dst = *to
+			// 目录取回到别处保持子树结构：--to 即该目录的新位置
+			if len(paths) > 1 || p != rel {
+				sub, _ := filepath.Rel(rel, p)
+				dst = filepath.Join(*to, sub)
+			}
+		}
+		if err := versions.Restore(rootAbs, p, v, dst, time.Now(), policy); err != nil {
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", p, err)
+			failed++
+			continue
+		}
+		fmt.Printf("  %s  ← version of %s\n", dst, v.Time.Local().Format("2006-01-02 15:04:05"))
+		restored++
+	}
+	if !*list && restored > 0 && *to == "" {
+		// 原位取回的内容与上游不同；汇端的数据库仍记着上游哈希，
+		// 直到上游再次改动这个路径前不会去动它
+		fmt.Println("note: a running sink overwrites these again only if they change upstream")
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// resolveRestoreTarget 把命令行给的路径换算成同步根内的相对路径：
+// 相对路径相对同步根（未给 -p 时即当前目录），绝对路径须落在同步根内
+func resolveRestoreTarget(root, target string) (string, string, error) {
+	if root == "" {
+		root = "."
+	}
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return "", "", err
+	}
+	abs := target
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(rootAbs, target)
+	}
+	rel, err := filepath.Rel(rootAbs, filepath.Clean(abs))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%s is not inside the sync root %s (set it with -p)", target, rootAbs)
+	}
+	if rel == ".local-mirror" || strings.HasPrefix(rel, ".local-mirror"+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("%s is local-mirror's own state, not a synced path", target)
+	}
+	return rootAbs, rel, nil
+}
+
+func parseRestoreTime(s string) (time.Time, error) {
+	for _, layout := range restoreTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --at %q, want RFC 3339, \"2006-01-02 15:04\" or \"2006-01-02\"", s)
+}
+
+func printRestoreUsage(w *os.File) {
+	fmt.Fprintf(w, "Usage: local-mirror restore [-p root] <path> [--at time] [--to dest] [--list]\n\n")
+	fmt.Fprintf(w, "Bring back an old copy kept by a sink running with --keep-versions. <path> is a\n")
+	fmt.Fprintf(w, "file or a deleted directory inside the sync root. Without --at the newest kept\n")
+	fmt.Fprintf(w, "version is restored; with --at, the content that was in place at that time.\n")
+	fmt.Fprintf(w, "Restoring in place first keeps the current file as a version of its own.\n\n")
+	fmt.Fprintf(w, "Flags:\n")
+	fmt.Fprintf(w, "  -p, --path string   sync root, defaults to the working directory\n")
+	fmt.Fprintf(w, "      --at time       RFC 3339, \"2006-01-02 15:04\" or \"2006-01-02\" (local time)\n")
+	fmt.Fprintf(w, "      --to dest       write the restored file (or directory tree) here instead\n")
+	fmt.Fprintf(w, "      --list          list the kept versions, restore nothing\n")
+}
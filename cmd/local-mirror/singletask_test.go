@@ -14,11 +14,16 @@ func TestApplySingleTaskMapsEveryField(t *testing.T) {
 	// applySingleTask 会改全局旗子状态，跑完还原，避免污染同包其他用例
 	restore := map[string]any{
 		"path": *config.Path, "alias": *config.Alias, "loglevel": *config.LogLevel,
-		"secret": *config.Secret, "mode": *config.Mode, "ignore": *config.Ignore,
+		"secret": *config.Secret, "mode": *config.Mode, "ignore": *config.Ignore, "include": *config.Include,
+		"gitignore":   *config.Gitignore,
 		"allowDelete": *config.AllowDelete, "allowCritical": *config.AllowCritical,
 		"cooldown": *config.CoolDown, "fileBuf": *config.FileBufferSize,
-		"listen": *config.ListenFlag, "send": *config.SendFlag, "receive": *config.ReceiveFlag,
-		"connect": *config.ConnectTo,
+		"parallel": *config.Parallel, "bwlimit": *config.BwLimit,
+		"keepVersions": *config.KeepVersions, "versionsKeep": *config.VersionsKeep,
+		"versionsMaxAge": *config.VersionsMaxAge, "snapshots": *config.Snapshots,
+		"snapshotRetain": *config.SnapshotRetain,
+		"listen":         *config.ListenFlag, "send": *config.SendFlag, "receive": *config.ReceiveFlag,
+		"connect": *config.ConnectTo, "upstream": *config.UpstreamFlag,
 	}
 	t.Cleanup(func() {
 		*config.Path = restore["path"].(string)
@@ -27,23 +32,36 @@ func TestApplySingleTaskMapsEveryField(t *testing.T) {
 		*config.Secret = restore["secret"].(string)
 		*config.Mode = restore["mode"].(string)
 		*config.Ignore = restore["ignore"].(string)
+		*config.Include = restore["include"].(string)
+		*config.Gitignore = restore["gitignore"].(bool)
 		*config.AllowDelete = restore["allowDelete"].(bool)
 		*config.AllowCritical = restore["allowCritical"].(bool)
 		*config.CoolDown = restore["cooldown"].(int64)
 		*config.FileBufferSize = restore["fileBuf"].(uint64)
+		*config.Parallel = restore["parallel"].(int)
+		*config.BwLimit = restore["bwlimit"].(string)
+		*config.KeepVersions = restore["keepVersions"].(bool)
+		*config.VersionsKeep = restore["versionsKeep"].(int)
+		*config.VersionsMaxAge = restore["versionsMaxAge"].(int)
+		*config.Snapshots = restore["snapshots"].(bool)
+		*config.SnapshotRetain = restore["snapshotRetain"].(string)
 		*config.ListenFlag = restore["listen"].(bool)
 		*config.SendFlag = restore["send"].(bool)
 		*config.ReceiveFlag = restore["receive"].(bool)
 		*config.ConnectTo = restore["connect"].(string)
+		*config.UpstreamFlag = restore["upstream"].(string)
 	})
 
 	const secret = "single-task-secret"
 	task := config.TaskConfig{
 		Name: "solo", Path: "/srv/solo", Mode: "mirror",
 		RealityIP: "10.0.0.9", Listen: false,
-		Ignore: []string{"cache", "*.log"}, Secret: secret,
+		Ignore: []string{"cache", "*.log"}, Include: []string{"projects/foo/**", "docs/*.pdf"}, Gitignore: true, Secret: secret,
 		LogLevel: "warn", AllowDelete: true, AllowCritical: true,
-		CoolDown: 3600, FileBufferSize: 128 * 1024,
+		CoolDown: 3600, FileBufferSize: 128 * 1024, Parallel: 4,
+		BwLimit: "2MB/s 09:00-18:00", KeepVersions: true, VersionsKeep: 5,
+		VersionsMaxAge: -1, Snapshots: true, SnapshotRetain: "daily=14",
+		Upstreams: []string{"a=laptop-a", "b=laptop-b:52345"},
 	}
 	applySingleTask(task)
 
@@ -62,6 +80,12 @@ func TestApplySingleTaskMapsEveryField(t *testing.T) {
 	if *config.Ignore != "cache,*.log" {
 		t.Errorf("ignore 未落地: %q", *config.Ignore)
 	}
+	if *config.Include != "projects/foo/**,docs/*.pdf" {
+		t.Errorf("include 未落地: %q", *config.Include)
+	}
+	if !*config.Gitignore {
+		t.Error("gitignore 未落地")
+	}
 	if !*config.AllowDelete || !*config.AllowCritical {
 		t.Errorf("allow_delete/allow_critical 未落地: %v/%v", *config.AllowDelete, *config.AllowCritical)
 	}
@@ -71,6 +95,23 @@ func TestApplySingleTaskMapsEveryField(t *testing.T) {
 	if *config.FileBufferSize != 128*1024 {
 		t.Errorf("filebuffersize 未落地: %d", *config.FileBufferSize)
 	}
+	if *config.Parallel != 4 {
+		t.Errorf("parallel 未落地: %d", *config.Parallel)
+	}
+	if *config.BwLimit != "2MB/s 09:00-18:00" {
+		t.Errorf("bwlimit 未落地: %q", *config.BwLimit)
+	}
+	// YAML 的 -1（永久）落成 CLI 的 0
+	if !*config.KeepVersions || *config.VersionsKeep != 5 || *config.VersionsMaxAge != 0 {
+		t.Errorf("keep_versions/versions_keep/versions_max_age 未落地: %v/%d/%d",
+			*config.KeepVersions, *config.VersionsKeep, *config.VersionsMaxAge)
+	}
+	if !*config.Snapshots || *config.SnapshotRetain != "daily=14" {
+		t.Errorf("snapshots/snapshot_retain 未落地: %v/%q", *config.Snapshots, *config.SnapshotRetain)
+	}
+	if *config.UpstreamFlag != "a=laptop-a,b=laptop-b:52345" {
+		t.Errorf("upstreams 未落地: %q", *config.UpstreamFlag)
+	}
 	if !*config.ReceiveFlag {
 		t.Errorf("mirror 应映射为 --receive，实际 receive=%v", *config.ReceiveFlag)
 	}
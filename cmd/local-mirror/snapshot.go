@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"local-mirror/config"
+	"local-mirror/internal/snapshot"
+)
+
+// runSnapshotCommand 处理 `local-mirror snapshot <list|diff|restore>`，不返回。
+// 只读快照库与副本本身，不碰汇端的树索引，运行中的汇端不受影响
+func runSnapshotCommand(args []string) {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	root := fs.String("path", "", "sync root, defaults to the working directory")
+	fs.StringVar(root, "p", "", "alias of --path")
+	to := fs.String("to", "", "restore: write into this directory instead of back in place")
+	fs.Usage = func() { printSnapshotUsage(os.Stdout) }
+
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	// 旗子可写在位置参数前后：逐段解析，收集位置参数
+	var pos []string
+	for {
+		_ = fs.Parse(args)
+		if fs.NArg() == 0 {
+			break
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	rootAbs, err := filepath.Abs(*root)
+	if *root == "" {
+		rootAbs, err = os.Getwd()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case action == "list" && len(pos) == 0:
+		snapshotList(rootAbs)
+	case action == "diff" && (len(pos) == 1 || len(pos) == 2):
+		snapshotDiff(rootAbs, pos)
+	case action == "restore" && (len(pos) == 1 || len(pos) == 2):
+		rel := "."
+		if len(pos) == 2 {
+			rel = pos[1]
+		}
+		snapshotRestore(rootAbs, pos[0], rel, *to)
+	case action == "":
+		printSnapshotUsage(os.Stderr)
+		os.Exit(2)
+	case action != "list" && action != "diff" && action != "restore":
+		fmt.Fprintf(os.Stderr, "local-mirror: unknown snapshot action %q\n\n", action)
+		printSnapshotUsage(os.Stderr)
+		os.Exit(2)
+	default:
+		printSnapshotUsage(os.Stderr)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func snapshotList(root string) {
+	snaps, err := snapshot.List(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(1)
+	}
+	if len(snaps) == 0 {
+		fmt.Printf("no snapshots under %s\n", snapshot.Dir(root))
+		return
+	}
+	for _, s := range snaps {
+		fmt.Printf("%s  %s  %8d files  %10s\n", s.Name, s.Taken.Local().Format("2006-01-02 15:04:05"),
+			s.Files, humanStatusBytes(s.Bytes))
+	}
+}
+
+// snapshotDiff 两个快照之间，或一个快照与副本现状之间的差异
+func snapshotDiff(root string, names []string) {
+	from := mustLoadSnapshot(root, names[0])
+	var to []snapshot.Entry
+	label := "live"
+	if len(names) == 2 {
+		m := mustLoadSnapshot(root, names[1])
+		to, label = m.Entries, m.Name
+	} else {
+		// 与同步一致的忽略规则，免得本地忽略的文件（.git 等）全被报成新增
+		if err := config.LoadIgnoreList(root); err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+			os.Exit(2)
+		}
+		entries, err := snapshot.Scan(root, config.IsIgnored, from.Entries)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: scanning %s: %v\n", root, err)
+			os.Exit(1)
+		}
+		to = entries
+	}
+	changes := snapshot.Diff(from.Entries, to)
+	counts := map[byte]int{}
+	for _, c := range changes {
+		fmt.Printf("%c %s\n", c.Kind, c.Path)
+		counts[c.Kind]++
+	}
+	fmt.Printf("%s → %s: %d added, %d removed, %d modified\n", from.Name, label, counts['+'], counts['-'], counts['M'])
+}
+
+func snapshotRestore(root, spec, rel, to string) {
+	name, err := snapshot.Resolve(root, spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(1)
+	}
+	rel, err = snapshotRel(root, rel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(2)
+	}
+	dest := filepath.Join(root, rel)
+	if to != "" {
+		dest = to
+	}
+	n, err := snapshot.Restore(root, name, rel, dest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: restoring from %s: %v\n", name, err)
+		os.Exit(1)
+	}
+	fmt.Printf("restored %d files from snapshot %s into %s\n", n, name, dest)
+	if to == "" {
+		fmt.Println("note: a running sink overwrites them again only if they change upstream")
+	}
+}
+
+// snapshotRel 与 restore 一致：相对路径相对同步根，绝对路径须落在同步根内
+func snapshotRel(root, p string) (string, error) {
+	if p == "." {
+		return p, nil
+	}
+	abs := p
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, p)
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(abs))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside the sync root %s (set it with -p)", p, root)
+	}
+	return rel, nil
+}
+
+func mustLoadSnapshot(root, spec string) *snapshot.Manifest {
+	name, err := snapshot.Resolve(root, spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(1)
+	}
+	m, err := snapshot.Load(root, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		os.Exit(1)
+	}
+	return m
+}
+
+func printSnapshotUsage(w *os.File) {
+	fmt.Fprintf(w, "Usage: local-mirror snapshot <list|diff|restore> [-p root] [args]\n\n")
+	fmt.Fprintf(w, "Work with the snapshots a sink running with --snapshots keeps under\n")
+	fmt.Fprintf(w, ".local-mirror/snapshots. A snapshot name accepts any unique prefix (a date\n")
+	fmt.Fprintf(w, "such as 2026-03-01 picks that day's last snapshot) or \"latest\".\n\n")
+	fmt.Fprintf(w, "  list                      snapshots with their file count and size\n")
+	fmt.Fprintf(w, "  diff <a> [b]              paths added (+), removed (-) or modified (M) from\n")
+	fmt.Fprintf(w, "                            snapshot a to b; without b, to the live replica\n")
+	fmt.Fprintf(w, "  restore <name> [path]     copy the snapshot, or one path in it, back in place.\n")
+	fmt.Fprintf(w, "                            Files missing from the snapshot are left alone\n\n")
+	fmt.Fprintf(w, "Flags:\n")
+	fmt.Fprintf(w, "  -p, --path string   sync root, defaults to the working directory\n")
+	fmt.Fprintf(w, "      --to dest       restore into dest instead of back in place\n")
+}
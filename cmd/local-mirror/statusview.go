@@ -132,11 +132,30 @@ func renderSingle(root string) {
 		}
 		row("Link", fmt.Sprintf("%s○ %s%s", p.Dim, detail, p.Reset))
 	}
+	// 扇出推送：Link 只说连着几路，哪个汇断了看逐路状态
+	if len(snap.Links) > 1 {
+		for i, l := range snap.Links {
+			label := ""
+			if i == 0 {
+				label = "Sinks"
+			}
+			row(label, linkLine(l, live, p))
+		}
+	}
 	enc := "off (plaintext)"
-	if snap.Encrypted {
+	switch {
+	case snap.TLSCert != "":
+		enc = fmt.Sprintf("on (TLS 1.3, cert %s)", snap.TLSCert)
+	case snap.Identity != "":
+		enc = fmt.Sprintf("on (Noise XX, identity %s)", snap.Identity)
+	case snap.Encrypted:
 		enc = "on (Noise NNpsk0)"
 	}
 	row("Encryption", enc)
+	// 身份模式：活跃会话各自认证出的对端（标签 + 指纹）
+	if live && len(snap.PeerIdentities) > 0 {
+		row("Peer IDs", strings.Join(snap.PeerIdentities, ", "))
+	}
 	row("Sync root", snap.Root)
 	fmt.Println(line)
 
@@ -156,6 +175,13 @@ func renderSingle(root string) {
 	}
 	row("Totals", fmt.Sprintf("%s / %d files   %s· last %s%s%s",
 		humanStatusBytes(snap.Bytes), snap.Files, p.Dim, humanSince(time.Unix(snap.LastSyncUnix, 0)), fileSuffix(snap.LastFile, p), p.Reset))
+	row("Compression", compressionLine(snap))
+	if snap.DedupFiles > 0 {
+		row("Dedup", dedupLine(snap))
+	}
+	if snap.BwSchedule != "" {
+		row("Bandwidth", bandwidthLine(snap))
+	}
 	if snap.Errors > 0 {
 		row("Errors", fmt.Sprintf("%s%d%s", p.Yellow, snap.Errors, p.Reset))
 	} else {
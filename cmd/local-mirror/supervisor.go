@@ -7,14 +7,20 @@ import (
 	"fmt"
 	"io"
 	"local-mirror/config"
+	app "local-mirror/internal"
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // 监督进程模式：--config 指定 YAML 后，父进程为每个任务 re-exec 自身
@@ -43,7 +49,26 @@ func (r *childRef) signal(sig os.Signal) {
 	}
 }
 
-// runSupervisor 阻塞运行直到收到关停信号（exit 0）或全部任务永久失败（exit 1）
+// supervisedTask 一个正在被监督的任务：配置快照、子进程句柄与单独的取消开关。
+// 配置重载时按任务名逐个比对，只重启 TaskConfig 真正变了的任务
+type supervisedTask struct {
+	cfg    config.TaskConfig
+	ref    *childRef
+	cancel context.CancelFunc
+	done   chan struct{} // 管理 goroutine 退出时关闭
+}
+
+// supervisor 监督进程的运行态。tasks 只由 runSupervisor 的主循环读写
+type supervisor struct {
+	exe    string
+	ctx    context.Context
+	tasks  map[string]*supervisedTask
+	failed chan *supervisedTask // 任务未经 stop 而自行结束 = 永久失败
+}
+
+// runSupervisor 阻塞运行直到收到关停信号（exit 0）或全部任务永久失败（exit 1）。
+// SIGHUP 或 YAML 文件变化时重载配置：增删改的任务相应启停，未变的任务不受打扰
+// （只转发 SIGHUP 让它重载自己的忽略规则）；新配置不合法则保留当前任务集
 func runSupervisor(cfg *config.MultiConfig) {
 	exe, err := os.Executable()
 	if err != nil {
@@ -59,44 +84,161 @@ func runSupervisor(cfg *config.MultiConfig) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	refs := make([]*childRef, len(cfg.Tasks))
-	var wg sync.WaitGroup
-	for i := range cfg.Tasks {
-		refs[i] = &childRef{}
-		wg.Add(1)
-		go func(t config.TaskConfig, ref *childRef) {
-			defer wg.Done()
-			superviseTask(ctx, exe, t, ref)
-		}(cfg.Tasks[i], refs[i])
+	s := &supervisor{exe: exe, ctx: ctx, tasks: make(map[string]*supervisedTask), failed: make(chan *supervisedTask)}
+	for _, t := range cfg.Tasks {
+		s.start(t)
 	}
 
-	allDone := make(chan struct{})
-	go func() { wg.Wait(); close(allDone) }()
-
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	select {
-	case <-sigCh:
-		fmt.Fprintln(os.Stderr, "local-mirror: shutdown signal received, stopping all tasks...")
-		cancel()
-		for _, r := range refs {
-			r.signal(syscall.SIGTERM)
+	changed := make(chan struct{}, 1)
+	if stop, err := app.WatchFile(*config.ConfigFile, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
 		}
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: cannot watch %s, reload it with SIGHUP instead: %v\n", *config.ConfigFile, err)
+	} else {
+		defer stop()
+	}
+
+	for {
 		select {
-		case <-allDone:
-		case <-time.After(shutdownGrace):
-			// 宽限期内未退出的强杀
-			for _, r := range refs {
-				r.signal(syscall.SIGKILL)
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				fmt.Fprintln(os.Stderr, "local-mirror: SIGHUP received, reloading the config")
+				s.reload(true)
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "local-mirror: shutdown signal received, stopping all tasks...")
+			cancel()
+			all := make([]*supervisedTask, 0, len(s.tasks))
+			for _, st := range s.tasks {
+				all = append(all, st)
+			}
+			stopTasks(all)
+			os.Exit(0)
+		case <-changed:
+			fmt.Fprintf(os.Stderr, "local-mirror: %s changed, reloading the config\n", *config.ConfigFile)
+			s.reload(false)
+		case st := <-s.failed:
+			if s.tasks[st.cfg.Name] == st {
+				delete(s.tasks, st.cfg.Name)
+			}
+			if len(s.tasks) == 0 {
+				// 所有管理 goroutine 自然退出 = 每个任务都永久失败
+				fmt.Fprintln(os.Stderr, "local-mirror: all tasks failed permanently, exiting")
+				os.Exit(1)
 			}
-			<-allDone
 		}
-		os.Exit(0)
-	case <-allDone:
-		// 所有管理 goroutine 自然退出 = 每个任务都永久失败
-		fmt.Fprintln(os.Stderr, "local-mirror: all tasks failed permanently, exiting")
-		os.Exit(1)
+	}
+}
+
+// start 为任务起一个管理 goroutine，任务有自己的取消开关，可单独停掉
+func (s *supervisor) start(t config.TaskConfig) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	st := &supervisedTask{cfg: t, ref: &childRef{}, cancel: cancel, done: make(chan struct{})}
+	s.tasks[t.Name] = st
+	go func() {
+		defer close(st.done)
+		superviseTask(ctx, s.exe, t, st.ref)
+		// 被 stop 掉的不算失败；select 防止与正在停它的主循环互等
+		select {
+		case s.failed <- st:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// reload 重读 YAML 并把运行中的任务集调整到新配置。forwardHup 为真（SIGHUP 触发）
+// 时给未变的任务转发 SIGHUP，让它们一并重载各自的忽略规则
+func (s *supervisor) reload(forwardHup bool) {
+	cfg, err := config.LoadMultiConfig(*config.ConfigFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: config reload failed, keeping the running tasks: %v\n", err)
+		return
+	}
+	current := make(map[string]config.TaskConfig, len(s.tasks))
+	for name, st := range s.tasks {
+		current[name] = st.cfg
+	}
+	stop, start := diffTasks(current, cfg.Tasks)
+
+	restarting := make(map[string]bool, len(start))
+	for _, t := range start {
+		restarting[t.Name] = true
+	}
+	var stopping []*supervisedTask
+	for _, name := range stop {
+		stopping = append(stopping, s.tasks[name])
+		delete(s.tasks, name)
+		if !restarting[name] {
+			fmt.Fprintf(os.Stderr, "[%s] removed from the config, stopping\n", name)
+		}
+	}
+	stopTasks(stopping)
+	for _, t := range start {
+		if _, had := current[t.Name]; had {
+			fmt.Fprintf(os.Stderr, "[%s] config changed, restarting\n", t.Name)
+		} else {
+			fmt.Fprintf(os.Stderr, "[%s] new task  %s  %s\n", t.Name, t.Mode, t.Path)
+		}
+		s.start(t)
+	}
+	if forwardHup {
+		for name, st := range s.tasks {
+			if !restarting[name] {
+				st.ref.signal(syscall.SIGHUP)
+			}
+		}
+	}
+	if len(stop) == 0 && len(start) == 0 {
+		fmt.Fprintln(os.Stderr, "local-mirror: config reloaded, no task changed")
+	}
+}
+
+// diffTasks 按任务名比对新旧配置：stop 是要停掉的（已删除或配置变了），
+// start 是要启动的（新增或配置变了，按新配置顺序）。配置完全一致的任务两边都不出现
+func diffTasks(current map[string]config.TaskConfig, next []config.TaskConfig) (stop []string, start []config.TaskConfig) {
+	seen := make(map[string]bool, len(next))
+	for _, t := range next {
+		seen[t.Name] = true
+		old, ok := current[t.Name]
+		if ok && reflect.DeepEqual(old, t) {
+			continue
+		}
+		if ok {
+			stop = append(stop, t.Name)
+		}
+		start = append(start, t)
+	}
+	for name := range current {
+		if !seen[name] {
+			stop = append(stop, name)
+		}
+	}
+	sort.Strings(stop)
+	return stop, start
+}
+
+// stopTasks 停掉一批任务：先取消、发 SIGTERM，宽限期内未退出的强杀
+func stopTasks(tasks []*supervisedTask) {
+	for _, st := range tasks {
+		st.cancel()
+		st.ref.signal(syscall.SIGTERM)
+	}
+	deadline := time.After(shutdownGrace)
+	for _, st := range tasks {
+		select {
+		case <-st.done:
+		case <-deadline:
+			for _, rest := range tasks {
+				rest.ref.signal(syscall.SIGKILL)
+			}
+			<-st.done
+		}
 	}
 }
 
@@ -190,16 +332,13 @@ func prefixLines(r io.Reader, w io.Writer, name string) {
 func countRealityTasks(cfg *config.MultiConfig) int {
 	n := 0
 	for _, t := range cfg.Tasks {
-		if t.Mode == "reality" || t.Mode == "relay" {
+		if t.Mode == "reality" || t.Mode == "relay" || t.Mode == "bidirectional" {
 			n++
 		}
 	}
 	return n
 }
 
-// taskArgs 把任务配置映射为子进程 argv。方向用 --send/--receive 表达（内部
-// Mode 已由 config 归一），传输用 --connect/--listen——子进程再走 resolveDirection
-// 还原四象限。这样 ps 里也是方向优先词汇，不出现遗留的 -m/-r
 // applySingleTask 把唯一任务的配置落进本进程自己的旗子状态，供单任务免 fork 直跑。
 //
 // 刻意复用 taskArgs 这同一份 TaskConfig→argv 映射在进程内重解析，而不是另写一个
@@ -217,6 +356,51 @@ func applySingleTask(t config.TaskConfig) {
 	*config.Secret = t.Secret
 }
 
+// watchSingleTask 单任务 --config 模式没有监督进程，YAML 的变化由本进程自己处理
+// （文件变化或 SIGHUP）：ignore 变了就地重载忽略规则；其余字段早已落进各处的
+// 运行态，只提示需要重启，不做半套的热改
+func watchSingleTask(t config.TaskConfig) {
+	var mu sync.Mutex
+	// check 重读 YAML，ignore 变了就重载并返回 true
+	check := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		cfg, err := config.LoadMultiConfig(*config.ConfigFile)
+		if err != nil {
+			log.Errorf("config reload failed, keeping the running config: %v", err)
+			return false
+		}
+		if len(cfg.Tasks) != 1 {
+			log.Warnf("%s now has %d tasks; restart to run them under a supervisor", *config.ConfigFile, len(cfg.Tasks))
+			return false
+		}
+		next := cfg.Tasks[0]
+		rest := next
+		rest.Ignore = t.Ignore
+		if !reflect.DeepEqual(rest, t) {
+			log.Warnf("%s changed beyond the ignore rules; restart to apply", *config.ConfigFile)
+		}
+		if slices.Equal(next.Ignore, t.Ignore) {
+			return false
+		}
+		t.Ignore = next.Ignore
+		app.ReloadIgnoresWith(strings.Join(next.Ignore, ","))
+		return true
+	}
+	if _, err := app.WatchFile(*config.ConfigFile, func() { check() }); err != nil {
+		log.Warnf("cannot watch %s, reload it with SIGHUP instead: %v", *config.ConfigFile, err)
+	}
+	// SIGHUP 仍由 App 收：先看 YAML，ignore 没变再照常重读 .local-mirror/ignore 等
+	app.SetHUPReload(func() {
+		if !check() {
+			app.ReloadIgnores()
+		}
+	})
+}
+
+// taskArgs 把任务配置映射为子进程 argv。方向用 --send/--receive 表达（内部
+// Mode 已由 config 归一），传输用 --connect/--listen——子进程再走 resolveDirection
+// 还原四象限。这样 ps 里也是方向优先词汇，不出现遗留的 -m/-r
 func taskArgs(t config.TaskConfig) []string {
 	var args []string
 	switch t.Mode {
@@ -226,6 +410,8 @@ func taskArgs(t config.TaskConfig) []string {
 		args = []string{"--receive"}
 	case "relay":
 		args = []string{"--send", "--receive"}
+	case "bidirectional":
+		args = []string{"--bidirectional"}
 	}
 	args = append(args, "-p", t.Path, "-a", t.Name)
 	if t.LogLevel != "" {
@@ -237,9 +423,36 @@ func taskArgs(t config.TaskConfig) []string {
 	if t.Listen {
 		args = append(args, "--listen")
 	}
+	if len(t.Upstreams) > 0 {
+		args = append(args, "--upstream", strings.Join(t.Upstreams, ","))
+	}
 	if len(t.Ignore) > 0 {
 		args = append(args, "-i", strings.Join(t.Ignore, ","))
 	}
+	if len(t.Include) > 0 {
+		args = append(args, "--include", strings.Join(t.Include, ","))
+	}
+	if t.Gitignore {
+		args = append(args, "--gitignore")
+	}
+	if t.Identity {
+		args = append(args, "--identity")
+	}
+	if t.Transport != "" {
+		args = append(args, "--transport", t.Transport)
+	}
+	if t.TLSCert != "" {
+		args = append(args, "--tls-cert", t.TLSCert)
+	}
+	if t.TLSKey != "" {
+		args = append(args, "--tls-key", t.TLSKey)
+	}
+	if t.TLSCA != "" {
+		args = append(args, "--tls-ca", t.TLSCA)
+	}
+	if len(t.TLSPin) > 0 {
+		args = append(args, "--tls-pin", strings.Join(t.TLSPin, ","))
+	}
 	if t.AllowDelete {
 		args = append(args, "--allow-delete")
 	}
@@ -252,5 +465,27 @@ func taskArgs(t config.TaskConfig) []string {
 	if t.FileBufferSize > 0 {
 		args = append(args, "-f", strconv.FormatUint(t.FileBufferSize, 10))
 	}
+	if t.Parallel > 0 {
+		args = append(args, "--parallel", strconv.Itoa(t.Parallel))
+	}
+	if t.BwLimit != "" {
+		args = append(args, "--bwlimit", t.BwLimit)
+	}
+	if t.KeepVersions {
+		args = append(args, "--keep-versions")
+	}
+	// YAML 的 -1（不限）对应 CLI 的 0
+	if t.VersionsKeep != 0 {
+		args = append(args, "--versions-keep", strconv.Itoa(max(t.VersionsKeep, 0)))
+	}
+	if t.VersionsMaxAge != 0 {
+		args = append(args, "--versions-max-age", strconv.Itoa(max(t.VersionsMaxAge, 0)))
+	}
+	if t.Snapshots {
+		args = append(args, "--snapshots")
+	}
+	if t.SnapshotRetain != "" {
+		args = append(args, "--snapshot-retain", t.SnapshotRetain)
+	}
 	return args
 }
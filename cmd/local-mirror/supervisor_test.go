@@ -49,6 +49,18 @@ func TestTaskArgsDirectionFirst(t *testing.T) {
 			want: []string{"--send", "--receive", "--connect", "10.0.0.9"},
 			deny: []string{"-m", "-r"},
 		},
+		{
+			name: "two-way",
+			t:    config.TaskConfig{Mode: "bidirectional", Path: "/srv/f", Name: "f", RealityIP: "ws-b"},
+			want: []string{"--bidirectional", "--connect", "ws-b"},
+			deny: []string{"-m", "-r", "--send", "--receive", "--listen"},
+		},
+		{
+			name: "fan-out push",
+			t:    config.TaskConfig{Mode: "reality", Path: "/srv/g", Name: "g", RealityIP: "vps-a,vps-b"},
+			want: []string{"--send", "--connect", "vps-a,vps-b"},
+			deny: []string{"-m", "-r", "--receive", "--listen"},
+		},
 	}
 	for _, c := range cases {
 		got := argvString(taskArgs(c.t))
@@ -65,3 +77,35 @@ func TestTaskArgsDirectionFirst(t *testing.T) {
 		}
 	}
 }
+
+// TestDiffTasks 配置重载只动真正变了的任务：改了的先停后起，删了的只停，
+// 新增的只起，一字未改的两边都不出现
+func TestDiffTasks(t *testing.T) {
+	a := config.TaskConfig{Name: "a", Mode: "reality", Path: "/srv/a"}
+	b := config.TaskConfig{Name: "b", Mode: "mirror", Path: "/srv/b", RealityIP: "10.0.0.5"}
+	c := config.TaskConfig{Name: "c", Mode: "mirror", Path: "/srv/c", Listen: true}
+	current := map[string]config.TaskConfig{"a": a, "b": b, "c": c}
+
+	b2 := b
+	b2.Ignore = []string{"*.tmp"}
+	d := config.TaskConfig{Name: "d", Mode: "reality", Path: "/srv/d"}
+	stop, start := diffTasks(current, []config.TaskConfig{a, b2, d})
+
+	if got := strings.Join(stop, ","); got != "b,c" {
+		t.Errorf("stop = %q, want b,c", got)
+	}
+	var names []string
+	for _, s := range start {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "b,d" {
+		t.Errorf("start = %q, want b,d", got)
+	}
+	if len(start) == 2 && len(start[0].Ignore) != 1 {
+		t.Error("重启的任务应按新配置启动")
+	}
+
+	if stop, start := diffTasks(current, []config.TaskConfig{c, b, a}); len(stop) != 0 || len(start) != 0 {
+		t.Errorf("只是顺序变了不该重启: stop=%v start=%v", stop, start)
+	}
+}
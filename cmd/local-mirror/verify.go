@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"local-mirror/config"
+	app "local-mirror/internal"
+	"local-mirror/internal/tree"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runVerify `local-mirror verify`：重算副本哈希与源比对，打印报告后退出。
+// 只读校验与 --dry-run 一样在缓存副本上做，不取目录锁，汇端运行中也能跑；
+// --repair 要经正常下载路径写副本与树，须取目录锁（汇端须先停下）。
+// 一致（或已修复）退出码 0，仍有差异 1。不返回
+func runVerify() {
+	var code int
+	if *config.Repair {
+		tree.InitDB()
+		code = verify()
+		if err := tree.CloseJournal(); err != nil {
+			log.Errorf("error closing the change journal: %v", err)
+		}
+		if err := tree.DB.Close(); err != nil {
+			log.Errorf("error closing database: %v", err)
+		}
+	} else {
+		scratch, err := os.MkdirTemp("", "local-mirror-verify-*")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+			os.Exit(1)
+		}
+		if err := tree.OpenScratchDB(scratch); err != nil {
+			fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+			code = 1
+		} else {
+			code = verify()
+			tree.DB.Close()
+		}
+		os.RemoveAll(scratch)
+	}
+	os.Exit(code)
+}
+
+func verify() int {
+	if err := config.LoadIgnoreList(config.StartPath); err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 2
+	}
+	if *config.RealityIP == "" {
+		runDiscovery()
+	}
+	report, err := app.Verify(*config.Repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 1
+	}
+	if *config.PlanJSON {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "local-mirror: %v\n", err)
+		return 1
+	}
+	if report.Clean() || report.Repaired {
+		return 0
+	}
+	return 1
+}
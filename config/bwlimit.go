@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinBwLimit --bwlimit 的最低速率。汇端限速是「收完一块再按配额睡」，睡眠期间
+// 源端的下一次写入挂在塞满的发送缓冲上；单块最大 MaxFileBufferSize（4 MiB），
+// 128 KiB/s 下至多约 32s，留在源端 sendMessageWriteTimeout（60s）之内
+const MinBwLimit = 128 << 10
+
+// BwSchedule 解析后的 --bwlimit 计划：若干条「速率 [时段]」规则，按书写顺序取
+// 第一条命中当前时刻的；都不命中即不限速。零值表示不限速
+type BwSchedule struct {
+	rules []bwRule
+}
+
+// bwRule 一条限速规则。bps 为 0 表示该时段明确不限速（unlimited）；
+// allDay 为真时不看时段，[start, end) 是一天中的分钟数，end <= start 表示跨零点
+type bwRule struct {
+	bps        uint64
+	allDay     bool
+	start, end int
+}
+
+// ParseBwLimit 解析 --bwlimit。语法为逗号分隔的规则，每条 `<速率> [HH:MM-HH:MM]`：
+//
+//	10MB/s                         全天 10 MB/s
+//	10MB/s 09:00-18:00             工作时间 10 MB/s，其余时间不限
+//	2MB/s 09:00-18:00, 20MB/s      工作时间 2 MB/s，其余时间 20 MB/s
+//	unlimited 00:00-06:00, 5M      凌晨不限，其余时间 5 MB/s
+//
+// 速率单位 K/M/G 按 1024 进位，B、iB 与 /s 后缀可省略；时段左闭右开，
+// 结束早于开始即跨零点。空串返回零值（不限速）
+func ParseBwLimit(s string) (BwSchedule, error) {
+	var sched BwSchedule
+	if strings.TrimSpace(s) == "" {
+		return sched, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 || len(fields) > 2 {
+			return BwSchedule{}, fmt.Errorf("bwlimit: invalid rule %q, want \"<rate> [HH:MM-HH:MM]\"", strings.TrimSpace(part))
+		}
+		bps, err := parseRate(fields[0])
+		if err != nil {
+			return BwSchedule{}, err
+		}
+		rule := bwRule{bps: bps, allDay: true}
+		if len(fields) == 2 {
+			start, end, err := parseWindow(fields[1])
+			if err != nil {
+				return BwSchedule{}, err
+			}
+			rule.allDay, rule.start, rule.end = false, start, end
+		}
+		sched.rules = append(sched.rules, rule)
+	}
+	return sched, nil
+}
+
+// parseRate 解析速率，返回字节/秒；unlimited/off 返回 0
+func parseRate(s string) (uint64, error) {
+	lower := strings.ToLower(s)
+	if lower == "unlimited" || lower == "off" {
+		return 0, nil
+	}
+	num := strings.TrimSuffix(lower, "/s")
+	num = strings.TrimSuffix(num, "b")
+	num = strings.TrimSuffix(num, "i")
+	mult := 1.0
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k':
+			mult = 1 << 10
+		case 'm':
+			mult = 1 << 20
+		case 'g':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("bwlimit: invalid rate %q (examples: 512K, 10MB/s, 1.5G, unlimited)", s)
+	}
+	bps := uint64(v * mult)
+	if bps < MinBwLimit {
+		return 0, fmt.Errorf("bwlimit: rate %q is below the minimum of %d KB/s", s, MinBwLimit>>10)
+	}
+	return bps, nil
+}
+
+// parseWindow 解析 HH:MM-HH:MM，返回一天中的起止分钟数
+func parseWindow(s string) (start, end int, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("bwlimit: invalid time window %q, want HH:MM-HH:MM", s)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("bwlimit: empty time window %q (omit the window for all day)", s)
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bwlimit: invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LimitAt 返回 t 时刻（本地时间）生效的速率上限，字节/秒；0 = 不限速
+func (b BwSchedule) LimitAt(t time.Time) uint64 {
+	m := t.Hour()*60 + t.Minute()
+	for _, r := range b.rules {
+		if r.allDay {
+			return r.bps
+		}
+		if r.start < r.end && m >= r.start && m < r.end {
+			return r.bps
+		}
+		if r.start > r.end && (m >= r.start || m < r.end) {
+			return r.bps
+		}
+	}
+	return 0
+}
+
+// Enabled 计划里是否有任何时段真正限速
+func (b BwSchedule) Enabled() bool {
+	for _, r := range b.rules {
+		if r.bps > 0 {
+			return true
+		}
+	}
+	return false
+}
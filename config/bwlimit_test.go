@@ -0,0 +1,79 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hhmm string) time.Time {
+	t, _ := time.Parse("15:04", hhmm)
+	return time.Date(2026, 1, 5, t.Hour(), t.Minute(), 0, 0, time.Local)
+}
+
+// TestParseBwLimitSchedule 计划按书写顺序取首条命中的规则，都不命中即不限速；
+// 跨零点时段、单位与 unlimited 关键字都要解析对
+func TestParseBwLimitSchedule(t *testing.T) {
+	cases := []struct {
+		spec string
+		at   string
+		want uint64
+	}{
+		{"", "12:00", 0},
+		{"10MB/s", "03:00", 10 << 20},
+		{"10MB/s 09:00-18:00", "09:00", 10 << 20},
+		{"10MB/s 09:00-18:00", "17:59", 10 << 20},
+		{"10MB/s 09:00-18:00", "18:00", 0},
+		{"10MB/s 09:00-18:00", "08:59", 0},
+		{"2MB/s 09:00-18:00, 20M", "12:00", 2 << 20},
+		{"2MB/s 09:00-18:00, 20M", "20:00", 20 << 20},
+		{"512K 22:00-06:00", "23:30", 512 << 10},
+		{"512K 22:00-06:00", "05:59", 512 << 10},
+		{"512K 22:00-06:00", "06:00", 0},
+		{"unlimited 00:00-06:00, 1.5MiB/s", "01:00", 0},
+		{"unlimited 00:00-06:00, 1.5MiB/s", "07:00", 3 << 19},
+		{"1G", "12:00", 1 << 30},
+		{"262144", "12:00", 256 << 10},
+	}
+	for _, c := range cases {
+		sched, err := ParseBwLimit(c.spec)
+		if err != nil {
+			t.Errorf("%q: 解析失败: %v", c.spec, err)
+			continue
+		}
+		if got := sched.LimitAt(at(c.at)); got != c.want {
+			t.Errorf("%q @%s = %d, want %d", c.spec, c.at, got, c.want)
+		}
+	}
+}
+
+// TestParseBwLimitRejects 非法速率、低于下限、坏时段都在启动时拒绝，
+// 而不是运行中静默不限速
+func TestParseBwLimitRejects(t *testing.T) {
+	for _, spec := range []string{
+		"fast",
+		"10XB/s",
+		"0",
+		"-5M",
+		"64K", // 低于 MinBwLimit
+		"10M 9-18",
+		"10M 09:00",
+		"10M 09:00-09:00",
+		"10M 25:00-26:00",
+		"10M 09:00-18:00 extra",
+		"10M,",
+	} {
+		if _, err := ParseBwLimit(spec); err == nil {
+			t.Errorf("%q 应被拒", spec)
+		}
+	}
+}
+
+// TestBwScheduleEnabled 只有 unlimited 规则的计划等同不限速
+func TestBwScheduleEnabled(t *testing.T) {
+	if s, _ := ParseBwLimit("unlimited"); s.Enabled() {
+		t.Error("纯 unlimited 不应视为启用限速")
+	}
+	if s, _ := ParseBwLimit("unlimited 00:00-06:00, 5M"); !s.Enabled() {
+		t.Error("含限速时段应视为启用")
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"local-mirror/pkg/utils"
 )
 
 const (
@@ -14,12 +17,17 @@ const (
 	RealityMode = 0x0001
 	MirrorMode  = 0x0002
 	RelayMode   = 0x0003
+	// TwoWayMode 双向同步：两端互为源与汇（--bidirectional）
+	TwoWayMode = 0x0004
 
 	// 握手 Role 字段承载的数据方向（公网化支柱 A）：老值平滑映射——
 	// reality 一直发 1、mirror 一直发 2，语义由「模式」重释为「方向」；
 	// RelayMode(3) 是旧 relay 两个方向都发的遗留值，握手校验里视为合法
 	RoleSend    uint8 = RealityMode // 本端是源（数据流出）
 	RoleReceive uint8 = MirrorMode  // 本端是汇（数据流入）
+	// RoleBoth 双向同步的两端在两条连接上都申报它。老端的互补校验只拒绝
+	// 「同为 send」或「同为 receive」，遇到 4 照常放行
+	RoleBoth uint8 = TwoWayMode
 
 	// DefaultPort 端口探测的起始 TCP 端口。
 	// 服务端从这里开始寻找第一个可用端口监听；
@@ -41,17 +49,71 @@ var (
 
 	// IgnoreFileList 生效的忽略列表：forced + default（去掉被 ! 取消的）+ -i/--ignore
 	// + .local-mirror/ignore 文件，合并去重后的结果（见 LoadIgnoreList，启动时调用
-	// 一次，不热加载）。匹配按路径段进行，每段支持 * ? [] 通配符（见 utils.IsIgnored）。
+	// 一次，运行中 SIGHUP 或 ignore 文件变化时重载）。匹配按路径段进行，每段支持 * ? [] 通配符（见 utils.IsIgnored）。
 	// 服务端命中即不扫描/不监听（不进树），客户端命中即不同步（不下载也不删除）。
-	// 未调用 LoadIgnoreList 前即 forced + default
+	// 未调用 LoadIgnoreList 前即 forced + default。运行中按路径判定一律走 IsIgnored
 	IgnoreFileList = append(append([]string{}, forcedIgnores...), defaultIgnores...)
+
+	// gitIgnore --gitignore 时按 .gitignore 语义判定的匹配器（见 LoadIgnoreList），否则为 nil
+	gitIgnore *utils.GitIgnore
+
+	// ignoreMu 保护 IgnoreFileList 与 gitIgnore：热重载在独立 goroutine 里整体替换它们
+	ignoreMu sync.RWMutex
 )
 
+// Ignores 返回当前生效的忽略列表。重载只整体替换、不原地修改，
+// 返回的切片可以放心遍历
+func Ignores() []string {
+	ignoreMu.RLock()
+	defer ignoreMu.RUnlock()
+	return IgnoreFileList
+}
+
+// IgnoreRules 某一时刻生效的全部忽略规则：忽略列表，加上 --gitignore 时的 .gitignore 匹配器。
+// 是值快照，重载前后各取一份即可对比哪些路径的判定变了
+type IgnoreRules struct {
+	List []string
+	Git  *utils.GitIgnore
+}
+
+// Ignored 判定同步根相对路径是否被忽略。isDir 只影响 .gitignore 里以 / 结尾的规则
+func (r IgnoreRules) Ignored(relPath string, isDir bool) bool {
+	if utils.IsIgnored(relPath, r.List) {
+		return true
+	}
+	return r.Git != nil && r.Git.Ignored(relPath, isDir)
+}
+
+// CurrentIgnores 当前生效的忽略规则快照
+func CurrentIgnores() IgnoreRules {
+	ignoreMu.RLock()
+	defer ignoreMu.RUnlock()
+	return IgnoreRules{List: IgnoreFileList, Git: gitIgnore}
+}
+
+// IsIgnored 按当前生效的忽略规则判定路径；建树、watcher、下发与客户端 diff 过滤都走这里
+func IsIgnored(relPath string, isDir bool) bool {
+	return CurrentIgnores().Ignored(relPath, isDir)
+}
+
+// GitignoreChanged 同步根下 relDir 目录里的 .gitignore 变了：让该目录的规则在
+// 下次用到时重读。返回改动前后的规则快照，未开 --gitignore 时两者相同
+func GitignoreChanged(relDir string) (before, now IgnoreRules) {
+	ignoreMu.Lock()
+	defer ignoreMu.Unlock()
+	before = IgnoreRules{List: IgnoreFileList, Git: gitIgnore}
+	if gitIgnore != nil {
+		gitIgnore = gitIgnore.Without(relDir)
+	}
+	return before, IgnoreRules{List: IgnoreFileList, Git: gitIgnore}
+}
+
 var (
 	ModeMap = map[string]uint8{
-		"reality": RealityMode,
-		"mirror":  MirrorMode,
-		"relay":   RelayMode,
+		"reality":       RealityMode,
+		"mirror":        MirrorMode,
+		"relay":         RelayMode,
+		"bidirectional": TwoWayMode,
 	}
 )
 
@@ -60,19 +122,47 @@ var (
 	LogLevel       *string
 	CoolDown       *int64
 	FileBufferSize *uint64
+	Parallel       *int
+	BwLimit        *string
+	KeepVersions   *bool
+	VersionsKeep   *int
+	VersionsMaxAge *int
+	Snapshots      *bool
+	SnapshotRetain *string
 	RealityIP      *string
+	UpstreamFlag   *string
 	Secret         *string
 	SecretStdin    *bool
 	Path           *string
 	Alias          *string
 	Ignore         *string
+	Include        *string
+	Gitignore      *bool
 	ConfigFile     *string
 	AllowDelete    *bool
+	DryRun         *bool
+	PlanJSON       *bool
+	Once           *bool
+	Repair         *bool
+	ScrubDays      *int
 	AllowCritical  *bool
 	GenKey         *bool
 	ShowKey        *bool
 	NoEncrypt      *bool
 	Force          *bool
+	RotateKey      *bool
+	GraceDays      *int
+	Transport      *string
+	TLSCert        *string
+	TLSKey         *string
+	TLSCA          *string
+	TLSPin         *string
+	GenCert        *bool
+	Identity       *bool
+	ShowIdentity   *bool
+	AddPeer        *string
+	PeerLabel      *string
+	RevokePeer     *string
 	Status         *bool
 	All            *bool
 	Heat           *bool
@@ -80,6 +170,7 @@ var (
 	ReceiveFlag    *bool
 	ConnectTo      *string
 	ListenFlag     *bool
+	Bidirectional  *bool
 	Help           *bool
 	Version        *bool
 
@@ -88,8 +179,20 @@ var (
 	// 依 --send/--receive × --connect/--listen 推导：
 	// SourceDials = 源端拨出（--send --connect，不监听、拨向监听的汇）；
 	// SinkListens = 汇端监听（--receive --listen，不拨出、等源拨入）
+	// Bandwidth 解析后的 --bwlimit 计划（ValidateRuntimeNumbers 定型），零值不限速
+	Bandwidth BwSchedule
+	// SnapshotRetention 解析后的 --snapshot-retain（ValidateRuntimeNumbers 定型）
+	SnapshotRetention Retention
+	// Upstreams 解析后的 --upstream（ValidateRuntimeNumbers 定型），非空即多上游汇
+	Upstreams []Upstream
+	// IncludeList 解析后的 --include（ValidateRuntimeNumbers 定型），非空即只同步订阅范围
+	IncludeList []string
+	// DialTargets 源拨出格的目标汇（ValidateRuntimeNumbers 定型）；多于一个即扇出推送
+	DialTargets []string
+
 	SourceDials bool   = false
 	SinkListens bool   = false
+	Verify      bool   = false      // `local-mirror verify` 子命令：本端恒为汇，重算副本哈希与源比对
 	ActualPort  int    = 0          // 服务端实际监听的端口（启动时探测确定）
 	StartPath   string = ""         // 同步根目录（-p 指定，默认为当前工作目录）
 	InstanceID  uint32 = 0x00000000 // Instance ID
@@ -126,7 +229,8 @@ var (
 // 文件（每行一条，# 注释，空行跳过，文件不存在则静默跳过）。以 ! 开头的条目表示
 // "取消一个默认忽略项"（如 !.git 让 .git 参与同步）；! 不能取消强制项。普通模式用
 // filepath.Match 预校验（非法如未闭合的 "[" 返回错误）。结果去重（保序）后写回
-// IgnoreFileList。启动时调用一次；运行中修改文件不生效，需重启
+// IgnoreFileList。启动时调用一次；运行中的重载也走这里（见 app.reloadIgnores），
+// 出错时保留原列表不动
 func LoadIgnoreList(startPath string) error {
 	var adds []string                // -i/文件里的普通忽略模式（叠加）
 	negated := make(map[string]bool) // 被 !pattern 取消的默认项
@@ -192,40 +296,64 @@ func LoadIgnoreList(startPath string) error {
 		seen[p] = struct{}{}
 		merged = append(merged, p)
 	}
+	ignoreMu.Lock()
 	IgnoreFileList = merged
+	// 重载时 .gitignore 也一并重读：换一个空缓存的匹配器
+	gitIgnore = nil
+	if *Gitignore {
+		gitIgnore = utils.NewGitIgnore(startPath)
+	}
+	ignoreMu.Unlock()
 	return nil
 }
 
 // ServesDownstream 本进程是否运行源引擎（对外送数据）。
 // 注意这只是数据方向：传输上源可能监听也可能拨出（见 TransportListens）
 func ServesDownstream() bool {
-	return *Mode == "reality" || *Mode == "relay"
+	return *Mode == "reality" || *Mode == "relay" || *Mode == "bidirectional"
 }
 
 // SyncsFromUpstream 本进程是否运行汇引擎（收数据）。
 // 同上，汇可能拨出也可能监听
 func SyncsFromUpstream() bool {
-	return *Mode == "mirror" || *Mode == "relay"
+	return *Mode == "mirror" || *Mode == "relay" || *Mode == "bidirectional"
+}
+
+// TwoWay 本进程是否在双向同步（--bidirectional）：源引擎与汇引擎同时运行，
+// 汇引擎按共同祖先判断每处差异该取对端、留本地还是冲突（见 internal/twoway.go）
+func TwoWay() bool {
+	return *Mode == "bidirectional"
+}
+
+// Encrypted 连接是否加密：设了口令（-k / 密钥文件）或开了身份模式（--identity）走 Noise，
+// --transport tls 走 TLS。须在 resolveSecret 之后调用
+func Encrypted() bool {
+	return *Secret != "" || *Identity || TLSTransport()
+}
+
+// TLSTransport 传输加密是否走 TLS（--transport tls）而非 Noise
+func TLSTransport() bool {
+	return *Transport == "tls"
 }
 
 // PlaintextListenBlocked 判定当前配置是否属于「明文 + 监听所有接口 + 未显式确认」——
 // SEC-01 据此在启动时拒绝：监听端固定绑所有接口，明文即对任何网络可达者敞开服务，
-// 未设密钥（-k / 密钥文件）又没显式 --no-encrypt 时必须拦下；设了密钥或显式 --no-encrypt 放行。
+// 未设密钥（-k / 密钥文件 / --identity）又没显式 --no-encrypt 时必须拦下；设了密钥或显式 --no-encrypt 放行。
 // 须在 resolveSecret 之后调用（那时 Secret 已从密钥文件填充）
 func PlaintextListenBlocked() bool {
-	return TransportListens() && *Secret == "" && !*NoEncrypt
+	return TransportListens() && !Encrypted() && !*NoEncrypt
 }
 
 // TransportListens 本进程是否需要绑定监听端口：
 // 源默认监听（除非 SourceDials）、汇默认不监听（除非 SinkListens）、
-// relay 下游侧恒监听
+// relay 下游侧与双向同步恒监听
 func TransportListens() bool {
 	switch *Mode {
 	case "reality":
 		return !SourceDials
 	case "mirror":
 		return SinkListens
-	case "relay":
+	case "relay", "bidirectional":
 		return true
 	}
 	return false
@@ -244,7 +372,10 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "  local-mirror [flags]\n")
 	fmt.Fprintf(w, "  local-mirror ./dir @host[:port]      push ./dir to the listening sink\n")
 	fmt.Fprintf(w, "  local-mirror @host[:port] ./dir      pull into ./dir from the listening source\n")
-	fmt.Fprintf(w, "  local-mirror service <action>        manage the system service (see below)\n\n")
+	fmt.Fprintf(w, "  local-mirror service <action>        manage the system service (see below)\n")
+	fmt.Fprintf(w, "  local-mirror restore <path>          bring back a version kept by --keep-versions (see below)\n")
+	fmt.Fprintf(w, "  local-mirror snapshot <action>       list, diff or restore --snapshots snapshots (see below)\n")
+	fmt.Fprintf(w, "  local-mirror verify [flags]          re-hash the replica and compare it with the source (see below)\n\n")
 
 	fmt.Fprintf(w, "Service subcommand:\n")
 	fmt.Fprintf(w, "  local-mirror service install         create the config dir and a blank config,\n")
@@ -255,19 +386,52 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "                               Flags: --system / --user / --run-as / --config / --dry-run\n")
 	fmt.Fprintf(w, "                               Handles systemd, launchd and procd (OpenWrt) automatically\n\n")
 
+	fmt.Fprintf(w, "Restore subcommand:\n")
+	fmt.Fprintf(w, "  local-mirror restore [-p root] <path> [--at time] [--to dest] [--list]\n")
+	fmt.Fprintf(w, "                               copy the version of <path> (relative to the sync root)\n")
+	fmt.Fprintf(w, "                               that was current at --at (default: the newest one) back\n")
+	fmt.Fprintf(w, "                               in place, or to --to. --at takes RFC 3339, \"2006-01-02 15:04\"\n")
+	fmt.Fprintf(w, "                               or a date; --list shows the kept versions instead\n\n")
+
+	fmt.Fprintf(w, "Snapshot subcommand:\n")
+	fmt.Fprintf(w, "  local-mirror snapshot list           snapshots with their file count and size\n")
+	fmt.Fprintf(w, "  local-mirror snapshot diff <a> [b]   what changed from a to b (default: the live replica)\n")
+	fmt.Fprintf(w, "  local-mirror snapshot restore <name> [path] [--to dest]\n")
+	fmt.Fprintf(w, "                               copy a snapshot, or a path in it, back in place or to dest.\n")
+	fmt.Fprintf(w, "                               Names accept a prefix such as a date, or \"latest\"; -p sets the root\n\n")
+	fmt.Fprintf(w, "Verify subcommand:\n")
+	fmt.Fprintf(w, "  local-mirror verify [-p root] [--connect host] [--repair] [--plan-json]\n")
+	fmt.Fprintf(w, "                               re-read every file of the replica, re-hash it and compare\n")
+	fmt.Fprintf(w, "                               with the source. Reports files whose content changed on\n")
+	fmt.Fprintf(w, "                               disk without a new size or mtime, and everything that\n")
+	fmt.Fprintf(w, "                               differs from the source. Takes the sink flags (-k, --include,\n")
+	fmt.Fprintf(w, "                               --allow-delete...). Read-only and safe next to a running sink;\n")
+	fmt.Fprintf(w, "                               --repair re-downloads the differences and needs the sink stopped.\n")
+	fmt.Fprintf(w, "                               Exit 0 = identical (or fully repaired), 1 = differences left\n\n")
+
 	fmt.Fprintf(w, "Direction (what this end is):\n")
 	fmt.Fprintf(w, "      --send                   this directory is the source: data flows out\n")
 	fmt.Fprintf(w, "      --receive                this directory is the sink: data flows in;\n")
 	fmt.Fprintf(w, "                               additive by default, --allow-delete for a faithful mirror.\n")
-	fmt.Fprintf(w, "                               Give both to relay (receive upstream, serve downstream)\n\n")
+	fmt.Fprintf(w, "                               Give both to relay (receive upstream, serve downstream)\n")
+	fmt.Fprintf(w, "      --bidirectional          two-way sync: both ends serve and pull. Each end listens and\n")
+	fmt.Fprintf(w, "                               dials the other with --connect; when both changed a file since\n")
+	fmt.Fprintf(w, "                               they last agreed, both versions are kept (the losing one as\n")
+	fmt.Fprintf(w, "                               name.conflict-<host>-<time>.ext). Needs --bidirectional on both ends\n\n")
 
 	fmt.Fprintf(w, "Transport (who dials whom; independent of direction):\n")
 	fmt.Fprintf(w, "      --connect host[:port]    dial the peer. Port omitted: a dialing sink scans\n")
 	fmt.Fprintf(w, "                               %d-%d, a dialing source uses %d. Domain names are\n", DefaultPort, DefaultPort+PortScanRange-1, DefaultPort)
-	fmt.Fprintf(w, "                               re-resolved on every reconnect (DDNS-friendly)\n")
+	fmt.Fprintf(w, "                               re-resolved on every reconnect (DDNS-friendly).\n")
+	fmt.Fprintf(w, "                               With --send alone it takes a list (\"vps-a,vps-b\"):\n")
+	fmt.Fprintf(w, "                               one tree pushed to every sink, each over its own link\n")
 	fmt.Fprintf(w, "      --listen                 wait for the peer to dial in; binds the first free\n")
 	fmt.Fprintf(w, "                               port from %d (IPv4+IPv6, printed at startup)\n", DefaultPort)
-	fmt.Fprintf(w, "                               Defaults: --send listens, --receive connects\n\n")
+	fmt.Fprintf(w, "                               Defaults: --send listens, --receive connects\n")
+	fmt.Fprintf(w, "      --upstream dir=host[:port],...\n")
+	fmt.Fprintf(w, "                               with --receive instead of --connect: merge several sources into\n")
+	fmt.Fprintf(w, "                               subdirectories of one root (\"a=laptop-a,b=laptop-b\" puts them\n")
+	fmt.Fprintf(w, "                               in ./a/ and ./b/), each over its own connection\n\n")
 
 	fmt.Fprintf(w, "LAN discovery:\n")
 	fmt.Fprintf(w, "  A --receive with neither --connect nor --listen scans the local network\n")
@@ -281,6 +445,21 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "  -c, --cooldown int           full-rescan safety-net interval in seconds, sink side;\n")
 	fmt.Fprintf(w, "                               changes are pushed in real time, this is the backstop (default 1800)\n")
 	fmt.Fprintf(w, "  -f, --filebuffersize uint    transfer chunk size in bytes, source side (default 65536)\n")
+	fmt.Fprintf(w, "      --bwlimit string         cap file transfer throughput, both sides: \"10MB/s\", or per time window\n")
+	fmt.Fprintf(w, "                               \"2MB/s 09:00-18:00, 20MB/s\" (first match wins, unlimited otherwise;\n")
+	fmt.Fprintf(w, "                               K/M/G = 1024-based, minimum %d KB/s)\n", MinBwLimit>>10)
+	fmt.Fprintf(w, "      --parallel int           files downloaded at once, sink side; each extra download opens\n")
+	fmt.Fprintf(w, "                               its own connection, a listening sink stays at 1 (default 1, max %d)\n", MaxParallel)
+	fmt.Fprintf(w, "      --keep-versions          sink side: move the old copy of every overwritten or deleted file\n")
+	fmt.Fprintf(w, "                               into .local-mirror/versions instead of discarding it;\n")
+	fmt.Fprintf(w, "                               bring one back with `local-mirror restore <path>`\n")
+	fmt.Fprintf(w, "      --versions-keep int      versions kept per file, 0 = unlimited (default 10)\n")
+	fmt.Fprintf(w, "      --versions-max-age int   days a version is kept, 0 = forever (default 30)\n")
+	fmt.Fprintf(w, "      --snapshots              sink side: after each clean full scan (at most once per hour),\n")
+	fmt.Fprintf(w, "                               snapshot the replica into .local-mirror/snapshots as a\n")
+	fmt.Fprintf(w, "                               hardlink tree; see `local-mirror snapshot`\n")
+	fmt.Fprintf(w, "      --snapshot-retain string newest snapshot kept per hour/day/ISO week, per bucket count\n")
+	fmt.Fprintf(w, "                               (default \"hourly=24,daily=7,weekly=4\")\n")
 	fmt.Fprintf(w, "  -a, --alias string           instance name shown in discovery lists; defaults to hostname\n")
 	fmt.Fprintf(w, "  -i, --ignore string          extra ignore patterns (comma-separated), matched per path\n")
 	fmt.Fprintf(w, "                               segment, * ? [] globs supported. Server: never scanned or\n")
@@ -288,9 +467,29 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "                               Defaults: .local-mirror (forced), plus .git and .DS_Store\n")
 	fmt.Fprintf(w, "                               (removable — prefix with ! to sync them, e.g. -i '!.git').\n")
 	fmt.Fprintf(w, "                               Also read from .local-mirror/ignore (one per line, # comments;\n")
-	fmt.Fprintf(w, "                               restart to apply)\n")
+	fmt.Fprintf(w, "                               edits apply live, as does SIGHUP)\n")
+	fmt.Fprintf(w, "      --gitignore              also honour .gitignore files at any depth with real gitignore\n")
+	fmt.Fprintf(w, "                               syntax (/anchored, dir/, **, !negation) on both ends, on top\n")
+	fmt.Fprintf(w, "                               of the ignore list above. .git/info/exclude is read too\n")
+	fmt.Fprintf(w, "      --include string         selective sync, sink side: pull only paths matching these\n")
+	fmt.Fprintf(w, "                               patterns (comma-separated), e.g. \"projects/foo/**,docs/*.pdf\".\n")
+	fmt.Fprintf(w, "                               Anchored at the sync root; ** spans directories; a matching\n")
+	fmt.Fprintf(w, "                               directory brings its whole subtree. Local files outside are\n")
+	fmt.Fprintf(w, "                               left alone; the source only reports changes inside them\n")
 	fmt.Fprintf(w, "      --allow-delete           delete local files that no longer exist upstream\n")
 	fmt.Fprintf(w, "                               (off by default: additive sync only)\n")
+	fmt.Fprintf(w, "      --dry-run                with --receive: compare with the source and print what a full\n")
+	fmt.Fprintf(w, "                               scan would create, overwrite, delete and rename, then exit.\n")
+	fmt.Fprintf(w, "                               Nothing on disk or in .local-mirror is touched; pair it with\n")
+	fmt.Fprintf(w, "                               --allow-delete to preview a faithful mirror\n")
+	fmt.Fprintf(w, "      --plan-json              with --dry-run: print the plan as JSON instead of a table\n")
+	fmt.Fprintf(w, "      --once                   with --receive: run one full scan, print a JSON summary and\n")
+	fmt.Fprintf(w, "                               exit (for cron/CI). Exit 0 = replica consistent, 1 = scan\n")
+	fmt.Fprintf(w, "                               could not run, 3 = disk full, 4 = permission denied,\n")
+	fmt.Fprintf(w, "                               5 = other items or directories left unsynced\n")
+	fmt.Fprintf(w, "      --scrub int              sink: re-read and re-hash the whole replica every N days and\n")
+	fmt.Fprintf(w, "                               re-download files whose content silently changed (bit rot,\n")
+	fmt.Fprintf(w, "                               tampering that kept size and mtime). 0 = off (default 0)\n")
 	fmt.Fprintf(w, "      --allow-critical         allow syncing on critical paths (~, /etc, system trees),\n")
 	fmt.Fprintf(w, "                               which are refused outright by default. The first overwrite\n")
 	fmt.Fprintf(w, "                               backs the original up to .local-mirror/backups; deletion\n")
@@ -314,7 +513,31 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "                               .local-mirror/heat.json (like --status; -p or cwd, or --all)\n")
 	fmt.Fprintf(w, "      --show-key               print the key file to the terminal and exit\n")
 	fmt.Fprintf(w, "      --no-encrypt             force plaintext even when a key file exists\n")
-	fmt.Fprintf(w, "      --force                  with --gen-key: overwrite the existing key file\n")
+	fmt.Fprintf(w, "      --force                  with --gen-key: overwrite the existing key file; with\n")
+	fmt.Fprintf(w, "                               --rotate-key: rotate again before the last grace window ends\n")
+	fmt.Fprintf(w, "      --rotate-key             replace the key file with a new key, then exit. The listening\n")
+	fmt.Fprintf(w, "                               end keeps accepting the old key for --grace-days (default 7);\n")
+	fmt.Fprintf(w, "                               dialers that connect with it are handed the new key and save\n")
+	fmt.Fprintf(w, "                               it. A running instance picks the new key up by itself\n")
+	fmt.Fprintf(w, "      --identity               authenticate each peer by its own public key (Noise XX)\n")
+	fmt.Fprintf(w, "                               instead of one shared passphrase; both ends set it. Peers\n")
+	fmt.Fprintf(w, "                               must be listed in .local-mirror/authorized_peers on each\n")
+	fmt.Fprintf(w, "                               side. A -k or key file, if present, is required as well\n")
+	fmt.Fprintf(w, "      --show-identity          print this instance's public key (generated on first use)\n")
+	fmt.Fprintf(w, "                               and the authorized peers, then exit\n")
+	fmt.Fprintf(w, "      --add-peer key           authorize a peer's public key, then exit; --label name\n")
+	fmt.Fprintf(w, "                               gives it a name for logs and --status\n")
+	fmt.Fprintf(w, "      --revoke-peer key|label  remove a peer (public key, label or fingerprint), then exit.\n")
+	fmt.Fprintf(w, "                               A running instance drops the peer's open sessions at once\n")
+	fmt.Fprintf(w, "      --transport noise|tls    transport encryption (default noise). tls wraps the connection\n")
+	fmt.Fprintf(w, "                               in TLS 1.3 with client certificates on both ends; each end\n")
+	fmt.Fprintf(w, "                               accepts the other by --tls-ca or --tls-pin (at least one)\n")
+	fmt.Fprintf(w, "      --tls-cert file          this end's certificate (default .local-mirror/tls.crt)\n")
+	fmt.Fprintf(w, "      --tls-key file           its private key (default .local-mirror/tls.key)\n")
+	fmt.Fprintf(w, "      --tls-ca file            accept peer certificates issued by this CA bundle (PEM)\n")
+	fmt.Fprintf(w, "      --tls-pin sha256,...     accept peer certificates with these SHA-256 fingerprints\n")
+	fmt.Fprintf(w, "      --gen-cert               write a self-signed certificate to .local-mirror/tls.crt and\n")
+	fmt.Fprintf(w, "                               tls.key, print the fingerprint the peer pins, then exit\n")
 	fmt.Fprintf(w, "      --config string          YAML config file (excludes the other flags). A single task\n")
 	fmt.Fprintf(w, "                               runs in this process; two or more get a supervisor with one\n")
 	fmt.Fprintf(w, "                               child each and crash backoff restart. secret: reaches children\n")
@@ -326,7 +549,13 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "Files (under the sync root):\n")
 	fmt.Fprintf(w, "  .local-mirror/key              transport key (600; auto-loaded when -k is omitted,\n")
 	fmt.Fprintf(w, "                                 never synced). Do not delete on the listening side:\n")
-	fmt.Fprintf(w, "                                 regenerating disconnects every dialer\n")
+	fmt.Fprintf(w, "                                 regenerating disconnects every dialer (--rotate-key doesn't)\n")
+	fmt.Fprintf(w, "  .local-mirror/key.previous     the key before --rotate-key and when its grace window ends\n")
+	fmt.Fprintf(w, "  .local-mirror/tls.crt, tls.key self-signed certificate from --gen-cert (key 600, never synced)\n")
+	fmt.Fprintf(w, "  .local-mirror/identity         this instance's private key for --identity (600, never synced)\n")
+	fmt.Fprintf(w, "  .local-mirror/authorized_peers public keys accepted with --identity (\"<key> [label]\" per line)\n")
+	fmt.Fprintf(w, "  .local-mirror/acl              optional per-peer subtree limits on a source\n")
+	fmt.Fprintf(w, "                                 (\"<peer> <path>...\" per line; peer = label or fingerprint, or *)\n")
 	fmt.Fprintf(w, "  .local-mirror/status.json      runtime status, written only while --status watches (discardable)\n")
 	fmt.Fprintf(w, "  .local-mirror/heat.json        directory heat table, written only while --heat watches (discardable)\n")
 	fmt.Fprintf(w, "  .local-mirror/cache.db         directory tree cache (reused across restarts)\n")
@@ -346,17 +575,41 @@ func PrintUsage(w io.Writer) {
 	fmt.Fprintf(w, "  # same, rsync-style positional sugar\n")
 	fmt.Fprintf(w, "  local-mirror ./proj @vps.example.net:%d\n\n", DefaultPort)
 
+	fmt.Fprintf(w, "  # fan-out: push the same tree to three off-site sinks from one process\n")
+	fmt.Fprintf(w, "  local-mirror --send --connect vps-a,vps-b,vps-c -p /srv/data\n\n")
+
 	fmt.Fprintf(w, "  # receive with LAN discovery (interactive pick)\n")
 	fmt.Fprintf(w, "  local-mirror --receive -p /srv/replica\n\n")
 
 	fmt.Fprintf(w, "  # relay: receive from upstream while serving downstream (A -> B -> C)\n")
 	fmt.Fprintf(w, "  local-mirror --send --receive --connect 192.168.1.100 -p /srv/relay\n\n")
 
+	fmt.Fprintf(w, "  # two-way: both workstations edit the same project folder\n")
+	fmt.Fprintf(w, "  ws-a$  local-mirror --bidirectional --connect ws-b -p ~/proj --allow-delete\n")
+	fmt.Fprintf(w, "  ws-b$  local-mirror --bidirectional --connect ws-a -p ~/proj --allow-delete\n\n")
+
 	fmt.Fprintf(w, "  # transport encryption, self-managed key: generate on the listening end,\n")
 	fmt.Fprintf(w, "  # dial in with it once (the dialer saves it and -k can then be omitted)\n")
 	fmt.Fprintf(w, "  local-mirror --gen-key --send\n")
 	fmt.Fprintf(w, "  local-mirror --receive --connect 192.168.1.100 -k <generated-key>\n\n")
 
+	fmt.Fprintf(w, "  # rotate that key later; dialers switch over on their next connection\n")
+	fmt.Fprintf(w, "  local-mirror --rotate-key --grace-days 3\n\n")
+
+	fmt.Fprintf(w, "  # per-peer identities: swap public keys once, revoke one machine later\n")
+	fmt.Fprintf(w, "  # (--show-identity on each end prints the key the other end adds)\n")
+	fmt.Fprintf(w, "  vps$     local-mirror --add-peer <laptop-key> --label laptop -p /srv/backup\n")
+	fmt.Fprintf(w, "  vps$     local-mirror --receive --listen --identity -p /srv/backup\n")
+	fmt.Fprintf(w, "  laptop$  local-mirror --add-peer <vps-key> --label vps\n")
+	fmt.Fprintf(w, "  laptop$  local-mirror --send --connect vps.example.net --identity\n")
+	fmt.Fprintf(w, "  vps$     local-mirror --revoke-peer laptop -p /srv/backup\n\n")
+
+	fmt.Fprintf(w, "  # TLS through middleboxes: self-signed certificates, each end pins the other's\n")
+	fmt.Fprintf(w, "  vps$   local-mirror --gen-cert -p /srv/backup        # prints <vps-fp>\n")
+	fmt.Fprintf(w, "  home$  local-mirror --gen-cert                       # prints <home-fp>\n")
+	fmt.Fprintf(w, "  vps$   local-mirror --receive --listen -p /srv/backup --transport tls --tls-pin <home-fp>\n")
+	fmt.Fprintf(w, "  home$  local-mirror --send --connect vps.example.net --transport tls --tls-pin <vps-fp>\n\n")
+
 	fmt.Fprintf(w, "  # ignore node_modules and all .log files\n")
 	fmt.Fprintf(w, "  local-mirror --send -i \"node_modules,*.log\"\n")
 }
@@ -370,7 +623,12 @@ const (
 	MaxFileBufferSize = 4 << 20 // 4 MiB
 )
 
-// ValidateRuntimeNumbers 校验驱动运行时行为的数值旗子落在合法区间。
+// MaxParallel --parallel 上限：每路下载占一条连接和对端一个文件服务槽
+// （源端全局至多 16 个），再高也换不来吞吐，只会挤占其他汇的份额
+const MaxParallel = 16
+
+// ValidateRuntimeNumbers 校验驱动运行时行为的数值旗子落在合法区间，
+// 并把 --bwlimit、--snapshot-retain、--upstream 解析定型。
 // 覆盖直连 CLI、单任务（applySingleTask 落回同一主流程）、多任务子进程（各自 main）；
 // 多任务父进程不经过这里，由 LoadMultiConfig 对 YAML 值另做 fail-fast 校验。
 func ValidateRuntimeNumbers() error {
@@ -381,6 +639,75 @@ func ValidateRuntimeNumbers() error {
 	if *CoolDown <= 0 {
 		return fmt.Errorf("cooldown (-c) must be a positive number of seconds, got %d", *CoolDown)
 	}
+	if *Parallel < 1 || *Parallel > MaxParallel {
+		return fmt.Errorf("parallel must be between 1 and %d, got %d", MaxParallel, *Parallel)
+	}
+	sched, err := ParseBwLimit(*BwLimit)
+	if err != nil {
+		return err
+	}
+	Bandwidth = sched
+	if *VersionsKeep < 0 {
+		return fmt.Errorf("versions-keep must not be negative, got %d", *VersionsKeep)
+	}
+	if *VersionsMaxAge < 0 {
+		return fmt.Errorf("versions-max-age must not be negative, got %d", *VersionsMaxAge)
+	}
+	retention, err := ParseRetention(*SnapshotRetain)
+	if err != nil {
+		return err
+	}
+	SnapshotRetention = retention
+	ups, err := ParseUpstreams(*UpstreamFlag)
+	if err != nil {
+		return err
+	}
+	Upstreams = ups
+	if SourceDials {
+		targets, err := ParseDialTargets(*RealityIP)
+		if err != nil {
+			return err
+		}
+		DialTargets = targets
+	}
+	includes, err := ParseIncludes(*Include)
+	if err != nil {
+		return err
+	}
+	if len(includes) > 0 && (!SyncsFromUpstream() || TwoWay()) {
+		return fmt.Errorf("--include selects what this end pulls: use it with --receive (sink or relay)")
+	}
+	IncludeList = includes
+	// --dry-run 只规划一路上游的一次拉取：汇监听要等源拨入、多上游各有各的挂载点、
+	// 双向还要看本端的改动，都不在这个范围里
+	if *DryRun && (*Mode != "mirror" || SinkListens || len(Upstreams) > 0) {
+		return fmt.Errorf("--dry-run previews one pull from one source: use it with --receive and --connect (or LAN discovery)")
+	}
+	if *PlanJSON && !*DryRun && !Verify {
+		return fmt.Errorf("--plan-json only applies together with --dry-run or verify")
+	}
+	if Verify && (*Mode != "mirror" || SinkListens || len(Upstreams) > 0 || *DryRun || *Once) {
+		return fmt.Errorf("verify compares this replica with one source: give it --connect (or use LAN discovery), not --send, --listen, --upstream, --dry-run or --once")
+	}
+	if *Repair && !Verify {
+		return fmt.Errorf("--repair only applies to the verify command")
+	}
+	if *ScrubDays < 0 {
+		return fmt.Errorf("scrub must not be negative, got %d", *ScrubDays)
+	}
+	if *ScrubDays > 0 && (!SyncsFromUpstream() || TwoWay() || *Once || *DryRun || Verify) {
+		return fmt.Errorf("--scrub re-hashes a long-running replica: use it with --receive (not --bidirectional, --once, --dry-run or verify)")
+	}
+	if *Transport != "noise" && *Transport != "tls" {
+		return fmt.Errorf("--transport must be noise or tls, got %q", *Transport)
+	}
+	if !TLSTransport() && (*TLSCert != "" || *TLSKey != "" || *TLSCA != "" || *TLSPin != "") {
+		return fmt.Errorf("--tls-cert, --tls-key, --tls-ca and --tls-pin only apply with --transport tls")
+	}
+	// --once 是纯汇的一轮拉取：中继还要常驻服务下游，双向还要常驻监视本端
+	if *Once && (*Mode != "mirror" || *DryRun) {
+		return fmt.Errorf("--once runs one pull and exits: use it with --receive alone (not relay, --bidirectional or --dry-run)")
+	}
 	return nil
 }
 
@@ -399,6 +726,11 @@ func init() {
 	// 默认关闭删除：仅增量同步（create/modify），本地多余文件不删。
 	// 这样源端异常清空不会级联删除下游。完全忠实镜像需显式解锁
 	AllowDelete = flag.Bool("allow-delete", false, "delete local files that no longer exist upstream (off: additive sync only)")
+	DryRun = flag.Bool("dry-run", false, "with --receive: print what a full scan would change, then exit without touching anything")
+	PlanJSON = flag.Bool("plan-json", false, "with --dry-run: print the plan as JSON")
+	Once = flag.Bool("once", false, "with --receive: run one full scan, print a JSON summary and exit")
+	Repair = flag.Bool("repair", false, "verify: re-download files that differ from the source")
+	ScrubDays = flag.Int("scrub", 0, "sink: re-hash the whole replica every N days and repair silent changes (0 = off)")
 
 	AllowCritical = flag.Bool("allow-critical", false, "allow syncing on critical paths (~, /etc, system trees); first overwrite is backed up")
 
@@ -411,9 +743,27 @@ func init() {
 	FileBufferSize = flag.Uint64("filebuffersize", 64*1024, "transfer chunk size in bytes, server side")
 	flag.Uint64Var(FileBufferSize, "f", 64*1024, "alias of --filebuffersize")
 
+	// 并行下载（汇端）：协议单连接单飞行，并行度来自附加连接。默认 1 即原串行行为
+	Parallel = flag.Int("parallel", 1, "files downloaded at once, client side; each extra download opens its own connection")
+
+	// 带宽限制：源端发送与汇端接收各一个令牌桶，速率按时段计划求值（见 bwlimit.go）
+	BwLimit = flag.String("bwlimit", "", "cap file transfer throughput, e.g. \"10MB/s\" or \"2MB/s 09:00-18:00, 20MB/s\"")
+
+	// 版本化回收站（汇端）：覆盖与删除前把旧副本留存到 .local-mirror/versions
+	KeepVersions = flag.Bool("keep-versions", false, "keep old copies of overwritten and deleted files in .local-mirror/versions, client side")
+	VersionsKeep = flag.Int("versions-keep", 10, "versions kept per file with --keep-versions; 0 = unlimited")
+	VersionsMaxAge = flag.Int("versions-max-age", 30, "days a version is kept with --keep-versions; 0 = forever")
+
+	// 时间点快照（汇端）：全量扫描干净结束后物化硬链接快照，按时段档位保留
+	Snapshots = flag.Bool("snapshots", false, "take hardlink snapshots of the replica into .local-mirror/snapshots, client side")
+	SnapshotRetain = flag.String("snapshot-retain", "hourly=24,daily=7,weekly=4", "snapshots kept per time bucket with --snapshots")
+
 	RealityIP = flag.String("realityip", "", "upstream server address (mirror/relay); empty = LAN discovery")
 	flag.StringVar(RealityIP, "r", "", "alias of --realityip")
 
+	// 多上游汇：每路上游一条连接、一个变更游标，共用同步根的状态目录与 --status
+	UpstreamFlag = flag.String("upstream", "", "merge several sources into subdirectories, e.g. \"a=laptop-a,b=laptop-b:52345\" (with --receive)")
+
 	Secret = flag.String("secret", "", "transport encryption passphrase, must match on both ends; empty = plaintext")
 	flag.StringVar(Secret, "k", "", "alias of --secret")
 
@@ -426,7 +776,24 @@ func init() {
 	GenKey = flag.Bool("gen-key", false, "generate a strong random key into .local-mirror/key, print it to the terminal, then exit")
 	ShowKey = flag.Bool("show-key", false, "print the existing key file to the terminal and exit")
 	NoEncrypt = flag.Bool("no-encrypt", false, "force plaintext even when a key file exists")
-	Force = flag.Bool("force", false, "with --gen-key: overwrite an existing key file")
+	Force = flag.Bool("force", false, "with --gen-key: overwrite an existing key file; with --rotate-key: rotate again inside the grace window")
+	RotateKey = flag.Bool("rotate-key", false, "replace the key file with a new key; the old one stays accepted for --grace-days, then exit")
+	GraceDays = flag.Int("grace-days", 7, "with --rotate-key: days the old key is still accepted while dialers pick up the new one")
+
+	// TLS 传输：供只放行 TLS 的网络与已有 X.509 PKI，双向证书认证
+	Transport = flag.String("transport", "noise", "transport encryption: noise (-k / key file / --identity) or tls (certificates)")
+	TLSCert = flag.String("tls-cert", "", "with --transport tls: this end's certificate (PEM); default .local-mirror/tls.crt")
+	TLSKey = flag.String("tls-key", "", "with --transport tls: the certificate's private key (PEM); default .local-mirror/tls.key")
+	TLSCA = flag.String("tls-ca", "", "with --transport tls: accept peers whose certificate chains to this CA bundle (PEM)")
+	TLSPin = flag.String("tls-pin", "", "with --transport tls: accept peers whose certificate has one of these SHA-256 fingerprints (comma-separated)")
+	GenCert = flag.Bool("gen-cert", false, "generate a self-signed certificate into .local-mirror/tls.crt and tls.key, print its fingerprint, then exit")
+
+	// 身份模式：每实例一把静态密钥对，对端按公钥逐个授权、逐个吊销
+	Identity = flag.Bool("identity", false, "authenticate peers by public key (Noise XX) against .local-mirror/authorized_peers")
+	ShowIdentity = flag.Bool("show-identity", false, "print this instance's public key and the authorized peers, then exit")
+	AddPeer = flag.String("add-peer", "", "authorize a peer's public key (from its --show-identity), then exit")
+	PeerLabel = flag.String("label", "", "with --add-peer: a name for the peer, shown in logs and --status")
+	RevokePeer = flag.String("revoke-peer", "", "remove a peer by public key, label or fingerprint, then exit")
 
 	// 运维观测：读取常驻进程写下的 .local-mirror/status.json 并渲染后退出
 	Status = flag.Bool("status", false, "print the running instance's status (from .local-mirror/status.json) and exit")
@@ -444,6 +811,8 @@ func init() {
 
 	Ignore = flag.String("ignore", "", "extra ignore patterns, comma-separated")
 	flag.StringVar(Ignore, "i", "", "alias of --ignore")
+	Gitignore = flag.Bool("gitignore", false, "also honour .gitignore files at any depth, with full gitignore syntax")
+	Include = flag.String("include", "", "sync only paths matching these patterns, comma-separated (sink side)")
 
 	ConfigFile = flag.String("config", "", "YAML config file; a single task runs in-process, two or more under a supervisor; excludes other flags")
 
@@ -451,8 +820,10 @@ func init() {
 	// -m 降级为废弃别名。方向 --send/--receive × 传输 --connect/--listen
 	SendFlag = flag.Bool("send", false, "this directory is the source: data flows out")
 	ReceiveFlag = flag.Bool("receive", false, "this directory is the sink: data flows in")
-	ConnectTo = flag.String("connect", "", "dial the peer at host[:port]; the peer must be listening")
+	ConnectTo = flag.String("connect", "", "dial the peer at host[:port]; the peer must be listening (a source may list several)")
 	ListenFlag = flag.Bool("listen", false, "wait for the peer to dial in")
+	// 双向同步：本端既是源也是汇，恒监听，另以 --connect 拨向对端
+	Bidirectional = flag.Bool("bidirectional", false, "two-way sync with the peer: both ends serve and pull, conflicts keep both versions")
 
 	Version = flag.Bool("version", false, "show version")
 	flag.BoolVar(Version, "v", false, "alias of --version")
@@ -104,3 +104,65 @@ func TestIgnoreInvalidPattern(t *testing.T) {
 		t.Fatal("invalid glob pattern should error")
 	}
 }
+
+// TestIgnoreGitignore --gitignore 时 IsIgnored 在忽略列表之外另按各层 .gitignore 判定；
+// 关掉后重载即恢复只看列表
+func TestIgnoreGitignore(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("/dist\nlogs/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", ".gitignore"), []byte("*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldFlag, oldGit, oldList := *Ignore, *Gitignore, IgnoreFileList
+	t.Cleanup(func() {
+		*Ignore, *Gitignore, IgnoreFileList = oldFlag, oldGit, oldList
+		gitIgnore = nil
+	})
+	*Ignore, *Gitignore = "", true
+	if err := LoadIgnoreList(root); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".git", true, true}, // 列表照常生效
+		{"dist", true, true},
+		{"sub/dist", true, false},
+		{"logs", true, true},
+		{"logs", false, false},
+		{"sub/a.tmp", false, true},
+		{"a.tmp", false, false},
+	}
+	for _, c := range cases {
+		if got := IsIgnored(filepath.FromSlash(c.path), c.isDir); got != c.want {
+			t.Errorf("IsIgnored(%q, dir=%v) = %v, want %v", c.path, c.isDir, got, c.want)
+		}
+	}
+
+	// 改了 sub/.gitignore：改动前的快照仍按旧规则，之后按新规则
+	if err := os.WriteFile(filepath.Join(root, "sub", ".gitignore"), []byte("*.bak\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, now := GitignoreChanged("sub")
+	if !before.Ignored(filepath.Join("sub", "a.tmp"), false) || now.Ignored(filepath.Join("sub", "a.tmp"), false) {
+		t.Error("sub/.gitignore 改动前后对 a.tmp 的判定应不同")
+	}
+	if !IsIgnored(filepath.Join("sub", "a.bak"), false) {
+		t.Error("改动后应按新规则忽略 a.bak")
+	}
+
+	*Gitignore = false
+	if err := LoadIgnoreList(root); err != nil {
+		t.Fatal(err)
+	}
+	if IsIgnored("dist", true) {
+		t.Error("关掉 --gitignore 后 .gitignore 不应再生效")
+	}
+}
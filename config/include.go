@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"local-mirror/pkg/utils"
+)
+
+// ParseIncludes 解析 --include（订阅范围，选择性同步）。逗号分隔，每项是锚定在
+// 同步根的 / 分隔路径模式：段内 * ? []，独占一段的 ** 匹配零到多段，开头的 / 可省，
+// 命中的目录连同整棵子树都在范围内（匹配见 utils.IsIncluded）。
+// 不能有 ".." 段（订阅不出同步根），不能碰 .local-mirror。空串返回 nil = 不限
+func ParseIncludes(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var patterns []string
+	for _, part := range strings.Split(s, ",") {
+		raw := strings.TrimSpace(part)
+		p := strings.Trim(filepath.ToSlash(raw), "/")
+		if p == "" || p == "." {
+			return nil, fmt.Errorf("include: empty pattern in %q", s)
+		}
+		for _, seg := range strings.Split(p, "/") {
+			switch {
+			case seg == "..":
+				return nil, fmt.Errorf("include: pattern %q leaves the sync root", raw)
+			case seg == ".local-mirror":
+				return nil, fmt.Errorf("include: pattern %q is inside the state directory", raw)
+			case strings.Contains(seg, "**") && seg != "**":
+				return nil, fmt.Errorf("include: ** must be a whole path segment in %q", raw)
+			}
+			if _, err := path.Match(seg, "x"); err != nil {
+				return nil, fmt.Errorf("include: invalid pattern %q: %w", raw, err)
+			}
+		}
+		if utils.IncludeGlobstars(p) > utils.MaxIncludeGlobstars {
+			return nil, fmt.Errorf("include: pattern %q has more than %d ** segments", raw, utils.MaxIncludeGlobstars)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIncludes(t *testing.T) {
+	got, err := ParseIncludes(" projects/foo/** , /docs/*.pdf,photos/2024/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"projects/foo/**", "docs/*.pdf", "photos/2024"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got, err := ParseIncludes(""); err != nil || got != nil {
+		t.Fatalf("empty = %q, %v; want nil, nil", got, err)
+	}
+
+	bad := map[string]string{
+		"a,,b":                   "empty pattern",
+		"/":                      "empty pattern",
+		"../x":                   "leaves the sync root",
+		"a/../../x":              "leaves the sync root",
+		".local-mirror/ignore":   "state directory",
+		"docs/**.pdf":            "whole path segment",
+		"docs/[a":                "invalid pattern",
+		"**/a/**/b/**/c/**/d/**": "more than 4",
+	}
+	for in, msg := range bad {
+		if _, err := ParseIncludes(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseIncludes(%q) = %v, want error containing %q", in, err, msg)
+		}
+	}
+}
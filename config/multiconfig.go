@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"local-mirror/internal/safety"
 
@@ -16,30 +17,75 @@ import (
 // TaskConfig 多任务配置中的单个任务，字段与命令行旗子一一对应。
 // 监督进程把它映射为子进程的 argv（secret 例外，走 stdin 首行）。
 //
-// 方向优先字段（send/receive/connect/listen）与 CLI 的 --send/--receive/
-// --connect/--listen 对齐，是文档化的写法；老的 mode/realityip 仍被解析
+// 方向优先字段（send/receive/bidirectional/connect/listen）与 CLI 的 --send/--receive/
+// --bidirectional/--connect/--listen 对齐，是文档化的写法；老的 mode/realityip 仍被解析
 // 以兼容既有 yml，但不再出现在文档里，且不能与方向字段混用
 type TaskConfig struct {
 	Name string `yaml:"name"` // 实例别名（-a），缺省取 path 的 basename，须唯一
 	Path string `yaml:"path"` // 同步工作目录（-p，必填）
 
 	// 方向优先（文档化）
-	Send    bool   `yaml:"send"`    // 本端是源：数据流出
-	Receive bool   `yaml:"receive"` // 本端是汇：数据流入（send+receive = 中继）
-	Connect string `yaml:"connect"` // 拨向对端 host[:port]；对端须在监听
-	Listen  bool   `yaml:"listen"`  // 等对端拨入（汇监听格）
+	Send    bool     `yaml:"send"`    // 本端是源：数据流出
+	Receive bool     `yaml:"receive"` // 本端是汇：数据流入（send+receive = 中继）
+	Connect PeerList `yaml:"connect"` // 拨向对端 host[:port]；对端须在监听。纯源可给列表（扇出推送）
+	Listen  bool     `yaml:"listen"`  // 等对端拨入（汇监听格）
+
+	// 双向同步（--bidirectional）：两端互为源与汇，与 send/receive/listen 互斥
+	Bidirectional bool `yaml:"bidirectional"`
+
+	// 多上游汇（--upstream）：每项 "子目录=host[:port]"，与 connect/listen 互斥
+	Upstreams []string `yaml:"upstreams"`
 
 	// 遗留兼容（不文档化）：解析后归一到 Mode/RealityIP，与方向字段互斥
 	Mode      string `yaml:"mode"`      // reality / mirror / relay
 	RealityIP string `yaml:"realityip"` // 上游地址
 
-	Ignore         []string `yaml:"ignore"`         // 忽略模式（-i）
-	Secret         string   `yaml:"secret"`         // 传输加密口令（经 stdin 传给子进程，不进 argv 也不进环境变量）
-	LogLevel       string   `yaml:"loglevel"`       // 日志级别（-l）
-	AllowDelete    bool     `yaml:"allow_delete"`   // 删除同步（--allow-delete）
-	AllowCritical  bool     `yaml:"allow_critical"` // 允许在关键路径上同步（--allow-critical）
-	CoolDown       int64    `yaml:"cooldown"`       // 全量扫描间隔（-c）
-	FileBufferSize uint64   `yaml:"filebuffersize"` // 传输分块（-f）
+	Ignore         []string `yaml:"ignore"`           // 忽略模式（-i）
+	Include        []string `yaml:"include"`          // 订阅范围（--include，选择性同步，仅汇端）
+	Gitignore      bool     `yaml:"gitignore"`        // 另按各层 .gitignore 忽略（--gitignore）
+	Secret         string   `yaml:"secret"`           // 传输加密口令（经 stdin 传给子进程，不进 argv 也不进环境变量）
+	Identity       bool     `yaml:"identity"`         // 按公钥逐个认证对端（--identity）
+	Transport      string   `yaml:"transport"`        // 传输加密：noise（默认）或 tls（--transport）
+	TLSCert        string   `yaml:"tls_cert"`         // 本端证书（--tls-cert）
+	TLSKey         string   `yaml:"tls_key"`          // 证书私钥（--tls-key）
+	TLSCA          string   `yaml:"tls_ca"`           // 信任的 CA（--tls-ca）
+	TLSPin         []string `yaml:"tls_pin"`          // 钉住的对端证书指纹（--tls-pin）
+	LogLevel       string   `yaml:"loglevel"`         // 日志级别（-l）
+	AllowDelete    bool     `yaml:"allow_delete"`     // 删除同步（--allow-delete）
+	AllowCritical  bool     `yaml:"allow_critical"`   // 允许在关键路径上同步（--allow-critical）
+	CoolDown       int64    `yaml:"cooldown"`         // 全量扫描间隔（-c）
+	FileBufferSize uint64   `yaml:"filebuffersize"`   // 传输分块（-f）
+	Parallel       int      `yaml:"parallel"`         // 并行下载数（--parallel）
+	BwLimit        string   `yaml:"bwlimit"`          // 带宽限制计划（--bwlimit）
+	KeepVersions   bool     `yaml:"keep_versions"`    // 版本化回收站（--keep-versions）
+	VersionsKeep   int      `yaml:"versions_keep"`    // 每个文件保留的版本数（--versions-keep；-1 = 不限）
+	VersionsMaxAge int      `yaml:"versions_max_age"` // 版本保留天数（--versions-max-age；-1 = 永久）
+	Snapshots      bool     `yaml:"snapshots"`        // 时间点快照（--snapshots）
+	SnapshotRetain string   `yaml:"snapshot_retain"`  // 快照保留档位（--snapshot-retain）
+}
+
+// PeerList connect 的取值：单个地址（connect: vps-a）或地址列表
+// （connect: [vps-a, vps-b]，纯源扇出推送到多个汇）
+type PeerList []string
+
+// UnmarshalYAML 标量与序列两种写法都收
+func (l *PeerList) UnmarshalYAML(n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		*l = nil
+		if n.ShortTag() != "!!null" && n.Value != "" {
+			*l = PeerList{n.Value}
+		}
+		return nil
+	case yaml.SequenceNode:
+		var peers []string
+		if err := n.Decode(&peers); err != nil {
+			return err
+		}
+		*l = peers
+		return nil
+	}
+	return fmt.Errorf("line %d: connect must be an address or a list of addresses", n.Line)
 }
 
 // MultiConfig --config 指定的 YAML 顶层结构
@@ -135,9 +181,26 @@ func LoadMultiConfig(path string) (*MultiConfig, error) {
 			}
 		}
 
+		switch t.Transport {
+		case "", "noise", "tls":
+		default:
+			return nil, fmt.Errorf("task %q: transport must be noise or tls, got %q", t.Name, t.Transport)
+		}
+		if t.Transport != "tls" && (t.TLSCert != "" || t.TLSKey != "" || t.TLSCA != "" || len(t.TLSPin) > 0) {
+			return nil, fmt.Errorf("task %q: tls_cert, tls_key, tls_ca and tls_pin only apply with transport: tls", t.Name)
+		}
+		// 证书路径与 path 一样按启动目录定成绝对路径，子进程不论在哪都指向同一份文件
+		for _, f := range []*string{&t.TLSCert, &t.TLSKey, &t.TLSCA} {
+			if *f != "" {
+				if *f, err = filepath.Abs(*f); err != nil {
+					return nil, fmt.Errorf("task %q: cannot resolve path: %w", t.Name, err)
+				}
+			}
+		}
+
 		// 数值范围 fail-fast（CFG-01）：父进程在此拒绝越界值，不必等子进程起来才报错。
 		// YAML 里 0 = "沿用默认"（监督进程省略该旗、子进程回落内置默认），故 filebuffersize
-		// 只校验非零值；cooldown 只拒负数（0 同样是"用默认"）
+		// 只校验非零值；cooldown 只拒负数、parallel 只拒负数与超上限（0 同样是"用默认"）
 		if t.FileBufferSize != 0 && (t.FileBufferSize < MinFileBufferSize || t.FileBufferSize > MaxFileBufferSize) {
 			return nil, fmt.Errorf("task %q: filebuffersize must be between %d and %d bytes, got %d",
 				t.Name, MinFileBufferSize, MaxFileBufferSize, t.FileBufferSize)
@@ -145,6 +208,40 @@ func LoadMultiConfig(path string) (*MultiConfig, error) {
 		if t.CoolDown < 0 {
 			return nil, fmt.Errorf("task %q: cooldown must not be negative, got %d", t.Name, t.CoolDown)
 		}
+		if t.Parallel < 0 || t.Parallel > MaxParallel {
+			return nil, fmt.Errorf("task %q: parallel must be between 1 and %d, got %d", t.Name, MaxParallel, t.Parallel)
+		}
+		if _, err := ParseBwLimit(t.BwLimit); err != nil {
+			return nil, fmt.Errorf("task %q: %w", t.Name, err)
+		}
+		// 版本保留：0 沿用默认，CLI 的「0 = 不限」在 YAML 里写作 -1
+		if t.VersionsKeep < -1 {
+			return nil, fmt.Errorf("task %q: versions_keep must be -1 (unlimited) or more, got %d", t.Name, t.VersionsKeep)
+		}
+		if t.SnapshotRetain != "" {
+			if _, err := ParseRetention(t.SnapshotRetain); err != nil {
+				return nil, fmt.Errorf("task %q: %w", t.Name, err)
+			}
+		}
+		if t.VersionsMaxAge < -1 {
+			return nil, fmt.Errorf("task %q: versions_max_age must be -1 (forever) or more, got %d", t.Name, t.VersionsMaxAge)
+		}
+		if len(t.Include) > 0 {
+			if t.Mode != "mirror" && t.Mode != "relay" {
+				return nil, fmt.Errorf("task %q: include selects what a sink pulls: use it with receive", t.Name)
+			}
+			if _, err := ParseIncludes(strings.Join(t.Include, ",")); err != nil {
+				return nil, fmt.Errorf("task %q: %w", t.Name, err)
+			}
+		}
+		if len(t.Upstreams) > 0 {
+			if t.Mode != "mirror" || t.RealityIP != "" || t.Listen {
+				return nil, fmt.Errorf("task %q: upstreams merge sources into a sink: use them with receive alone, without connect/listen", t.Name)
+			}
+			if _, err := ParseUpstreams(strings.Join(t.Upstreams, ",")); err != nil {
+				return nil, fmt.Errorf("task %q: %w", t.Name, err)
+			}
+		}
 	}
 	return &cfg, nil
 }
@@ -153,7 +250,7 @@ func LoadMultiConfig(path string) (*MultiConfig, error) {
 // 的 Mode/RealityIP，供后续计数、聚合展示与 argv 映射复用。语义与 CLI 的
 // resolveDirection 对齐：两套词汇不可混用；未给方向即报错。
 func resolveTaskDirection(t *TaskConfig, n int) error {
-	hasDir := t.Send || t.Receive || t.Connect != "" || t.Listen
+	hasDir := t.Send || t.Receive || t.Bidirectional || len(t.Connect) > 0 || t.Listen
 	hasLegacy := t.Mode != "" || t.RealityIP != ""
 	if !hasDir {
 		return nil // 老词汇：Mode/RealityIP 原样交给下游校验
@@ -161,9 +258,26 @@ func resolveTaskDirection(t *TaskConfig, n int) error {
 	if hasLegacy {
 		return fmt.Errorf("task %d: direction fields (send/receive/connect/listen) cannot be mixed with mode/realityip", n)
 	}
-	if t.Connect != "" && t.Listen {
+	if len(t.Connect) > 0 && t.Listen {
 		return fmt.Errorf("task %d: connect and listen are mutually exclusive on one link", n)
 	}
+	connect := strings.Join(t.Connect, ",")
+	if len(t.Connect) > 1 {
+		if !t.Send || t.Receive || t.Bidirectional {
+			return fmt.Errorf("task %d: a connect list fans a source out to several sinks: use it with send alone", n)
+		}
+		if _, err := ParseDialTargets(connect); err != nil {
+			return fmt.Errorf("task %d: %w", n, err)
+		}
+	}
+	if t.Bidirectional {
+		if t.Send || t.Receive || t.Listen || len(t.Upstreams) > 0 {
+			return fmt.Errorf("task %d: bidirectional already makes this end both source and sink: drop send/receive/listen/upstreams", n)
+		}
+		t.Mode = "bidirectional"
+		t.RealityIP = connect
+		return nil
+	}
 	switch {
 	case t.Send && t.Receive:
 		t.Mode = "relay"
@@ -174,14 +288,14 @@ func resolveTaskDirection(t *TaskConfig, n int) error {
 	default:
 		return fmt.Errorf("task %d: connect/listen need a direction: add send (source) or receive (sink)", n)
 	}
-	t.RealityIP = t.Connect
+	t.RealityIP = connect
 	return nil
 }
 
 // applyDefaults 任务字段为零值时回退到 defaults 的同名字段。
 // name/direction/path 是任务身份，不参与回退
 func applyDefaults(t, d *TaskConfig) {
-	if t.Connect == "" {
+	if len(t.Connect) == 0 {
 		t.Connect = d.Connect
 	}
 	if t.RealityIP == "" {
@@ -193,6 +307,24 @@ func applyDefaults(t, d *TaskConfig) {
 	if t.Secret == "" {
 		t.Secret = d.Secret
 	}
+	if !t.Identity {
+		t.Identity = d.Identity
+	}
+	if t.Transport == "" {
+		t.Transport = d.Transport
+	}
+	if t.TLSCert == "" {
+		t.TLSCert = d.TLSCert
+	}
+	if t.TLSKey == "" {
+		t.TLSKey = d.TLSKey
+	}
+	if t.TLSCA == "" {
+		t.TLSCA = d.TLSCA
+	}
+	if len(t.TLSPin) == 0 {
+		t.TLSPin = d.TLSPin
+	}
 	if t.LogLevel == "" {
 		t.LogLevel = d.LogLevel
 	}
@@ -208,4 +340,28 @@ func applyDefaults(t, d *TaskConfig) {
 	if t.FileBufferSize == 0 {
 		t.FileBufferSize = d.FileBufferSize
 	}
+	if t.Parallel == 0 {
+		t.Parallel = d.Parallel
+	}
+	if t.BwLimit == "" {
+		t.BwLimit = d.BwLimit
+	}
+	if !t.Gitignore {
+		t.Gitignore = d.Gitignore
+	}
+	if !t.KeepVersions {
+		t.KeepVersions = d.KeepVersions
+	}
+	if t.VersionsKeep == 0 {
+		t.VersionsKeep = d.VersionsKeep
+	}
+	if t.VersionsMaxAge == 0 {
+		t.VersionsMaxAge = d.VersionsMaxAge
+	}
+	if !t.Snapshots {
+		t.Snapshots = d.Snapshots
+	}
+	if t.SnapshotRetain == "" {
+		t.SnapshotRetain = d.SnapshotRetain
+	}
 }
@@ -73,6 +73,10 @@ func TestLoadMultiConfigErrors(t *testing.T) {
 		// CFG-01：数值越界 fail-fast（非零 filebuffersize 出界、cooldown 负数）
 		"filebuffersize too small": {"tasks:\n  - mode: reality\n    path: /tmp/x\n    filebuffersize: 100", "filebuffersize must be between"},
 		"negative cooldown":        {"tasks:\n  - mode: reality\n    path: /tmp/x\n    cooldown: -5", "cooldown must not be negative"},
+		"parallel too large":       {"tasks:\n  - mode: mirror\n    path: /tmp/x\n    parallel: 64", "parallel must be between"},
+		"bad bwlimit":              {"tasks:\n  - mode: mirror\n    path: /tmp/x\n    bwlimit: fast", "bwlimit: invalid rate"},
+		"bad snapshot_retain":      {"tasks:\n  - mode: mirror\n    path: /tmp/x\n    snapshot_retain: monthly=3", "unknown bucket"},
+		"versions_keep below -1":   {"tasks:\n  - mode: mirror\n    path: /tmp/x\n    versions_keep: -3", "versions_keep must be"},
 	}
 	for name, c := range cases {
 		_, err := LoadMultiConfig(writeYAML(t, c.yml))
@@ -107,11 +111,19 @@ tasks:
     receive: true
     connect: 10.0.0.9
     path: /tmp/dd
+  - name: twoway
+    bidirectional: true
+    connect: ws-b
+    path: /tmp/de
+  - name: fanout
+    send: true
+    connect: [vps-a, "vps-b:52345"]
+    path: /tmp/df
 `))
 	if err != nil {
 		t.Fatalf("LoadMultiConfig: %v", err)
 	}
-	src, sink, sinklisten, relay := cfg.Tasks[0], cfg.Tasks[1], cfg.Tasks[2], cfg.Tasks[3]
+	src, sink, sinklisten, relay, twoway, fanout := cfg.Tasks[0], cfg.Tasks[1], cfg.Tasks[2], cfg.Tasks[3], cfg.Tasks[4], cfg.Tasks[5]
 	if src.Mode != "reality" {
 		t.Errorf("send → reality, got %q", src.Mode)
 	}
@@ -124,6 +136,12 @@ tasks:
 	if relay.Mode != "relay" || relay.RealityIP != "10.0.0.9" {
 		t.Errorf("send+receive → relay, got mode=%q ip=%q", relay.Mode, relay.RealityIP)
 	}
+	if twoway.Mode != "bidirectional" || twoway.RealityIP != "ws-b" {
+		t.Errorf("bidirectional wrong: mode=%q ip=%q", twoway.Mode, twoway.RealityIP)
+	}
+	if fanout.Mode != "reality" || fanout.RealityIP != "vps-a,vps-b:52345" {
+		t.Errorf("send+connect list wrong: mode=%q ip=%q", fanout.Mode, fanout.RealityIP)
+	}
 }
 
 // TestLoadMultiConfigDirectionErrors 方向字段的非法组合
@@ -137,6 +155,17 @@ func TestLoadMultiConfigDirectionErrors(t *testing.T) {
 		"connect+listen": {"tasks:\n  - receive: true\n    connect: 1.2.3.4\n    listen: true\n    path: /tmp/x", "mutually exclusive"},
 		"no direction":   {"tasks:\n  - connect: 1.2.3.4\n    path: /tmp/x", "need a direction"},
 		"nothing at all": {"tasks:\n  - path: /tmp/x", "specify a direction"},
+		"upstreams+send": {"tasks:\n  - send: true\n    receive: true\n    upstreams: [a=h1]\n    path: /tmp/x", "receive alone"},
+		"upstreams+conn": {"tasks:\n  - receive: true\n    connect: h0\n    upstreams: [a=h1]\n    path: /tmp/x", "receive alone"},
+		"upstreams nest": {"tasks:\n  - receive: true\n    upstreams: [a=h1, a/b=h2]\n    path: /tmp/x", "overlap"},
+		"two-way+send":   {"tasks:\n  - bidirectional: true\n    send: true\n    path: /tmp/x", "both source and sink"},
+		"two-way+listen": {"tasks:\n  - bidirectional: true\n    listen: true\n    path: /tmp/x", "both source and sink"},
+		"fan-out sink":   {"tasks:\n  - receive: true\n    connect: [h1, h2]\n    path: /tmp/x", "send alone"},
+		"fan-out relay":  {"tasks:\n  - send: true\n    receive: true\n    connect: [h1, h2]\n    path: /tmp/x", "send alone"},
+		"include+send":   {"tasks:\n  - send: true\n    include: [docs/**]\n    path: /tmp/x", "use it with receive"},
+		"include escape": {"tasks:\n  - receive: true\n    include: [../etc]\n    path: /tmp/x", "leaves the sync root"},
+		"fan-out dup":    {"tasks:\n  - send: true\n    connect: [h1, h1]\n    path: /tmp/x", "listed twice"},
+		"connect map":    {"tasks:\n  - send: true\n    connect: {a: h1}\n    path: /tmp/x", "list of addresses"},
 	}
 	for name, c := range cases {
 		_, err := LoadMultiConfig(writeYAML(t, c.yml))
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Retention 解析后的 --snapshot-retain：最近 Hourly 个小时、Daily 天、Weekly 周里
+// 各留每个时段最新的一个快照。按档挑选的逻辑在 snapshot.Retention
+type Retention struct {
+	Hourly, Daily, Weekly int
+}
+
+// ParseRetention 解析 --snapshot-retain："hourly=24,daily=7,weekly=4"，未写的档为 0
+func ParseRetention(s string) (Retention, error) {
+	var r Retention
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || n < 0 {
+			return Retention{}, fmt.Errorf("snapshot retention: invalid entry %q, want e.g. \"hourly=24,daily=7,weekly=4\"", part)
+		}
+		switch strings.TrimSpace(k) {
+		case "hourly":
+			r.Hourly = n
+		case "daily":
+			r.Daily = n
+		case "weekly":
+			r.Weekly = n
+		default:
+			return Retention{}, fmt.Errorf("snapshot retention: unknown bucket %q (hourly, daily or weekly)", k)
+		}
+	}
+	if r.Hourly == 0 && r.Daily == 0 && r.Weekly == 0 {
+		return Retention{}, errors.New("snapshot retention keeps nothing; set at least one of hourly, daily, weekly")
+	}
+	return r, nil
+}
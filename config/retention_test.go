@@ -0,0 +1,15 @@
+package config
+
+import "testing"
+
+func TestParseRetention(t *testing.T) {
+	r, err := ParseRetention(" hourly=2, daily=2")
+	if err != nil || r != (Retention{Hourly: 2, Daily: 2}) {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+	for _, bad := range []string{"", "hourly=0", "monthly=3", "daily=-1", "daily"} {
+		if _, err := ParseRetention(bad); err == nil {
+			t.Errorf("%q 应被拒", bad)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Upstream 多上游汇（--upstream）里的一路上游：它的整棵树挂在同步根下的 Dir 子目录里
+type Upstream struct {
+	Dir  string // 同步根下的相对子目录（本机分隔符）
+	Addr string // host[:port]，语义同 --connect
+}
+
+// ParseUpstreams 解析 --upstream。语法为逗号分隔的 `<子目录>=<host[:port]>`：
+//
+//	a=laptop-a,b=laptop-b:52345    laptop-a 落到 ./a/，laptop-b 落到 ./b/
+//
+// 子目录须是同步根内的相对路径，不能是根本身、不能进 .local-mirror，
+// 彼此不能相同或嵌套（嵌套时一路上游的删除会波及另一路）。空串返回 nil
+func ParseUpstreams(s string) ([]Upstream, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var ups []Upstream
+	for _, part := range strings.Split(s, ",") {
+		dir, addr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		dir, addr = strings.TrimSpace(dir), strings.TrimSpace(addr)
+		if !ok || dir == "" || addr == "" {
+			return nil, fmt.Errorf("upstream: invalid entry %q, want \"<subdir>=<host[:port]>\"", strings.TrimSpace(part))
+		}
+		clean := filepath.Clean(filepath.FromSlash(dir))
+		if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("upstream: subdir %q must be a relative path inside the sync root", dir)
+		}
+		if first := strings.SplitN(clean, string(filepath.Separator), 2)[0]; first == ".local-mirror" {
+			return nil, fmt.Errorf("upstream: subdir %q is inside the state directory", dir)
+		}
+		for _, u := range ups {
+			if nestedDirs(u.Dir, clean) {
+				return nil, fmt.Errorf("upstream: subdirs %q and %q overlap", u.Dir, clean)
+			}
+		}
+		ups = append(ups, Upstream{Dir: clean, Addr: addr})
+	}
+	return ups, nil
+}
+
+// nestedDirs a 与 b 相同或一方位于另一方之内（均为清洗过的相对路径）
+func nestedDirs(a, b string) bool {
+	sep := string(filepath.Separator)
+	return a == b || strings.HasPrefix(a, b+sep) || strings.HasPrefix(b, a+sep)
+}
+
+// ParseDialTargets 解析源拨出的 --connect。逗号分隔的多个 host[:port] 即扇出推送：
+// 同一棵树、同一个 watcher，向每个汇各跑一路独立的拨号循环。
+// 不能有空项或重复项（重复的目标会让同一个汇被推两遍）。空串返回 nil
+func ParseDialTargets(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var targets []string
+	for _, part := range strings.Split(s, ",") {
+		addr := strings.TrimSpace(part)
+		if addr == "" {
+			return nil, fmt.Errorf("connect: empty target in %q", s)
+		}
+		for _, t := range targets {
+			if t == addr {
+				return nil, fmt.Errorf("connect: target %q is listed twice", addr)
+			}
+		}
+		targets = append(targets, addr)
+	}
+	return targets, nil
+}
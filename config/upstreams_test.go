@@ -0,0 +1,57 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	ups, err := ParseUpstreams(" a=laptop-a , team/b=10.0.0.2:52345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Upstream{{Dir: "a", Addr: "laptop-a"}, {Dir: filepath.Join("team", "b"), Addr: "10.0.0.2:52345"}}
+	if len(ups) != len(want) || ups[0] != want[0] || ups[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", ups, want)
+	}
+	if ups, err := ParseUpstreams(""); err != nil || ups != nil {
+		t.Fatalf("empty = %+v, %v; want nil, nil", ups, err)
+	}
+
+	bad := map[string]string{
+		"a":               "invalid entry",
+		"a=":              "invalid entry",
+		"=host":           "invalid entry",
+		".=host":          "relative path inside the sync root",
+		"../x=host":       "relative path inside the sync root",
+		"/abs=host":       "relative path inside the sync root",
+		".local-mirror=h": "state directory",
+		"a=h1,a/b=h2":     "overlap",
+		"a=h1,./a=h2":     "overlap",
+	}
+	for in, msg := range bad {
+		if _, err := ParseUpstreams(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseUpstreams(%q) = %v, want error containing %q", in, err, msg)
+		}
+	}
+}
+
+func TestParseDialTargets(t *testing.T) {
+	targets, err := ParseDialTargets(" vps-a , vps-b:52345,[2001:db8::1]:52345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"vps-a", "vps-b:52345", "[2001:db8::1]:52345"}
+	if strings.Join(targets, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", targets, want)
+	}
+	if targets, err := ParseDialTargets(""); err != nil || targets != nil {
+		t.Fatalf("empty = %q, %v; want nil, nil", targets, err)
+	}
+	for in, msg := range map[string]string{"a,,b": "empty target", "a,": "empty target", "a,b,a": "listed twice"} {
+		if _, err := ParseDialTargets(in); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ParseDialTargets(%q) = %v, want error containing %q", in, err, msg)
+		}
+	}
+}
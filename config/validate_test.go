@@ -2,11 +2,15 @@ package config
 
 import "testing"
 
-// TestValidateRuntimeNumbers 验证 CFG-01 的数值域校验：-f 0 / 过大、-c 0 / 负数都被拒，
+// TestValidateRuntimeNumbers 验证 CFG-01 的数值域校验：-f 0 / 过大、-c 0 / 负数、--parallel 越界、版本保留数/天数为负、快照保留档位全空都被拒，
 // 默认值合法。这是直连 CLI / 单任务 / 多任务子进程共用的启动闸门。
 func TestValidateRuntimeNumbers(t *testing.T) {
-	saveBuf, saveCd := FileBufferSize, CoolDown
-	defer func() { FileBufferSize, CoolDown = saveBuf, saveCd }()
+	saveBuf, saveCd, savePar := FileBufferSize, CoolDown, Parallel
+	saveKeep, saveAge := VersionsKeep, VersionsMaxAge
+	defer func() {
+		FileBufferSize, CoolDown, Parallel = saveBuf, saveCd, savePar
+		VersionsKeep, VersionsMaxAge = saveKeep, saveAge
+	}()
 	set := func(buf uint64, cd int64) {
 		b, c := buf, cd
 		FileBufferSize, CoolDown = &b, &c
@@ -32,4 +36,29 @@ func TestValidateRuntimeNumbers(t *testing.T) {
 	if err := ValidateRuntimeNumbers(); err == nil {
 		t.Error("cooldown 负数应被拒")
 	}
+	set(64*1024, 1800)
+	for _, p := range []int{0, MaxParallel + 1} {
+		n := p
+		Parallel = &n
+		if err := ValidateRuntimeNumbers(); err == nil {
+			t.Errorf("parallel=%d 应被拒", p)
+		}
+	}
+	one := 1
+	Parallel = &one
+	for _, v := range []*int{VersionsKeep, VersionsMaxAge} {
+		old := *v
+		*v = -1
+		if err := ValidateRuntimeNumbers(); err == nil {
+			t.Error("版本保留数/天数为负应被拒")
+		}
+		*v = old
+	}
+	saveRetain := SnapshotRetain
+	defer func() { SnapshotRetain = saveRetain }()
+	empty := "hourly=0"
+	SnapshotRetain = &empty
+	if err := ValidateRuntimeNumbers(); err == nil {
+		t.Error("一个快照都不保留的档位应被拒")
+	}
 }
@@ -2,10 +2,13 @@ package app
 
 import (
 	"local-mirror/config"
+	"local-mirror/internal/keyfile"
+	"local-mirror/internal/network"
 	"local-mirror/internal/tree"
 	"local-mirror/internal/watcher"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 
 	"github.com/fsnotify/fsnotify"
@@ -13,25 +16,16 @@ import (
 )
 
 func App() {
+	if *config.Gitignore {
+		watchGitignores() // 须在 watcher 启动前挂上
+	}
 	if err := tree.BuildFileTree(config.StartPath); err != nil {
 		log.Fatalf("failed to build file tree: %v", err)
 	}
 
 	switch *config.Mode {
 	case "reality":
-		_watcher, err := fsnotify.NewWatcher()
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer func() {
-			log.Info("shutting down watcher...")
-			if err := _watcher.Close(); err != nil {
-				log.Errorf("error closing watcher: %v", err)
-			}
-		}()
-		if err := watcher.InitWatcher(_watcher); err != nil {
-			log.Fatalf("failed to init watcher: %v", err)
-		}
+		defer startWatcher()()
 		// 四象限：数据方向相同（本端是源），传输方向二选一
 		if config.SourceDials {
 			go RealityDial()
@@ -39,9 +33,12 @@ func App() {
 			go Reality()
 		}
 	case "mirror":
-		if config.SinkListens {
+		switch {
+		case config.SinkListens:
 			go MirrorListen()
-		} else {
+		case len(config.Upstreams) > 0:
+			go MirrorUpstreams()
+		default:
 			go Mirror()
 		}
 	case "relay":
@@ -51,11 +48,68 @@ func App() {
 		// 比 watcher 更精确，且不受 tier2 冷目录轮询延迟影响
 		go Reality()
 		go Mirror()
+	case "bidirectional":
+		// 双向同步 = 源端全套（watcher 维护本地树、服务端供对端拉取）＋ 汇引擎
+		// 拨向对端。与中继不同，本地目录也有人直接编辑，必须有 watcher；
+		// 两侧的改动如何取舍由汇引擎按共同祖先判定（见 twoway.go）
+		defer startWatcher()()
+		go Reality()
+		go Mirror()
 	default:
-		log.Fatalf("unknown mode: %s (valid: reality, mirror, relay)", *config.Mode)
+		log.Fatalf("unknown mode: %s (valid: reality, mirror, relay, bidirectional)", *config.Mode)
+	}
+
+	// 忽略规则热重载：改 .local-mirror/ignore 即生效，SIGHUP 手动触发同一流程
+	if stop, err := WatchFile(filepath.Join(config.StartPath, ".local-mirror", "ignore"), ReloadIgnores); err != nil {
+		log.Warnf("cannot watch the ignore file, reload it with SIGHUP instead: %v", err)
+	} else {
+		defer stop()
+	}
+
+	// 身份模式：授权名单一变（--revoke-peer 或手改）就断开已被吊销对端的现有会话；
+	// 新连接每次握手都现读名单，不需要这里
+	if *config.Identity {
+		if stop, err := WatchFile(keyfile.PeersPath(config.StartPath), network.DropRevoked); err != nil {
+			log.Warnf("cannot watch the authorized peers file, revoked peers keep their open sessions until they reconnect: %v", err)
+		} else {
+			defer stop()
+		}
+	}
+
+	// 密钥轮换：另一进程里执行的 --rotate-key 改写密钥文件后，监听端即刻换用新 key
+	// 并在宽限期内兼收旧 key。显式 -k 的实例不跟随文件
+	if config.SecretFromKeyFile && config.TransportListens() {
+		if stop, err := WatchFile(keyfile.Path(config.StartPath), network.ReloadKeys); err != nil {
+			log.Warnf("cannot watch the key file, restart after --rotate-key to use the new key: %v", err)
+		} else {
+			defer stop()
+		}
 	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			return
+		}
+		log.Info("SIGHUP received, reloading ignore rules")
+		hupReload()
+	}
+}
+
+// startWatcher 启动 fsnotify 监视器维护本地树，返回的函数在退出时关闭它
+func startWatcher() func() {
+	_watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := watcher.InitWatcher(_watcher); err != nil {
+		log.Fatalf("failed to init watcher: %v", err)
+	}
+	return func() {
+		log.Info("shutting down watcher...")
+		if err := _watcher.Close(); err != nil {
+			log.Errorf("error closing watcher: %v", err)
+		}
+	}
 }
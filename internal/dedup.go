@@ -0,0 +1,123 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"local-mirror/config"
+	"local-mirror/internal/safety"
+	"local-mirror/internal/status"
+	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
+	"local-mirror/pkg/utils"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 本地去重：vendored 依赖、拷贝的素材等让同一份内容在树里出现多次。汇端下载前
+// 上游哈希已知，若本地树里已有同哈希的普通文件（hash_index），直接复制一份到位，
+// 不走网络。
+//
+// 用复制而非硬链接：硬链接共享权限/属主/mtime，applyMeta 对一处生效会改到另一处，
+// 本地对其中一份的就地修改也会波及另一份，镜像语义承受不起
+
+// copyFromLocal 尝试用本地同哈希文件满足 v 的 create/modify，满足了返回 true。
+// 与 applyRename 同样不信任索引里的哈希：复制到临时文件后对副本重算哈希，
+// 对不上（本地文件被外部改过而树未更新）就换下一个候选，全不行则返回 false
+// 由调用方照常下载。覆盖前的留存失败返回错误，放弃这次覆盖
+func copyFromLocal(v DiffResult) (bool, error) {
+	cands, err := tree.PathsByHash(v.Hash)
+	if err != nil || len(cands) == 0 {
+		return false, nil
+	}
+	fullPath, err := safety.SafeResolve(config.StartPath, v.Path)
+	if err != nil {
+		return false, nil // 交给下载路径按越界统一拒绝
+	}
+	tmpDir := filepath.Join(config.StartPath, ".local-mirror", "partial")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return false, nil
+	}
+	tmp := filepath.Join(tmpDir, utils.HashString(v.Path)+".dedup")
+	defer os.Remove(tmp)
+
+	src := ""
+	for _, c := range cands {
+		if c == v.Path {
+			continue
+		}
+		cFull, err := safety.SafeResolve(config.StartPath, c)
+		if err != nil {
+			continue
+		}
+		if err := copyRegular(cFull, tmp); err != nil {
+			log.Debugf("cannot copy %s for %s: %v", c, v.Path, err)
+			continue
+		}
+		if err := verifyLocalHash(tmp, v.Hash); err != nil {
+			log.Debugf("local copy %s drifted from its recorded hash, not using it for %s", c, v.Path)
+			continue
+		}
+		src = c
+		break
+	}
+	if src == "" {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return true, err
+	}
+	// 覆盖前的留存与下载落盘（network.FileClient）一致：失败即放弃本次覆盖
+	if config.SnapshotOverwrites {
+		if err := safety.SnapshotBeforeOverwrite(config.StartPath, v.Path, fullPath); err != nil {
+			return true, fmt.Errorf("backing up the original failed, skipping overwrite of %s: %w", v.Path, err)
+		}
+	}
+	if *config.KeepVersions {
+		if err := versions.Preserve(config.StartPath, v.Path, time.Now(), versionPolicy()); err != nil {
+			return true, fmt.Errorf("keeping the old version failed, skipping overwrite of %s: %w", v.Path, err)
+		}
+	}
+	if err := safety.VerifyNoSymlinkComponents(config.StartPath, v.Path); err != nil {
+		return true, fmt.Errorf("refusing to write %s: %w", v.Path, err)
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		return true, fmt.Errorf("placing local copy at %s: %w", v.Path, err)
+	}
+
+	applyModTime(v)
+	if err := tree.AddNodes([]*tree.Node{createNodeFromDiff(v, v.Hash)}); err != nil {
+		return true, err
+	}
+	status.RecordDedup(v.Path, v.Size)
+	log.Infof("File copied from local duplicate %s: %s (no download)", src, v.Path)
+	return true, nil
+}
+
+// copyRegular 把普通文件 src 的内容复制到 dst（覆盖），不跟随 src 为符号链接的情况
+func copyRegular(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file: %s", src)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
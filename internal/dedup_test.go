@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+	"local-mirror/pkg/utils"
+)
+
+// TestCopyFromLocalDuplicate 本地树里已有同哈希文件时，create 直接复制满足
+// （fileClient 为 nil，走到下载即 panic）；本地副本被外部改过（与登记哈希不符）
+// 则不拿来用
+func TestCopyFromLocalDuplicate(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+
+	src := filepath.Join(root, "vendor", "lib.js")
+	os.MkdirAll(filepath.Dir(src), 0o755)
+	if err := os.WriteFile(src, []byte("shared"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(root, "app"), 0o755)
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := utils.CalcBlake3(src)
+	hash := fmt.Sprintf("%x", h)
+
+	dst := filepath.Join("app", "lib.js")
+	if err := processFileDiff(DiffResult{Path: dst, Name: "lib.js", Action: "create", Size: 6, Hash: hash}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, dst)); string(b) != "shared" {
+		t.Fatalf("复制后的内容不对: %q", b)
+	}
+	if b, _ := os.ReadFile(src); string(b) != "shared" {
+		t.Fatal("源副本应原样保留")
+	}
+	paths, err := tree.PathsByHash(hash)
+	if err != nil || len(paths) != 2 {
+		t.Fatalf("两份副本都应进哈希索引: %v %v", paths, err)
+	}
+
+	// 两份都被外部改过：索引里的哈希不再可信，不能拿来满足
+	os.WriteFile(src, []byte("drift1"), 0o644)
+	os.WriteFile(filepath.Join(root, dst), []byte("drift2"), 0o644)
+	if done, _ := copyFromLocal(DiffResult{Path: "other.js", Name: "other.js", Action: "create", Size: 6, Hash: hash}); done {
+		t.Fatal("漂移的本地副本不应被使用")
+	}
+
+	if err := tree.DeleteNode("vendor"); err != nil {
+		t.Fatal(err)
+	}
+	if paths, _ := tree.PathsByHash(hash); len(paths) != 1 || paths[0] != dst {
+		t.Fatalf("删除子树后哈希索引应随之清理: %v", paths)
+	}
+}
@@ -1,28 +1,48 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"local-mirror/config"
+	"local-mirror/internal/fsmeta"
 	"local-mirror/internal/tree"
 	"time"
 )
 
 type DiffResult struct {
-	Path    string    `json:"path"`
-	IsDir   bool      `json:"is_dir"` // 是否为目录
-	Action  string    `json:"action"` // "create", "delete", "modify"
-	Name    string    `json:"name"`
-	Size    uint64    `json:"size"`     // 文件大小
-	Hash    string    `json:"hash"`     // 文件内容哈希（create/modify 取服务端，delete 取本地）
-	ModTime time.Time `json:"mod_time"` // 源文件修改时间，用于镜像端保真
+	Path    string       `json:"path"`
+	IsDir   bool         `json:"is_dir"` // 是否为目录
+	Action  string       `json:"action"` // "create", "delete", "modify", "retype", "meta"
+	Name    string       `json:"name"`
+	Size    uint64       `json:"size"`           // 文件大小
+	Hash    string       `json:"hash"`           // 文件内容哈希（create/modify 取服务端，delete 取本地）
+	ModTime time.Time    `json:"mod_time"`       // 源文件修改时间，用于镜像端保真
+	Meta    *fsmeta.Meta `json:"meta,omitempty"` // 元数据（协商了 FeatureMetadata 时才有），delete 取本地
 }
 
-// FindDifferences 比较两个树结构，以 a（服务端）为基准
-func FindDifferences(a, b []tree.Node) []DiffResult {
+// IsSymlink 该项是否是符号链接（create/modify 为上游类型，delete 为本地类型）
+func (d DiffResult) IsSymlink() bool {
+	return d.Meta != nil && d.Meta.Link != ""
+}
+
+// FindDifferences 比较两个树结构，以 a（服务端）为基准。
+// withMeta 为会话是否协商了 FeatureMetadata：未协商时上游页里没有元数据与符号链接，
+// 本地的符号链接节点整体不参与比较（否则会被当成上游已删除），元数据也不比
+func FindDifferences(a, b []tree.Node, withMeta bool) []DiffResult {
 	var diffs []DiffResult
 
 	// 将b转换为map以便快速查找
 	bMap := make(map[string]tree.Node)
 	aMap := make(map[string]tree.Node)
+	if !withMeta {
+		local := make([]tree.Node, 0, len(b))
+		for _, node := range b {
+			if !node.IsSymlink() {
+				local = append(local, node)
+			}
+		}
+		b = local
+	}
 	for _, node := range b {
 		bMap[node.Path] = node
 	}
@@ -39,13 +59,15 @@ func FindDifferences(a, b []tree.Node) []DiffResult {
 				Size:    nodeA.Size,
 				Hash:    nodeA.Hash,
 				ModTime: nodeA.ModTime,
+				Meta:    nodeA.Meta,
 			})
 			continue
 		}
 		// 类型互换（文件↔目录，COR-03）：同一路径但 IsDir 不同，必须先删旧类型再建新类型，
 		// 不能当普通 modify——os.Rename 覆盖不了目录、MkdirAll 撞同名文件都会失败。独立成
-		// retype 动作，且优先于大小/哈希比较：否则大小碰巧相同、哈希又不可比时会完全漏掉
-		if nodeA.IsDir != nodeB.IsDir {
+		// retype 动作，且优先于大小/哈希比较：否则大小碰巧相同、哈希又不可比时会完全漏掉。
+		// 符号链接与文件/目录之间的互换同理
+		if nodeA.IsDir != nodeB.IsDir || nodeA.IsSymlink() != nodeB.IsSymlink() {
 			diffs = append(diffs, DiffResult{
 				Path:    nodeA.Path,
 				IsDir:   nodeA.IsDir, // 目标（新）类型
@@ -54,6 +76,7 @@ func FindDifferences(a, b []tree.Node) []DiffResult {
 				Size:    nodeA.Size,
 				Hash:    nodeA.Hash,
 				ModTime: nodeA.ModTime,
+				Meta:    nodeA.Meta,
 			})
 			continue
 		}
@@ -68,6 +91,21 @@ func FindDifferences(a, b []tree.Node) []DiffResult {
 				Size:    nodeA.Size,
 				Hash:    nodeA.Hash,
 				ModTime: nodeA.ModTime,
+				Meta:    nodeA.Meta,
+			})
+			continue
+		}
+		// 内容一致、只有元数据（权限/属主/扩展属性）不同：就地施加，不必重新下载
+		if withMeta && fsmeta.Differs(nodeA.Meta, nodeB.Meta, nodeA.IsDir) {
+			diffs = append(diffs, DiffResult{
+				Path:    nodeA.Path,
+				IsDir:   nodeA.IsDir,
+				Action:  "meta",
+				Name:    nodeA.Name,
+				Size:    nodeA.Size,
+				Hash:    nodeA.Hash,
+				ModTime: nodeA.ModTime,
+				Meta:    nodeA.Meta,
 			})
 		}
 	}
@@ -82,6 +120,7 @@ func FindDifferences(a, b []tree.Node) []DiffResult {
 				Size:    nodeB.Size,
 				Hash:    nodeB.Hash,
 				ModTime: nodeB.ModTime,
+				Meta:    nodeB.Meta,
 			})
 		}
 	}
@@ -89,11 +128,13 @@ func FindDifferences(a, b []tree.Node) []DiffResult {
 	return diffs
 }
 
-// Diff 用服务端目录列表与本地数据库中的同名目录比对，返回差异列表
-func Diff(realityNodes []tree.Node, path string) ([]DiffResult, error) {
+// Diff 用服务端目录列表与本地数据库中的同名目录比对，返回差异列表。
+// 双向同步会下钻本端已删除的目录（见 twoway.go 的 descendDir），--dry-run 会下钻计划中
+// 才新建的目录，本地都没有这一层，按空目录比
+func Diff(realityNodes []tree.Node, path string, withMeta bool) ([]DiffResult, error) {
 	localTree, err := tree.GetDirContents(path)
-	if err != nil {
+	if err != nil && !((config.TwoWay() || dryRun != nil) && errors.Is(err, tree.ErrDirNotFound)) {
 		return nil, fmt.Errorf("failed to get local tree contents: %w", err)
 	}
-	return FindDifferences(realityNodes, localTree), nil
+	return FindDifferences(realityNodes, localTree, withMeta), nil
 }
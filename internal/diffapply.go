@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"local-mirror/config"
 	"local-mirror/internal/appError"
+	"local-mirror/internal/fsmeta"
 	"local-mirror/internal/network"
 	"local-mirror/internal/safety"
 	"local-mirror/internal/status"
 	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
 	"local-mirror/pkg/utils"
 	"os"
 	"path/filepath"
@@ -31,12 +33,15 @@ func createNodeFromDiff(v DiffResult, hash string) *tree.Node {
 		log.Warnf("Parent node not found for %s: %v", v.Path, err)
 	}
 	// ModTime 必须取磁盘上的真实值：启动校准按 size+mtime 判断哈希可否复用，
-	// 记下载时刻会导致重启后所有文件都被误判为已变化而重算哈希
+	// 记下载时刻会导致重启后所有文件都被误判为已变化而重算哈希。
+	// 用 Lstat：符号链接记链接本身，绝不解引用
 	modTime := time.Now()
-	if info, err := os.Stat(filepath.Join(config.StartPath, v.Path)); err == nil {
+	full := filepath.Join(config.StartPath, v.Path)
+	info, statErr := os.Lstat(full)
+	if statErr == nil {
 		modTime = info.ModTime()
 	}
-	return &tree.Node{
+	node := &tree.Node{
 		ID:       uuid,
 		Path:     v.Path,
 		Name:     v.Name,
@@ -47,6 +52,16 @@ func createNodeFromDiff(v DiffResult, hash string) *tree.Node {
 		Hash:     hash,
 		Depth:    strings.Count(v.Path, string(filepath.Separator)),
 	}
+	// 元数据同样取磁盘现状（非 root 汇端施加不了属主，记的是本地真实属主），
+	// 与 BuildFileTree 口径一致，下一轮比较才不会检出假差异
+	if statErr == nil {
+		target := ""
+		if v.IsSymlink() {
+			target = filepath.FromSlash(v.Meta.Link)
+		}
+		tree.FillMeta(node, full, info, target)
+	}
+	return node
 }
 
 // processDiffItem handles a single diff item (file or directory)
@@ -60,11 +75,17 @@ func processDiffItem(v DiffResult, fileClient *network.FileClient) error {
 			log.Debugf("skipping deletion (--allow-delete off): %s", v.Path)
 			return nil
 		}
-		full, err := safety.SafeResolve(config.StartPath, v.Path)
+		// 末段允许是符号链接：RemoveAll 删的是链接本身，不跟随到目标
+		full, err := safety.SafeResolveEntry(config.StartPath, v.Path)
 		if err != nil {
 			log.Errorf("refusing to delete out-of-root path: %v", err)
 			return nil
 		}
+		// 有哈希的文件先进移动检测池，别的目录稍后创建同内容文件时本地 rename 过去
+		stageDeleted(v)
+		if err := stashVersions(v.Path); err != nil {
+			return err
+		}
 		if err := os.RemoveAll(full); err == nil {
 			tree.DeleteNode(v.Path)
 			return nil
@@ -80,22 +101,28 @@ func processDiffItem(v DiffResult, fileClient *network.FileClient) error {
 			warnRetypeOnce(v.Path)
 			return nil
 		}
-		full, err := safety.SafeResolve(config.StartPath, v.Path)
+		full, err := safety.SafeResolveEntry(config.StartPath, v.Path)
 		if err != nil {
 			log.Errorf("refusing to retype out-of-root path: %v", err)
 			return nil
 		}
 		// RemoveAll 对文件和目录（含子树）都适用；随后清掉本地树里的旧节点及其子树
+		if err := stashVersions(v.Path); err != nil {
+			return err
+		}
 		if err := os.RemoveAll(full); err != nil {
 			return err
 		}
 		if err := tree.DeleteNode(v.Path); err != nil {
 			return err
 		}
-		// 建新类型：目录直接建，文件走正常下载（上游哈希缺失同 create 分支跳过）
+		// 建新类型：目录直接建，链接本地建，文件走正常下载（上游哈希缺失同 create 分支跳过）
 		if v.IsDir {
 			return processDirectoryDiff(v)
 		}
+		if v.IsSymlink() {
+			return processSymlinkDiff(v)
+		}
 		if v.Hash == "" {
 			warnUnreadableOnce(v.Path)
 			return nil
@@ -106,6 +133,9 @@ func processDiffItem(v DiffResult, fileClient *network.FileClient) error {
 		if v.IsDir {
 			return processDirectoryDiff(v)
 		}
+		if v.IsSymlink() {
+			return processSymlinkDiff(v)
+		}
 		// 上游哈希缺失 = 服务端自己都读不了这个文件（扫描/监听时哈希失败，
 		// 典型是权限问题），下载注定失败——确定性跳过并明确告知，而不是发一个
 		// 注定失败的请求。节点仍在上游树里，本地已有副本因此不会被
@@ -115,14 +145,71 @@ func processDiffItem(v DiffResult, fileClient *network.FileClient) error {
 			warnUnreadableOnce(v.Path)
 			return nil
 		}
+		if v.Action == "create" {
+			if moved, err := claimMove(v); moved {
+				return err
+			}
+		}
 		return processFileDiff(v, fileClient)
 
+	case "meta":
+		// 内容未变，只对齐权限/属主/扩展属性；节点按磁盘现状更新（哈希沿用）。
+		// 符号链接作用在链接本身（lchown），末段允许是链接
+		resolve := safety.SafeResolve
+		if v.IsSymlink() {
+			resolve = safety.SafeResolveEntry
+		}
+		full, err := resolve(config.StartPath, v.Path)
+		if err != nil {
+			log.Errorf("refusing to set metadata on out-of-root path: %v", err)
+			return nil
+		}
+		if !v.IsDir && !v.IsSymlink() {
+			if err := detachHardlink(v.Path, full); err != nil {
+				return fmt.Errorf("not setting metadata on %s: %w", v.Path, err)
+			}
+		}
+		applyMeta(full, v)
+		return tree.AddNodes([]*tree.Node{createNodeFromDiff(v, v.Hash)})
+
 	default:
 		log.Warnf("Unknown action type: %s", v.Action)
 		return nil
 	}
 }
 
+// versionPolicy --versions-keep / --versions-max-age 对应的版本保留策略
+func versionPolicy() versions.Policy {
+	return versions.NewPolicy(*config.VersionsKeep, *config.VersionsMaxAge)
+}
+
+// stashVersions --keep-versions 下把即将删除的 rel（文件或整棵子树）移入版本库。
+// 失败即放弃这次删除：返回的错误走单项失败计数，下一轮重试
+func stashVersions(rel string) error {
+	if !*config.KeepVersions {
+		return nil
+	}
+	if err := versions.Stash(config.StartPath, rel, time.Now(), versionPolicy()); err != nil {
+		return fmt.Errorf("keeping deleted version of %s: %w", rel, err)
+	}
+	return nil
+}
+
+// pruneVersions 对整个版本库执行保留策略（启动时与每轮全量扫描后）。
+// 逐次留存只修剪被触及的路径，过期但再没被改动过的版本靠这里清
+func pruneVersions() {
+	if !*config.KeepVersions {
+		return
+	}
+	n, err := versions.Prune(config.StartPath, versionPolicy(), time.Now())
+	if err != nil {
+		log.Warnf("pruning kept versions: %v", err)
+	}
+	if n > 0 {
+		log.Infof("pruned %d expired versions from %s", n, versions.Dir(config.StartPath))
+	}
+}
+
 func processDirectoryDiff(v DiffResult) error {
 	// v.Path 来自服务端，必须校验拼接后仍在同步根内，防止 ".." 越界建目录
 	fullPath, err := safety.SafeResolve(config.StartPath, v.Path)
@@ -133,6 +220,7 @@ func processDirectoryDiff(v DiffResult) error {
 	if err := os.MkdirAll(fullPath, 0755); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
 	}
+	applyMeta(fullPath, v)
 
 	// AddNodes 对已存在路径按更新处理，无需先查询
 	node := createNodeFromDiff(v, "")
@@ -149,6 +237,7 @@ const diskReserve uint64 = 64 << 20 // 64 MB
 var unreadableWarned sync.Map
 
 func warnUnreadableOnce(path string) {
+	tallyItem(path, itemPermission) // 提示只一次，没同步成却每次都算
 	if _, loaded := unreadableWarned.LoadOrStore(path, struct{}{}); !loaded {
 		log.Errorf("upstream cannot read %s (server failed to hash it, usually a permission problem); skipping. Sync resumes automatically once fixed upstream", path)
 	}
@@ -160,10 +249,51 @@ var retypeWarned sync.Map
 
 func warnRetypeOnce(path string) {
 	if _, loaded := retypeWarned.LoadOrStore(path, struct{}{}); !loaded {
-		log.Warnf("%s changed type (file<->directory) upstream; applying it requires removing the old one, which --allow-delete governs. Skipping (local copy kept as-is). Enable --allow-delete for a faithful mirror", path)
+		log.Warnf("%s changed type (file, directory or symlink) upstream; applying it requires removing the old one, which --allow-delete governs. Skipping (local copy kept as-is). Enable --allow-delete for a faithful mirror", path)
 	}
 }
 
+// processSymlinkDiff 建立或改指符号链接。目标来自对端，属不可信输入：经 safety
+// 再校验一遍，以清洗后的目标建链接。先在状态目录里建好再 rename 到位，
+// 替换旧链接是原子的，也不会让中继端的 watcher 看到半成品
+func processSymlinkDiff(v DiffResult) error {
+	full, err := safety.SafeResolveEntry(config.StartPath, v.Path)
+	if err != nil {
+		log.Errorf("refusing to create out-of-root symlink: %v", err)
+		return nil
+	}
+	target, err := safety.SymlinkTarget(config.StartPath, v.Path, v.Meta.Link)
+	if err != nil {
+		log.Errorf("refusing to create symlink: %v", err)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	tmpDir := filepath.Join(config.StartPath, ".local-mirror", "partial")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(tmpDir, utils.HashString(v.Path)+".link")
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return fmt.Errorf("creating symlink %s: %w", v.Path, err)
+	}
+	if err := os.Rename(tmp, full); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("placing symlink %s: %w", v.Path, err)
+	}
+	applyMeta(full, v)
+
+	// 节点按上游清洗后的目标记（与源端入树口径一致），哈希由 FillMeta 填写
+	v.Meta = &fsmeta.Meta{Link: filepath.ToSlash(target), UID: v.Meta.UID, GID: v.Meta.GID}
+	if err := tree.AddNodes([]*tree.Node{createNodeFromDiff(v, "")}); err != nil {
+		return err
+	}
+	log.Infof("Symlink created: %s -> %s", v.Path, target)
+	return nil
+}
+
 func humanBytes(b uint64) string {
 	switch {
 	case b >= 1<<30:
@@ -182,6 +312,10 @@ func processFileDiff(v DiffResult, fileClient *network.FileClient) error {
 		return fmt.Errorf("%w: %s needs %s but only %s is free (reserve %s)",
 			appError.ErrDiskFull, v.Path, humanBytes(v.Size), humanBytes(free), humanBytes(diskReserve))
 	}
+	// 本地已有同内容文件（vendored 依赖、拷贝的素材）就复制过来，免去下载
+	if done, err := copyFromLocal(v); done {
+		return err
+	}
 
 	hash, err := fileClient.DownloadFile(v.Path)
 	if err != nil {
@@ -227,9 +361,11 @@ func recordChangedDir(relPath string) {
 	tree.AddRecentChangedDir(filepath.Dir(relPath))
 }
 
-// applyModTime 将本地文件的修改时间对齐到服务端源文件
+// applyModTime 将本地文件的元数据（权限/属主/扩展属性，会话协商了 FeatureMetadata
+// 时才有）与修改时间对齐到服务端源文件。chmod/chown/setxattr 都不改 mtime，
+// 先施加元数据、最后 Chtimes，磁盘上的 mtime 即上游值
 func applyModTime(v DiffResult) {
-	if v.ModTime.IsZero() {
+	if v.ModTime.IsZero() && v.Meta == nil {
 		return
 	}
 	full, err := safety.SafeResolve(config.StartPath, v.Path)
@@ -237,7 +373,53 @@ func applyModTime(v DiffResult) {
 		log.Errorf("refusing to set mtime on out-of-root path: %v", err)
 		return
 	}
+	// 移动（applyRename）落位的文件仍是原 inode，可能与快照、旧版本共用
+	if !v.IsDir && !v.IsSymlink() {
+		if err := detachHardlink(v.Path, full); err != nil {
+			log.Warnf("not setting metadata on %s: %v", v.Path, err)
+			return
+		}
+	}
+	applyMeta(full, v)
+	if v.ModTime.IsZero() {
+		return
+	}
 	if err := os.Chtimes(full, v.ModTime, v.ModTime); err != nil {
 		log.Warnf("Failed to set mtime for %s: %v", v.Path, err)
 	}
 }
+
+// detachHardlink 让 full 独占自己的 inode 再施加元数据。链接计数大于 1 说明它与
+// --snapshots 快照或 --keep-versions 旧版本共用 inode（二者都靠硬链接留存），
+// 而 chmod/chown/setxattr 改的是 inode：原地施加会连带改写本应不可变的快照与旧版本。
+// 做法同 copyFromLocal：复制到 .local-mirror/partial 再改名覆盖，内容与 mtime 不变
+func detachHardlink(rel, full string) error {
+	info, err := os.Lstat(full)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || fsmeta.LinkCount(info) <= 1 {
+		return nil
+	}
+	tmpDir := filepath.Join(config.StartPath, ".local-mirror", "partial")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(tmpDir, utils.HashString(rel)+".detach")
+	defer os.Remove(tmp)
+	if err := copyRegular(full, tmp); err != nil {
+		return fmt.Errorf("copying out of its shared inode: %w", err)
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, full)
+}
+
+// applyMeta 施加 v 携带的元数据。失败只告警：内容已经落盘，元数据不全好过整项
+// 重试；差异会在下一轮比较中以 meta 动作再次检出
+func applyMeta(full string, v DiffResult) {
+	if err := fsmeta.Apply(full, v.Meta, v.IsDir); err != nil {
+		log.Warnf("Failed to apply metadata for %s: %v", v.Path, err)
+	}
+}
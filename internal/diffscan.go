@@ -9,9 +9,11 @@ import (
 	"local-mirror/internal/safety"
 	"local-mirror/internal/status"
 	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
 	"local-mirror/pkg/utils"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -51,68 +53,118 @@ func dirGone(err error, path string) error {
 func getDirectory(fileClient *network.FileClient, path string, recurseAll bool, itemFailures map[string]int, blacklist map[string]bool) error {
 	// 客户端忽略：命中忽略列表的目录整体跳过（变更追踪可能推来
 	// 忽略目录内的深层路径，连目录列表请求都不必发）
-	if utils.IsIgnored(path, config.IgnoreFileList) {
+	if config.IsIgnored(path, true) {
 		log.Debugf("skipping ignored directory: %s", path)
 		return nil
 	}
+	// 选择性同步：订阅范围外、也不通向范围内任何路径的目录同样整体跳过
+	if !utils.IsIncluded(path, true, config.IncludeList) {
+		log.Debugf("skipping directory outside --include: %s", path)
+		return nil
+	}
 	// 树响应按页下发并在客户端内聚合（超大目录不再撞消息体上限），
 	// 返回的节点路径已是本机分隔符格式
-	realityNodes, err := fileClient.GetRealityTree(path)
+	realityNodes, digest, err := fileClient.GetRealityTree(path)
 	if err != nil {
 		if gone := dirGone(err, path); gone != nil {
 			return gone
 		}
 		return handleConnectionError(err, fileClient)
 	}
+	var unchanged map[string]bool
+	if recurseAll {
+		unchanged = unchangedSubtrees(fileClient, path, digest, realityNodes)
+	}
 
-	diffs, err := Diff(realityNodes, path)
+	diffs, err := Diff(realityNodes, path, fileClient.Features()&network.FeatureMetadata != 0)
 	if err != nil {
 		return fmt.Errorf("error analyzing diff for path %s: %w", path, err)
 	}
 
+	// 双向同步：比对结果里两端一致的项记为共同祖先（须在忽略过滤前：
+	// 被过滤掉的项并不一致）
+	twoWay := config.TwoWay()
+	if twoWay {
+		recordCommonBase(path, realityNodes, diffs)
+	}
+
 	// 客户端忽略：命中项从 diff 中整体剔除——create/modify 不下载、
 	// delete 不删除、也不参与后面的重命名配对。服务端未忽略而客户端
 	// 忽略的条目由此对同步完全隐形（本地已有的副本也不会被碰）
 	diffs = filterIgnoredDiffs(diffs)
 
+	diffDirs := make(map[string]bool)
+	if dryRun != nil {
+		// --dry-run：diff 只记进计划，不落盘也不改树。有 diff 的目录按计划决定是否
+		// 下钻（如未开 --allow-delete 时被拒的 retype 不下钻），其余照常
+		for _, v := range diffs {
+			if v.IsDir {
+				diffDirs[v.Path] = true
+			}
+		}
+		for _, v := range dryRun.add(diffs) {
+			NextLevel.Push(v)
+		}
+		if recurseAll {
+			pushSubdirs(realityNodes, diffDirs, unchanged)
+		}
+		return nil
+	}
+
+	// 双向同步：按共同祖先剔掉本地一侧的改动、处理冲突（见 twoway.go）
+	var descend []DiffResult
+	if twoWay {
+		diffs, descend = reconcileTwoWay(diffs)
+	}
+
 	// 保真：就地重命名的文件走本地 rename，免整文件重新下载（COR-02，门控见 maybeDetectRenames）
 	diffs = maybeDetectRenames(diffs)
 
 	log.Infof("Diff count for %s: %d", path, len(diffs))
-	diffDirs := make(map[string]bool)
+	for _, v := range descend {
+		diffDirs[v.Path] = true
+		NextLevel.Push(v)
+	}
 	diskFullSkipped := 0
+	// --parallel > 1 时文件下载留到最后分给多条连接并行；目录/删除/改名等本地操作
+	// 仍按原顺序串行执行（量小且彼此可能有先后依赖，如 retype 先删后建）
+	parallel := *config.Parallel > 1
+	var downloads []DiffResult
 	for _, v := range diffs {
 		if blacklist[v.Path] {
 			// 已确认持续失败，本轮不再尝试，让其余正常项能被处理到
 			continue
 		}
+		if deferCreate(v) {
+			continue // 变更追踪中等本批删除都进池后再处理，见 movepool.go
+		}
+		if parallel && isFileDownload(v) {
+			downloads = append(downloads, v)
+			continue
+		}
 		if err := processDiffItem(v, fileClient); err != nil {
-			// 磁盘空间不足：跳过该文件继续处理其余项（小文件可能仍装得下），
-			// 目录处理完后聚合成一条提示，避免逐文件刷屏
-			if errors.Is(err, appError.ErrDiskFull) {
-				diskFullSkipped++
-				log.Debugf("skipped for low disk space: %v", err)
-				continue
-			}
 			// 连接断了：无论是否拉黑，这次调用都不能继续复用这个连接处理
 			// 剩余项，必须整体返回交给上层重连后重试；其他错误跳过单项继续
-			if errors.Is(err, appError.ErrConnection) {
-				itemFailures[v.Path]++
-				if itemFailures[v.Path] > maxItemRetries {
-					blacklist[v.Path] = true
-					log.Errorf("%s failed %d times in a row, giving it up for this round (other files unaffected)", v.Path, itemFailures[v.Path]-1)
-				}
+			if recordItemError(v, err, itemFailures, blacklist, &diskFullSkipped) {
 				return err
 			}
-			log.Errorf("Error processing diff item %v: %v", v, err)
 			continue
 		}
 		recordChangedDir(v.Path)
+		noteGitignore(v.Path)
+		noteSynced(v)
 		if v.IsDir && v.Action != "delete" {
 			diffDirs[v.Path] = true
 			NextLevel.Push(v)
 		}
 	}
+	if len(downloads) > 0 {
+		// 附加连接按需拨建：没有待下载文件的目录不触发拨号
+		workers := fileClient.Workers(*config.Parallel)
+		if err := downloadConcurrently(workers, downloads, itemFailures, blacklist, &diskFullSkipped); err != nil {
+			return err
+		}
+	}
 	if diskFullSkipped > 0 {
 		free, _ := utils.DiskFree(config.StartPath)
 		log.Errorf("directory %s: %d files skipped for low disk space (%s free, %s reserved); they will catch up automatically once space is freed",
@@ -120,35 +172,171 @@ func getDirectory(fileClient *network.FileClient, path string, recurseAll bool,
 	}
 
 	if recurseAll {
-		for _, node := range realityNodes {
-			if node.IsDir && utils.IsIgnored(node.Path, config.IgnoreFileList) {
-				// 忽略目录不下钻（服务端可能没忽略它，树里存在）
-				continue
-			}
-			if node.IsDir && !diffDirs[node.Path] {
-				NextLevel.Push(DiffResult{
-					Path:   node.Path,
-					IsDir:  true,
-					Action: "modify",
-					Name:   node.Name,
-					Size:   node.Size,
-				})
+		pushSubdirs(realityNodes, diffDirs, unchanged)
+	}
+	return nil
+}
+
+// pushSubdirs 全量扫描的下钻：把本层没有经 diff 处理过的子目录压入 NextLevel，
+// 跳过忽略/订阅范围外的目录与摘要一致的子树
+func pushSubdirs(realityNodes []tree.Node, diffDirs, unchanged map[string]bool) {
+	for _, node := range realityNodes {
+		if node.IsDir && (config.IsIgnored(node.Path, true) || !utils.IsIncluded(node.Path, true, config.IncludeList)) {
+			// 忽略目录与订阅范围外的目录不下钻（服务端树里存在）
+			continue
+		}
+		if node.IsDir && unchanged[node.Path] && !diffDirs[node.Path] {
+			log.Debugf("subtree digest matches, not descending into %s", node.Path)
+			digestSkipped++
+			continue
+		}
+		if node.IsDir && !diffDirs[node.Path] {
+			NextLevel.Push(DiffResult{
+				Path:   node.Path,
+				IsDir:  true,
+				Action: "modify",
+				Name:   node.Name,
+				Size:   node.Size,
+			})
+		}
+	}
+}
+
+// digestSkipped 本轮全量扫描因子树摘要一致而没有下钻的目录数（只在任务协程里读写）
+var digestSkipped int
+
+// unchangedSubtrees 全量扫描的剪枝（FeatureDigest）：返回子树摘要与本地一致、
+// 不必再下钻的子目录。本目录摘要整体一致时所有子目录都不必下钻，本层列表仍照常
+// 比对——摘要不含元数据。须在应用本目录的 diff 之前取本地摘要。
+// 双向同步不剪枝：本地一侧的改动由 watcher 随时写进树，摘要只在扫描开始时重算过
+func unchangedSubtrees(fileClient *network.FileClient, path, digest string, realityNodes []tree.Node) map[string]bool {
+	if fileClient.Features()&network.FeatureDigest == 0 || config.TwoWay() {
+		return nil
+	}
+	unchanged := make(map[string]bool)
+	if self, err := tree.GetNodeByPath(path); err == nil && digest != "" && self.Digest == digest {
+		for _, n := range realityNodes {
+			if n.IsDir {
+				unchanged[n.Path] = true
 			}
 		}
+		return unchanged
 	}
-	return nil
+	local, err := tree.GetDirContents(path)
+	if err != nil {
+		return unchanged // 本地还没有这个目录
+	}
+	localDigests := make(map[string]string, len(local))
+	for _, n := range local {
+		if n.IsDir && n.Digest != "" {
+			localDigests[n.Path] = n.Digest
+		}
+	}
+	for _, n := range realityNodes {
+		if n.IsDir && n.Digest != "" && localDigests[n.Path] == n.Digest {
+			unchanged[n.Path] = true
+		}
+	}
+	return unchanged
 }
 
-// filterIgnoredDiffs 剔除命中忽略列表的 diff 项。
+// recordItemError 按单项失败隔离语义归类 processDiffItem 的错误，返回 true 表示
+// 连接类错误、调用方必须停止使用该连接：
+//   - 磁盘空间不足：计入 diskFullSkipped 后跳过（小文件可能仍装得下），
+//     目录处理完后聚合成一条提示，避免逐文件刷屏；
+//   - 连接错误：计入 itemFailures，连续超过 maxItemRetries 次即拉黑；
+//   - 其他错误：记日志跳过该项。
+func recordItemError(v DiffResult, err error, itemFailures map[string]int, blacklist map[string]bool, diskFullSkipped *int) bool {
+	if errors.Is(err, appError.ErrDiskFull) {
+		*diskFullSkipped++
+		tallyItemError(v.Path, err)
+		log.Debugf("skipped for low disk space: %v", err)
+		return false
+	}
+	if errors.Is(err, appError.ErrConnection) {
+		itemFailures[v.Path]++
+		if itemFailures[v.Path] > maxItemRetries {
+			blacklist[v.Path] = true
+			tallyItem(v.Path, itemBlacklisted)
+			log.Errorf("%s failed %d times in a row, giving it up for this round (other files unaffected)", v.Path, itemFailures[v.Path]-1)
+		}
+		return true
+	}
+	tallyItemError(v.Path, err)
+	log.Errorf("Error processing diff item %v: %v", v, err)
+	return false
+}
+
+// isFileDownload 该 diff 项是否为一次普通文件下载（可交给并行连接执行）。
+// 上游哈希缺失的项由 processDiffItem 原地跳过并告警，不占并行名额
+func isFileDownload(v DiffResult) bool {
+	return (v.Action == "create" || v.Action == "modify") && !v.IsDir && !v.IsSymlink() && v.Hash != ""
+}
+
+// downloadConcurrently 把同一目录内的文件下载分给 workers 并行执行（--parallel），
+// 每个 worker 独占一条连接、逐项领取。失败隔离与串行循环一致（recordItemError），
+// 共享状态由 mu 保护。某条连接出错后它所在的 worker 即退出（连接已由
+// processFileDiff 关闭），未领取的项由其余 worker 接着处理；全部结束后返回
+// 首个连接错误，交上层重连后重试本目录——届时已完成的项 diff 为空，不会重复下载
+func downloadConcurrently(workers []*network.FileClient, items []DiffResult, itemFailures map[string]int, blacklist map[string]bool, diskFullSkipped *int) error {
+	var (
+		mu      sync.Mutex
+		next    int
+		connErr error
+		wg      sync.WaitGroup
+	)
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *network.FileClient) {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if next >= len(items) {
+					mu.Unlock()
+					return
+				}
+				v := items[next]
+				next++
+				mu.Unlock()
+
+				err := processDiffItem(v, w)
+				if err == nil {
+					recordChangedDir(v.Path)
+					noteGitignore(v.Path)
+					noteSynced(v)
+					continue
+				}
+				mu.Lock()
+				fatal := recordItemError(v, err, itemFailures, blacklist, diskFullSkipped)
+				if fatal && connErr == nil {
+					connErr = err
+				}
+				mu.Unlock()
+				if fatal {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return connErr
+}
+
+// filterIgnoredDiffs 剔除命中忽略列表、或落在订阅范围（--include）外的 diff 项。
 // 客户端忽略语义：不下载（create/modify）、不删除（delete）——
-// 即便服务端树里有该条目，也当它不存在；本地磁盘上的同名内容原样保留
+// 即便服务端树里有该条目，也当它不存在；本地磁盘上的同名内容原样保留。
+// 范围外的项同理：订阅只决定拉什么，不清理本地已有的其他内容
 func filterIgnoredDiffs(diffs []DiffResult) []DiffResult {
 	kept := diffs[:0]
 	for _, d := range diffs {
-		if utils.IsIgnored(d.Path, config.IgnoreFileList) {
+		if config.IsIgnored(d.Path, d.IsDir) {
 			log.Debugf("ignoring diff item (%s): %s", d.Action, d.Path)
 			continue
 		}
+		if !utils.IsIncluded(d.Path, d.IsDir, config.IncludeList) {
+			log.Debugf("diff item outside --include (%s): %s", d.Action, d.Path)
+			continue
+		}
 		kept = append(kept, d)
 	}
 	return kept
@@ -167,12 +355,25 @@ func maybeDetectRenames(diffs []DiffResult) []DiffResult {
 // detectRenames 在单个目录的 diff 内识别"就地重命名"：一个 delete 与一个
 // create 若指向哈希相同的文件（内容未变、仅换名），直接本地 rename，
 // 避免整文件重新下载。返回消化掉重命名对之后剩余的 diff。
-// 仅处理同目录内的文件（跨目录移动分属不同目录的 diff，无法在此配对）。
+// 仅处理同目录内的文件；跨目录移动分属不同目录的 diff，由移动检测池（movepool.go）配对。
 func detectRenames(diffs []DiffResult) []DiffResult {
+	return matchRenames(diffs, func(oldDiff, newDiff DiffResult) bool {
+		if err := applyRename(oldDiff, newDiff); err != nil {
+			log.Warnf("rename %s -> %s failed, falling back to download: %v", oldDiff.Path, newDiff.Path, err)
+			return false
+		}
+		log.Infof("move detected: %s -> %s (local rename, no download)", oldDiff.Path, newDiff.Path)
+		return true
+	})
+}
+
+// matchRenames detectRenames 的配对逻辑：对每一对哈希相同的 delete/create 调用 apply，
+// 返回 true 即视为已消化；--dry-run 借它把配对记进计划
+func matchRenames(diffs []DiffResult, apply func(oldDiff, newDiff DiffResult) bool) []DiffResult {
 	// 按哈希索引待删除的文件（每个哈希取第一个）
 	delIdxByHash := make(map[string]int)
 	for i, d := range diffs {
-		if d.Action == "delete" && !d.IsDir && d.Hash != "" {
+		if d.Action == "delete" && !d.IsDir && !d.IsSymlink() && d.Hash != "" {
 			if _, exists := delIdxByHash[d.Hash]; !exists {
 				delIdxByHash[d.Hash] = i
 			}
@@ -184,20 +385,18 @@ func detectRenames(diffs []DiffResult) []DiffResult {
 
 	handled := make(map[int]bool)
 	for i, d := range diffs {
-		if d.Action != "create" || d.IsDir || d.Hash == "" {
+		if d.Action != "create" || d.IsDir || d.IsSymlink() || d.Hash == "" {
 			continue
 		}
 		di, ok := delIdxByHash[d.Hash]
 		if !ok || handled[di] || diffs[di].Path == d.Path {
 			continue
 		}
-		if err := applyRename(diffs[di], d); err != nil {
-			log.Warnf("rename %s -> %s failed, falling back to download: %v", diffs[di].Path, d.Path, err)
+		if !apply(diffs[di], d) {
 			continue
 		}
 		handled[i] = true
 		handled[di] = true
-		log.Infof("move detected: %s -> %s (local rename, no download)", diffs[di].Path, d.Path)
 	}
 	if len(handled) == 0 {
 		return diffs
@@ -228,16 +427,18 @@ func applyRename(oldDiff, newDiff DiffResult) error {
 	// 外部改动而 DB 未更新、被换成目录/软链、或已消失），把「错内容」搬到新路径并登记成
 	// 上游哈希会造成静默损坏。校验不过就返回错误——detectRenames 会据此放弃这对配对，
 	// 回落到正常的 delete+download，取到的是上游的正确内容
-	linfo, lerr := os.Lstat(oldFull)
-	if lerr != nil || !linfo.Mode().IsRegular() {
-		return fmt.Errorf("local source not a regular file (drifted or gone): %s", oldDiff.Path)
-	}
-	if h, herr := utils.CalcBlake3(oldFull); herr != nil || fmt.Sprintf("%x", h) != oldDiff.Hash {
-		return fmt.Errorf("local source hash mismatch (drifted): %s", oldDiff.Path)
+	if err := verifyLocalHash(oldFull, oldDiff.Hash); err != nil {
+		return fmt.Errorf("local source: %w", err)
 	}
 	if err := os.MkdirAll(filepath.Dir(newFull), 0755); err != nil {
 		return err
 	}
+	// 重命名覆盖到已有文件时，被顶掉的内容同样留存（删除一侧由 rename 本身保全）
+	if *config.KeepVersions {
+		if err := versions.Preserve(config.StartPath, newDiff.Path, time.Now(), versionPolicy()); err != nil {
+			return err
+		}
+	}
 	if err := os.Rename(oldFull, newFull); err != nil {
 		return err
 	}
@@ -251,6 +452,8 @@ func applyRename(oldDiff, newDiff DiffResult) error {
 	// 重命名影响新旧两个父目录
 	recordChangedDir(oldDiff.Path)
 	recordChangedDir(newDiff.Path)
+	noteSynced(oldDiff)
+	noteSynced(newDiff)
 	return nil
 }
 
@@ -301,6 +504,7 @@ func drainNextLevel(fileClient *network.FileClient, recurseAll bool) error {
 			}
 			if retries[v.Path] > maxDirRetries {
 				log.Errorf("directory %s failed %d times in a row, giving up this round", v.Path, retries[v.Path]-1)
+				tallyDir(v.Path)
 				continue
 			}
 			if reconnectErr := fileClient.Reconnect(); reconnectErr != nil {
@@ -313,6 +517,7 @@ func drainNextLevel(fileClient *network.FileClient, recurseAll bool) error {
 			// §5.1：非连接错误（Diff/DB 等）跳过该目录、继续同步其余目录（一个坏目录不该
 			// 拖垮整轮），但计入错误统计——别让「某目录本轮没同步成」静默地当成成功
 			status.RecordError()
+			tallyDir(v.Path)
 		}
 	}
 	return nil
@@ -0,0 +1,172 @@
+// Package fsmeta 采集与还原内容之外的文件元数据：权限位、属主、扩展属性与
+// 符号链接目标。树节点据此携带元数据，汇端在落盘后原样施加。
+//
+// 取舍：
+//   - 权限位只取 0777 与粘滞位。setuid/setgid 一律不镜像——对端能让以 root
+//     运行的汇端落下一个 setuid 可执行文件，等于远程提权
+//   - 属主只在汇端以 root 运行时施加与比较：普通用户 chown 必然失败，比较它
+//     只会让每轮全量扫描都检出永远消不掉的差异
+//   - 扩展属性在 Linux 上只取 user.* 命名空间（security.*/trusted.* 需要特权
+//     且多为主机相关，如 SELinux 标签），macOS 上取全部但跳过隔离标记
+//   - 非 root 汇端上目录始终保留属主 rwx：否则上游一个只读目录落地后，其下内容
+//     再也写不进去
+//   - Windows 上没有可靠的 POSIX 元数据，整体不支持（Supported 为 false）
+package fsmeta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/zeebo/blake3"
+)
+
+// Meta 一个条目内容之外的元数据，随树节点以 JSON 下发（字段均可省略）
+type Meta struct {
+	Mode   uint32            `json:"mode,omitempty"`   // 权限位（0777|粘滞位，Unix 语义）
+	UID    *uint32           `json:"uid,omitempty"`    // 属主，平台不支持时为空
+	GID    *uint32           `json:"gid,omitempty"`    // 属组，平台不支持时为空
+	Xattrs map[string][]byte `json:"xattrs,omitempty"` // 扩展属性（见包注释的命名空间取舍）
+	Link   string            `json:"link,omitempty"`   // 符号链接目标（"/" 分隔的相对路径）；非空即符号链接
+}
+
+// modeMask 镜像的权限位：rwx 与粘滞位，不含 setuid/setgid
+const modeMask = 0o1777
+
+// maxXattrBytes 单个条目扩展属性名与值的总长上限。超出的整体不采集：
+// 元数据随目录页下发，不能让个别条目（如带资源分支的 macOS 文件）撑爆页面
+const maxXattrBytes = 64 << 10
+
+// xattrsRejected 本地文件系统拒绝过扩展属性（ENOTSUP）。此后不再比较，
+// 免得每轮扫描都对同一批文件重试注定失败的 setxattr
+var xattrsRejected atomic.Bool
+
+// Read 采集 full 处条目的元数据。info 须是 Lstat 的结果；target 为符号链接
+// 已校验过的目标（非链接传空）。平台不支持时返回 nil
+func Read(full string, info fs.FileInfo, target string) *Meta {
+	if !Supported {
+		return nil
+	}
+	m := &Meta{}
+	if target != "" {
+		// 链接自身的权限位无意义（Linux 恒为 0777），扩展属性在 Linux 上也不允许
+		// 挂在链接上；只记目标与属主
+		m.Link = filepath.ToSlash(target)
+	} else {
+		m.Mode = unixMode(info.Mode())
+		m.Xattrs = readXattrs(full)
+	}
+	m.UID, m.GID = owner(info)
+	return m
+}
+
+// unixMode 把 Go 的 FileMode 还原为 Unix 权限位（粘滞位在 Go 里是独立的高位）
+func unixMode(mode fs.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&fs.ModeSticky != 0 {
+		m |= 0o1000
+	}
+	return m & modeMask
+}
+
+// effectiveMode 汇端实际落下的权限位（见包注释：非 root 的目录保留属主 rwx）
+func effectiveMode(m uint32, isDir bool) uint32 {
+	m &= modeMask
+	if isDir && !CanChown {
+		m |= 0o700
+	}
+	return m
+}
+
+func goMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
+
+// Apply 把 m 施加到 full 处的条目上（符号链接须已建好），isDir 为条目是否目录。顺序有讲究：chown 会
+// 清掉部分权限位，故先属主后权限；扩展属性最后，且本地多出的同命名空间属性
+// 一并删除。各项失败不互相阻断，汇总返回
+func Apply(full string, m *Meta, isDir bool) error {
+	if m == nil || !Supported {
+		return nil
+	}
+	var errs []error
+	if CanChown && (m.UID != nil || m.GID != nil) {
+		uid, gid := -1, -1
+		if m.UID != nil {
+			uid = int(*m.UID)
+		}
+		if m.GID != nil {
+			gid = int(*m.GID)
+		}
+		if err := os.Lchown(full, uid, gid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if m.Link == "" {
+		if err := os.Chmod(full, goMode(effectiveMode(m.Mode, isDir))); err != nil {
+			errs = append(errs, err)
+		}
+		if err := writeXattrs(full, m.Xattrs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("applying metadata to %s: %w", full, err)
+	}
+	return nil
+}
+
+// Differs 判断上游元数据 want 与本地现状 have 是否需要同步。只比汇端能够施加的
+// 部分：非 root 不比属主，否则属主不同的两端会在每轮扫描都检出同一差异
+func Differs(want, have *Meta, isDir bool) bool {
+	if want == nil {
+		return false // 上游没有元数据（未协商或平台不支持），无从比较
+	}
+	if have == nil {
+		have = &Meta{}
+	}
+	if want.Link != have.Link {
+		return true
+	}
+	if want.Link == "" && (effectiveMode(want.Mode, isDir) != have.Mode&modeMask || !xattrsEqual(want.Xattrs, have.Xattrs)) {
+		return true
+	}
+	if CanChown && (!idEqual(want.UID, have.UID) || !idEqual(want.GID, have.GID)) {
+		return true
+	}
+	return false
+}
+
+func idEqual(want, have *uint32) bool {
+	return want == nil || (have != nil && *want == *have)
+}
+
+// xattrsEqual 只比本地会接收的属性（上游平台的命名空间可能不同）。本地存不了
+// 扩展属性时不比：否则只会产生永远消不掉的差异
+func xattrsEqual(want, have map[string][]byte) bool {
+	if !xattrsSupported || xattrsRejected.Load() {
+		return true
+	}
+	accepted := make(map[string][]byte, len(want))
+	for name, val := range want {
+		if syncedXattr(name) {
+			accepted[name] = val
+		}
+	}
+	return maps.EqualFunc(accepted, have, bytes.Equal)
+}
+
+// LinkHash 符号链接节点的哈希：对目标取哈希，加前缀与文件内容哈希区分开，
+// 目标变化即哈希变化，复用文件的 modify 判定
+func LinkHash(target string) string {
+	return fmt.Sprintf("%x", blake3.Sum256([]byte("symlink\x00"+filepath.ToSlash(target))))
+}
@@ -0,0 +1,70 @@
+//go:build linux || darwin
+
+package fsmeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadApplyRoundTrip 采集到的权限位（含粘滞位、不含 setuid）施加到另一个文件后
+// 两者不再有差异；扩展属性能落地时一并对齐，本地多出的被删掉
+func TestReadApplyRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(src, 0o755|os.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Lstat(src)
+	m := Read(src, info, "")
+	if m.Mode != 0o755 {
+		t.Fatalf("权限位应为 0755（setuid 不镜像），实际 %o", m.Mode)
+	}
+	m.Xattrs = map[string][]byte{"user.lm-test": []byte("v")}
+
+	if err := Apply(dst, m, false); err != nil {
+		t.Skipf("文件系统不支持扩展属性: %v", err)
+	}
+	info, _ = os.Lstat(dst)
+	if got := Read(dst, info, ""); Differs(m, got, false) {
+		t.Fatalf("施加后不应再有差异: want %+v, got %+v", m, got)
+	}
+
+	m.Xattrs = nil
+	if err := Apply(dst, m, false); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Lstat(dst)
+	if got := Read(dst, info, ""); len(got.Xattrs) != 0 {
+		t.Fatalf("上游没有的扩展属性应被删掉，实际 %v", got.Xattrs)
+	}
+}
+
+// TestDiffersMasks 非 root 汇端不比属主、目录保留属主 rwx；链接只比目标
+func TestDiffersMasks(t *testing.T) {
+	uid, other := uint32(0), uint32(12345)
+	defer func(v bool) { CanChown = v }(CanChown)
+	CanChown = false
+	if Differs(&Meta{Mode: 0o644, UID: &uid}, &Meta{Mode: 0o644, UID: &other}, false) {
+		t.Error("非 root 不应比较属主")
+	}
+	if Differs(&Meta{Mode: 0o555}, &Meta{Mode: 0o755}, true) {
+		t.Error("非 root 汇端上只读目录落地为 0755，不应视为差异")
+	}
+	if !Differs(&Meta{Link: "a"}, &Meta{Link: "b"}, false) || Differs(nil, &Meta{Mode: 0o600}, false) {
+		t.Error("链接目标不同须检出；上游无元数据时不比较")
+	}
+	CanChown = true
+	if !Differs(&Meta{Mode: 0o644, UID: &uid}, &Meta{Mode: 0o644, UID: &other}, false) {
+		t.Error("root 汇端属主不同须检出")
+	}
+	if LinkHash("a/b") == LinkHash("a/c") {
+		t.Error("目标不同，哈希应不同")
+	}
+}
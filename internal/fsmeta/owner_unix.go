@@ -0,0 +1,33 @@
+//go:build !windows
+
+package fsmeta
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// Supported 本平台能否采集与施加元数据
+const Supported = true
+
+// CanChown 汇端是否以 root 运行（只有 root 能把属主改成任意用户）
+var CanChown = os.Geteuid() == 0
+
+func owner(info fs.FileInfo) (*uint32, *uint32) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, nil
+	}
+	uid, gid := st.Uid, st.Gid
+	return &uid, &gid
+}
+
+// LinkCount 条目的硬链接计数，取不到时按 1
+func LinkCount(info fs.FileInfo) uint64 {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return uint64(st.Nlink)
+}
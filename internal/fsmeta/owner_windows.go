@@ -0,0 +1,14 @@
+package fsmeta
+
+import "io/fs"
+
+// Supported Windows 上没有可靠的 POSIX 元数据，不采集也不施加
+const Supported = false
+
+// CanChown Windows 上恒为 false
+var CanChown = false
+
+func owner(fs.FileInfo) (*uint32, *uint32) { return nil, nil }
+
+// LinkCount Windows 上不施加元数据，无需区分硬链接，恒为 1
+func LinkCount(fs.FileInfo) uint64 { return 1 }
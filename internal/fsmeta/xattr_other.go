@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package fsmeta
+
+const xattrsSupported = false
+
+func syncedXattr(string) bool { return false }
+
+func readXattrs(string) map[string][]byte { return nil }
+
+func writeXattrs(string, map[string][]byte) error { return nil }
@@ -0,0 +1,97 @@
+//go:build linux || darwin
+
+package fsmeta
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const xattrsSupported = true
+
+// syncedXattr 哪些扩展属性参与镜像（见包注释）
+func syncedXattr(name string) bool {
+	if runtime.GOOS == "darwin" {
+		return name != "com.apple.quarantine" && name != "com.apple.provenance"
+	}
+	return strings.HasPrefix(name, "user.")
+}
+
+// listXattrs 列出 full 上参与镜像的扩展属性名
+func listXattrs(full string) ([]string, error) {
+	size, err := unix.Llistxattr(full, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(full, buf); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 && syncedXattr(string(name)) {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+// readXattrs 读取 full 上参与镜像的扩展属性；文件系统不支持或读取失败时当作没有
+func readXattrs(full string) map[string][]byte {
+	names, err := listXattrs(full)
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+	attrs := make(map[string][]byte, len(names))
+	total := 0
+	for _, name := range names {
+		size, err := unix.Lgetxattr(full, name, nil)
+		if err != nil {
+			continue
+		}
+		val := make([]byte, size)
+		if size, err = unix.Lgetxattr(full, name, val); err != nil {
+			continue
+		}
+		total += len(name) + size
+		if total > maxXattrBytes {
+			return nil
+		}
+		attrs[name] = val[:size]
+	}
+	return attrs
+}
+
+// writeXattrs 让 full 上参与镜像的扩展属性恰好等于 want：逐个设置，再删掉多余的
+func writeXattrs(full string, want map[string][]byte) error {
+	var errs []error
+	for name, val := range want {
+		if !syncedXattr(name) {
+			continue // 上游平台的命名空间本地不接收（如 macOS 属性落到 Linux）
+		}
+		if err := unix.Lsetxattr(full, name, val, 0); err != nil {
+			if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+				xattrsRejected.Store(true)
+				return fmt.Errorf("filesystem does not support extended attributes: %w", err)
+			}
+			errs = append(errs, fmt.Errorf("setxattr %s: %w", name, err))
+		}
+	}
+	have, err := listXattrs(full)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range have {
+		if _, ok := want[name]; !ok {
+			if err := unix.Lremovexattr(full, name); err != nil {
+				errs = append(errs, fmt.Errorf("removexattr %s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
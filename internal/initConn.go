@@ -16,16 +16,22 @@ import (
 // 用握手确认对端确实是 local-mirror 服务端，避免误连到恰好占用端口的其他程序。
 // 单轮探测失败直接返回错误，重试交给 Mirror 主循环的退避逻辑。
 func InitConn() (*network.FileClient, error) {
+	return connectPeer(*config.RealityIP, config.DiscoveredAddr)
+}
+
+// connectPeer 按 InitConn 的规则连接 peer（host[:port]）；peer 为空时用局域网
+// 发现选定的 discovered。多上游汇的每路上游各自以其地址调用
+func connectPeer(peer, discovered string) (*network.FileClient, error) {
 	// -r/--connect 收 host[:port]：IPv4 / IPv6 字面量 / 域名，端口可选。
 	// 域名交给 Dial 每次重新解析（DDNS 友好，不缓存 IP——见
 	// docs/PUBLIC_EXPOSURE.md §B.3）
-	ip, exactPort := network.SplitPeer(*config.RealityIP)
+	ip, exactPort := network.SplitPeer(peer)
 	exactAddr := ""
 	if ip == "" {
-		if config.DiscoveredAddr != "" {
+		if discovered != "" {
 			// 自动发现选定的精确地址优先直连；端口段扫描保留为后备
 			// （服务端重启可能落到相邻端口，重连时靠扫描自愈）
-			exactAddr = config.DiscoveredAddr
+			exactAddr = discovered
 			if host, _, err := net.SplitHostPort(exactAddr); err == nil {
 				ip = host
 			}
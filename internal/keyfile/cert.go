@@ -0,0 +1,97 @@
+package keyfile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TLS 传输（--transport tls）的自签名证书：--gen-cert 生成到 .local-mirror/tls.crt
+// 与 tls.key（私钥 600）。自签名证书没有 CA 可依，对端用 --tls-pin 钉住它的
+// SHA-256 指纹；已有 PKI 的用 --tls-cert/--tls-key/--tls-ca 指向自己的文件
+
+// CertPath 返回同步根下自签名证书的路径
+func CertPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "tls.crt")
+}
+
+// CertKeyPath 返回同步根下自签名证书私钥的路径
+func CertKeyPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "tls.key")
+}
+
+// CertFingerprint 证书（DER）的 SHA-256 指纹，小写十六进制，即 --tls-pin 要填的值
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertFingerprint 解析 --tls-pin 的一项：64 位十六进制，可带 sha256: 前缀与冒号分隔
+// （openssl x509 -fingerprint -sha256 的输出格式）
+func ParseCertFingerprint(s string) (string, error) {
+	fp := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(s), "sha256:"), ":", ""))
+	if raw, err := hex.DecodeString(fp); err != nil || len(raw) != sha256.Size {
+		return "", fmt.Errorf("%q is not a SHA-256 certificate fingerprint (64 hex digits, as printed by --gen-cert)", s)
+	}
+	return fp, nil
+}
+
+// GenerateCert 生成自签名证书（ECDSA P-256，服务端与客户端用途兼有，十年有效）
+// 写入 tls.crt/tls.key，返回证书 DER。已存在时拒绝覆盖：钉住它的对端会连不上，
+// 须 force 显式确认
+func GenerateCert(root, commonName string, force bool) ([]byte, error) {
+	if !force {
+		if _, err := os.Stat(CertPath(root)); err == nil {
+			return nil, fmt.Errorf("certificate already exists: %s\n"+
+				"regenerating breaks every peer that pinned it; pass --force to overwrite", CertPath(root))
+		}
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the certificate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather randomness: %w", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the certificate key: %w", err)
+	}
+	// 先写私钥：中途失败时不会留下一张没有私钥的证书
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := write(CertKeyPath(root), strings.TrimSuffix(string(keyPEM), "\n")); err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(CertPath(root), certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write certificate %s: %w", CertPath(root), err)
+	}
+	return der, nil
+}
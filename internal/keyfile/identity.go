@@ -0,0 +1,206 @@
+package keyfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/curve25519"
+)
+
+// 身份模式（--identity）：每个实例一把 X25519 静态密钥对，私钥在
+// .local-mirror/identity（600），公钥可公开；本端信任的对端公钥逐行列在
+// .local-mirror/authorized_peers（"<公钥> [标签]"，# 注释）。两端互相登记对方
+// 公钥即成对，吊销一台泄露的机器 = 从名单删掉它的一行，其余对端不受影响。
+// 与共享口令的密钥文件并存：两者都在时口令作为额外的一道 PSK
+
+// IdentityPath 返回同步根下的身份私钥文件路径
+func IdentityPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "identity")
+}
+
+// PeersPath 返回同步根下的授权对端名单路径
+func PeersPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "authorized_peers")
+}
+
+// Peer 授权名单里的一个对端
+type Peer struct {
+	Key   []byte // X25519 公钥（32 字节）
+	Label string // 可选标签，不含空白
+}
+
+// PeerFingerprint 公钥指纹（8 位十六进制），日志与 --status 里代替整把公钥展示。
+// 域分离前缀与口令指纹互不重合
+func PeerFingerprint(pub []byte) string {
+	sum := blake3.Sum256(append([]byte("local-mirror-peer-fingerprint-v1:"), pub...))
+	return hex.EncodeToString(sum[:4])
+}
+
+// Fingerprint 该对端公钥的指纹
+func (p Peer) Fingerprint() string {
+	return PeerFingerprint(p.Key)
+}
+
+// String 人读的身份："标签 (指纹)"，无标签时只有指纹
+func (p Peer) String() string {
+	if p.Label == "" {
+		return p.Fingerprint()
+	}
+	return fmt.Sprintf("%s (%s)", p.Label, p.Fingerprint())
+}
+
+// PublicKeyString 公钥的文本形式（base64），即对端 --add-peer 要填的值
+func PublicKeyString(pub []byte) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey 解析 base64 公钥
+func ParsePublicKey(s string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != curve25519.PointSize {
+		return nil, fmt.Errorf("%q is not a public key (expected base64 of %d bytes, as printed by --show-identity)", s, curve25519.PointSize)
+	}
+	return raw, nil
+}
+
+// LoadIdentity 读取本实例的身份密钥对，不存在时生成并落盘（600）。
+// 私钥只存一份，公钥每次由私钥推出；返回 created 供调用方提示首次生成
+func LoadIdentity(root string) (priv, pub []byte, created bool, err error) {
+	path := IdentityPath(root)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		priv, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(priv) != curve25519.ScalarSize {
+			return nil, nil, false, fmt.Errorf("identity file %s is corrupt (delete it to generate a new identity; every peer must then re-add this one)", path)
+		}
+	case os.IsNotExist(err):
+		priv = make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(priv); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to gather randomness: %w", err)
+		}
+		if err := write(path, base64.StdEncoding.EncodeToString(priv)); err != nil {
+			return nil, nil, false, err
+		}
+		created = true
+	default:
+		return nil, nil, false, fmt.Errorf("failed to read identity file %s: %w", path, err)
+	}
+	pub, err = curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("identity file %s holds an invalid key: %w", path, err)
+	}
+	return priv, pub, created, nil
+}
+
+// LoadPeers 读取授权名单。文件不存在返回空名单（谁都不认）
+func LoadPeers(root string) ([]Peer, error) {
+	path := PeersPath(root)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var peers []Peer
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key, err := ParsePublicKey(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		peers = append(peers, Peer{Key: key, Label: strings.Join(fields[1:], " ")})
+	}
+	return peers, nil
+}
+
+// FindPeer 在名单里按公钥查找
+func FindPeer(peers []Peer, pub []byte) (Peer, bool) {
+	for _, p := range peers {
+		if bytes.Equal(p.Key, pub) {
+			return p, true
+		}
+	}
+	return Peer{}, false
+}
+
+// AddPeer 把公钥加入授权名单。已在名单里时只更新标签；标签须唯一，
+// 否则 --revoke-peer 按标签吊销时会有歧义
+func AddPeer(root, key, label string) (Peer, error) {
+	pub, err := ParsePublicKey(key)
+	if err != nil {
+		return Peer{}, err
+	}
+	if strings.ContainsAny(label, " \t\r\n") {
+		return Peer{}, fmt.Errorf("peer label %q must not contain whitespace", label)
+	}
+	peers, err := LoadPeers(root)
+	if err != nil {
+		return Peer{}, err
+	}
+	added := Peer{Key: pub, Label: label}
+	replaced := false
+	for i, p := range peers {
+		switch {
+		case bytes.Equal(p.Key, pub):
+			peers[i] = added
+			replaced = true
+		case label != "" && p.Label == label:
+			return Peer{}, fmt.Errorf("label %q is already used by peer %s", label, p.Fingerprint())
+		}
+	}
+	if !replaced {
+		peers = append(peers, added)
+	}
+	return added, savePeers(root, peers)
+}
+
+// RevokePeer 从授权名单删掉一个对端，match 可以是公钥、标签或指纹
+func RevokePeer(root, match string) (Peer, error) {
+	peers, err := LoadPeers(root)
+	if err != nil {
+		return Peer{}, err
+	}
+	for i, p := range peers {
+		if PublicKeyString(p.Key) == match || p.Fingerprint() == match || (p.Label != "" && p.Label == match) {
+			return p, savePeers(root, append(peers[:i], peers[i+1:]...))
+		}
+	}
+	return Peer{}, fmt.Errorf("no authorized peer matches %q (give its public key, label or fingerprint)", match)
+}
+
+func savePeers(root string, peers []Peer) error {
+	var b strings.Builder
+	b.WriteString("# local-mirror authorized peers: <public key> [label]\n")
+	for _, p := range peers {
+		b.WriteString(PublicKeyString(p.Key))
+		if p.Label != "" {
+			b.WriteString(" " + p.Label)
+		}
+		b.WriteString("\n")
+	}
+	path := PeersPath(root)
+	tmp := path + ".tmp"
+	if err := write(tmp, strings.TrimSuffix(b.String(), "\n")); err != nil {
+		return err
+	}
+	// 原子替换：运行中的监听端随时可能在握手时读它
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return nil
+}
@@ -51,7 +51,7 @@ func Generate(root string, force bool) (string, error) {
 	if !force {
 		if _, err := os.Stat(path); err == nil {
 			return "", fmt.Errorf("key file already exists: %s\n"+
-				"regenerating disconnects every dialer holding the old key; pass --force to overwrite", path)
+				"regenerating disconnects every dialer holding the old key; use --rotate-key to switch keys without that, or pass --force to overwrite", path)
 		}
 	}
 	buf := make([]byte, KeyBytes)
@@ -65,6 +65,13 @@ func Generate(root string, force bool) (string, error) {
 	return key, nil
 }
 
+// IsGenerated key 是否具备 Generate 产出的形态（base64 的 KeyBytes 字节随机数）。
+// 这样的 key 不怕离线猜测，握手时不做口令拉伸
+func IsGenerated(key string) bool {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(raw) == KeyBytes
+}
+
 // Save 把 key 落盘（拨号端对称持有，下次启动可省 -k）。
 // 内容一致时不重写；损坏的旧文件直接覆盖自愈。返回是否实际写入
 func Save(root, key string) (bool, error) {
@@ -1,11 +1,14 @@
 package keyfile
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestGenerate 验证生成的 key 是 base64(32B)、文件权限 600、Load 可读回
@@ -130,3 +133,142 @@ func TestFingerprint(t *testing.T) {
 		t.Fatal("different keys produced the same fingerprint")
 	}
 }
+
+// TestIdentityAndPeers 身份首次生成后复用同一把；名单按公钥去重、标签唯一，
+// 可按标签/指纹/公钥吊销
+func TestIdentityAndPeers(t *testing.T) {
+	root := t.TempDir()
+	_, pub, created, err := LoadIdentity(root)
+	if err != nil || !created {
+		t.Fatalf("first LoadIdentity: created=%v err=%v", created, err)
+	}
+	if info, err := os.Stat(IdentityPath(root)); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("identity file should exist with mode 600: %v", err)
+	}
+	_, again, created, err := LoadIdentity(root)
+	if err != nil || created || string(again) != string(pub) {
+		t.Fatalf("second LoadIdentity should reuse the key: created=%v err=%v", created, err)
+	}
+
+	a, b := PublicKeyString(pub), PublicKeyString(make([]byte, 32))
+	if _, err := AddPeer(root, a, "laptop"); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	if _, err := AddPeer(root, b, "laptop"); err == nil {
+		t.Fatal("a second peer with the same label should be refused")
+	}
+	if _, err := AddPeer(root, b, ""); err != nil {
+		t.Fatalf("AddPeer: %v", err)
+	}
+	if _, err := AddPeer(root, a, "work-laptop"); err != nil {
+		t.Fatalf("re-adding a key should relabel it: %v", err)
+	}
+	if _, err := AddPeer(root, "not-a-key", ""); err == nil {
+		t.Fatal("a malformed key should be refused")
+	}
+	peers, err := LoadPeers(root)
+	if err != nil || len(peers) != 2 {
+		t.Fatalf("want 2 peers, got %d (%v)", len(peers), err)
+	}
+	if p, ok := FindPeer(peers, pub); !ok || p.Label != "work-laptop" {
+		t.Fatalf("relabelled peer not found: %+v", p)
+	}
+
+	if _, err := RevokePeer(root, "work-laptop"); err != nil {
+		t.Fatalf("revoke by label: %v", err)
+	}
+	if _, err := RevokePeer(root, PeerFingerprint(make([]byte, 32))); err != nil {
+		t.Fatalf("revoke by fingerprint: %v", err)
+	}
+	if _, err := RevokePeer(root, a); err == nil {
+		t.Fatal("revoking an absent peer should fail")
+	}
+	if peers, _ := LoadPeers(root); len(peers) != 0 {
+		t.Fatalf("all peers revoked, got %d", len(peers))
+	}
+}
+
+// TestRotate 轮换：旧 key 连同截止时刻进 key.previous；没有密钥文件时报错；
+// 上一轮宽限期未过时拒绝再轮换，force 才放行；RetirePrevious 删掉旧 key
+func TestRotate(t *testing.T) {
+	root := t.TempDir()
+	if _, _, err := Rotate(root, time.Hour, false); err == nil {
+		t.Fatal("Rotate without a key file should fail")
+	}
+	old, err := Generate(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, until, err := Rotate(root, time.Hour, false)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if key == old {
+		t.Fatal("rotation kept the old key")
+	}
+	if loaded, _ := Load(root); loaded != key {
+		t.Fatalf("key file holds %q, want the new key", loaded)
+	}
+	prev, prevUntil, err := LoadPrevious(root)
+	if err != nil || prev != old || prevUntil.Unix() != until.Unix() {
+		t.Fatalf("LoadPrevious = (%q, %v, %v), want the old key until %v", prev, prevUntil, err, until)
+	}
+	if _, _, err := Rotate(root, time.Hour, false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("rotating inside the grace window should ask for --force, got: %v", err)
+	}
+	if _, _, err := Rotate(root, time.Hour, true); err != nil {
+		t.Fatalf("forced Rotate: %v", err)
+	}
+	if prev, _, _ := LoadPrevious(root); prev != key {
+		t.Fatal("a forced rotation should keep the key it replaced as the previous one")
+	}
+	if err := RetirePrevious(root); err != nil {
+		t.Fatal(err)
+	}
+	if prev, _, err := LoadPrevious(root); prev != "" || err != nil {
+		t.Fatalf("retired previous key still loads: %q %v", prev, err)
+	}
+}
+
+// TestGenerateCert 生成的证书与私钥成对可读、私钥 600；指纹两种写法都认；
+// 已存在时拒绝覆盖，force 才放行
+func TestGenerateCert(t *testing.T) {
+	root := t.TempDir()
+	der, err := GenerateCert(root, "alpha", false)
+	if err != nil {
+		t.Fatalf("GenerateCert: %v", err)
+	}
+	pair, err := tls.LoadX509KeyPair(CertPath(root), CertKeyPath(root))
+	if err != nil {
+		t.Fatalf("generated pair does not load: %v", err)
+	}
+	if !bytes.Equal(pair.Certificate[0], der) {
+		t.Fatal("GenerateCert returned a different certificate than it wrote")
+	}
+	info, err := os.Stat(CertKeyPath(root))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("certificate key mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+
+	fp := CertFingerprint(der)
+	colons := "SHA256:" + strings.ToUpper(fp)
+	for i := len(fp) - 2; i > 0; i -= 2 {
+		colons = colons[:7+i] + ":" + colons[7+i:]
+	}
+	for _, in := range []string{fp, "sha256:" + fp, strings.ToLower(colons)} {
+		if got, err := ParseCertFingerprint(in); err != nil || got != fp {
+			t.Errorf("ParseCertFingerprint(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseCertFingerprint(fp[:62]); err == nil {
+		t.Error("a short fingerprint should be rejected")
+	}
+
+	if _, err := GenerateCert(root, "alpha", false); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("regenerating should ask for --force, got: %v", err)
+	}
+	again, err := GenerateCert(root, "alpha", true)
+	if err != nil || bytes.Equal(again, der) {
+		t.Fatalf("forced GenerateCert should write a new certificate: %v", err)
+	}
+}
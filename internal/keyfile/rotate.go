@@ -0,0 +1,87 @@
+package keyfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 密钥轮换（--rotate-key）：新 key 写进 key 文件，旧 key 连同宽限期截止时刻移到
+// key.previous。宽限期内监听端两把都认，用旧 key 拨进来的对端在加密会话里拿到新 key
+// 并自行落盘；宽限期过后旧 key 作废、文件删除。轮换不是吊销：持有旧 key 的任何人
+// 在宽限期内同样会拿到新 key，要踢掉一台泄露的机器用 --identity
+
+// DefaultGrace 轮换后旧 key 仍被接受的默认时长
+const DefaultGrace = 7 * 24 * time.Hour
+
+// PreviousPath 返回同步根下轮换前旧 key 的文件路径
+func PreviousPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "key.previous")
+}
+
+// LoadPrevious 读取轮换前的旧 key 及其宽限期截止时刻。文件不存在返回 ("", 零值, nil)；
+// 已过期的照常返回，由调用方判断并退役
+func LoadPrevious(root string) (string, time.Time, error) {
+	path := PreviousPath(root)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return "", time.Time{}, fmt.Errorf("%s is corrupt (delete it to retire the previous key now)", path)
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%s is corrupt (delete it to retire the previous key now)", path)
+	}
+	return fields[0], time.Unix(unix, 0), nil
+}
+
+// SavePrevious 记下旧 key 与宽限期截止时刻（600）
+func SavePrevious(root, key string, until time.Time) error {
+	return write(PreviousPath(root), fmt.Sprintf("%s %d", key, until.Unix()))
+}
+
+// RetirePrevious 宽限期结束，删掉旧 key
+func RetirePrevious(root string) error {
+	if err := os.Remove(PreviousPath(root)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to retire the previous key: %w", err)
+	}
+	return nil
+}
+
+// Rotate 生成新 key，旧 key 在 grace 内继续有效。上一轮的宽限期还没结束时拒绝——
+// 再轮换会让还拿着更早那把 key 的对端直接失联，须 force 显式确认。
+// 先写 key.previous 再写 key：中途失败时旧 key 仍是有效的那把
+func Rotate(root string, grace time.Duration, force bool) (key string, until time.Time, err error) {
+	old, err := Load(root)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if old == "" {
+		return "", time.Time{}, fmt.Errorf("no key file to rotate at %s (generate one with --gen-key)", Path(root))
+	}
+	if !force {
+		if prev, prevUntil, err := LoadPrevious(root); err == nil && prev != "" && time.Now().Before(prevUntil) {
+			return "", time.Time{}, fmt.Errorf("the previous rotation's grace window runs until %s\n"+
+				"rotating again cuts off every peer still holding the key before it; pass --force to rotate anyway",
+				prevUntil.Format(time.DateTime))
+		}
+	}
+	until = time.Now().Add(grace)
+	if err := SavePrevious(root, old, until); err != nil {
+		return "", time.Time{}, err
+	}
+	key, err = Generate(root, true)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return key, until, nil
+}
@@ -32,13 +32,13 @@ func LogPath() string {
 func InitLogger() {
 	// 日志同时写入文件和 stderr：
 	// 错误必须让终端上的用户看得见，只写文件会让进程"无声退出"。
-	// 文件侧走基于大小的轮转 writer，长驻进程不会写满磁盘
+	// 文件侧走基于大小的轮转 writer，长驻进程不会写满磁盘。
+	// --dry-run 与 verify 是一次性命令且不该碰同步根（汇端可能正在运行），日志只上终端
 	output := io.Writer(os.Stderr)
-	if err := os.MkdirAll(getLogDir(), 0755); err != nil {
-		log.Warnf("failed to create log directory, logging to terminal only: %v", err)
-	} else {
-		rw, err := newRotatingWriter(LogPath(), logMaxSize, logMaxFiles)
-		if err != nil {
+	if !*config.DryRun && !config.Verify {
+		if err := os.MkdirAll(getLogDir(), 0755); err != nil {
+			log.Warnf("failed to create log directory, logging to terminal only: %v", err)
+		} else if rw, err := newRotatingWriter(LogPath(), logMaxSize, logMaxFiles); err != nil {
 			log.Warnf("failed to open log file, logging to terminal only: %v", err)
 		} else {
 			output = io.MultiWriter(rw, os.Stderr)
@@ -0,0 +1,65 @@
+//go:build !windows
+
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/fsmeta"
+	"local-mirror/internal/snapshot"
+	"local-mirror/internal/tree"
+)
+
+// TestMetaKeepsSnapshotIntact 只改权限的 meta 动作不能穿过硬链接改到快照：
+// 活文件先脱离共享的 inode 再 chmod，快照里的那份权限不变，内容与 mtime 都不动
+func TestMetaKeepsSnapshotIntact(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+
+	live := filepath.Join(root, "run.sh")
+	if err := os.WriteFile(live, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(live, mtime, mtime)
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	node, err := tree.GetNodeByPath("run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := snapshot.Take(root, []snapshot.Entry{{Path: node.Path, Hash: node.Hash, Size: node.Size, ModTime: node.ModTime}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := filepath.Join(snapshot.TreeDir(root, info.Name), "run.sh")
+	if st, err := os.Lstat(live); err != nil || fsmeta.LinkCount(st) < 2 {
+		t.Fatalf("快照应与活文件共用 inode: %v", err)
+	}
+
+	v := DiffResult{Path: "run.sh", Name: "run.sh", Action: "meta", Size: node.Size, Hash: node.Hash, Meta: &fsmeta.Meta{Mode: 0o755}}
+	if err := processDiffItem(v, nil); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Lstat(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0o755 || fsmeta.LinkCount(st) != 1 || !st.ModTime().Equal(mtime) {
+		t.Fatalf("活文件: mode %v, links %d, mtime %v；应为 0755、独占 inode、mtime 不变", st.Mode().Perm(), fsmeta.LinkCount(st), st.ModTime())
+	}
+	if b, _ := os.ReadFile(live); string(b) != "#!/bin/sh\n" {
+		t.Fatalf("活文件内容被改: %q", b)
+	}
+	if st, err := os.Lstat(snap); err != nil || st.Mode().Perm() != 0o644 {
+		t.Fatalf("快照里的副本权限被改写: %v %v", st.Mode().Perm(), err)
+	}
+}
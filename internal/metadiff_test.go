@@ -0,0 +1,39 @@
+package app
+
+import (
+	"testing"
+
+	"local-mirror/internal/fsmeta"
+	"local-mirror/internal/tree"
+)
+
+// TestFindDifferencesMetadata 协商了元数据：只有权限不同产出 meta 动作（不重新下载），
+// 链接↔文件按 retype 处理；未协商时本地符号链接不参与比较，不会被当成上游已删除
+func TestFindDifferencesMetadata(t *testing.T) {
+	exec := &fsmeta.Meta{Mode: 0o755}
+	plain := &fsmeta.Meta{Mode: 0o644}
+	link := &fsmeta.Meta{Link: "target.txt"}
+
+	a := []tree.Node{{Path: "run.sh", Size: 3, Hash: "h", Meta: exec}}
+	b := []tree.Node{{Path: "run.sh", Size: 3, Hash: "h", Meta: plain}}
+	if d := FindDifferences(a, b, true); len(d) != 1 || d[0].Action != "meta" || d[0].Meta.Mode != 0o755 {
+		t.Fatalf("仅权限不同应产出 meta，实际 %+v", d)
+	}
+	if d := FindDifferences(a, b, false); len(d) != 0 {
+		t.Fatalf("未协商元数据时不应比较权限，实际 %+v", d)
+	}
+
+	a2 := []tree.Node{{Path: "x", Hash: fsmeta.LinkHash("target.txt"), Meta: link}}
+	b2 := []tree.Node{{Path: "x", Size: 0, Hash: fsmeta.LinkHash("target.txt"), Meta: plain}}
+	if d := FindDifferences(a2, b2, true); len(d) != 1 || d[0].Action != "retype" || !d[0].IsSymlink() {
+		t.Fatalf("文件→链接应产出 retype，实际 %+v", d)
+	}
+
+	b3 := []tree.Node{{Path: "local-link", Hash: fsmeta.LinkHash("t"), Meta: link}}
+	if d := FindDifferences(nil, b3, false); len(d) != 0 {
+		t.Fatalf("未协商时本地链接不应产出 delete，实际 %+v", d)
+	}
+	if d := FindDifferences(nil, b3, true); len(d) != 1 || d[0].Action != "delete" {
+		t.Fatalf("协商后上游没有的链接应产出 delete，实际 %+v", d)
+	}
+}
@@ -25,12 +25,29 @@ var NextLevel = stack.NewStack[DiffResult]()
 
 var taskMutex sync.Mutex // 确保任务串行执行
 
-// lastChangeCursor 记录变更查询已覆盖到的服务端时刻（unix 秒）。
-// 该值始终由服务端返回的 CoveredUntil 推进，绝不使用客户端本地时钟，
-// 以免客户端时钟快于服务端时漏查中间窗口的变更（服务端 changed_dirs
-// 只保留 1 小时）。0 表示"从窗口起点全查"，用作重连/全量扫描后的重置。
-// 任务由 taskMutex 保证串行，无需原子操作。
-var lastChangeCursor int64
+// changeCursors 记录各路上游的变更查询已覆盖到的位置（见 network.ChangeCursor），
+// 按 FileClient.Mount 区分（单上游即 "" 一项）。
+// 该值始终由服务端返回的位置推进，绝不使用客户端本地时钟，
+// 以免客户端时钟快于服务端时漏查中间窗口的变更。零值表示"尚未确立"，
+// 用作全量扫描后的重置。游标跨重连保留：协商了 FeatureJournal 时，
+// 重连后从这里接着追对端的变更日志，不必先做一轮全量对账（见 runMirrorTasks）。
+// 每路上游只由自己的循环读写；多上游时长轮询不持 taskMutex，map 本身由 cursorMu 保护
+var (
+	cursorMu      sync.Mutex
+	changeCursors = make(map[string]network.ChangeCursor)
+)
+
+func changeCursor(fileClient *network.FileClient) network.ChangeCursor {
+	cursorMu.Lock()
+	defer cursorMu.Unlock()
+	return changeCursors[fileClient.Mount]
+}
+
+func setChangeCursor(fileClient *network.FileClient, at network.ChangeCursor) {
+	cursorMu.Lock()
+	changeCursors[fileClient.Mount] = at
+	cursorMu.Unlock()
+}
 
 // handleConnectionError wraps connection error handling to reduce duplication
 func handleConnectionError(err error, fileClient *network.FileClient) error {
@@ -69,8 +86,8 @@ func executeTaskWithClient(taskName string, fileClient *network.FileClient, task
 }
 
 // ensureConnected makes sure we have a valid connection
-func ensureConnected() (*network.FileClient, error) {
-	fileClient, err := InitConn()
+func ensureConnected(dial func() (*network.FileClient, error)) (*network.FileClient, error) {
+	fileClient, err := dial()
 	if err != nil {
 		fileClient.ConnectionClose()
 		// 保留探测的具体失败原因（如加密口令不一致），方便用户定位
@@ -86,22 +103,42 @@ func ensureConnected() (*network.FileClient, error) {
 
 func Mirror() {
 	log.Debug("step 3 >> start file client")
+	mirrorLoop(InitConn)
+}
+
+// MirrorUpstreams 多上游汇（--upstream）：每路上游一个独立的拨号/重连循环，
+// 对端的树挂在同步根下各自的子目录里。各路共享同一个状态目录（cache.db 锁、
+// --status 快照）；任务仍经 taskMutex 串行（共用 NextLevel、移动检测池与同一棵
+// 本地树），只有等变更的长轮询各自挂起、互不阻塞
+func MirrorUpstreams() {
+	log.Debug("step 3 >> start file clients for every upstream")
+	for _, u := range config.Upstreams {
+		go mirrorLoop(func() (*network.FileClient, error) {
+			fileClient, err := connectPeer(u.Addr, "")
+			fileClient.Mount = u.Dir
+			return fileClient, err
+		})
+	}
+}
+
+// mirrorLoop 拨号 → 跑镜像任务 → 断线退避重拨，永不返回
+func mirrorLoop(dial func() (*network.FileClient, error)) {
 	baseDelay := 5 * time.Second
 	maxDelay := 60 * time.Second
 	currentDelay := baseDelay
 	for {
-		fileClient, err := ensureConnected()
+		fileClient, err := ensureConnected(dial)
 		if err != nil {
-			log.Error("Failed to connect: ", err)
+			log.Errorf("Failed to connect%s: %v", mountSuffix(fileClient), err)
 			time.Sleep(currentDelay)
 			currentDelay = time.Duration(float64(currentDelay) * 1.5)
 			currentDelay = min(currentDelay, maxDelay)
 			continue
 		}
 		currentDelay = baseDelay
-		status.SessionUp(fmt.Sprintf("connected to %s", fileClient.RealityAddr))
+		sessionDown := sessionUp(fmt.Sprintf("connected to %s%s", fileClient.RealityAddr, mountSuffix(fileClient)), fileClient)
 		err = runMirrorTasks(fileClient)
-		status.SessionDown()
+		sessionDown()
 		if err != nil {
 			status.RecordError()
 			log.Errorf("Error running mirror tasks: %v", err)
@@ -112,6 +149,31 @@ func Mirror() {
 	}
 }
 
+// sessionUp 在 status 里登记一个汇侧会话，身份模式下附上对端身份；
+// 返回的函数登记会话结束
+func sessionUp(detail string, fileClient *network.FileClient) func() {
+	peer := fileClient.Peer()
+	if peer == nil {
+		status.SessionUp(detail)
+		return status.SessionDown
+	}
+	id := peer.String()
+	status.PeerIdentityUp(id)
+	status.SessionUp(detail + " as " + id)
+	return func() {
+		status.PeerIdentityDown(id)
+		status.SessionDown()
+	}
+}
+
+// mountSuffix 多上游汇里给日志/状态补上这路上游落在哪个子目录
+func mountSuffix(fileClient *network.FileClient) string {
+	if fileClient == nil || fileClient.Mount == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (into ./%s)", filepath.ToSlash(fileClient.Mount))
+}
+
 // MirrorListen 汇监听格（--receive --listen，四象限）：不拨出，在
 // ServerListener 上等源端拨入，每条入站连接跑一轮完整镜像会话。
 // 协议报文与谁拨号无关——汇仍先说话（accept 后立即发握手）。
@@ -144,13 +206,17 @@ func MirrorListen() {
 			fileClient.ConnectionClose()
 			continue
 		}
-		log.Infof("Source dialed in from %s, mirror session starting", conn.RemoteAddr())
-		status.SessionUp(fmt.Sprintf("source dialed in from %s", conn.RemoteAddr()))
+		if p := network.PeerOf(prepared); p != nil {
+			log.Infof("Source dialed in from %s as %s, mirror session starting", conn.RemoteAddr(), p)
+		} else {
+			log.Infof("Source dialed in from %s, mirror session starting", conn.RemoteAddr())
+		}
+		sessionDown := sessionUp(fmt.Sprintf("source dialed in from %s", conn.RemoteAddr()), fileClient)
 		if err := runMirrorTasks(fileClient); err != nil {
 			status.RecordError()
 			log.Errorf("Mirror session over inbound transport ended: %v", err)
 		}
-		status.SessionDown()
+		sessionDown()
 		fileClient.ConnectionClose()
 	}
 }
@@ -187,25 +253,32 @@ func shouldRebuildLocalTree() bool {
 }
 
 func runMirrorTasks(fileClient *network.FileClient) error {
-	// 连接后先全量对账；重连（含休眠后 socket 断开）都会重新走到这里
-	if err := executeTaskWithClient("initial full scan", fileClient, fullScan); err != nil {
+	// 连接后先全量对账；重连（含休眠后 socket 断开）都会重新走到这里。
+	// 例外是手里已有对端变更日志的游标：对端重启过也好、断线几个小时也好，
+	// 第一轮变更追踪就能从游标补齐；接不上时服务端回 FullResync，照样全量对账
+	if cursor := changeCursor(fileClient); fileClient.Features()&network.FeatureJournal != 0 && cursor.Epoch != 0 {
+		log.Infof("resuming from change journal %016x#%d%s, skipping the initial full scan",
+			cursor.Epoch, cursor.Seq, mountSuffix(fileClient))
+	} else if err := executeTaskWithClient("initial full scan", fileClient, fullScan); err != nil {
 		return err
 	}
 
 	// 有了实时推送，全量扫描退化为低频安全网
 	fullScanInterval := time.Duration(*config.CoolDown) * time.Second
 	lastFullScan := time.Now()
+	seenReloads := ignoreReloads.Load()
 
 	for {
 		// 长轮询：阻塞等待服务端推送变更（无变更时约 LongPollHold 后返回空）。
 		// 空闲时客户端就阻塞在这一个 socket 读上，零轮询、零额外唤醒
 		beforePoll := time.Now()
-		if err := executeTaskWithClient("change tracking", fileClient, TrackingChanges); err != nil {
+		if err := trackChanges(fileClient); err != nil {
 			return err
 		}
 
 		// 休眠感知：长轮询最多挂 ~60s，墙钟却跳了远超此值 → 刚从休眠醒来。
-		// 服务端 changed_dirs 只保留 1 小时，睡久了增量窗口不可信，强制全量对账
+		// 时间游标的窗口只有 1 小时，睡久了增量窗口不可信；休眠期间本地也可能
+		// 被外部改动——都强制全量对账
 		if elapsed := time.Since(beforePoll); elapsed > sleepDetectThreshold {
 			log.Warnf("long sleep detected (%v), forcing a full reconciliation", elapsed.Round(time.Second))
 			// 休眠期间本地可能被外部改动，强制从磁盘重建本地树、无视节流（§5.3 补充触发）
@@ -217,8 +290,22 @@ func runMirrorTasks(fileClient *network.FileClient) error {
 			continue
 		}
 
-		// 低频全量扫描安全网，兜住推送链路任何潜在遗漏
-		if time.Since(lastFullScan) >= fullScanInterval {
+		// --scrub 到期：重读整个副本重算哈希，内容悄悄变了的文件随后的全量扫描重新下载。
+		// 多上游汇里只由一路执行，其余上游照常同步
+		if scrubDue() && scrubbing.CompareAndSwap(false, true) {
+			err := scrub(fileClient)
+			scrubbing.Store(false)
+			if err != nil {
+				return err
+			}
+			lastFullScan = time.Now()
+			continue
+		}
+
+		// 低频全量扫描安全网，兜住推送链路任何潜在遗漏；忽略规则重载后也立即补一轮，
+		// 把不再被忽略的路径拉下来（增量窗口里没有它们的变更记录）
+		if reloads := ignoreReloads.Load(); time.Since(lastFullScan) >= fullScanInterval || reloads != seenReloads {
+			seenReloads = reloads
 			if err := executeTaskWithClient("full scan", fileClient, fullScan); err != nil {
 				return err
 			}
@@ -230,6 +317,16 @@ func runMirrorTasks(fileClient *network.FileClient) error {
 func fullScan(fileClient *network.FileClient) error {
 	startTime := time.Now()
 
+	// 日志游标在扫描前取：扫描期间的变更落在它之后，扫完照常追上
+	var resume network.ChangeCursor
+	if fileClient.Features()&network.FeatureJournal != 0 {
+		head, err := fileClient.JournalHead()
+		if err != nil {
+			return handleConnectionError(err, fileClient)
+		}
+		resume = head
+	}
+
 	// COR-01：纯汇端没有 fsnotify watcher，运行期本地漂移（备份目录被外部改/删/增）不会
 	// 进树；而差异比对读的是 bbolt 缓存树、不是磁盘现状，漂移到重启前都不会被发现或修复。
 	// 全量扫描是低频安全网，正好在此按磁盘现状重建一次本地树（BuildFileTree 的校准模式：
@@ -246,9 +343,28 @@ func fullScan(fileClient *network.FileClient) error {
 		}
 	}
 
+	// 子树摘要剪枝要和本地树的最新状态比：先把积压的脏目录算掉
+	digestSkipped = 0
+	if fileClient.Features()&network.FeatureDigest != 0 {
+		if err := tree.RefreshDigests(); err != nil {
+			log.Warnf("full scan: failed to refresh local directory digests: %v", err)
+		}
+	}
+
+	// 多上游汇：这路上游的根是同步根下的 Mount 子目录，先确保它在本地与树里存在
+	root := "."
+	if fileClient.Mount != "" {
+		root = fileClient.Mount
+		if err := processDirectoryDiff(DiffResult{Path: root, Name: filepath.Base(root), IsDir: true, Action: "create"}); err != nil {
+			return err
+		}
+	}
+
+	beginMoveScan(false)
+	defer endMoveScan()
 	NextLevel.Clear()
 	NextLevel.Push(DiffResult{
-		Path:   ".",
+		Path:   root,
 		IsDir:  true,
 		Action: "create",
 		Name:   "root",
@@ -258,41 +374,85 @@ func fullScan(fileClient *network.FileClient) error {
 		return err
 	}
 
-	// 不用客户端时钟设置游标（会因时钟偏差漏查）。全量扫描后把游标重置为 0，
-	// 下一次变更追踪以 [0, 服务端now] 全查一次窗口（此时多为已同步的空 diff），
-	// 并从服务端返回的 CoveredUntil 重新确立游标，之后全程服务端时钟。
-	// 这也顺带覆盖了扫描期间发生的变更，不会遗漏。
-	lastChangeCursor = 0
+	// 不用客户端时钟设置游标（会因时钟偏差漏查）。日志游标用扫描前取到的末尾；
+	// 时间游标重置为 0，下一次变更追踪以 [0, 服务端now] 全查一次窗口（此时多为
+	// 已同步的空 diff），并从服务端返回的 CoveredUntil 重新确立游标，之后全程
+	// 服务端时钟。两者都覆盖了扫描期间发生的变更，不会遗漏。
+	setChangeCursor(fileClient, resume)
+	pruneVersions()
+	snapshotAfterScan()
 
-	log.Infof("Full scan completed, total time taken: %v", time.Since(startTime))
+	log.Infof("Full scan completed, total time taken: %v (%d identical subtrees skipped)", time.Since(startTime), digestSkipped)
 	return nil
 }
 
-func TrackingChanges(fileClient *network.FileClient) error {
-	change, coveredUntil, fullResync, err := fileClient.GetTreeChange(lastChangeCursor)
+// scrubWorkers 定期 scrub 的并发读数：常驻汇端的后台校验不该把磁盘读满
+const scrubWorkers = 2
+
+// scrubbing 某一路上游正在 scrub（多上游共用一个副本，重读一遍就够）
+var scrubbing atomic.Bool
+
+// scrubDue --scrub 是否到期。上次完成时刻记在缓存库里，重启不会让周期重新计起
+func scrubDue() bool {
+	return *config.ScrubDays > 0 && time.Since(tree.LastRehash()) >= time.Duration(*config.ScrubDays)*24*time.Hour
+}
+
+// scrub 重算副本所有文件的哈希（tree.Rehash），再全量扫描一轮修复不符的文件。
+// 大副本上重读要几个小时，不持 taskMutex（期间被同步改动的文件 Rehash 自会跳过），
+// 只有随后的全量扫描作为任务执行
+func scrub(fileClient *network.FileClient) error {
+	log.Infof("scrub: re-hashing the replica")
+	rh, err := tree.Rehash(scrubWorkers)
+	if err != nil {
+		status.RecordError()
+		return fmt.Errorf("scrub: %w", err)
+	}
+	for _, p := range rh.Mismatched {
+		log.Warnf("scrub: %s changed on disk without a new size or mtime, re-downloading", p)
+	}
+	log.Infof("scrub: re-hashed %d files (%s), %d changed silently", rh.Files, humanBytes(rh.Bytes), len(rh.Mismatched))
+	return executeTaskWithClient("scrub full scan", fileClient, fullScan)
+}
+
+// trackChanges 长轮询等待变更，拿到结果后作为任务应用。等待本身不持 taskMutex：
+// 空闲时挂起约 LongPollHold，多上游汇里一路上游的挂起不能堵住其余上游的任务
+func trackChanges(fileClient *network.FileClient) error {
+	if fileClient.State == network.Deprecated {
+		return fmt.Errorf("client is deprecated")
+	}
+	change, covered, fullResync, err := fileClient.GetTreeChange(changeCursor(fileClient))
 	if err != nil {
 		return handleConnectionError(err, fileClient)
 	}
+	return executeTaskWithClient("change tracking", fileClient, func(fileClient *network.FileClient) error {
+		return TrackingChanges(fileClient, change, covered, fullResync)
+	})
+}
+
+// TrackingChanges 应用一次变更查询的结果（change/covered/fullResync 见 GetTreeChange）
+func TrackingChanges(fileClient *network.FileClient, change []string, covered network.ChangeCursor, fullResync bool) error {
 
 	if fullResync {
-		// 服务端本区间变更数超阈值，列表被省略：全量对账一次。
-		// 注意 fullScan 会把游标归 0——若沿用，下一轮又会查到同一批超限
-		// 变更再触发全量，活锁到日志窗口滑过为止。这里覆盖为本次响应的
-		// CoveredUntil：全量扫描发生在响应之后，该时刻前的状态已被扫描覆盖
-		log.Warnf("server reports too many changed directories in the window, falling back to a full reconciliation")
+		// 服务端本区间变更数超阈值、或日志游标接不上，列表被省略：全量对账一次。
+		// 注意时间游标下 fullScan 会把游标归 0——若沿用，下一轮又会查到同一批
+		// 超限变更再触发全量，活锁到窗口滑过为止。这里覆盖为本次响应已覆盖的
+		// 位置：全量扫描发生在响应之后，该位置前的状态已被扫描覆盖
+		log.Warnf("server cannot send the changes incrementally (too many, or the change journal no longer covers our cursor), falling back to a full reconciliation")
 		if err := fullScan(fileClient); err != nil {
 			return err
 		}
-		lastChangeCursor = coveredUntil
+		setChangeCursor(fileClient, covered)
 		return nil
 	}
 
 	if len(change) == 0 {
 		// 长轮询保活返回，无变更；推进游标到服务端已覆盖时刻
-		lastChangeCursor = coveredUntil
+		setChangeCursor(fileClient, covered)
 		return nil
 	}
 	allPaths := extractMinimalPathsFromChanges(change)
+	beginMoveScan(true)
+	defer endMoveScan()
 	NextLevel.Clear()
 	// 本次变更批次内共享的失败隔离状态；不跨多次 TrackingChanges 调用持续，
 	// 一个文件持续失败时下次心跳周期会重新尝试（成本很低，且能自愈）
@@ -322,8 +482,11 @@ func TrackingChanges(fileClient *network.FileClient) error {
 	if err := drainNextLevel(fileClient, false); err != nil {
 		return err
 	}
+	if err := runDeferredCreates(fileClient, itemFailures, blacklist); err != nil {
+		return handleConnectionError(err, fileClient)
+	}
 	// 游标推进到服务端本次已覆盖的时刻，不重叠不遗漏
-	lastChangeCursor = coveredUntil
+	setChangeCursor(fileClient, covered)
 	return nil
 }
 
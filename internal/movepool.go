@@ -0,0 +1,317 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"local-mirror/config"
+	"local-mirror/internal/network"
+	"local-mirror/internal/safety"
+	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
+	"local-mirror/pkg/utils"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 跨目录移动检测（detectRenames 只能配对同一目录 diff 内的 delete/create）。
+// --allow-delete 要删除的文件不直接删，先按原相对路径移进暂存区 .local-mirror/moving，
+// 按哈希登记进池；之后任何目录的 create 若哈希命中，就从暂存区本地 rename 过去，
+// 免整文件重新下载。暂存满 moveGrace 仍没被认领的才真正删除（--keep-versions 下
+// 收进版本库），在每轮扫描结束时收尾。
+//
+// 暂存要跨轮次保留：源端移动一棵目录时，旧路径的删除与新目录本身很快可见，
+// 新目录下的文件却要等源端逐个重新哈希后才陆续出现在后面几轮变更里。
+//
+// 变更追踪的一批里，池满足不了的文件 create 推迟到本批其余目录处理完再执行：
+// 同一层级内的单文件移动（a/x → b/x）不论两个目录谁先处理都能配上对。全量扫描
+// 不推迟（首次同步动辄百万文件，不能全压到最后），同层移动在目标目录先被处理时
+// 照旧下载，结果同样正确。
+//
+// 暂存区按原相对路径组织，进程退出时留下的文件，下次启动据路径按同样规则收尾，
+// 不会丢失本该留存的版本
+type movePool struct {
+	mu       sync.Mutex
+	ready    bool                   // 已收尾过上次进程的遗留、可以暂存
+	byHash   map[string][]string    // 哈希 → 暂存文件的原相对路径
+	staged   map[string]stagedEntry // 原相对路径 → 暂存记录
+	deferOK  bool                   // 本轮是否推迟池满足不了的 create（仅变更追踪）
+	deferred []DiffResult
+}
+
+type stagedEntry struct {
+	hash string
+	at   time.Time
+}
+
+// moveGrace 暂存文件等待认领的时长。要盖住源端对整棵移入目录重新哈希、逐批
+// 公布的耗时；代价是这期间被删文件仍占着磁盘
+const moveGrace = 5 * time.Minute
+
+var moves = &movePool{}
+
+func movingDir() string {
+	return filepath.Join(config.StartPath, ".local-mirror", "moving")
+}
+
+// beginMoveScan 开始一轮扫描。仅 --allow-delete 下有意义（默认模式不删除，也就
+// 没有可配对的删除）；首轮先收尾上次进程遗留的暂存文件。deferCreates 见类型注释
+func beginMoveScan(deferCreates bool) {
+	if !*config.AllowDelete {
+		return
+	}
+	moves.mu.Lock()
+	ready := moves.ready
+	moves.mu.Unlock()
+	if !ready {
+		finishStaged(leftoverStaged())
+	}
+	moves.mu.Lock()
+	if !moves.ready {
+		moves.ready = true
+		moves.byHash = make(map[string][]string)
+		moves.staged = make(map[string]stagedEntry)
+	}
+	moves.deferOK = deferCreates
+	moves.deferred = nil
+	moves.mu.Unlock()
+}
+
+// endMoveScan 结束本轮：暂存超过 moveGrace 仍没被认领的，即真正的删除
+func endMoveScan() {
+	moves.mu.Lock()
+	moves.deferOK = false
+	moves.deferred = nil
+	var expired []string
+	cutoff := time.Now().Add(-moveGrace)
+	for rel, e := range moves.staged {
+		if e.at.Before(cutoff) {
+			expired = append(expired, rel)
+			moves.unindex(rel, e.hash)
+		}
+	}
+	moves.mu.Unlock()
+	finishStaged(expired)
+}
+
+// unindex 从池里摘掉 rel（调用方持锁）
+func (p *movePool) unindex(rel, hash string) {
+	delete(p.staged, rel)
+	rels := p.byHash[hash]
+	for i, r := range rels {
+		if r == rel {
+			p.byHash[hash] = append(rels[:i], rels[i+1:]...)
+			break
+		}
+	}
+	if len(p.byHash[hash]) == 0 {
+		delete(p.byHash, hash)
+	}
+}
+
+// deferCreate 本轮推迟 v 时返回 true：v 是池里暂时没有对应内容的文件 create
+func deferCreate(v DiffResult) bool {
+	if v.Action != "create" || !isFileDownload(v) {
+		return false
+	}
+	moves.mu.Lock()
+	defer moves.mu.Unlock()
+	if !moves.deferOK || len(moves.byHash[v.Hash]) > 0 {
+		return false
+	}
+	moves.deferred = append(moves.deferred, v)
+	return true
+}
+
+// runDeferredCreates 执行本轮推迟的 create：此时本批所有删除都已进池，能配对的
+// 本地 rename，其余照常（按 --parallel）下载
+func runDeferredCreates(fileClient *network.FileClient, itemFailures map[string]int, blacklist map[string]bool) error {
+	moves.mu.Lock()
+	items := moves.deferred
+	moves.deferred = nil
+	moves.mu.Unlock()
+	if len(items) == 0 {
+		return nil
+	}
+	diskFullSkipped := 0
+	err := downloadConcurrently(fileClient.Workers(*config.Parallel), items, itemFailures, blacklist, &diskFullSkipped)
+	if diskFullSkipped > 0 {
+		log.Errorf("%d files skipped for low disk space; they will catch up automatically once space is freed", diskFullSkipped)
+	}
+	return err
+}
+
+// stageDeleted 把即将删除的 v（文件或整棵子树）中有哈希的普通文件移进暂存区。
+// 移不动的（跨文件系统、权限等）留在原处，随后的 RemoveAll 照常删除
+func stageDeleted(v DiffResult) {
+	moves.mu.Lock()
+	ready := moves.ready
+	moves.mu.Unlock()
+	if !ready || v.IsSymlink() {
+		return
+	}
+	full, err := safety.SafeResolveEntry(config.StartPath, v.Path)
+	if err != nil {
+		return
+	}
+	if !v.IsDir {
+		stageFile(v.Path, full, v.Hash)
+		return
+	}
+	_ = filepath.WalkDir(full, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(config.StartPath, path)
+		if err != nil {
+			return nil
+		}
+		if node, err := tree.GetNodeByPath(rel); err == nil && !node.IsSymlink() {
+			stageFile(rel, path, node.Hash)
+		}
+		return nil
+	})
+}
+
+func stageFile(rel, full, hash string) {
+	if hash == "" {
+		return
+	}
+	dst := filepath.Join(movingDir(), rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return
+	}
+	moves.mu.Lock()
+	defer moves.mu.Unlock()
+	if _, dup := moves.staged[rel]; dup {
+		return // 同一路径已暂存着一份（删了又建又删），后来的照常删除
+	}
+	if err := os.Rename(full, dst); err != nil {
+		log.Debugf("cannot stage %s for move detection, deleting it instead: %v", rel, err)
+		return
+	}
+	moves.staged[rel] = stagedEntry{hash: hash, at: time.Now()}
+	moves.byHash[hash] = append(moves.byHash[hash], rel)
+}
+
+// claimMove 用暂存区里哈希相同的文件满足一次 create。与 applyRename 同样不信任
+// 登记的哈希：rename 前重算暂存文件的哈希，对不上就按删除收尾掉它，
+// 返回 false 由调用方照常下载
+func claimMove(v DiffResult) (bool, error) {
+	if v.Hash == "" || v.IsDir || v.IsSymlink() {
+		return false, nil
+	}
+	moves.mu.Lock()
+	cands := moves.byHash[v.Hash]
+	if len(cands) == 0 {
+		moves.mu.Unlock()
+		return false, nil
+	}
+	oldRel := cands[len(cands)-1]
+	moves.unindex(oldRel, v.Hash)
+	moves.mu.Unlock()
+
+	staged := filepath.Join(movingDir(), oldRel)
+	if err := verifyLocalHash(staged, v.Hash); err != nil {
+		log.Debugf("staged %s no longer matches its recorded hash, downloading %s instead: %v", oldRel, v.Path, err)
+		finishStaged([]string{oldRel})
+		return false, nil
+	}
+	newFull, err := safety.SafeResolve(config.StartPath, v.Path)
+	if err != nil {
+		log.Errorf("refusing to move to out-of-root path: %v", err)
+		finishStaged([]string{oldRel})
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(newFull), 0755); err != nil {
+		finishStaged([]string{oldRel})
+		return true, err
+	}
+	if *config.KeepVersions {
+		if err := versions.Preserve(config.StartPath, v.Path, time.Now(), versionPolicy()); err != nil {
+			finishStaged([]string{oldRel})
+			return true, err
+		}
+	}
+	if err := os.Rename(staged, newFull); err != nil {
+		finishStaged([]string{oldRel})
+		return false, nil
+	}
+	applyModTime(v)
+	if err := tree.AddNodes([]*tree.Node{createNodeFromDiff(v, v.Hash)}); err != nil {
+		return true, err
+	}
+	log.Infof("move detected: %s -> %s (local rename, no download)", oldRel, v.Path)
+	return true, nil
+}
+
+// finishStaged 对没被认领的暂存文件执行当初推迟的删除：--keep-versions 下收进
+// 版本库，否则删掉。删空的暂存目录随之清理
+func finishStaged(rels []string) {
+	now := time.Now()
+	for _, rel := range rels {
+		src := filepath.Join(movingDir(), rel)
+		if *config.KeepVersions {
+			if err := versions.Adopt(config.StartPath, rel, src, now, versionPolicy()); err != nil {
+				log.Warnf("keeping deleted version of %s: %v", rel, err)
+			} else {
+				continue
+			}
+		}
+		if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+			log.Warnf("removing staged %s: %v", rel, err)
+		}
+	}
+	if len(rels) > 0 {
+		removeEmptyDirs(movingDir())
+	}
+}
+
+// leftoverStaged 上次扫描中途退出时留在暂存区的文件（原相对路径）
+func leftoverStaged() []string {
+	var rels []string
+	dir := movingDir()
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			rels = append(rels, rel)
+		}
+		return nil
+	})
+	if len(rels) > 0 {
+		log.Infof("finishing %d deletions left staged by an interrupted scan", len(rels))
+	}
+	return rels
+}
+
+// removeEmptyDirs 自底向上删掉 dir 下（含 dir 自身）的空目录
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // 非空目录删除失败，正是想要的效果
+	}
+}
+
+// verifyLocalHash 重算 full 的哈希并与 want 比对；不是普通文件同样视为不符
+func verifyLocalHash(full, want string) error {
+	linfo, err := os.Lstat(full)
+	if err != nil || !linfo.Mode().IsRegular() {
+		return fmt.Errorf("not a regular file (drifted or gone): %s", full)
+	}
+	h, err := utils.CalcBlake3(full)
+	if err != nil || fmt.Sprintf("%x", h) != want {
+		return fmt.Errorf("hash mismatch (drifted): %s", full)
+	}
+	return nil
+}
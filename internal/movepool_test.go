@@ -0,0 +1,79 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
+	"local-mirror/pkg/utils"
+)
+
+// TestCrossDirectoryMove 删除整个目录时其下文件先进暂存池，别的目录随后创建同内容
+// 文件即本地 rename 过去（不需要连接）；暂存满宽限期仍没被认领的在扫描结束时才真正删除，
+// --keep-versions 下收进版本库
+func TestCrossDirectoryMove(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+	saveDel, saveKeep := config.AllowDelete, config.KeepVersions
+	defer func() { config.AllowDelete, config.KeepVersions = saveDel, saveKeep }()
+	on := true
+	config.AllowDelete, config.KeepVersions = &on, &on
+
+	for rel, content := range map[string]string{"big/dir/a.bin": "moved", "big/dir/sub/b.bin": "gone"} {
+		full := filepath.Join(root, rel)
+		os.MkdirAll(filepath.Dir(full), 0o755)
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := utils.CalcBlake3(filepath.Join(root, "big/dir/a.bin"))
+	hash := fmt.Sprintf("%x", h)
+
+	beginMoveScan(false)
+	if err := processDiffItem(DiffResult{Path: filepath.Join("big", "dir"), IsDir: true, Action: "delete"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "big", "dir")); !os.IsNotExist(err) {
+		t.Fatal("被删目录应已移除")
+	}
+	dst := filepath.Join("other", "place", "a.bin")
+	if err := processDiffItem(DiffResult{Path: dst, Name: "a.bin", Action: "create", Size: 5, Hash: hash}, nil); err != nil {
+		t.Fatalf("同哈希的 create 应由暂存池满足而不下载: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, dst)); string(b) != "moved" {
+		t.Fatalf("移动后的内容不对: %q", b)
+	}
+	if n, err := tree.GetNodeByPath(dst); err != nil || n.Hash != hash {
+		t.Fatalf("新路径应入树: %+v %v", n, err)
+	}
+	// 模拟等待认领的宽限期已过
+	moves.mu.Lock()
+	for rel, e := range moves.staged {
+		e.at = e.at.Add(-moveGrace - time.Second)
+		moves.staged[rel] = e
+	}
+	moves.mu.Unlock()
+	endMoveScan()
+
+	if _, err := os.Stat(movingDir()); !os.IsNotExist(err) {
+		t.Error("扫描结束后暂存区应清空")
+	}
+	vs, _ := versions.List(root, filepath.Join("big", "dir", "sub", "b.bin"))
+	if len(vs) != 1 {
+		t.Errorf("没被认领的删除应收进版本库，实际 %+v", vs)
+	}
+	if vs, _ := versions.List(root, filepath.Join("big", "dir", "a.bin")); len(vs) != 0 {
+		t.Errorf("被认领的文件是移动而非删除，不应留版本，实际 %+v", vs)
+	}
+}
@@ -0,0 +1,234 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 按对端限定可见子树（ACL）：<同步根>/.local-mirror/acl 每行 "<对端> <路径>..."，
+// # 注释。对端写 authorized_peers 里的标签或身份指纹（--identity）、共享密钥的指纹
+// （--show-key 所示）、TLS 证书的 CN 或 SHA-256 指纹，或 * 表示其余所有人；路径相对同步根，"." 即整棵树。
+// 没有 acl 文件 = 不限制（与从前一致）；有文件时一行都匹配不上的对端什么都看不到。
+// 一个对端匹配多行取并集，具名行匹配上就不再看 * 行。
+//
+// 目录树请求只列允许的子树及通向它们的祖先目录（祖先目录里的其余条目隐去、不带摘要，
+// 免得摘要泄露看不到的内容），文件与增量请求只许允许子树内的文件，变更推送只报可见
+// 目录；越界请求回 ErrCodePermissionDenied。文件改了下一个请求即按新规则（按 mtime 重读）
+
+// ACLPath 返回同步根下的 ACL 文件路径
+func ACLPath(root string) string {
+	return filepath.Join(root, ".local-mirror", "acl")
+}
+
+// aclRule ACL 的一行
+type aclRule struct {
+	peer  string
+	paths []string // 已 Clean 成 OS 分隔符形式，"." 为整棵树
+}
+
+// aclState 解析结果按文件 mtime/size 缓存，每个请求只多一次 stat
+var aclState struct {
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	present bool
+	rules   []aclRule
+	err     error
+}
+
+// parseACL 解析 ACL 文件内容
+func parseACL(data []byte) ([]aclRule, error) {
+	var rules []aclRule
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: want \"<peer> <path>...\", got %q", n, line)
+		}
+		rule := aclRule{peer: fields[0]}
+		for _, raw := range fields[1:] {
+			p := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(raw, "/")))
+			if filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, ".."+string(filepath.Separator)) {
+				return nil, fmt.Errorf("line %d: path %q must stay inside the sync root", n, raw)
+			}
+			rule.paths = append(rule.paths, p)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// loadACL 读取（必要时重读）ACL 文件。present 为 false 表示没有 ACL、不限制
+func loadACL() (rules []aclRule, present bool, err error) {
+	aclState.mu.Lock()
+	defer aclState.mu.Unlock()
+	path := ACLPath(config.StartPath)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		aclState.present, aclState.rules, aclState.err = false, nil, nil
+		aclState.modTime, aclState.size = time.Time{}, 0
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if aclState.present && info.ModTime().Equal(aclState.modTime) && info.Size() == aclState.size {
+		return aclState.rules, true, aclState.err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	aclState.rules, aclState.err = parseACL(data)
+	if aclState.err != nil {
+		aclState.err = fmt.Errorf("%s %w", path, aclState.err)
+	}
+	aclState.present, aclState.modTime, aclState.size = true, info.ModTime(), info.Size()
+	return aclState.rules, true, aclState.err
+}
+
+// CheckACL 启动时校验 ACL 文件，写错了尽早报出来而不是让每个对端都被拒
+func CheckACL() error {
+	_, _, err := loadACL()
+	return err
+}
+
+// grant 一个对端可见的子树
+type grant struct {
+	all   bool     // 不受限
+	paths []string // 允许的子树（all 为 false 时）
+}
+
+// grantFor 按对端的名字（标签、身份指纹、密钥指纹）合出它的授权
+func grantFor(rules []aclRule, names []string) grant {
+	var g grant
+	named := false
+	for _, r := range rules {
+		for _, name := range names {
+			if r.peer == name {
+				named = true
+				g.paths = append(g.paths, r.paths...)
+				break
+			}
+		}
+	}
+	if !named {
+		for _, r := range rules {
+			if r.peer == "*" {
+				g.paths = append(g.paths, r.paths...)
+			}
+		}
+	}
+	for _, p := range g.paths {
+		if p == "." {
+			return grant{all: true}
+		}
+	}
+	return g
+}
+
+// allows rel（OS 分隔符、根为 "."）是否在某个允许的子树内
+func (g grant) allows(rel string) bool {
+	if g.all {
+		return true
+	}
+	rel = filepath.Clean(rel)
+	for _, p := range g.paths {
+		if rel == p || strings.HasPrefix(rel, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reveals rel 是否可见：在允许的子树内，或是通向某个允许子树的祖先目录
+func (g grant) reveals(rel string) bool {
+	if g.allows(rel) {
+		return true
+	}
+	rel = filepath.Clean(rel)
+	for _, p := range g.paths {
+		if rel == "." || strings.HasPrefix(p, rel+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter 筛出目录页里可见的条目。只因通向允许子树而可见的目录去掉摘要。
+// page 可能是缓存快照的子切片，结果是新切片、不改原条目
+func (g grant) filter(page []tree.Node) []tree.Node {
+	if g.all {
+		return page
+	}
+	out := make([]tree.Node, 0, len(page))
+	for _, n := range page {
+		switch {
+		case g.allows(n.Path):
+			out = append(out, n)
+		case n.IsDir && g.reveals(n.Path):
+			n.Digest = ""
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// peerNames 连接在 ACL 里可被指认的名字：身份模式下对端的标签与身份指纹，
+// 加密连接用的共享密钥指纹，TLS 连接对端证书的 CN 与 SHA-256 指纹。
+// 明文连接没有名字，只受 * 行约束
+func peerNames(conn net.Conn) []string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		return tlsPeerNames(tc)
+	}
+	s, ok := conn.(*secureConn)
+	if !ok {
+		return nil
+	}
+	var names []string
+	if s.peer != nil {
+		if s.peer.Label != "" {
+			names = append(names, s.peer.Label)
+		}
+		names = append(names, s.peer.Fingerprint())
+	}
+	if s.keyFP != "" {
+		names = append(names, s.keyFP)
+	}
+	return names
+}
+
+// access 该客户端当前的授权。ACL 文件读不出或写错时什么都不给（fail closed）
+func (c *client) access() grant {
+	rules, present, err := loadACL()
+	if !present {
+		return grant{all: true}
+	}
+	if err != nil {
+		log.Errorf("refusing every request from %s until the ACL is fixed: %v", c.Addr, err)
+		return grant{}
+	}
+	return grantFor(rules, c.names)
+}
+
+// deniedByACL 越界请求的结构化应答
+func deniedByACL(path string) *wireError {
+	return &wireError{Code: ErrCodePermissionDenied, Path: path, Message: "not permitted for this peer by .local-mirror/acl"}
+}
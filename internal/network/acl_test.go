@@ -0,0 +1,94 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+)
+
+// TestACLGrants 具名行取并集且优先于 *；允许子树内可读，祖先目录只可见；
+// 目录页里隐去其余条目，只通向允许子树的目录去掉摘要
+func TestACLGrants(t *testing.T) {
+	rules, err := parseACL([]byte("# teams\nteam-a projects/a shared\nteam-a /docs\n* public\nab12cd34 .\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseACL([]byte("team-a ../etc\n")); err == nil {
+		t.Fatal("paths escaping the root should be refused")
+	}
+	if _, err := parseACL([]byte("team-a\n")); err == nil {
+		t.Fatal("a rule without paths should be refused")
+	}
+
+	a := grantFor(rules, []string{"team-a", "ffffffff"})
+	j := func(p ...string) string { return filepath.Join(p...) }
+	for _, p := range []string{j("projects", "a"), j("projects", "a", "x.txt"), "shared", "docs"} {
+		if !a.allows(p) {
+			t.Errorf("team-a should read %s", p)
+		}
+	}
+	for _, p := range []string{"public", j("projects", "b"), j("projects", "ab"), "."} {
+		if a.allows(p) {
+			t.Errorf("team-a should not read %s", p)
+		}
+	}
+	if !a.reveals(".") || !a.reveals("projects") || a.reveals(j("projects", "b")) {
+		t.Error("only ancestors of allowed subtrees should be visible")
+	}
+
+	if other := grantFor(rules, nil); !other.allows("public") || other.allows("shared") {
+		t.Errorf("unnamed peers fall back to *: %+v", other)
+	}
+	if !grantFor(rules, []string{"ab12cd34"}).all {
+		t.Error("a rule for . grants the whole tree")
+	}
+	if none := grantFor(rules[:2], nil); none.reveals(".") {
+		t.Error("without a * rule an unnamed peer sees nothing")
+	}
+
+	page := []tree.Node{
+		{Path: "projects", IsDir: true, Digest: "d1"},
+		{Path: "public", IsDir: true, Digest: "d2"},
+		{Path: "shared", IsDir: true, Digest: "d3"},
+		{Path: "top.txt", Hash: "h"},
+	}
+	got := a.filter(page)
+	if len(got) != 2 || got[0].Path != "projects" || got[0].Digest != "" || got[1].Path != "shared" || got[1].Digest != "d3" {
+		t.Fatalf("filtered page: %+v", got)
+	}
+	if page[0].Digest != "d1" {
+		t.Fatal("filter must not modify the cached page")
+	}
+	if changes := visibleChanges([]string{".", "projects", "public", j("shared", "x")}, a); !slices.Equal(changes, []string{".", "projects", j("shared", "x")}) {
+		t.Fatalf("visible changes: %v", changes)
+	}
+}
+
+// TestACLReload 没有 acl 文件不限制；写入后下一次取授权即生效，写错了什么都不给
+func TestACLReload(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	if err := os.MkdirAll(filepath.Join(root, ".local-mirror"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{Addr: "test", names: []string{"team-a"}}
+	if !c.access().all {
+		t.Fatal("no ACL file means no restriction")
+	}
+	if err := os.WriteFile(ACLPath(root), []byte("team-a shared\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if g := c.access(); g.all || !g.allows("shared") {
+		t.Fatalf("ACL not picked up: %+v", g)
+	}
+	if err := os.WriteFile(ACLPath(root), []byte("team-a\n# broken\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if CheckACL() == nil || c.access().reveals(".") {
+		t.Fatal("a malformed ACL should be reported and grant nothing")
+	}
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"local-mirror/config"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter 令牌桶限速（--bwlimit）。速率随时段计划变化，每次取令牌时
+// 按当前时刻求值；桶容量为一秒的配额，空闲攒下的令牌至多换来一秒突发。
+//
+// 采用「先扣后睡」：扣成负数即欠账，按欠账睡足再返回。并发的多条连接
+// （--parallel、多个下游）共用一个桶，各自看到更深的欠账、睡更久，
+// 合计吞吐自然收敛到上限。限速按消息体原始字节计（压缩前），实际上线更少
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   uint64 // 上次取令牌时生效的速率，变化时重置桶
+	tokens float64
+	last   time.Time
+}
+
+var (
+	sendLimiter bandwidthLimiter // 源端：文件数据与增量指令的发送
+	recvLimiter bandwidthLimiter // 汇端：文件数据与增量指令的接收
+)
+
+// wait 取 n 字节的令牌，不足则阻塞到配额够为止。当前时段不限速时立即返回
+func (l *bandwidthLimiter) wait(n int) {
+	l.waitAt(n, config.Bandwidth.LimitAt(time.Now()), time.Now(), time.Sleep)
+}
+
+// waitAt 是 wait 的可测形态：速率、时刻与睡眠函数由调用方给出
+func (l *bandwidthLimiter) waitAt(n int, rate uint64, now time.Time, sleep func(time.Duration)) {
+	if rate == 0 || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	if rate != l.rate || l.last.IsZero() {
+		// 首次使用或时段切换：按新速率给满一秒配额重新起算
+		l.rate, l.tokens, l.last = rate, float64(rate), now
+	}
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), float64(rate))
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay > 0 {
+		sleep(delay)
+	}
+}
@@ -0,0 +1,44 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBandwidthLimiterPacing 一秒突发之后按速率欠账睡眠：1 MB/s 下连取
+// 3 MB，总睡眠应约 2s；空闲攒的令牌不超过一秒配额；时段切换按新速率重起
+func TestBandwidthLimiterPacing(t *testing.T) {
+	const rate = 1 << 20
+	var l bandwidthLimiter
+	var slept time.Duration
+	now := time.Now()
+	sleep := func(d time.Duration) { slept += d; now = now.Add(d) }
+
+	for i := 0; i < 3; i++ {
+		l.waitAt(rate, rate, now, sleep)
+	}
+	if slept < 1900*time.Millisecond || slept > 2100*time.Millisecond {
+		t.Errorf("1 MB/s 下取 3 MB 应睡约 2s，实际 %v", slept)
+	}
+
+	// 空闲一分钟：桶封顶一秒配额（1 MB），取 2 MB 欠 1 MB
+	slept = 0
+	now = now.Add(time.Minute)
+	l.waitAt(2*rate, rate, now, sleep)
+	if slept < 900*time.Millisecond || slept > 1100*time.Millisecond {
+		t.Errorf("空闲后突发应封顶一秒配额，实际睡 %v", slept)
+	}
+
+	// 时段切换到不限速：立即返回
+	slept = 0
+	l.waitAt(100*rate, 0, now, sleep)
+	if slept != 0 {
+		t.Errorf("不限速时段不应睡眠，实际 %v", slept)
+	}
+
+	// 切到新速率：按新速率给满一秒配额重起，旧欠账作废
+	l.waitAt(4*rate, 4*rate, now, sleep)
+	if slept != 0 {
+		t.Errorf("速率切换后首个一秒配额内不应睡眠，实际 %v", slept)
+	}
+}
@@ -14,7 +14,9 @@ import (
 // changeFullResyncThreshold 变更响应降级阈值。单次区间查询命中的变更目录
 // 超过此数时不再下发列表，改为 FullResync 信号让客户端全量对账——
 // 既避免响应逼近消息体上限（此前是确定性失败 + 最长 1 小时的重连活锁），
-// 也因为处理上万条目录 diff 本就比一次全量扫描更慢
+// 也因为处理上万条目录 diff 本就比一次全量扫描更慢。
+// 按日志游标查询的不受此限：日志按同一条数分页，汇端断线再久也逐页追上，
+// 只有单条日志（如源端启动校准补记的一批）就超过阈值时才降级
 const changeFullResyncThreshold = 8192
 
 // buildRecentChangeResponse 组装变更响应：数量超过阈值时降级为 FullResync
@@ -35,39 +37,102 @@ func buildRecentChangeResponse(changes []string, now int64) RecentChangeResponse
 	}
 }
 
-func (s *fileServer) handleRecentChangeRequest(ID uint32, bodyBytes []byte) error {
-	_client, ok := s.clientMap.Load(ID)
-	if !ok {
-		// 与其余 handler 一致：未握手/已注销的连接按连接错误关闭，
-		// 而不是静默不应答让对端干等读超时
-		return fmt.Errorf("%w, client not found for ID: %d", appError.ErrConnection, ID)
+// subscribedChanges 按客户端的订阅范围（--include）筛变更目录：留下范围内的、
+// 以及通向范围内路径的祖先目录（新的订阅子目录就建在这些目录里）。
+// 客户端仍会在本地再过滤一遍，这里只是少报、少让它白跑——所以模式超出
+// 匹配代价上限时（只可能是构造的请求）不筛、原样全报
+func subscribedChanges(changes, include []string) []string {
+	if len(include) == 0 {
+		return changes
 	}
-	conn := _client.(*client).Conn
+	for _, p := range include {
+		if utils.IncludeGlobstars(p) > utils.MaxIncludeGlobstars {
+			return changes
+		}
+	}
+	kept := changes[:0:0]
+	for _, dir := range changes {
+		if utils.IsIncluded(dir, true, include) {
+			kept = append(kept, dir)
+		}
+	}
+	return kept
+}
+
+// visibleChanges 只留 ACL 下可见的变更目录（允许的子树内或通向它们的祖先）
+func visibleChanges(changes []string, access grant) []string {
+	if access.all {
+		return changes
+	}
+	kept := changes[:0:0]
+	for _, dir := range changes {
+		if access.reveals(dir) {
+			kept = append(kept, dir)
+		}
+	}
+	return kept
+}
+
+func (s *fileServer) handleRecentChangeRequest(c *client, bodyBytes []byte) error {
+	if !c.Connected {
+		// 未握手/已注销的连接按连接错误关闭，而不是静默不应答让对端干等读超时
+		return fmt.Errorf("%w, client %s has not completed handshake", appError.ErrConnection, c.Addr)
+	}
+	conn := c.Conn
 	recentChangeRequest, err := decodeRecentChangeRequest(bodyBytes)
 	if err != nil {
 		return fmt.Errorf("%w, error decoding recent change request: %v", appError.ErrConnection, err)
 	}
 	log.Debugf("Received recent change request from %s, client ID: %d, startTime: %d",
 		conn.RemoteAddr().String(), recentChangeRequest.ClientID, recentChangeRequest.StartTime)
+	if !c.access().reveals(".") {
+		return deniedByACL(".")
+	}
 
 	// 长轮询：区间内已有变更立刻回（追赶/重连场景）；无变更则挂起，
 	// 等到变更落库广播或挂满上限后返回。挂起期间不读 socket，
 	// 上限兜底避免死连接常驻。上界用服务端当前时刻，随每次唤醒重新求值。
+	// 协商了 FeatureJournal 的客户端按日志游标查询：游标接不上（源端换了库、
+	// 日志已清理到游标之后）立刻回 FullResync，Epoch 为 0 的请求只取日志末尾；
+	// 日志一页读不完时也立刻回，客户端带着新游标接着读下一页
 	start := recentChangeRequest.StartTime
+	from := tree.JournalPos{Epoch: recentChangeRequest.Epoch, Seq: recentChangeRequest.Since}
 	holdDeadline := time.Now().Add(LongPollHold)
 	for {
 		// 先取信号再查询：若广播发生在查询之后、select 之前，
 		// 该 channel 已被 close，select 立即返回并重查，不会漏
 		sig := tree.ChangeSignal()
 		now := time.Now().Unix()
-		recentChanges, err := tree.GetChangedDirs(start, now)
+		var recentChanges []string
+		head, immediate, more := from, false, false
+		if recentChangeRequest.Journal {
+			var ok bool
+			recentChanges, head, more, ok, err = tree.ReadJournal(from, changeFullResyncThreshold)
+			if err != nil {
+				head = from // 查询失败游标原地不动，客户端下一轮重试
+			}
+			immediate = !ok && err == nil
+		} else {
+			recentChanges, err = tree.GetChangedDirs(start, now)
+		}
 		if err != nil {
 			log.Error("Error getting changed dirs:", err)
 			recentChanges = nil
 		}
+		// 订阅范围外的变更不报，也不因它们提前结束挂起；ACL 看不到的目录同样。
+		// 授权每轮重取：挂起期间改了 ACL 也按新规则
+		recentChanges = visibleChanges(subscribedChanges(recentChanges, recentChangeRequest.Include), c.access())
 
-		if len(recentChanges) > 0 || err != nil || !time.Now().Before(holdDeadline) {
+		if len(recentChanges) > 0 || err != nil || immediate || more || !time.Now().Before(holdDeadline) {
 			responseMsg := buildRecentChangeResponse(recentChanges, now)
+			if recentChangeRequest.Journal {
+				responseMsg.Epoch, responseMsg.Seq = head.Epoch, head.Seq
+				if immediate && from.Epoch != 0 {
+					log.Infof("change journal cursor %016x#%d from %s is not available (journal is at %016x#%d), asking for a full reconciliation",
+						from.Epoch, from.Seq, conn.RemoteAddr().String(), head.Epoch, head.Seq)
+					responseMsg.FullResync, responseMsg.Changes = true, nil
+				}
+			}
 			if serr := sendMessage(conn, MsgTypeRecentChangeResponse, encodeRecentChangeResponse(responseMsg)); serr != nil {
 				return fmt.Errorf("%w, error sending recent change response: %v", appError.ErrConnection, serr)
 			}
@@ -0,0 +1,78 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+)
+
+// TestJournalCatchUpPaged 汇端断线期间源端累计变更目录超过 changeFullResyncThreshold：
+// 按日志游标追赶时逐页下发、游标逐页推进，全程不降级为 FullResync
+func TestJournalCatchUpPaged(t *testing.T) {
+	config.StartPath = t.TempDir()
+	config.IgnoreFileList = []string{".local-mirror"}
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.CloseJournal(); err != nil { // 同包其它用例留在节流批次里的变更先落掉
+		t.Fatal(err)
+	}
+	from, err := tree.JournalHead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const batches, perBatch = 3, 3000 // 共 9000 > 8192
+	for b := range batches {
+		for i := range perBatch {
+			tree.AddRecentChangedDir(fmt.Sprintf("b%d/d%05d", b, i))
+		}
+		if err := tree.CloseJournal(); err != nil { // 每批落成一条日志
+			t.Fatal(err)
+		}
+	}
+	head, _ := tree.JournalHead()
+
+	s := &fileServer{}
+	seen := make(map[string]bool)
+	pages := 0
+	for from != head {
+		server, peer := net.Pipe()
+		c := &client{Addr: "test", Connected: true, Conn: server}
+		req := RecentChangeRequestMessage{Journal: true, Epoch: from.Epoch, Since: from.Seq}
+		errc := make(chan error, 1)
+		go func() { errc <- s.handleRecentChangeRequest(c, encodeRecentChangeRequest(req)) }()
+		msgType, body, err := receiveMessage(peer)
+		if err != nil || msgType != MsgTypeRecentChangeResponse {
+			t.Fatalf("receive: type=%d err=%v", msgType, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		peer.Close()
+		resp, err := decodeRecentChangeResponse(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.FullResync {
+			t.Fatalf("page %d: journal catch-up fell back to FullResync", pages)
+		}
+		if resp.Epoch != from.Epoch || resp.Seq <= from.Seq {
+			t.Fatalf("page %d: cursor did not advance: %016x#%d -> %016x#%d", pages, from.Epoch, from.Seq, resp.Epoch, resp.Seq)
+		}
+		for _, d := range resp.Changes {
+			seen[d] = true
+		}
+		from = tree.JournalPos{Epoch: resp.Epoch, Seq: resp.Seq}
+		pages++
+	}
+	if len(seen) != batches*perBatch {
+		t.Errorf("received %d distinct dirs, want %d", len(seen), batches*perBatch)
+	}
+	if pages < 2 {
+		t.Errorf("expected the catch-up to span several pages, got %d", pages)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"local-mirror/config"
 	"local-mirror/internal/appError"
+	"local-mirror/internal/keyfile"
 	"local-mirror/internal/safety"
 	"local-mirror/internal/status"
 	"local-mirror/internal/tree"
+	"local-mirror/internal/versions"
 	"local-mirror/pkg/utils"
 	"net"
 	"os"
@@ -23,14 +25,18 @@ import (
 // localHandshake 构造本端握手消息（客户端首次握手与重连重验证共用）。
 // Role 承载的是本连接端点的数据方向而非进程模式：汇引擎恒申报 receive
 // （relay 的上游连接也是收）。老 reality/mirror 值恰与 send/receive 同值，
-// 平滑映射；旧 relay 发的 3 由对端按合法遗留值放行
+// 平滑映射；旧 relay 发的 3 由对端按合法遗留值放行。双向同步申报 RoleBoth
 func localHandshake() HandshakeMessage {
+	role := config.RoleReceive
+	if config.TwoWay() {
+		role = config.RoleBoth
+	}
 	return HandshakeMessage{
 		Version:     config.ProtocolVersion,
 		MinVersion:  config.MinProtocolVersion,
 		UUID:        config.InstanceID,
-		Role:        config.RoleReceive,
-		FeatureBits: 0,
+		Role:        role,
+		FeatureBits: localFeatureBits,
 	}
 }
 
@@ -76,11 +82,11 @@ func SplitPeer(addr string) (host string, port int) {
 }
 
 // PrepareInboundConn 监听端收到入站连接后的传输就绪化：
-// keepalive +（配置口令时）Noise responder 握手。失败时连接已关闭
+// keepalive +（配置了加密时）Noise responder 或 TLS 服务端握手。失败时连接已关闭
 func PrepareInboundConn(conn net.Conn) (net.Conn, error) {
 	enableKeepAlive(conn)
-	if *config.Secret != "" {
-		secured, err := SecureConn(conn, *config.Secret, false)
+	if config.Encrypted() {
+		secured, err := secure(conn, false, "")
 		if err != nil {
 			conn.Close()
 			return nil, err
@@ -102,7 +108,7 @@ func NewFileClientFromConn(conn net.Conn) *FileClient {
 	}
 }
 
-// dialConn 建立到服务端的连接；配置了口令时在 TCP 之上完成 Noise 加密握手
+// dialConn 建立到服务端的连接；配置了加密时在 TCP 之上完成 Noise（或 TLS）握手
 func dialConn(addr string) (net.Conn, error) {
 	// 带超时拨号：端口扫描时不能在无响应的地址上无限期等待
 	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
@@ -111,12 +117,16 @@ func dialConn(addr string) (net.Conn, error) {
 	}
 	// 长轮询期间连接长时间静默，开启 TCP keepalive 让 OS 层更快发现死对端
 	enableKeepAlive(conn)
-	if *config.Secret != "" {
-		secured, err := SecureConn(conn, *config.Secret, true)
+	if config.Encrypted() {
+		host, _ := SplitPeer(addr)
+		secured, err := secure(conn, true, host)
 		if err != nil {
 			conn.Close()
 			return nil, fmt.Errorf("%w %s: %v", ErrSecureHandshake, addr, err)
 		}
+		if p := PeerOf(secured); p != nil {
+			log.Infof("Peer %s authenticated as %s", addr, p)
+		}
 		return secured, nil
 	}
 	return conn, nil
@@ -198,7 +208,16 @@ type FileClient struct {
 	connectionManage *ConnectionManager
 	realityVersion   uint16
 	realityID        uint32
+	features         uint64 // 会话能力位（两端 FeatureBits 的交集）
 	State            ConnectionState
+	// Mount 对端整棵树挂在本地同步根下的哪个子目录（多上游汇，--upstream）；
+	// 空即同步根本身。对外接口一律收发本地路径，与对端往来时换算，见 mount.go
+	Mount string
+
+	// 并行下载的附加连接池（--parallel），由 Workers 按需拨建，见 workers.go
+	workersMu      sync.Mutex
+	workers        []*FileClient
+	workersRetryAt time.Time
 }
 
 func NewFileClient(realityAddr string, serverAlias string) (*FileClient, error) {
@@ -220,14 +239,35 @@ func NewFileClient(realityAddr string, serverAlias string) (*FileClient, error)
 	}, nil
 }
 
+// Features 会话协商出的能力位（两端 FeatureBits 的交集，握手前为 0）
+func (c *FileClient) Features() uint64 {
+	return c.features
+}
+
+// Peer 身份模式下主连接握手认证出的对端身份，其他模式为 nil
+func (c *FileClient) Peer() *keyfile.Peer {
+	if c.connectionManage == nil {
+		return nil
+	}
+	conn, err := c.connectionManage.GetConnection()
+	if err != nil {
+		return nil
+	}
+	return PeerOf(conn)
+}
+
+// ConnectionClose 关闭主连接及其附加下载连接：主连接即会话，主连接断了整个会话作废
 func (c *FileClient) ConnectionClose() {
 	if c.connectionManage != nil {
 		c.connectionManage.Close()
 	}
+	c.closeWorkers()
 }
 
 func (c *FileClient) Reconnect() error {
 	log.Warnf("Reconnecting to server at %s", c.RealityAddr)
+	// 附加连接属于旧会话，重连后由 Workers 按新会话重新拨建并校验对端身份
+	c.closeWorkers()
 	if err := c.connectionManage.Reconnect(); err != nil {
 		return fmt.Errorf("failed to reconnect: %w", err)
 	}
@@ -287,6 +327,7 @@ func (c *FileClient) Reverify() error {
 			c.realityVersion, c.realityID,
 			handshakeResponse.Version, handshakeResponse.UUID)
 	}
+	c.features = handshakeResponse.FeatureBits & localFeatureBits
 	return nil
 }
 
@@ -299,7 +340,14 @@ func (c *FileClient) Handshake() error {
 	conn.SetDeadline(time.Now().Add(5 * time.Second))
 	defer conn.SetDeadline(time.Time{})
 
-	if err := sendMessage(conn, MsgTypeHandshake, encodeHandshake(localHandshake())); err != nil {
+	hello := localHandshake()
+	// 汇监听格：拨进来的源用了轮换前的旧 key，在请求里交给它新 key。
+	// 此时还不知道对端能力位，旧版源会忽略尾部字段
+	if key := handOverKey(conn); key != "" {
+		hello.NewKey = key
+		log.Infof("Peer at %s connected with the previous key; handing it the new key %s", conn.RemoteAddr(), keyfile.Fingerprint(key))
+	}
+	if err := sendMessage(conn, MsgTypeHandshake, encodeHandshake(hello)); err != nil {
 		return fmt.Errorf("failed to send handshake message: %w", err)
 	}
 	msgType, bodyBytes, err := receiveMessage(conn)
@@ -341,11 +389,21 @@ func (c *FileClient) Handshake() error {
 		return fmt.Errorf("direction conflict: this end receives (sink), but peer %08x also declares receive — exactly one end must be the source (--send)",
 			handshakeResponse.UUID)
 	}
+	// 双向同步要求对端也是双向的：单向源永远不会来取本端的改动，
+	// 本端按共同祖先保留下来的本地修改就再也到不了对端
+	if config.TwoWay() && handshakeResponse.Role != config.RoleBoth {
+		return fmt.Errorf("peer %08x is a one-way source; two-way sync needs --bidirectional on both ends",
+			handshakeResponse.UUID)
+	}
 	c.realityVersion = handshakeResponse.Version
 	c.realityID = handshakeResponse.UUID
+	c.features = handshakeResponse.FeatureBits & localFeatureBits
 	c.State = Online
-	log.Infof("Received handshake response: version: %d (agreed %d), realityID: %d",
-		handshakeResponse.Version, agreed, handshakeResponse.UUID)
+	log.Infof("Received handshake response: version: %d (agreed %d), realityID: %d, features: %#x",
+		handshakeResponse.Version, agreed, handshakeResponse.UUID, c.features)
+	if handshakeResponse.NewKey != "" {
+		adoptKey(handshakeResponse.NewKey, fmt.Sprintf("%08x", handshakeResponse.UUID))
+	}
 	return nil
 }
 
@@ -367,39 +425,45 @@ func unmarshalTreePage(data []byte) ([]tree.Node, error) {
 
 // GetRealityTree 拉取服务端某目录的全部条目。响应按页下发
 // （超大目录不再逼近消息体上限），本函数循环携带 ContinueFrom
-// 续页游标直至取完，调用方拿到的始终是完整列表
-func (c *FileClient) GetRealityTree(rootPath string) ([]tree.Node, error) {
+// 续页游标直至取完，调用方拿到的始终是完整列表。digest 是该目录的子树摘要
+// （协商了 FeatureDigest 且服务端已算出时非空，取自首页）
+func (c *FileClient) GetRealityTree(rootPath string) (nodes []tree.Node, digest string, err error) {
 	conn, err := c.connectionManage.GetConnection()
 	if err != nil {
-		return nil, fmt.Errorf("%w: failed to get connection: %v", appError.ErrConnection, err)
+		return nil, "", fmt.Errorf("%w: failed to get connection: %v", appError.ErrConnection, err)
 	}
 	realityAddr := conn.RemoteAddr().String()
 
-	var nodes []tree.Node
 	continueFrom := ""
 	for page := 1; ; page++ {
-		request := TreeRequestMessage{RootPath: rootPath, ContinueFrom: continueFrom}
+		request := TreeRequestMessage{RootPath: c.remotePath(rootPath), ContinueFrom: continueFrom}
 		if err := sendMessage(conn, MsgTypeTreeRequest, encodeTreeRequest(request)); err != nil {
-			return nil, fmt.Errorf("%w: failed to send tree request: %v", appError.ErrConnection, err)
+			return nil, "", fmt.Errorf("%w: failed to send tree request: %v", appError.ErrConnection, err)
 		}
 		log.Debugf("Sent tree request to %s for path: %s (page %d)", realityAddr, rootPath, page)
 		msgType, bodyBytes, err := receiveMessage(conn)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
+			return nil, "", fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
 		}
 		if msgType == MsgTypeError {
-			return nil, realityErrorFrom(bodyBytes)
+			return nil, "", realityErrorFrom(bodyBytes)
 		}
 		if msgType != MsgTypeTreeResponse {
-			return nil, fmt.Errorf("invalid tree response message type, got %d", msgType)
+			return nil, "", fmt.Errorf("invalid tree response message type, got %d", msgType)
 		}
 		treeResponse, err := decodeTreeResponse(bodyBytes)
 		if err != nil {
-			return nil, fmt.Errorf("%w: failed to decode tree response: %v", appError.ErrConnection, err)
+			return nil, "", fmt.Errorf("%w: failed to decode tree response: %v", appError.ErrConnection, err)
+		}
+		if page == 1 {
+			digest = treeResponse.Digest
 		}
 		pageNodes, err := unmarshalTreePage(treeResponse.Data)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		for i := range pageNodes {
+			pageNodes[i].Path = c.localPath(pageNodes[i].Path)
 		}
 		nodes = append(nodes, pageNodes...)
 		log.Debugf("Received tree response page %d from %s: %d entries, more=%v",
@@ -414,7 +478,7 @@ func (c *FileClient) GetRealityTree(rootPath string) ([]tree.Node, error) {
 	} else {
 		log.Infof("Received tree from %s for %s: %d entries", realityAddr, rootPath, len(nodes))
 	}
-	return nodes, nil
+	return nodes, digest, nil
 }
 
 // partialMeta 记录分片对应的服务端文件指纹。
@@ -467,7 +531,7 @@ func drainFileSession(conn net.Conn) error {
 			return err
 		}
 		switch msgType {
-		case MsgTypeFileData:
+		case MsgTypeFileData, MsgTypeDeltaData:
 			continue
 		case MsgTypeFileComplete, MsgTypeError:
 			return nil
@@ -477,6 +541,181 @@ func drainFileSession(conn net.Conn) error {
 	}
 }
 
+// finalizeDownload 收尾一次已收完的下载（整文件与增量共用）：对分片做整文件
+// BLAKE3 校验，通过后（必要时先快照原文件）原子替换到目标位置。
+// 调用方须已关闭分片文件句柄
+func finalizeDownload(filePath, fullPath, partialPath, metaPath string, expected [32]byte) ([32]byte, error) {
+	// 无论是否续传，都对拼装后的整个文件做完整性校验
+	fileHash, err := utils.CalcBlake3(partialPath)
+	if err != nil {
+		return fileHash, fmt.Errorf("error calculating file hash: %w", err)
+	}
+	if fileHash != expected {
+		// 分片已被证明损坏，保留只会反复失败
+		discardPartial(partialPath, metaPath)
+		return fileHash, fmt.Errorf("file hash mismatch, expected %x, got %x", expected, fileHash)
+	}
+	// 关键路径解锁档：覆盖已有文件前先把原文件快照到 .local-mirror/backups。
+	// 快照失败即中止本文件覆盖（fail-safe：原文件必须先有退路才允许被覆盖），
+	// 其余文件不受影响（走既有单项失败隔离逻辑）
+	if config.SnapshotOverwrites {
+		if err := safety.SnapshotBeforeOverwrite(config.StartPath, filePath, fullPath); err != nil {
+			return fileHash, fmt.Errorf("%w: backing up the original failed, skipping overwrite of %s: %v", appError.ErrConnection, filePath, err)
+		}
+	}
+	// 版本化回收站：被覆盖的旧内容硬链接进 .local-mirror/versions。留存失败同样
+	// 中止本文件覆盖——用户要求保留的旧版本不能在覆盖中静默丢失
+	if *config.KeepVersions {
+		if err := versions.Preserve(config.StartPath, filePath, time.Now(), versions.NewPolicy(*config.VersionsKeep, *config.VersionsMaxAge)); err != nil {
+			return fileHash, fmt.Errorf("%w: keeping the old version failed, skipping overwrite of %s: %v", appError.ErrConnection, filePath, err)
+		}
+	}
+	// SEC-04：数据传输期间某级父目录可能被换成符号链接，替换落盘前再校验一次（缩小 TOCTOU）
+	if err := safety.VerifyNoSymlinkComponents(config.StartPath, filePath); err != nil {
+		return fileHash, fmt.Errorf("refusing to write %s: %w", filePath, err)
+	}
+	if err := os.Rename(partialPath, fullPath); err != nil {
+		return fileHash, fmt.Errorf("error renaming partial file to %s: %w", fullPath, err)
+	}
+	os.Remove(metaPath)
+	return fileHash, nil
+}
+
+// openDeltaBasis 打开本地现有版本作为增量传输的 basis。仅当会话协商了
+// FeatureDelta、目标是根内的普通文件且大小值得做增量时返回 ok
+func (c *FileClient) openDeltaBasis(filePath string) (*os.File, uint64, bool) {
+	if c.features&FeatureDelta == 0 {
+		return nil, 0, false
+	}
+	full, err := safety.SafeResolve(config.StartPath, filePath)
+	if err != nil {
+		return nil, 0, false
+	}
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() || info.Size() < deltaMinFileSize || deltaBlockSize(uint64(info.Size())) == 0 {
+		return nil, 0, false
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, 0, false
+	}
+	return f, uint64(info.Size()), true
+}
+
+// downloadDelta 增量下载：发送 basis 签名，按服务端指令在分片路径上重建新文件，
+// 之后与整文件下载走同一收尾（finalizeDownload）。分片与元数据照常落盘，
+// 中断后下次按普通续传从已重建的前缀继续
+func (c *FileClient) downloadDelta(conn net.Conn, filePath string, basis *os.File, basisSize uint64, partialPath, metaPath string) (string, error) {
+	blockSize := deltaBlockSize(basisSize)
+	sigs, sigBytes, err := computeSignatures(basis, blockSize)
+	if err != nil {
+		return "", fmt.Errorf("error reading basis for delta of %s: %w", filePath, err)
+	}
+	// 签名期间文件被改动：签名与 basis 不再自洽，重建必然校验失败，直接放弃本次
+	if sigBytes != basisSize {
+		return "", fmt.Errorf("basis %s changed while computing delta signatures", filePath)
+	}
+	request := DeltaRequestMessage{FilePath: c.remotePath(filePath), BlockSize: blockSize, BasisSize: basisSize, Signatures: sigs}
+	if err := sendMessage(conn, MsgTypeDeltaRequest, encodeDeltaRequest(request)); err != nil {
+		return "", fmt.Errorf("%w: failed to send delta request: %v", appError.ErrConnection, err)
+	}
+
+	msgType, bodyBytes, err := receiveMessage(conn)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
+	}
+	if msgType == MsgTypeError {
+		discardPartial(partialPath, metaPath)
+		return "", realityErrorFrom(bodyBytes)
+	}
+	if msgType != MsgTypeFileResponse {
+		return "", fmt.Errorf("invalid delta response message type, got %d", msgType)
+	}
+	fileResponse, err := decodeFileResponse(bodyBytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decode file response: %v", appError.ErrConnection, err)
+	}
+	serverHash := fmt.Sprintf("%x", fileResponse.FileHash)
+	fullPath := filepath.Join(config.StartPath, filePath)
+
+	file, err := os.Create(partialPath)
+	if err != nil {
+		// 服务端已开始下发指令，排空以保持连接可复用
+		if derr := drainFileSession(conn); derr != nil {
+			return "", fmt.Errorf("%w: failed to drain delta session: %v", appError.ErrConnection, derr)
+		}
+		return "", fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer file.Close()
+	metaData, _ := json.Marshal(partialMeta{Hash: serverHash, Size: fileResponse.FileSize})
+	if werr := os.WriteFile(metaPath, metaData, 0644); werr != nil {
+		log.Warnf("Failed to write partial meta for %s: %v", filePath, werr)
+	}
+
+	sessionID := fileResponse.SessionID
+	var written, literal uint64
+	startTime := time.Now()
+	for {
+		msgType, bodyBytes, err := receiveMessage(conn)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
+		}
+		switch msgType {
+		case MsgTypeDeltaData:
+			dataMsg, err := decodeDeltaData(bodyBytes)
+			if err != nil {
+				return "", fmt.Errorf("%w: error decoding delta data message: %v", appError.ErrConnection, err)
+			}
+			if dataMsg.SessionID != sessionID {
+				return "", fmt.Errorf("%w: invalid session ID in delta data message, got %x", appError.ErrConnection, dataMsg.SessionID)
+			}
+			w, l, err := applyDeltaOps(dataMsg.Ops, basis, blockSize, basisSize, file)
+			written += w
+			literal += l
+			if err != nil {
+				// 与整文件写入失败同理：数据流中途放弃会在连接里留下未消费的字节，按连接错误重建
+				if appError.IsDiskFull(err) {
+					return "", fmt.Errorf("%w: disk full, delta write of %s interrupted: %v", appError.ErrConnection, filePath, err)
+				}
+				return "", fmt.Errorf("%w: error applying delta for %s: %v", appError.ErrConnection, filePath, err)
+			}
+			recvLimiter.wait(int(l))
+			status.RecordProgress(filePath, written, fileResponse.FileSize)
+		case MsgTypeFileComplete:
+			completeMsg, err := decodeFileComplete(bodyBytes)
+			if err != nil {
+				return "", fmt.Errorf("%w: error decoding file complete message: %v", appError.ErrConnection, err)
+			}
+			if completeMsg.SessionID != sessionID {
+				return "", fmt.Errorf("%w: invalid session ID in file complete message, got %x", appError.ErrConnection, completeMsg.SessionID)
+			}
+			if err := file.Sync(); err != nil {
+				log.Warnf("file.Sync() failed for %s: %v", partialPath, err)
+			}
+			if err := file.Close(); err != nil {
+				return "", fmt.Errorf("error closing file: %w", err)
+			}
+			// basis 即将被替换：先关句柄（Windows 上打开中的文件不可被 rename 覆盖）
+			basis.Close()
+			fileHash, err := finalizeDownload(filePath, fullPath, partialPath, metaPath, completeMsg.FileHash)
+			if err != nil {
+				return "", err
+			}
+			saved := 0.0
+			if fileResponse.FileSize > 0 {
+				saved = 100 * (1 - float64(literal)/float64(fileResponse.FileSize))
+			}
+			log.Infof("Delta transfer complete, file path: %s, file size: %d bytes, literal %d bytes (%.1f%% reused), took %v",
+				fullPath, fileResponse.FileSize, literal, saved, time.Since(startTime).Round(time.Millisecond))
+			return fmt.Sprintf("%x", fileHash), nil
+		case MsgTypeError:
+			return "", realityErrorFrom(bodyBytes)
+		default:
+			return "", fmt.Errorf("invalid delta data message type, got %d", msgType)
+		}
+	}
+}
+
 func (c *FileClient) DownloadFile(filePath string) (string, error) {
 	// filePath 来自服务端下发的目录树，属不可信输入：拼接后必须仍在同步根内。
 	// 越界（如 "../../etc/x"）直接拒绝，绝不向服务端发起请求、也绝不落盘，
@@ -495,8 +734,16 @@ func (c *FileClient) DownloadFile(filePath string) (string, error) {
 	}
 	offset, prevMeta := loadPartialState(partialPath, metaPath)
 
+	// 本地已有旧版本且没有可续传的分片：走增量传输，只收与旧版本不同的字节
+	if offset == 0 {
+		if basis, basisSize, ok := c.openDeltaBasis(filePath); ok {
+			defer basis.Close()
+			return c.downloadDelta(conn, filePath, basis, basisSize, partialPath, metaPath)
+		}
+	}
+
 	requestFile := FileRequestMessage{
-		FilePath: filePath,
+		FilePath: c.remotePath(filePath),
 		Offset:   offset,
 	}
 	requestBytes := encodeFileRequest(requestFile)
@@ -603,6 +850,7 @@ func (c *FileClient) DownloadFile(filePath string) (string, error) {
 			// 大文件的确认消息会填满对端接收缓冲，造成双向阻塞死锁；
 			// 续传依据本地分片大小，不需要确认机制
 			receivedSize += uint64(len(dataMsg.Data))
+			recvLimiter.wait(len(dataMsg.Data))
 			// 进度上报（--status 实时展示当前文件/速率）：节流在 status 内部，
 			// 这里每块调用只更新内存态，不落盘
 			status.RecordProgress(filePath, receivedSize, fileResponse.FileSize)
@@ -621,33 +869,10 @@ func (c *FileClient) DownloadFile(filePath string) (string, error) {
 			if err := file.Close(); err != nil {
 				return "", fmt.Errorf("error closing file: %w", err)
 			}
-
-			// 无论是否续传，都对拼装后的整个文件做完整性校验
-			fileHash, err := utils.CalcBlake3(partialPath)
+			fileHash, err := finalizeDownload(filePath, fullPath, partialPath, metaPath, completeMsg.FileHash)
 			if err != nil {
-				return "", fmt.Errorf("error calculating file hash: %w", err)
-			}
-			if fileHash != completeMsg.FileHash {
-				// 分片已被证明损坏，保留只会反复失败
-				discardPartial(partialPath, metaPath)
-				return "", fmt.Errorf("file hash mismatch, expected %x, got %x", completeMsg.FileHash, fileHash)
-			}
-			// 关键路径解锁档：覆盖已有文件前先把原文件快照到 .local-mirror/backups。
-			// 快照失败即中止本文件覆盖（fail-safe：原文件必须先有退路才允许被覆盖），
-			// 其余文件不受影响（走既有单项失败隔离逻辑）
-			if config.SnapshotOverwrites {
-				if err := safety.SnapshotBeforeOverwrite(config.StartPath, filePath, fullPath); err != nil {
-					return "", fmt.Errorf("%w: backing up the original failed, skipping overwrite of %s: %v", appError.ErrConnection, filePath, err)
-				}
-			}
-			// SEC-04：数据传输期间某级父目录可能被换成符号链接，替换落盘前再校验一次（缩小 TOCTOU）
-			if err := safety.VerifyNoSymlinkComponents(config.StartPath, filePath); err != nil {
-				return "", fmt.Errorf("refusing to write %s: %w", filePath, err)
-			}
-			if err := os.Rename(partialPath, fullPath); err != nil {
-				return "", fmt.Errorf("error renaming partial file to %s: %w", fullPath, err)
+				return "", err
 			}
-			os.Remove(metaPath)
 			transferSpeed := float64(fileResponse.FileSize-offset) / time.Since(startTime).Seconds()
 			log.Infof("File transfer complete, file path: %s, file size: %d bytes, transfer speed: %.2f MB/s",
 				fullPath,
@@ -667,15 +892,24 @@ func (c *FileClient) DownloadFile(filePath string) (string, error) {
 // 正常保活返回前误判超时
 const LongPollReadTimeout = 60 * time.Second
 
-// GetTreeChange 发起一次变更长轮询：请求自 startTime 起的变更，
+// ChangeCursor 变更查询的游标。协商了 FeatureJournal 时用 Epoch/Seq（源端变更日志的
+// 位置，跨源端重启有效），否则用 At（服务端时刻，unix 秒）。零值表示"尚未确立"：
+// 时间游标据此全查窗口，日志游标据此只取日志末尾
+type ChangeCursor struct {
+	At    int64
+	Epoch uint64
+	Seq   uint64
+}
+
+// GetTreeChange 发起一次变更长轮询：请求游标 from 之后的变更，
 // 服务端有变更立即返回、否则挂起至保活上限。返回变更目录列表与
-// coveredUntil（服务端已覆盖到的时刻，调用方据此推进游标，杜绝重叠/遗漏）。
-// fullResync 为真表示变更数超过服务端阈值、列表被省略：调用方应做一次
-// 全量对账，然后把游标推进到 coveredUntil。
-func (c *FileClient) GetTreeChange(startTime int64) (changes []string, coveredUntil int64, fullResync bool, err error) {
+// covered（服务端已覆盖到的位置，调用方据此推进游标，杜绝重叠/遗漏）。
+// fullResync 为真表示变更数超过服务端阈值、或日志游标已接不上，列表被省略：
+// 调用方应做一次全量对账，然后把游标推进到 covered。
+func (c *FileClient) GetTreeChange(from ChangeCursor) (changes []string, covered ChangeCursor, fullResync bool, err error) {
 	conn, err := c.connectionManage.GetConnection()
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("%w: failed to get connection: %v", appError.ErrConnection, err)
+		return nil, covered, false, fmt.Errorf("%w: failed to get connection: %v", appError.ErrConnection, err)
 	}
 	// 读超时必须覆盖服务端挂起上限，挂起本身不算连接异常
 	conn.SetReadDeadline(time.Now().Add(LongPollReadTimeout))
@@ -683,34 +917,56 @@ func (c *FileClient) GetTreeChange(startTime int64) (changes []string, coveredUn
 
 	request := RecentChangeRequestMessage{
 		ClientID:  config.InstanceID,
-		StartTime: startTime,
+		StartTime: from.At,
+	}
+	if c.features&FeatureJournal != 0 {
+		request.Journal, request.Epoch, request.Since = true, from.Epoch, from.Seq
+	}
+	// 选择性同步：把订阅范围告诉源端，范围外的变更目录它就不报了。多上游汇的
+	// 订阅锚定在本地根、换不成对端路径，只在本地过滤（getDirectory）
+	if c.features&FeatureInclude != 0 && c.Mount == "" {
+		request.Include = config.IncludeList
 	}
 	requestBytes := encodeRecentChangeRequest(request)
 	if err := sendMessage(conn, MsgTypeRecentChangeRequest, requestBytes); err != nil {
-		return nil, 0, false, fmt.Errorf("%w: failed to send recent change request: %v", appError.ErrConnection, err)
+		return nil, covered, false, fmt.Errorf("%w: failed to send recent change request: %v", appError.ErrConnection, err)
 	}
 	msgType, bodyBytes, err := receiveMessage(conn)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
+		return nil, covered, false, fmt.Errorf("%w: failed to receive message: %v", appError.ErrConnection, err)
 	}
 	if msgType == MsgTypeError {
-		return nil, 0, false, realityErrorFrom(bodyBytes)
+		return nil, covered, false, realityErrorFrom(bodyBytes)
 	}
 	if msgType != MsgTypeRecentChangeResponse {
-		return nil, 0, false, fmt.Errorf("invalid recent change response message type, got %d", msgType)
+		return nil, covered, false, fmt.Errorf("invalid recent change response message type, got %d", msgType)
 	}
 	resp, err := decodeRecentChangeResponse(bodyBytes)
 	if err != nil {
-		return nil, 0, false, fmt.Errorf("%w: failed to decode recent change response: %v", appError.ErrConnection, err)
+		return nil, covered, false, fmt.Errorf("%w: failed to decode recent change response: %v", appError.ErrConnection, err)
 	}
 	// 服务端实例变化（悄悄重启）→ 本地缓存树不可信，按连接错误触发会话重建
 	if resp.ServerID != c.realityID {
-		return nil, 0, false, fmt.Errorf("%w: server instance changed, expected %08x, got %08x",
+		return nil, covered, false, fmt.Errorf("%w: server instance changed, expected %08x, got %08x",
 			appError.ErrConnection, c.realityID, resp.ServerID)
 	}
+	for i := range resp.Changes {
+		resp.Changes[i] = c.localPath(resp.Changes[i])
+	}
 	if len(resp.Changes) > 0 {
 		log.Infof("Received %d changed dirs from %s", len(resp.Changes), c.RealityAddr)
 		log.Debugf("Changed dirs: %v", resp.Changes)
 	}
-	return resp.Changes, resp.CoveredUntil, resp.FullResync, nil
+	covered = ChangeCursor{At: resp.CoveredUntil, Epoch: resp.Epoch, Seq: resp.Seq}
+	if request.Journal && covered.Epoch == 0 {
+		return nil, ChangeCursor{}, false, fmt.Errorf("%w: recent change response carries no journal position", appError.ErrConnection)
+	}
+	return resp.Changes, covered, resp.FullResync, nil
+}
+
+// JournalHead 取对端变更日志的末尾位置（需协商 FeatureJournal），服务端立即应答。
+// 全量对账前取一次、对账后从这里继续，对账期间发生的变更随后照常追上
+func (c *FileClient) JournalHead() (ChangeCursor, error) {
+	_, head, _, err := c.GetTreeChange(ChangeCursor{})
+	return head, err
 }
@@ -0,0 +1,126 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// headerFlagDeflate 消息头 ReservedWord 的 bit0：消息体经 raw deflate 压缩。
+// 只有协商了 FeatureCompress 的会话才会置位；接收端据标志透明解压，
+// 旧端从不置位，因此对旧端完全不可见
+const headerFlagDeflate uint16 = 1 << 0
+
+// compressMinBody 小于此长度的消息体不尝试压缩：deflate 的块头与哈希表
+// 初始化开销摊不开，几乎不可能换来收益
+const compressMinBody = 512
+
+// compressMaxMisses 同一文件连续这么多个分块压缩后没变小，即认定内容
+// 不可压缩（未在扩展名表里的加密/压缩数据），余下分块直接原样发送
+const compressMaxMisses = 4
+
+// incompressibleExts 已压缩格式的扩展名（小写、含点）。这类文件再过一遍
+// deflate 只是白烧 CPU，整文件跳过压缩
+var incompressibleExts = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true,
+	".zip": true, ".7z": true, ".rar": true, ".br": true, ".jar": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+}
+
+// compressibleFile 按扩展名判定文件内容是否值得压缩
+func compressibleFile(path string) bool {
+	return !incompressibleExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// deflateWriters 复用 flate.Writer：每个 Writer 自带约 1 MB 的哈希表与窗口，
+// 逐消息新建会让分块传输的分配量翻倍
+var deflateWriters = sync.Pool{
+	New: func() any {
+		// BestSpeed：局域网上压缩不能成为瓶颈；慢链路上它与默认级别的压缩率差距很小
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// deflateBody 压缩消息体。ok=false 表示不值得（太短或压完没变小），调用方原样发送
+func deflateBody(body []byte) (out []byte, ok bool) {
+	if len(body) < compressMinBody {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(body) / 2)
+	w := deflateWriters.Get().(*flate.Writer)
+	defer deflateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(body) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// inflateBody 解压消息体，解压后长度同样受 MaxBodyLength 约束——
+// 否则几 KB 的恶意包就能膨胀成任意大的内存分配（压缩炸弹）
+func inflateBody(body []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(body))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, MaxBodyLength+1))
+	if err != nil {
+		return nil, fmt.Errorf("error inflating message body: %w", err)
+	}
+	if len(out) > MaxBodyLength {
+		return nil, fmt.Errorf("inflated message body exceeds %d bytes", MaxBodyLength)
+	}
+	return out, nil
+}
+
+// sendCompressed 尝试压缩后发送，不划算时退回原样发送。返回是否实际压缩，
+// 供逐块发送的调用方统计命中率、对不可压缩内容及早停手。
+// 调用方负责确认会话协商了 FeatureCompress
+func sendCompressed(conn net.Conn, msgType uint16, body []byte) (compressed bool, err error) {
+	if packed, ok := deflateBody(body); ok {
+		return true, writeMessage(conn, msgType, packed, headerFlagDeflate, len(body))
+	}
+	return false, writeMessage(conn, msgType, body, 0, len(body))
+}
+
+// compressState 单个文件传输的压缩决策：起始按会话协商与扩展名定，
+// 传输中连续 compressMaxMisses 块没压小就关掉
+type compressState struct {
+	on     bool
+	misses int
+}
+
+func newCompressState(features uint64, path string) *compressState {
+	return &compressState{on: features&FeatureCompress != 0 && compressibleFile(path)}
+}
+
+// send 按当前决策发送一条消息并更新命中统计
+func (cs *compressState) send(conn net.Conn, msgType uint16, body []byte) error {
+	if !cs.on {
+		return sendMessage(conn, msgType, body)
+	}
+	compressed, err := sendCompressed(conn, msgType, body)
+	if err != nil {
+		return err
+	}
+	if compressed {
+		cs.misses = 0
+	} else if cs.misses++; cs.misses >= compressMaxMisses {
+		cs.on = false
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"net"
+	"testing"
+)
+
+// roundTrip 经 net.Pipe 发一条消息，返回接收端解出的消息体
+func roundTrip(t *testing.T, send func(conn net.Conn) error) []byte {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- send(a) }()
+	_, body, err := receiveMessage(b)
+	if err != nil {
+		t.Fatalf("receiveMessage: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	return body
+}
+
+// TestCompressedMessageRoundTrip 可压缩内容压后发出、接收端透明解压；
+// 不可压缩内容自动退回原样发送，线上不会比原文更大
+func TestCompressedMessageRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("local-mirror compresses repetitive payloads\n"), 2000)
+	var compressed bool
+	got := roundTrip(t, func(conn net.Conn) (err error) {
+		compressed, err = sendCompressed(conn, MsgTypeFileData, text)
+		return err
+	})
+	if !compressed {
+		t.Error("重复文本应被压缩")
+	}
+	if !bytes.Equal(got, text) {
+		t.Fatal("解压后内容不一致")
+	}
+
+	random := make([]byte, 64<<10)
+	rand.Read(random)
+	got = roundTrip(t, func(conn net.Conn) (err error) {
+		compressed, err = sendCompressed(conn, MsgTypeFileData, random)
+		return err
+	})
+	if compressed {
+		t.Error("随机数据压不小，应原样发送")
+	}
+	if !bytes.Equal(got, random) {
+		t.Fatal("原样发送的内容不一致")
+	}
+}
+
+// TestInflateBodyBounded 解压后体积同样受 MaxBodyLength 约束，
+// 几 KB 的压缩炸弹不能膨胀成任意大的内存分配
+func TestInflateBodyBounded(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i <= MaxBodyLength>>20; i++ {
+		w.Write(zeros)
+	}
+	w.Close()
+	if _, err := inflateBody(buf.Bytes()); err == nil {
+		t.Fatal("解压超过 MaxBodyLength 应被拒")
+	}
+	if _, err := inflateBody([]byte("not deflate")); err == nil {
+		t.Fatal("损坏的压缩数据应报错")
+	}
+}
+
+// TestCompressStateGivesUp 未协商、已压缩扩展名直接不压；未知扩展名的
+// 不可压缩内容连续若干块压不小后停手，不再白烧 CPU
+func TestCompressStateGivesUp(t *testing.T) {
+	if newCompressState(FeatureDelta, "a.txt").on {
+		t.Error("未协商 FeatureCompress 不应压缩")
+	}
+	if newCompressState(FeatureCompress, "photo.JPG").on {
+		t.Error("已压缩格式应整文件跳过")
+	}
+	cs := newCompressState(FeatureCompress, "blob.bin")
+	if !cs.on {
+		t.Fatal("未知扩展名应先尝试压缩")
+	}
+	random := make([]byte, 8<<10)
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	go func() {
+		for {
+			if _, _, err := receiveMessage(b); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < compressMaxMisses; i++ {
+		rand.Read(random)
+		if err := cs.send(a, MsgTypeFileData, random); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cs.on {
+		t.Errorf("连续 %d 块压不小后应停止压缩", compressMaxMisses)
+	}
+}
@@ -0,0 +1,308 @@
+package network
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zeebo/blake3"
+)
+
+// ============================ 块级增量传输 ============================
+//
+// rsync 算法：客户端把本地旧版本（basis）切成定长块，逐块算「弱校验 + 强校验」
+// 签名发给服务端；服务端在新文件上滚动计算弱校验，逐字节滑动窗口查表，
+// 弱校验命中再用强校验确认，命中即下发「复制第 i 块」，未命中的字节作为字面量下发。
+// 客户端按指令从 basis 复制块、追加字面量，在分片路径上重建出新文件，
+// 之后与普通下载走同一套 BLAKE3 整文件校验 + 原子替换。
+//
+// 协商：仅当两端 FeatureBits 都声明 FeatureDelta 时使用，旧端自动回落整文件传输。
+// =====================================================================
+
+const (
+	deltaOpCopy    uint8 = 0 // 从 basis 复制 Count 个连续块（起始块号 Block）
+	deltaOpLiteral uint8 = 1 // 追加字面量字节
+)
+
+// deltaOp 一条重建指令
+type deltaOp struct {
+	Kind  uint8
+	Block uint32 // copy：起始块号
+	Count uint32 // copy：连续块数
+	Data  []byte // literal：字节内容
+}
+
+// blockSignatureSize 单条签名的线格式长度（弱校验 4 + 强校验 16）
+const blockSignatureSize = 20
+
+const (
+	// deltaMinFileSize basis 低于此大小不走增量：签名往返的开销与整文件传输相当
+	deltaMinFileSize = 64 << 10
+	// 客户端选块大小的区间：约 sqrt(文件大小)，在签名体积与匹配粒度间折中
+	deltaMinBlockSize = 2 << 10
+	deltaMaxBlockSize = 1 << 20
+	// deltaMaxSignatures 单次请求的签名条数上限（约 20 MB），远低于消息体上限；
+	// 超大文件据此放大块大小
+	deltaMaxSignatures = 1 << 20
+	// deltaMaxWireBlockSize 服务端接受的块大小上限，挡住伪造请求撑大匹配缓冲
+	deltaMaxWireBlockSize = 16 << 20
+)
+
+// deltaBlockSize 为 size 字节的 basis 选择块大小；返回 0 表示文件过大不适合增量
+func deltaBlockSize(size uint64) uint32 {
+	bs := uint64(deltaMinBlockSize)
+	for bs*bs < size && bs < deltaMaxBlockSize {
+		bs <<= 1
+	}
+	for (size+bs-1)/bs > deltaMaxSignatures {
+		bs <<= 1
+	}
+	if bs > deltaMaxWireBlockSize {
+		return 0
+	}
+	return uint32(bs)
+}
+
+// weakChecksum rsync 滚动和：a = Σx，b = Σ(n-i)·x，各取低 16 位。
+// uint32 自然回绕等价于模 2^32 运算，取低 16 位时结果与模 2^16 一致
+func weakChecksum(block []byte) (a, b uint32) {
+	n := uint32(len(block))
+	for i, x := range block {
+		a += uint32(x)
+		b += (n - uint32(i)) * uint32(x)
+	}
+	return a, b
+}
+
+func packWeak(a, b uint32) uint32 {
+	return a&0xffff | b<<16
+}
+
+// strongChecksum 块的强校验：BLAKE3 截断到 16 字节
+func strongChecksum(block []byte) [16]byte {
+	sum := blake3.Sum256(block)
+	var out [16]byte
+	copy(out[:], sum[:16])
+	return out
+}
+
+// computeSignatures 读完 r，按 blockSize 切块生成签名，返回签名与总字节数
+func computeSignatures(r io.Reader, blockSize uint32) ([]BlockSignature, uint64, error) {
+	var sigs []BlockSignature
+	var total uint64
+	block := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			a, b := weakChecksum(block[:n])
+			sigs = append(sigs, BlockSignature{Weak: packWeak(a, b), Strong: strongChecksum(block[:n])})
+			total += uint64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sigs, total, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+// deltaEmitter 收集指令并合并相邻的块复制，保证输出顺序与重建顺序一致
+type deltaEmitter struct {
+	emit       func(deltaOp) error
+	pendBlock  uint32
+	pendCount  uint32
+	maxLiteral int
+}
+
+func (e *deltaEmitter) copyBlock(i uint32) error {
+	if e.pendCount > 0 && i == e.pendBlock+e.pendCount {
+		e.pendCount++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.pendBlock, e.pendCount = i, 1
+	return nil
+}
+
+func (e *deltaEmitter) flushCopy() error {
+	if e.pendCount == 0 {
+		return nil
+	}
+	op := deltaOp{Kind: deltaOpCopy, Block: e.pendBlock, Count: e.pendCount}
+	e.pendCount = 0
+	return e.emit(op)
+}
+
+// literal 下发字面量（复制一份：data 指向可复用的匹配缓冲），超长按 maxLiteral 切分
+func (e *deltaEmitter) literal(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := min(len(data), e.maxLiteral)
+		if err := e.emit(deltaOp{Kind: deltaOpLiteral, Data: append([]byte(nil), data[:n]...)}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// generateDelta 在新文件 r 上对照 basis 签名生成重建指令，逐条交给 emit。
+// 内存有界：缓冲只保留「未下发的字面量 + 一个窗口 + 一次读取」，字面量攒满
+// maxLiteral 即下发，与文件大小无关
+func generateDelta(r io.Reader, blockSize uint32, basisSize uint64, sigs []BlockSignature,
+	maxLiteral int, emit func(deltaOp) error) error {
+	bs := int(blockSize)
+	e := &deltaEmitter{emit: emit, maxLiteral: maxLiteral}
+
+	index := make(map[uint32][]uint32, len(sigs))
+	for i, s := range sigs {
+		index[s.Weak] = append(index[s.Weak], uint32(i))
+	}
+	// basis 最后一块可能不足 blockSize，只能与新文件的尾部匹配
+	lastLen := 0
+	if len(sigs) > 0 {
+		lastLen = int(basisSize - uint64(len(sigs)-1)*uint64(blockSize))
+	}
+
+	readChunk := max(bs, 64<<10)
+	chunk := make([]byte, readChunk)
+	var buf []byte
+	litStart, pos := 0, 0
+	eof := false
+	fill := func() error {
+		// 已下发的前缀不再需要，超过一半容量时整体前移，缓冲不随文件增长
+		if litStart > 0 && litStart >= cap(buf)/2 {
+			n := copy(buf, buf[litStart:])
+			buf = buf[:n]
+			pos -= litStart
+			litStart = 0
+		}
+		n, err := io.ReadFull(r, chunk)
+		buf = append(buf, chunk[:n]...)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+			return nil
+		}
+		return err
+	}
+
+	var a, b uint32
+	haveSum := false
+	for {
+		for !eof && len(buf)-pos < bs+1 {
+			if err := fill(); err != nil {
+				return err
+			}
+		}
+		if len(buf)-pos < bs {
+			break
+		}
+		window := buf[pos : pos+bs]
+		if !haveSum {
+			a, b = weakChecksum(window)
+			haveSum = true
+		}
+		if cands, ok := index[packWeak(a, b)]; ok {
+			strong := strongChecksum(window)
+			matched := -1
+			for _, i := range cands {
+				// 短尾块长度不等于窗口，不参与整窗匹配
+				if int(i) == len(sigs)-1 && lastLen != bs {
+					continue
+				}
+				if sigs[i].Strong == strong {
+					matched = int(i)
+					break
+				}
+			}
+			if matched >= 0 {
+				if err := e.literal(buf[litStart:pos]); err != nil {
+					return err
+				}
+				if err := e.copyBlock(uint32(matched)); err != nil {
+					return err
+				}
+				pos += bs
+				litStart = pos
+				haveSum = false
+				continue
+			}
+		}
+		if pos+bs >= len(buf) {
+			// 已到文件末尾且整窗未命中：剩余字节全部归入尾部处理
+			break
+		}
+		out, in := uint32(buf[pos]), uint32(buf[pos+bs])
+		a = a - out + in
+		b = b - uint32(bs)*out + a
+		pos++
+		if pos-litStart >= maxLiteral {
+			if err := e.literal(buf[litStart:pos]); err != nil {
+				return err
+			}
+			litStart = pos
+		}
+	}
+
+	// 尾部：恰好等于 basis 短尾块时尝试整块匹配，否则作为字面量
+	tail := buf[pos:]
+	if lastLen > 0 && lastLen < bs && len(tail) == lastLen {
+		ta, tb := weakChecksum(tail)
+		last := sigs[len(sigs)-1]
+		if packWeak(ta, tb) == last.Weak && strongChecksum(tail) == last.Strong {
+			if err := e.literal(buf[litStart:pos]); err != nil {
+				return err
+			}
+			if err := e.copyBlock(uint32(len(sigs) - 1)); err != nil {
+				return err
+			}
+			return e.flushCopy()
+		}
+	}
+	if err := e.literal(buf[litStart:]); err != nil {
+		return err
+	}
+	return e.flushCopy()
+}
+
+// applyDeltaOps 按指令把重建结果写入 out，返回写入字节数与其中字面量字节数。
+// 指令来自对端属不可信输入：块号越界直接报错，绝不读 basis 之外的偏移
+func applyDeltaOps(ops []deltaOp, basis io.ReaderAt, blockSize uint32, basisSize uint64, out io.Writer) (written, literal uint64, err error) {
+	numBlocks := (basisSize + uint64(blockSize) - 1) / uint64(blockSize)
+	for _, op := range ops {
+		switch op.Kind {
+		case deltaOpCopy:
+			if op.Count == 0 || uint64(op.Block)+uint64(op.Count) > numBlocks {
+				return written, literal, fmt.Errorf("delta copy of blocks [%d,+%d) out of range (basis has %d blocks)",
+					op.Block, op.Count, numBlocks)
+			}
+			off := uint64(op.Block) * uint64(blockSize)
+			n := min(uint64(op.Count)*uint64(blockSize), basisSize-off)
+			copied, cerr := io.Copy(out, io.NewSectionReader(basis, int64(off), int64(n)))
+			written += uint64(copied)
+			if cerr != nil {
+				return written, literal, cerr
+			}
+			if uint64(copied) != n {
+				return written, literal, fmt.Errorf("basis shrank during delta apply: copied %d of %d bytes", copied, n)
+			}
+		case deltaOpLiteral:
+			n, werr := out.Write(op.Data)
+			written += uint64(n)
+			literal += uint64(n)
+			if werr != nil {
+				return written, literal, werr
+			}
+		default:
+			return written, literal, fmt.Errorf("unknown delta op kind %d", op.Kind)
+		}
+	}
+	return written, literal, nil
+}
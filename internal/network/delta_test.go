@@ -0,0 +1,160 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"local-mirror/internal/appError"
+)
+
+// runDelta 对 basis/target 跑一遍完整的签名 → 生成 → 重建，返回重建结果与字面量字节数
+func runDelta(t *testing.T, basis, target []byte, blockSize uint32, maxLiteral int) ([]byte, uint64) {
+	t.Helper()
+	sigs, n, err := computeSignatures(bytes.NewReader(basis), blockSize)
+	if err != nil {
+		t.Fatalf("computeSignatures: %v", err)
+	}
+	if n != uint64(len(basis)) {
+		t.Fatalf("签名覆盖 %d 字节，应为 %d", n, len(basis))
+	}
+	var ops []deltaOp
+	if err := generateDelta(bytes.NewReader(target), blockSize, uint64(len(basis)), sigs, maxLiteral,
+		func(op deltaOp) error { ops = append(ops, op); return nil }); err != nil {
+		t.Fatalf("generateDelta: %v", err)
+	}
+	// 过一遍线格式，确保编解码与算法一致
+	msg, err := decodeDeltaData(encodeDeltaData(DeltaDataMessage{Ops: ops}))
+	if err != nil {
+		t.Fatalf("decodeDeltaData: %v", err)
+	}
+	var out bytes.Buffer
+	_, literal, err := applyDeltaOps(msg.Ops, bytes.NewReader(basis), blockSize, uint64(len(basis)), &out)
+	if err != nil {
+		t.Fatalf("applyDeltaOps: %v", err)
+	}
+	return out.Bytes(), literal
+}
+
+func randomBytes(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// TestDeltaRoundTrip 覆盖典型改动形态：重建结果必须与目标逐字节一致，
+// 局部改动只应产生少量字面量
+func TestDeltaRoundTrip(t *testing.T) {
+	const bs = 2048
+	basis := randomBytes(1, 200*1024+123) // 末块不足整块
+
+	inserted := append(append(append([]byte{}, basis[:50000]...), []byte("INSERTED")...), basis[50000:]...)
+	modified := append([]byte{}, basis...)
+	copy(modified[100000:], []byte("patched bytes"))
+	truncated := basis[:150000]
+	appended := append(append([]byte{}, basis...), randomBytes(2, 5000)...)
+
+	cases := []struct {
+		name       string
+		target     []byte
+		maxLiteral uint64 // 字面量字节上限（粗略，证明复用生效）
+	}{
+		{"完全相同", basis, 0},
+		{"中间插入", inserted, 2 * bs},
+		{"原地修改", modified, 2 * bs},
+		{"截断", truncated, bs},
+		{"尾部追加", appended, 5000 + bs},
+		{"完全不同", randomBytes(3, 100000), 100000},
+		{"空目标", nil, 0},
+	}
+	for _, c := range cases {
+		got, literal := runDelta(t, basis, c.target, bs, 64*1024)
+		if !bytes.Equal(got, c.target) {
+			t.Errorf("%s: 重建结果与目标不一致（%d vs %d 字节）", c.name, len(got), len(c.target))
+			continue
+		}
+		if literal > c.maxLiteral {
+			t.Errorf("%s: 字面量 %d 字节，超过预期上限 %d", c.name, literal, c.maxLiteral)
+		}
+	}
+}
+
+// TestDeltaSmallLiteralLimit 字面量上限远小于改动区时必须切分下发，结果仍正确
+func TestDeltaSmallLiteralLimit(t *testing.T) {
+	basis := randomBytes(4, 64*1024)
+	target := append(randomBytes(5, 30000), basis...)
+	got, _ := runDelta(t, basis, target, 2048, 1000)
+	if !bytes.Equal(got, target) {
+		t.Fatal("小字面量上限下重建结果与目标不一致")
+	}
+}
+
+// TestApplyDeltaRejectsOutOfRange 对端下发越界块号必须报错，不得读 basis 之外
+func TestApplyDeltaRejectsOutOfRange(t *testing.T) {
+	basis := randomBytes(6, 5000) // 3 块（2048）
+	var out bytes.Buffer
+	ops := []deltaOp{{Kind: deltaOpCopy, Block: 2, Count: 2}}
+	if _, _, err := applyDeltaOps(ops, bytes.NewReader(basis), 2048, uint64(len(basis)), &out); err == nil {
+		t.Fatal("越界的块复制应报错")
+	}
+}
+
+// TestDeltaRequestRoundTrip 请求编解码往返，且伪造的签名条数被边界校验拦截
+func TestDeltaRequestRoundTrip(t *testing.T) {
+	orig := DeltaRequestMessage{
+		FilePath:   "dir/file.bin",
+		BlockSize:  4096,
+		BasisSize:  5000,
+		Signatures: []BlockSignature{{Weak: 1, Strong: [16]byte{1}}, {Weak: 2, Strong: [16]byte{2}}},
+	}
+	got, err := decodeDeltaRequest(encodeDeltaRequest(orig))
+	if err != nil {
+		t.Fatalf("decodeDeltaRequest: %v", err)
+	}
+	if got.FilePath != orig.FilePath || got.BlockSize != orig.BlockSize || got.BasisSize != orig.BasisSize ||
+		len(got.Signatures) != 2 || got.Signatures[1] != orig.Signatures[1] {
+		t.Errorf("往返结果不符: %+v", got)
+	}
+
+	body := encodeDeltaRequest(orig)
+	// 把签名条数改成天量：路径(2+12) + 块大小 4 + basis 8 之后即条数字段
+	body[26], body[27], body[28], body[29] = 0xFF, 0xFF, 0xFF, 0xFF
+	if _, err := decodeDeltaRequest(body); err == nil {
+		t.Error("伪造的签名条数应被拒绝")
+	}
+}
+
+// TestDeltaBlockSize 块大小约为 sqrt(文件大小)，落在区间内，超大文件放大块以限制签名条数
+func TestDeltaBlockSize(t *testing.T) {
+	if bs := deltaBlockSize(100 << 10); bs != deltaMinBlockSize {
+		t.Errorf("小文件块大小 %d，应为下限 %d", bs, deltaMinBlockSize)
+	}
+	if bs := deltaBlockSize(1 << 30); bs != 32<<10 {
+		t.Errorf("1 GiB 文件块大小 %d，应为 32 KiB", bs)
+	}
+	if bs := deltaBlockSize(4 << 40); uint64(4<<40)/uint64(bs) > deltaMaxSignatures {
+		t.Errorf("4 TiB 文件块大小 %d 导致签名条数超限", bs)
+	}
+}
+
+// TestDeltaRequestGated 未协商 FeatureDelta 的请求、签名条数与 basis 对不上的请求
+// 都是协议错误：按连接错误关闭，不进匹配路径
+func TestDeltaRequestGated(t *testing.T) {
+	s := &fileServer{}
+	req := DeltaRequestMessage{
+		FilePath:   "dir/file.bin",
+		BlockSize:  deltaMinBlockSize,
+		BasisSize:  uint64(deltaMinBlockSize) * 2,
+		Signatures: []BlockSignature{{Weak: 1}, {Weak: 2}},
+	}
+	c := &client{Addr: "test", Connected: true}
+	if err := s.handleDeltaRequest(c, encodeDeltaRequest(req)); !errors.Is(err, appError.ErrConnection) {
+		t.Fatalf("未协商的增量请求应按连接错误拒绝，实际: %v", err)
+	}
+	c.Features = FeatureDelta
+	req.Signatures = req.Signatures[:1]
+	if err := s.handleDeltaRequest(c, encodeDeltaRequest(req)); !errors.Is(err, appError.ErrConnection) {
+		t.Fatalf("签名条数不符的增量请求应按连接错误拒绝，实际: %v", err)
+	}
+}
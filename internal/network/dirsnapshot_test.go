@@ -34,7 +34,7 @@ func TestDirSnapshotPagingNoCacheCorruption(t *testing.T) {
 	for {
 		page, next := pageSortedEntries(entries, cursor, 10)
 		pages++
-		for _, n := range wirePageCopy(page) {
+		for _, n := range wirePageCopy(page, localFeatureBits) {
 			got = append(got, n.Path) // 副本应为 "/" 形式
 			if n.ID != "" {
 				t.Error("线格式副本应清空 ID")
@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"local-mirror/config"
+	"local-mirror/internal/keyfile"
 	"net"
 	"sort"
 	"strconv"
@@ -25,6 +26,11 @@ import (
 // 未认证探测（不向局域网扫描者泄露同步路径）。应答本身是明文，对
 // 被动嗅探者可见——嗅探者本就能在一次合法发现交互中看到同样内容，
 // 属可接受范围。MAC 过的探测可被重放，只会诱出应答，无权限提升。
+//
+// MAC 密钥由 Noise 握手所用的 PSK 派生。人选口令的 PSK 用监听进程自己的随机盐
+// 拉伸（见 kdf.go），扫描端事先不知道盐：它先发未认证探测，设了口令的服务端回一个
+// 只含拉伸参数的挑战包，扫描端据此派生密钥后再向该地址单播认证探测。嗅探到的
+// 探测-应答只能针对这一个监听进程逐个猜口令，没法拿一张预算好的字典通吃所有部署
 const (
 	// DiscoveryMagic 发现协议魔数，与 TCP 协议的 MagicNumber 区分
 	DiscoveryMagic uint32 = 0xD15C4FBE
@@ -32,8 +38,9 @@ const (
 	// SO_REUSEADDR 共享监听，无需像 TCP 那样逐实例递增
 	DiscoveryPort = config.DefaultPort
 
-	discoveryKindProbe byte = 0x01
-	discoveryKindReply byte = 0x02
+	discoveryKindProbe     byte = 0x01
+	discoveryKindReply     byte = 0x02
+	discoveryKindChallenge byte = 0x03
 
 	// DiscoveryMaxAlias/DiscoveryMaxPath 应答中字符串的字节上限，
 	// 保证整包 ≤610 字节，避免 IP 分片
@@ -45,6 +52,8 @@ const (
 	discoveryProbeLen = 36
 	// 应答包头部：magic(4)+kind(1)+version(2)+serverID(4)+tcpPort(2)+role(1)+authFlag(1)+aliasLen(1)+pathLen(2)
 	discoveryReplyHeaderLen = 18
+	// 挑战包定长：magic(4)+kind(1)+version(2)+nonce(8)+kdf(26)
+	discoveryChallengeLen = 15 + 1 + 4 + 4 + 1 + kdfSaltLen
 )
 
 // discoveryGroup 组播组地址（239.255.0.0/16 站点本地范围）
@@ -64,10 +73,10 @@ func (d DiscoveredServer) Addr() string {
 	return net.JoinHostPort(d.IP, strconv.Itoa(int(d.TCPPort)))
 }
 
-// deriveDiscoveryKey 从口令派生发现协议的 MAC 密钥。
-// 域分离前缀与 DerivePSK 不同，两个协议的密钥互不相通
-func deriveDiscoveryKey(secret string) []byte {
-	sum := blake3.Sum256([]byte("local-mirror-discovery-mac-v1:" + secret))
+// deriveDiscoveryKey 从 Noise PSK（DerivePSK 或 StretchPSK 的结果）派生发现协议的
+// MAC 密钥。域分离前缀保证发现包里的 MAC 不会成为握手 PSK 的替身
+func deriveDiscoveryKey(psk []byte) []byte {
+	sum := blake3.Sum256(append([]byte("local-mirror-discovery-mac-v2:"), psk...))
 	return sum[:]
 }
 
@@ -99,6 +108,34 @@ func truncateUTF8(s string, maxBytes int) []byte {
 
 // ---- 编解码（纯函数，不依赖网络） ----
 
+// encodeChallenge 编码挑战包：回显探测的 nonce，附上本进程的拉伸参数（encodeKDF）
+func encodeChallenge(version uint16, nonce [8]byte, kdf []byte) []byte {
+	buf := make([]byte, 15, discoveryChallengeLen)
+	binary.BigEndian.PutUint32(buf[0:4], DiscoveryMagic)
+	buf[4] = discoveryKindChallenge
+	binary.BigEndian.PutUint16(buf[5:7], version)
+	copy(buf[7:15], nonce[:])
+	return append(buf, kdf...)
+}
+
+// parseChallenge 解析挑战包。挑战未经认证，参数按 decodeKDF 的上下限把关：
+// 假冒的应答方压不低猜口令的代价，也撑不爆扫描端的内存
+func parseChallenge(pkt []byte, nonce [8]byte, wantVersion uint16) (kdfParams, error) {
+	if len(pkt) != discoveryChallengeLen {
+		return kdfParams{}, fmt.Errorf("challenge length %d != %d", len(pkt), discoveryChallengeLen)
+	}
+	if binary.BigEndian.Uint32(pkt[0:4]) != DiscoveryMagic || pkt[4] != discoveryKindChallenge {
+		return kdfParams{}, fmt.Errorf("not a discovery challenge")
+	}
+	if v := binary.BigEndian.Uint16(pkt[5:7]); v != wantVersion {
+		return kdfParams{}, fmt.Errorf("version mismatch: got %d want %d", v, wantVersion)
+	}
+	if subtle.ConstantTimeCompare(pkt[7:15], nonce[:]) != 1 {
+		return kdfParams{}, fmt.Errorf("challenge bound to a different scan")
+	}
+	return decodeKDF(pkt[15:])
+}
+
 type discoveryProbe struct {
 	Version  uint16
 	ClientID uint32
@@ -210,8 +247,9 @@ func parseResponse(pkt []byte, nonce [8]byte, key []byte, wantVersion uint16) (D
 }
 
 // handleProbe 服务端处理一个探测包，返回应答字节（ok=false 表示静默丢弃）。
+// kdf 非空（人选口令）时对未认证探测回挑战包，只含拉伸参数、不含同步路径。
 // 纯函数，便于不起网络的单元测试
-func handleProbe(pkt []byte, version uint16, self DiscoveredServer, key []byte) ([]byte, bool) {
+func handleProbe(pkt []byte, version uint16, self DiscoveredServer, key, kdf []byte) ([]byte, bool) {
 	probe, err := decodeProbe(pkt)
 	if err != nil {
 		return nil, false
@@ -227,6 +265,9 @@ func handleProbe(pkt []byte, version uint16, self DiscoveredServer, key []byte)
 	}
 	if key != nil {
 		if !probe.Authed {
+			if kdf != nil {
+				return encodeChallenge(version, probe.Nonce, kdf), true
+			}
 			log.Debugf("ignoring unauthenticated discovery probe")
 			return nil, false
 		}
@@ -248,9 +289,12 @@ func handleProbe(pkt []byte, version uint16, self DiscoveredServer, key []byte)
 // wildcard 绑定使这些 socket 同时能收到发往本端口的广播与单播包。
 // 返回的 stop 关闭全部 socket；进程退出时 OS 自动回收，可不调用
 func StartDiscoveryResponder(tcpPort int, alias, syncPath, secret string) (func(), error) {
-	var key []byte
+	var key, kdf []byte
 	if secret != "" {
-		key = deriveDiscoveryKey(secret)
+		key = deriveDiscoveryKey(responderPSK(secret))
+		if !keyfile.IsGenerated(secret) {
+			kdf = encodeKDF(localKDF())
+		}
 	}
 	self := DiscoveredServer{
 		InstanceID: config.InstanceID,
@@ -286,7 +330,7 @@ func StartDiscoveryResponder(tcpPort int, alias, syncPath, secret string) (func(
 		conns = append(conns, c)
 	}
 	for _, c := range conns {
-		go discoveryRespondLoop(c, self, key)
+		go discoveryRespondLoop(c, self, key, kdf)
 	}
 	log.Infof("UDP discovery responder started (%d interfaces, port %d)", len(conns), DiscoveryPort)
 	return func() {
@@ -296,14 +340,14 @@ func StartDiscoveryResponder(tcpPort int, alias, syncPath, secret string) (func(
 	}, nil
 }
 
-func discoveryRespondLoop(conn *net.UDPConn, self DiscoveredServer, key []byte) {
+func discoveryRespondLoop(conn *net.UDPConn, self DiscoveredServer, key, kdf []byte) {
 	buf := make([]byte, 128)
 	for {
 		n, raddr, err := conn.ReadFromUDP(buf)
 		if err != nil {
 			return // socket 已被 stop() 关闭
 		}
-		reply, ok := handleProbe(buf[:n], config.ProtocolVersion, self, key)
+		reply, ok := handleProbe(buf[:n], config.ProtocolVersion, self, key, kdf)
 		if !ok {
 			continue
 		}
@@ -407,11 +451,13 @@ func subnetBroadcast(ipnet *net.IPNet) net.IP {
 }
 
 // discoverOn 在给定的发送 socket 集上执行一轮扫描（senders 的所有 socket
-// 都会被关闭）。与 socket 构建分离，便于测试用回环 socket 直接驱动
+// 都会被关闭）。与 socket 构建分离，便于测试用回环 socket 直接驱动。
+// 人选口令下广播的是未认证探测，收到挑战再按对方的参数派生密钥、单播认证探测
 func discoverOn(senders []probeSender, timeout time.Duration, secret string, selfID uint32) ([]DiscoveredServer, error) {
 	var key []byte
-	if secret != "" {
-		key = deriveDiscoveryKey(secret)
+	passphrase := secret != "" && !keyfile.IsGenerated(secret)
+	if secret != "" && !passphrase {
+		key = deriveDiscoveryKey(DerivePSK(secret))
 	}
 	var nonce [8]byte
 	if _, err := rand.Read(nonce[:]); err != nil {
@@ -443,12 +489,33 @@ func discoverOn(senders []probeSender, timeout time.Duration, secret string, sel
 			defer c.Close()
 			_ = c.SetReadDeadline(deadline)
 			buf := make([]byte, 2048)
+			peerKeys := make(map[string][]byte) // 人选口令下各应答方（按 UDP 源地址）的 MAC 密钥
 			for {
 				n, raddr, err := c.ReadFromUDP(buf)
 				if err != nil {
 					return // 超时或关闭
 				}
-				srv, err := parseResponse(buf[:n], nonce, key, config.ProtocolVersion)
+				replyKey := key
+				if passphrase {
+					if n > 4 && buf[4] == discoveryKindChallenge {
+						p, err := parseChallenge(buf[:n], nonce, config.ProtocolVersion)
+						if err != nil {
+							log.Debugf("ignoring discovery challenge from %s: %v", raddr, err)
+							continue
+						}
+						k := deriveDiscoveryKey(StretchPSK(secret, p))
+						peerKeys[raddr.String()] = k
+						if _, err := c.WriteToUDP(encodeProbe(config.ProtocolVersion, selfID, nonce, k), raddr); err != nil {
+							log.Debugf("failed to send discovery probe to %s: %v", raddr, err)
+						}
+						continue
+					}
+					if replyKey = peerKeys[raddr.String()]; replyKey == nil {
+						log.Debugf("ignoring discovery reply from %s without a prior challenge", raddr)
+						continue
+					}
+				}
+				srv, err := parseResponse(buf[:n], nonce, replyKey, config.ProtocolVersion)
 				if err != nil {
 					log.Debugf("ignoring invalid discovery reply from %s: %v", raddr, err)
 					continue
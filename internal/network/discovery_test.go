@@ -2,6 +2,7 @@ package network
 
 import (
 	"net"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -21,7 +22,7 @@ var testServer = DiscoveredServer{
 func testNonce() [8]byte { return [8]byte{1, 2, 3, 4, 5, 6, 7, 8} }
 
 func TestProbeRoundTrip(t *testing.T) {
-	for _, key := range [][]byte{nil, deriveDiscoveryKey("s1")} {
+	for _, key := range [][]byte{nil, deriveDiscoveryKey(DerivePSK("s1"))} {
 		pkt := encodeProbe(0x0002, 0x11223344, testNonce(), key)
 		if len(pkt) != discoveryProbeLen {
 			t.Fatalf("probe length = %d, want %d", len(pkt), discoveryProbeLen)
@@ -40,7 +41,7 @@ func TestProbeRoundTrip(t *testing.T) {
 }
 
 func TestResponseRoundTrip(t *testing.T) {
-	for _, key := range [][]byte{nil, deriveDiscoveryKey("s1")} {
+	for _, key := range [][]byte{nil, deriveDiscoveryKey(DerivePSK("s1"))} {
 		pkt := encodeResponse(0x0002, testNonce(), testServer, key)
 		r, err := parseResponse(pkt, testNonce(), key, 0x0002)
 		if err != nil {
@@ -54,7 +55,7 @@ func TestResponseRoundTrip(t *testing.T) {
 }
 
 func TestResponseMACTamper(t *testing.T) {
-	key := deriveDiscoveryKey("s1")
+	key := deriveDiscoveryKey(DerivePSK("s1"))
 	pkt := encodeResponse(0x0002, testNonce(), testServer, key)
 	// 除魔数/类型/长度字段外，翻转任何一个字节都必须导致 MAC 校验失败
 	for i := 5; i < len(pkt); i++ {
@@ -72,21 +73,21 @@ func TestResponseMACTamper(t *testing.T) {
 }
 
 func TestSecretGating(t *testing.T) {
-	key := deriveDiscoveryKey("s1")
+	key := deriveDiscoveryKey(DerivePSK("s1"))
 	// 有密钥的服务端忽略未认证探测
 	plainProbe := encodeProbe(0x0002, 1, testNonce(), nil)
-	if _, ok := handleProbe(plainProbe, 0x0002, testServer, key); ok {
+	if _, ok := handleProbe(plainProbe, 0x0002, testServer, key, nil); ok {
 		t.Error("server with secret answered unauthenticated probe")
 	}
 	// 探测 MAC 被篡改同样忽略
 	badProbe := encodeProbe(0x0002, 1, testNonce(), key)
 	badProbe[25] ^= 0x01
-	if _, ok := handleProbe(badProbe, 0x0002, testServer, key); ok {
+	if _, ok := handleProbe(badProbe, 0x0002, testServer, key, nil); ok {
 		t.Error("server accepted probe with tampered MAC")
 	}
 	// 口令不同 → MAC 不同 → 忽略
-	otherProbe := encodeProbe(0x0002, 1, testNonce(), deriveDiscoveryKey("s2"))
-	if _, ok := handleProbe(otherProbe, 0x0002, testServer, key); ok {
+	otherProbe := encodeProbe(0x0002, 1, testNonce(), deriveDiscoveryKey(DerivePSK("s2")))
+	if _, ok := handleProbe(otherProbe, 0x0002, testServer, key, nil); ok {
 		t.Error("server accepted probe MAC'd with different secret")
 	}
 	// 有密钥的客户端丢弃未认证应答
@@ -96,7 +97,7 @@ func TestSecretGating(t *testing.T) {
 	}
 	// 双方口令一致的完整探测-应答链路
 	goodProbe := encodeProbe(0x0002, 1, testNonce(), key)
-	reply, ok := handleProbe(goodProbe, 0x0002, testServer, key)
+	reply, ok := handleProbe(goodProbe, 0x0002, testServer, key, nil)
 	if !ok {
 		t.Fatal("server rejected valid authenticated probe")
 	}
@@ -105,15 +106,50 @@ func TestSecretGating(t *testing.T) {
 	}
 }
 
+// TestPassphraseChallenge 人选口令：未认证探测换回只含拉伸参数的挑战包；挑战须回显
+// 本次扫描的 nonce、参数不得弱于下限；不同盐下同一口令派生出不同的 MAC 密钥
+func TestPassphraseChallenge(t *testing.T) {
+	p := kdfParams{Time: kdfMinTime, Memory: kdfMinMemory, Threads: 1}
+	p.Salt[0] = 1
+	kdf := encodeKDF(p)
+	key := deriveDiscoveryKey(DerivePSK("s1"))
+
+	challenge, ok := handleProbe(encodeProbe(0x0002, 1, testNonce(), nil), 0x0002, testServer, key, kdf)
+	if !ok {
+		t.Fatal("server with a passphrase should answer an unauthenticated probe with a challenge")
+	}
+	if strings.Contains(string(challenge), testServer.SyncPath) || strings.Contains(string(challenge), testServer.Alias) {
+		t.Error("challenge leaks the alias or sync path")
+	}
+	got, err := parseChallenge(challenge, testNonce(), 0x0002)
+	if err != nil || got != p {
+		t.Fatalf("parseChallenge = %+v, %v; want %+v", got, err, p)
+	}
+	if _, err := parseChallenge(challenge, [8]byte{9}, 0x0002); err == nil {
+		t.Error("challenge bound to a different scan accepted")
+	}
+	weak := p
+	weak.Time = 1
+	if _, err := parseChallenge(encodeChallenge(0x0002, testNonce(), encodeKDF(weak)), testNonce(), 0x0002); err == nil {
+		t.Error("challenge with parameters below the floor accepted")
+	}
+
+	other := p
+	other.Salt[0] = 2
+	if slices.Equal(deriveDiscoveryKey(StretchPSK("s1", p)), deriveDiscoveryKey(StretchPSK("s1", other))) {
+		t.Error("the same passphrase under different salts must give different discovery keys")
+	}
+}
+
 func TestHandleProbeFilters(t *testing.T) {
 	// 版本不匹配静默丢弃
 	probe := encodeProbe(0x0001, 1, testNonce(), nil)
-	if _, ok := handleProbe(probe, 0x0002, testServer, nil); ok {
+	if _, ok := handleProbe(probe, 0x0002, testServer, nil, nil); ok {
 		t.Error("answered version-mismatched probe")
 	}
 	// 自己实例的探测不应答
 	selfProbe := encodeProbe(0x0002, testServer.InstanceID, testNonce(), nil)
-	if _, ok := handleProbe(selfProbe, 0x0002, testServer, nil); ok {
+	if _, ok := handleProbe(selfProbe, 0x0002, testServer, nil, nil); ok {
 		t.Error("answered own probe")
 	}
 	// 结构错误：长度、魔数、类型
@@ -124,7 +160,7 @@ func TestHandleProbeFilters(t *testing.T) {
 		func() []byte { p := encodeProbe(0x0002, 1, testNonce(), nil); p[0] ^= 0xFF; return p }(),
 		func() []byte { p := encodeProbe(0x0002, 1, testNonce(), nil); p[4] = discoveryKindReply; return p }(),
 	} {
-		if _, ok := handleProbe(bad, 0x0002, testServer, nil); ok {
+		if _, ok := handleProbe(bad, 0x0002, testServer, nil, nil); ok {
 			t.Errorf("answered malformed probe %v", bad)
 		}
 	}
@@ -227,3 +263,37 @@ func TestDiscoverLoopback(t *testing.T) {
 		t.Errorf("Addr() = %s", got.Addr())
 	}
 }
+
+// TestDiscoverLoopbackPassphrase 人选口令下的完整扫描链路：探测 → 挑战 → 单播认证
+// 探测 → 认证应答。口令不同的扫描端什么也拿不到
+func TestDiscoverLoopbackPassphrase(t *testing.T) {
+	responder, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen responder: %v", err)
+	}
+	defer responder.Close()
+	key, kdf := deriveDiscoveryKey(responderPSK("s1")), encodeKDF(localKDF())
+	go discoveryRespondLoop(responder, testServer, key, kdf)
+
+	scan := func(secret string) []DiscoveredServer {
+		sender, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen sender: %v", err)
+		}
+		senders := []probeSender{{
+			conn:    sender,
+			targets: []*net.UDPAddr{responder.LocalAddr().(*net.UDPAddr)},
+		}}
+		servers, err := discoverOn(senders, 1500*time.Millisecond, secret, 0x0EADBEEF)
+		if err != nil {
+			t.Fatalf("discoverOn: %v", err)
+		}
+		return servers
+	}
+	if servers := scan("s1"); len(servers) != 1 || servers[0].SyncPath != testServer.SyncPath {
+		t.Fatalf("scan with the right passphrase: %+v", servers)
+	}
+	if servers := scan("s2"); len(servers) != 0 {
+		t.Errorf("scan with a wrong passphrase found %+v", servers)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package network
+
+// localMetadataFeature 本平台支持元数据与符号链接同步
+const localMetadataFeature = FeatureMetadata
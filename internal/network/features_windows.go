@@ -0,0 +1,4 @@
+package network
+
+// localMetadataFeature Windows 不申报 FeatureMetadata（见 fsmeta.Supported）
+const localMetadataFeature = 0
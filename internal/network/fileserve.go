@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"local-mirror/config"
@@ -30,11 +31,12 @@ const treePageMaxEntries = 20000
 const localMirrorStateDir = ".local-mirror"
 
 type session struct {
-	ID       [16]byte // 会话ID
-	FilePath string   // 文件路径
-	FileSize uint64   // 文件大小
-	file     *os.File // 文件句柄
-	fileHash [32]byte // 文件哈希值
+	ID       [16]byte       // 会话ID
+	FilePath string         // 文件路径
+	FileSize uint64         // 文件大小
+	file     *os.File       // 文件句柄
+	fileHash [32]byte       // 文件哈希值
+	compress *compressState // 数据消息的压缩决策（FeatureCompress）
 }
 
 // dirSnapshot 一次分页遍历的稳定目录快照（PERF-01）：首页时加载并排序一次，续页复用，
@@ -43,6 +45,7 @@ type session struct {
 type dirSnapshot struct {
 	rootPath string
 	nodes    []tree.Node // 已按 Path 升序
+	digest   string      // 目录自身的子树摘要（协商了 FeatureDigest 才取）
 	expiry   time.Time
 }
 
@@ -79,50 +82,82 @@ func pageTreeEntries(entries []tree.Node, continueFrom string, limit int) (page
 
 // wirePageCopy 返回 page 的线格式副本：清空 ID/ParentID、Path 转 "/"。必须在**副本**上做——
 // page 可能是缓存目录快照（dirSnapshot）的子切片，原地改会把 ID 清零、Path 改成 "/" 形式
-// 写回缓存，污染后续页的游标比较。Node 无指针字段，浅拷贝即安全（PERF-01 关键正确性点）
-func wirePageCopy(page []tree.Node) []tree.Node {
-	out := make([]tree.Node, len(page))
-	copy(out, page)
-	for i := range out {
-		out[i].ID = ""
-		out[i].ParentID = ""
+// 写回缓存，污染后续页的游标比较（PERF-01 关键正确性点）。Node 唯一的指针字段 Meta 只读
+// 共享、从不原地修改，浅拷贝即安全。
+//
+// 对端未协商 FeatureMetadata 时剔除元数据与符号链接节点：旧版汇端不认识链接，
+// 会把它当普通文件请求下载，而服务端对链接一律按越界拒绝。未协商 FeatureDigest 时
+// 同样去掉子目录摘要，不给旧版汇端多发用不上的字段
+func wirePageCopy(page []tree.Node, features uint64) []tree.Node {
+	out := make([]tree.Node, 0, len(page))
+	for _, n := range page {
+		if features&FeatureMetadata == 0 {
+			if n.IsSymlink() {
+				continue
+			}
+			n.Meta = nil
+		}
+		if features&FeatureDigest == 0 {
+			n.Digest = ""
+		}
+		n.ID = ""
+		n.ParentID = ""
 		// 节点路径随 JSON 进入线格式，统一转为 "/"（见 protocol.go 线格式约定）
-		out[i].Path = filepath.ToSlash(out[i].Path)
+		n.Path = filepath.ToSlash(n.Path)
+		out = append(out, n)
 	}
 	return out
 }
 
-func (s *fileServer) handleTreeRequest(ID uint32, bodyBytes []byte) error {
-	_client, ok := s.clientMap.Load(ID)
-	if !ok {
-		return fmt.Errorf("%w, client not found for ID: %d", appError.ErrConnection, ID)
+func (s *fileServer) handleTreeRequest(c *client, bodyBytes []byte) error {
+	if !c.Connected {
+		// 未握手/已注销的连接按连接错误关闭，而不是静默不应答让对端干等读超时
+		return fmt.Errorf("%w, client %s has not completed handshake", appError.ErrConnection, c.Addr)
 	}
-	conn := _client.(*client).Conn
+	conn := c.Conn
 	treeRequest, err := decodeTreeRequest(bodyBytes)
 	if err != nil {
 		return fmt.Errorf("%w, error decoding tree request: %v", appError.ErrConnection, err)
 	}
 	clientAddr := conn.RemoteAddr().String()
 	log.Infof("Received tree request from %s for path: %s (cursor %q)", clientAddr, treeRequest.RootPath, treeRequest.ContinueFrom)
+	access := c.access()
+	if !access.reveals(treeRequest.RootPath) {
+		return deniedByACL(treeRequest.RootPath)
+	}
 
 	// PERF-01：续页复用首页建立的已排序快照，避免超大目录每页都全量加载 + 排序。
 	// handleTreeRequest 在该客户端唯一的消息循环 goroutine 内串行执行，dirCache 无需加锁
-	c := _client.(*client)
 	var entries []tree.Node
+	var digest string
 	if snap := c.dirCache; snap != nil && treeRequest.ContinueFrom != "" &&
 		snap.rootPath == treeRequest.RootPath && time.Now().Before(snap.expiry) {
-		entries = snap.nodes
+		entries, digest = snap.nodes, snap.digest
 	} else {
+		// 摘要随快照一起取：先把积压的脏目录算掉，本页与后续页看到的都是最新值。
+		// 重算失败只是本次不带摘要（对端照常下钻），不影响目录页本身
+		if c.Features&FeatureDigest != 0 {
+			if err := tree.RefreshDigests(); err != nil {
+				log.Warnf("failed to refresh directory digests: %v", err)
+			} else if self, err := tree.GetNodeByPath(treeRequest.RootPath); err == nil {
+				digest = self.Digest
+			}
+		}
 		entries, err = tree.GetDirContents(treeRequest.RootPath)
 		if err != nil {
 			return &wireError{Code: ErrCodeNotFound, Path: treeRequest.RootPath,
 				Message: fmt.Sprintf("error getting tree contents: %v", err)}
 		}
 		sortNodesByPath(entries)
-		c.dirCache = &dirSnapshot{rootPath: treeRequest.RootPath, nodes: entries, expiry: time.Now().Add(dirSnapshotTTL)}
+		c.dirCache = &dirSnapshot{rootPath: treeRequest.RootPath, nodes: entries, digest: digest, expiry: time.Now().Add(dirSnapshotTTL)}
 	}
 	page, next := pageSortedEntries(entries, treeRequest.ContinueFrom, treePageMaxEntries)
-	treeData, err := json.Marshal(wirePageCopy(page))
+	// ACL：只通向允许子树的目录，其余条目隐去，自身摘要也不能给
+	page = access.filter(page)
+	if !access.allows(treeRequest.RootPath) {
+		digest = ""
+	}
+	treeData, err := json.Marshal(wirePageCopy(page, c.Features))
 	if err != nil {
 		return fmt.Errorf("error marshalling tree leaf for path %s: %v", treeRequest.RootPath, err)
 	}
@@ -130,9 +165,16 @@ func (s *fileServer) handleTreeRequest(ID uint32, bodyBytes []byte) error {
 		ContinueFrom: next,
 		DataLength:   uint32(len(treeData)),
 		Data:         treeData,
+		Digest:       digest,
 	}
 	responseBytes := encodeTreeResponse(treeResponse)
-	if err := sendMessage(conn, MsgTypeTreeResponse, responseBytes); err != nil {
+	// 目录页是 JSON，压缩收益最大；协商了 FeatureCompress 即压
+	if c.Features&FeatureCompress != 0 {
+		_, err = sendCompressed(conn, MsgTypeTreeResponse, responseBytes)
+	} else {
+		err = sendMessage(conn, MsgTypeTreeResponse, responseBytes)
+	}
+	if err != nil {
 		return fmt.Errorf("%w, error sending tree response for path %s: %v", appError.ErrConnection, treeRequest.RootPath, err)
 	}
 	log.Infof("Sent tree response to %s for path: %s, %d entries, %d bytes, more=%v",
@@ -152,7 +194,7 @@ func authorizeServeFile(rel, reportPath string) *wireError {
 	if rel == localMirrorStateDir || strings.HasPrefix(rel, localMirrorStateDir+string(filepath.Separator)) {
 		return notFound
 	}
-	if utils.IsIgnored(rel, config.IgnoreFileList) {
+	if config.IsIgnored(rel, false) {
 		return notFound
 	}
 	if node, err := tree.GetNodeByPath(rel); err != nil || node == nil || node.IsDir || node.Hash == "" {
@@ -161,125 +203,284 @@ func authorizeServeFile(rel, reportPath string) *wireError {
 	return nil
 }
 
-func (s *fileServer) handleFileRequest(ID uint32, bodyBytes []byte) error {
-	_client, ok := s.clientMap.Load(ID)
-	if !ok {
-		return fmt.Errorf("%w, client not found for ID: %d", appError.ErrConnection, ID)
-	}
-	conn := _client.(*client).Conn
-	fileRequest, err := decodeFileRequest(bodyBytes)
-	if err != nil {
-		return fmt.Errorf("%w, error decoding file request: %v", appError.ErrConnection, err)
-	}
-	log.Debugf("Received file request: %s, offset: %d", fileRequest.FilePath, fileRequest.Offset)
-	fullPath := filepath.Join(config.StartPath, fileRequest.FilePath)
+// resolveServeFile 文件服务的公共前置校验（整文件下载与增量传输共用）：
+// 根检查 → 授权闸门 → 符号链接组件 → stat。全部是廉价校验，在获取
+// 全局服务槽之前完成，被拒的请求不占槽
+func resolveServeFile(reqPath string) (fullPath string, fileInfo os.FileInfo, err error) {
+	fullPath = filepath.Join(config.StartPath, reqPath)
 	// 防止路径穿越：请求路径解析后必须仍位于同步根目录内
 	rel, relErr := filepath.Rel(config.StartPath, fullPath)
 	if relErr != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
-		return &wireError{Code: ErrCodeOutOfRoot, Path: fileRequest.FilePath, Message: "illegal file path (escapes sync root)"}
+		return "", nil, &wireError{Code: ErrCodeOutOfRoot, Path: reqPath, Message: "illegal file path (escapes sync root)"}
 	}
 	// 授权闸门（SEC-02）：文件服务只提供「公开目录树里、哈希非空的普通文件」。词法根检查
 	// 只保证「没逃出根」，但已握手的对端仍能绕过树枚举、直接点名根内任意路径。策略抽到
 	// authorizeServeFile 便于单测；rel 复用上面根检查算出的同一个值，与 tree/IsIgnored 的
 	// 键形态（OS 分隔符、根为 "."）一致。
-	if werr := authorizeServeFile(rel, fileRequest.FilePath); werr != nil {
-		return werr
+	if werr := authorizeServeFile(rel, reqPath); werr != nil {
+		return "", nil, werr
 	}
 	// SEC-03：逐级校验请求路径的每一级组件都不是符号链接。只查末段（原 Lstat）挡不住
 	// 「中间某级目录是指向根外的符号链接」——后续 Stat/Open 会解引用它，读到同步根之外的
 	// 文件（outside→/etc，请求 outside/passwd）。SEC-02 的树成员校验已基本关掉此路（建树跳过
 	// 符号链接，故这类路径不在树里），这里作纵深防御 + 收 TOCTOU
 	if err := safety.VerifyNoSymlinkComponents(config.StartPath, rel); err != nil {
-		return &wireError{Code: ErrCodeOutOfRoot, Path: fileRequest.FilePath, Message: "refusing to serve symlinked path"}
+		return "", nil, &wireError{Code: ErrCodeOutOfRoot, Path: reqPath, Message: "refusing to serve symlinked path"}
 	}
-	fileInfo, err := os.Stat(fullPath)
+	fileInfo, err = os.Stat(fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &wireError{Code: ErrCodeNotFound, Path: fileRequest.FilePath, Message: "file not found"}
+			return "", nil, &wireError{Code: ErrCodeNotFound, Path: reqPath, Message: "file not found"}
 		}
-		return fmt.Errorf("error getting file info: %s :%v", fileRequest.FilePath, err)
+		return "", nil, fmt.Errorf("error getting file info: %s :%v", reqPath, err)
+	}
+	return fullPath, fileInfo, nil
+}
 
-	} else {
-		// 5.4 全局限流：整文件预哈希 + 传输是两次全盘读，256 连接各自触发大文件会把磁盘/CPU
-		// 打爆。在此获取全局服务槽（容量远小于连接上限），跨「哈希 → 传输」整段持有、出函数即释放。
-		// 阻塞发生在该连接自己的消息循环 goroutine 内——只是排队等槽，不影响其它连接的握手/目录树/
-		// 变更长轮询等轻量交互。所有廉价校验（越权/忽略/不在树/不存在/软链）都在获取槽之前完成，
-		// 被拒的请求不占槽
-		release := acquireFileServeSlot()
-		defer release()
-
-		// 错误带上系统级原因（如 permission denied），它会随结构化错误应答
-		// 发给客户端——对端日志里能直接看到失败根因，不用两头对日志；
-		// 权限类失败带 ErrCodePermissionDenied，客户端据此跳过而非反复重试。
-		// 读取失败同时登记进不可读列表，恢复可读后由 watcher 恢复循环补哈希
-		fileHash, err := utils.CalcBlake3(fullPath)
-		if err != nil {
-			tree.MarkUnreadable(fullPath)
-			if os.IsPermission(err) {
-				return &wireError{Code: ErrCodePermissionDenied, Path: fileRequest.FilePath,
-					Message: fmt.Sprintf("error calculating file hash: %v", err)}
-			}
-			return fmt.Errorf("error calculating file hash for %s: %v", fileRequest.FilePath, err)
+// openServeFile 预哈希并打开待下发文件，调用方须已持有全局服务槽。
+// 错误带上系统级原因（如 permission denied），它会随结构化错误应答
+// 发给客户端——对端日志里能直接看到失败根因，不用两头对日志；
+// 权限类失败带 ErrCodePermissionDenied，客户端据此跳过而非反复重试。
+// 读取失败同时登记进不可读列表，恢复可读后由 watcher 恢复循环补哈希
+func openServeFile(reqPath, fullPath string) (*os.File, [32]byte, error) {
+	fileHash, err := utils.CalcBlake3(fullPath)
+	if err != nil {
+		tree.MarkUnreadable(fullPath)
+		if os.IsPermission(err) {
+			return nil, fileHash, &wireError{Code: ErrCodePermissionDenied, Path: reqPath,
+				Message: fmt.Sprintf("error calculating file hash: %v", err)}
 		}
+		return nil, fileHash, fmt.Errorf("error calculating file hash for %s: %v", reqPath, err)
+	}
 
-		file, err := os.Open(fullPath)
-		if err != nil {
-			if os.IsPermission(err) {
-				return &wireError{Code: ErrCodePermissionDenied, Path: fileRequest.FilePath,
-					Message: fmt.Sprintf("error opening file: %v", err)}
-			}
-			return fmt.Errorf("error opening file %s: %v", fileRequest.FilePath, err)
+	file, err := os.Open(fullPath)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, fileHash, &wireError{Code: ErrCodePermissionDenied, Path: reqPath,
+				Message: fmt.Sprintf("error opening file: %v", err)}
 		}
-		defer file.Close()
+		return nil, fileHash, fmt.Errorf("error opening file %s: %v", reqPath, err)
+	}
+	return file, fileHash, nil
+}
 
-		sessionID, err := utils.RandomString(16)
-		if err != nil {
-			return fmt.Errorf("error generating session ID for file %s", fileRequest.FilePath)
-		}
-		var sessionBytes [16]byte
-		copy(sessionBytes[:], sessionID)
+// newSessionID 生成一次文件传输会话的 ID
+func newSessionID(reqPath string) ([16]byte, error) {
+	var sessionBytes [16]byte
+	sessionID, err := utils.RandomString(16)
+	if err != nil {
+		return sessionBytes, fmt.Errorf("error generating session ID for file %s", reqPath)
+	}
+	copy(sessionBytes[:], sessionID)
+	return sessionBytes, nil
+}
 
-		if fileRequest.Offset > 0 {
-			if _, err := file.Seek(int64(fileRequest.Offset), io.SeekStart); err != nil {
-				return fmt.Errorf("error seeking file %s at offset %d", fileRequest.FilePath, fileRequest.Offset)
-			}
-		}
-		session := &session{
-			ID:       sessionBytes,
-			FilePath: fullPath,
-			FileSize: uint64(fileInfo.Size()),
-			file:     file,
-			fileHash: fileHash,
+func (s *fileServer) handleFileRequest(c *client, bodyBytes []byte) error {
+	if !c.Connected {
+		// 未握手/已注销的连接按连接错误关闭，而不是静默不应答让对端干等读超时
+		return fmt.Errorf("%w, client %s has not completed handshake", appError.ErrConnection, c.Addr)
+	}
+	conn := c.Conn
+	fileRequest, err := decodeFileRequest(bodyBytes)
+	if err != nil {
+		return fmt.Errorf("%w, error decoding file request: %v", appError.ErrConnection, err)
+	}
+	log.Debugf("Received file request: %s, offset: %d", fileRequest.FilePath, fileRequest.Offset)
+	// ACL 先于存在性检查：越界路径一律拒绝，不透露它在不在
+	if !c.access().allows(fileRequest.FilePath) {
+		return deniedByACL(fileRequest.FilePath)
+	}
+	fullPath, fileInfo, err := resolveServeFile(fileRequest.FilePath)
+	if err != nil {
+		return err
+	}
+
+	// 5.4 全局限流：整文件预哈希 + 传输是两次全盘读，256 连接各自触发大文件会把磁盘/CPU
+	// 打爆。在此获取全局服务槽（容量远小于连接上限），跨「哈希 → 传输」整段持有、出函数即释放。
+	// 阻塞发生在该连接自己的消息循环 goroutine 内——只是排队等槽，不影响其它连接的握手/目录树/
+	// 变更长轮询等轻量交互。所有廉价校验（越权/忽略/不在树/不存在/软链）都在获取槽之前完成，
+	// 被拒的请求不占槽
+	release := acquireFileServeSlot()
+	defer release()
+
+	file, fileHash, err := openServeFile(fileRequest.FilePath, fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sessionBytes, err := newSessionID(fileRequest.FilePath)
+	if err != nil {
+		return err
+	}
+
+	if fileRequest.Offset > 0 {
+		if _, err := file.Seek(int64(fileRequest.Offset), io.SeekStart); err != nil {
+			return fmt.Errorf("error seeking file %s at offset %d", fileRequest.FilePath, fileRequest.Offset)
 		}
+	}
+	session := &session{
+		ID:       sessionBytes,
+		FilePath: fullPath,
+		FileSize: uint64(fileInfo.Size()),
+		file:     file,
+		fileHash: fileHash,
+		compress: newCompressState(c.Features, fullPath),
+	}
+
+	c.SessionMap.Store(session.ID, session)
+
+	fileResponse := FileResponseMessage{
+		SessionID: sessionBytes,
+		FileSize:  uint64(fileInfo.Size()),
+		FileHash:  fileHash,
+	}
+	responseBytes := encodeFileResponse(fileResponse)
+	if err := sendMessage(conn, MsgTypeFileResponse, responseBytes); err != nil {
+		s.removeClientIfCurrent(c.ID, c)
+		return fmt.Errorf("%w, error sending file response for %s", appError.ErrConnection, fileRequest.FilePath)
+	}
+	log.Debugf("Sent file response: session ID: %x, file size: %d bytes", sessionBytes, fileInfo.Size())
+	if err := s.sendFileData(c, session); err != nil {
+		return err
+	}
+	return nil
+}
+
+// handleDeltaRequest 增量传输（FeatureDelta）：校验与限流同 handleFileRequest，
+// 随后在文件上对照客户端签名生成重建指令，分批以 DeltaData 下发。
+// 未协商 FeatureDelta 就发来、或签名与 basis 对不上的请求是协议错误，按连接错误关闭
+func (s *fileServer) handleDeltaRequest(c *client, bodyBytes []byte) error {
+	if !c.Connected {
+		// 未握手/已注销的连接按连接错误关闭，而不是静默不应答让对端干等读超时
+		return fmt.Errorf("%w, client %s has not completed handshake", appError.ErrConnection, c.Addr)
+	}
+	if c.Features&FeatureDelta == 0 {
+		return fmt.Errorf("%w, client %s sent a delta request without negotiating delta transfer", appError.ErrConnection, c.Addr)
+	}
+	conn := c.Conn
+	req, err := decodeDeltaRequest(bodyBytes)
+	if err != nil {
+		return fmt.Errorf("%w, error decoding delta request: %v", appError.ErrConnection, err)
+	}
+	log.Debugf("Received delta request: %s, block size: %d, %d signatures", req.FilePath, req.BlockSize, len(req.Signatures))
+	// 签名与 basis 大小必须自洽，块大小不得超过上限（匹配缓冲按块大小分配）
+	if req.BlockSize < deltaMinBlockSize || req.BlockSize > deltaMaxWireBlockSize ||
+		uint64(len(req.Signatures)) != (req.BasisSize+uint64(req.BlockSize)-1)/uint64(req.BlockSize) {
+		return fmt.Errorf("%w, malformed delta request from %s for %s (block size %d, basis %d bytes, %d signatures)",
+			appError.ErrConnection, c.Addr, req.FilePath, req.BlockSize, req.BasisSize, len(req.Signatures))
+	}
+	if !c.access().allows(req.FilePath) {
+		return deniedByACL(req.FilePath)
+	}
+	fullPath, fileInfo, err := resolveServeFile(req.FilePath)
+	if err != nil {
+		return err
+	}
+
+	release := acquireFileServeSlot()
+	defer release()
 
-		_client.(*client).SessionMap.Store(session.ID, session)
+	file, fileHash, err := openServeFile(req.FilePath, fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sessionBytes, err := newSessionID(req.FilePath)
+	if err != nil {
+		return err
+	}
+	session := &session{
+		ID:       sessionBytes,
+		FilePath: fullPath,
+		FileSize: uint64(fileInfo.Size()),
+		file:     file,
+		fileHash: fileHash,
+		compress: newCompressState(c.Features, fullPath),
+	}
+	c.SessionMap.Store(session.ID, session)
+	defer c.SessionMap.Delete(session.ID)
 
-		fileResponse := FileResponseMessage{
-			SessionID: sessionBytes,
-			FileSize:  uint64(fileInfo.Size()),
-			FileHash:  fileHash,
+	fileResponse := FileResponseMessage{
+		SessionID: sessionBytes,
+		FileSize:  session.FileSize,
+		FileHash:  fileHash,
+	}
+	if err := sendMessage(conn, MsgTypeFileResponse, encodeFileResponse(fileResponse)); err != nil {
+		s.removeClientIfCurrent(c.ID, c)
+		return fmt.Errorf("%w, error sending file response for %s", appError.ErrConnection, req.FilePath)
+	}
+
+	// 指令按编码后体积攒批，单批约一个传输分块，与整文件传输的消息粒度一致
+	rel := strings.Replace(session.FilePath, config.StartPath, ".", 1)
+	batchLimit := int(*config.FileBufferSize)
+	reader := &countingReader{r: file}
+	var batch []deltaOp
+	batchBytes := 0
+	var literalBytes uint64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
-		responseBytes := encodeFileResponse(fileResponse)
-		if err := sendMessage(conn, MsgTypeFileResponse, responseBytes); err != nil {
-			s.removeClientIfCurrent(ID, _client.(*client))
-			return fmt.Errorf("%w, error sending file response for %s", appError.ErrConnection, fileRequest.FilePath)
+		msg := DeltaDataMessage{SessionID: session.ID, Ops: batch}
+		if err := session.compress.send(conn, MsgTypeDeltaData, encodeDeltaData(msg)); err != nil {
+			return fmt.Errorf("%w, error sending delta data for %s", appError.ErrConnection, rel)
 		}
-		log.Debugf("Sent file response: session ID: %s, file size: %d bytes", sessionID, fileInfo.Size())
-		if err := s.sendFileData(ID, session); err != nil {
-			return err
+		status.RecordProgress(rel, reader.n, session.FileSize)
+		batch, batchBytes = nil, 0
+		return nil
+	}
+	err = generateDelta(reader, req.BlockSize, req.BasisSize, req.Signatures, batchLimit, func(op deltaOp) error {
+		// 限速只计字面量：复制指令几个字节，代表的是汇端本地已有的数据
+		sendLimiter.wait(len(op.Data))
+		batch = append(batch, op)
+		batchBytes += 9 + len(op.Data)
+		literalBytes += uint64(len(op.Data))
+		if batchBytes >= batchLimit {
+			return flush()
 		}
 		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		if errors.Is(err, appError.ErrConnection) {
+			return err
+		}
+		return fmt.Errorf("error reading file %s: %v", rel, err)
+	}
+
+	completeMsg := FileCompleteMessage{SessionID: session.ID, FileHash: fileHash}
+	if err := sendMessage(conn, MsgTypeFileComplete, encodeFileComplete(completeMsg)); err != nil {
+		return fmt.Errorf("%w, error sending file complete for %s", appError.ErrConnection, rel)
 	}
+	status.RecordFile(rel, session.FileSize)
+	log.Infof("Sent delta for %s: %d bytes, %d literal", rel, session.FileSize, literalBytes)
+	return nil
+}
+
+// countingReader 统计已读字节数，供增量生成期间上报进度
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
 }
 
-func (s *fileServer) sendFileData(ID uint32, session *session) error {
-	_client, ok := s.clientMap.Load(ID)
-	if !ok {
-		return fmt.Errorf("%w, client not found for ID: %d", appError.ErrConnection, ID)
+func (s *fileServer) sendFileData(c *client, session *session) error {
+	if !c.Connected {
+		// 未握手/已注销的连接按连接错误关闭，而不是静默不应答让对端干等读超时
+		return fmt.Errorf("%w, client %s has not completed handshake", appError.ErrConnection, c.Addr)
 	}
-	conn := _client.(*client).Conn
+	conn := c.Conn
 	// session.file 由 handleFileRequest 中的 defer 统一关闭，这里不重复 Close
-	defer _client.(*client).SessionMap.Delete(session.ID)
+	defer c.SessionMap.Delete(session.ID)
 
 	fileBuf := make([]byte, *config.FileBufferSize)
 	rel := strings.Replace(session.FilePath, config.StartPath, ".", 1)
@@ -287,12 +488,13 @@ func (s *fileServer) sendFileData(ID uint32, session *session) error {
 	for {
 		n, err := session.file.Read(fileBuf)
 		if n > 0 {
+			sendLimiter.wait(n)
 			dataMsg := FileDataMessage{
 				SessionID:  session.ID,
 				DataLength: uint32(n),
 				Data:       fileBuf[:n],
 			}
-			if err := sendMessage(conn, MsgTypeFileData, encodeFileData(dataMsg)); err != nil {
+			if err := session.compress.send(conn, MsgTypeFileData, encodeFileData(dataMsg)); err != nil {
 				return fmt.Errorf("%w, error sending file data for %s", appError.ErrConnection, rel)
 			}
 			// 进度上报（--status 实时展示）：节流在 status 内部
@@ -0,0 +1,253 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/keyfile"
+
+	"github.com/flynn/noise"
+	log "github.com/sirupsen/logrus"
+)
+
+// 身份模式（--identity）：Noise XX 模式，两端各带静态密钥对，握手中互换公钥
+// 并各自对照本端的 authorized_peers 名单（见 keyfile 包）——任一方不认对方即断开。
+// 同时设了口令（-k 或密钥文件）时用 XXpsk0，口令作为额外一道认证并让不持口令者
+// 在第一条消息就失败、看不到双方公钥。
+// 握手后多一帧加密的认可结果：发起方据此区分"对端不认我"与网络故障，
+// 不必等第一条协议消息读到 EOF 才知道被拒
+
+const (
+	identityAccepted byte = 0
+	identityRejected byte = 1
+)
+
+var (
+	// localIdentity 本实例的静态密钥对，启动时由 UseIdentity 设置
+	localIdentity noise.DHKey
+	// identityConns 握手认证过身份、尚未关闭的连接。授权名单变化时
+	// DropRevoked 据此断开已被吊销的对端——吊销不必等对方下次重连
+	identityConns sync.Map // *secureConn → struct{}
+)
+
+// ErrPeerNotAuthorized 对端公钥不在本端 authorized_peers 里
+var ErrPeerNotAuthorized = errors.New("peer identity is not authorized")
+
+// UseIdentity 设置本实例的身份密钥对（keyfile.LoadIdentity 读出）
+func UseIdentity(priv, pub []byte) {
+	localIdentity = noise.DHKey{Private: priv, Public: pub}
+}
+
+// LocalPublicKey 本实例身份的公钥，未开身份模式为 nil
+func LocalPublicKey() []byte {
+	return localIdentity.Public
+}
+
+// secure 按配置完成传输加密握手：--transport tls 走 SecureTLSConn（host 为拨号时的
+// 主机名，响应方为空），身份模式走 SecureIdentityConn，否则走口令派生 PSK 的
+// SecureConn。调用方先以 config.Encrypted 判定是否需要加密。
+// 响应方另外接受轮换宽限期内的旧 key（见 rotate.go）
+func secure(conn net.Conn, initiator bool, host string) (net.Conn, error) {
+	if config.TLSTransport() {
+		return SecureTLSConn(conn, localTLS, initiator, host)
+	}
+	keys := []string{currentKey()}
+	if !initiator {
+		keys = acceptedKeys()
+	}
+	if !*config.Identity {
+		return SecureConn(conn, keys[0], initiator, keys[1:]...)
+	}
+	if keys[0] == "" {
+		keys = nil
+	}
+	return SecureIdentityConn(conn, localIdentity, keys, authorizedPeers, initiator)
+}
+
+// authorizedPeers 每次握手现读名单：--add-peer/--revoke-peer 改完即对新连接生效
+func authorizedPeers() ([]keyfile.Peer, error) {
+	return keyfile.LoadPeers(config.StartPath)
+}
+
+// SecureIdentityConn 在已建立的连接上执行 Noise XX（secrets 非空时 XXpsk0）握手，
+// 对端公钥须出现在 peers() 返回的名单里。返回的连接经 PeerOf 可取得对端身份。
+// secrets 首个为本端口令，其后是响应方额外接受的旧 key（轮换宽限期内），发起方只用首个
+func SecureIdentityConn(conn net.Conn, local noise.DHKey, secrets []string, peers func() ([]keyfile.Peer, error), initiator bool) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	newHandshake := func(psk []byte) (*noise.HandshakeState, error) {
+		cfg := noise.Config{
+			CipherSuite:   noiseCipherSuite,
+			Pattern:       noise.HandshakeXX,
+			Initiator:     initiator,
+			StaticKeypair: local,
+		}
+		if psk != nil {
+			cfg.PresharedKey = psk
+			cfg.PresharedKeyPlacement = 0
+		}
+		return noise.NewHandshakeState(cfg)
+	}
+	if len(secrets) == 0 {
+		secrets = []string{""}
+	}
+	// keyFP 握手所用口令的指纹（未用口令为空）
+	keyFP := func(secret string) string {
+		if secret == "" {
+			return ""
+		}
+		return keyfile.Fingerprint(secret)
+	}
+	var hs *noise.HandshakeState
+	// authorize 查名单，返回对端身份（带标签）
+	authorize := func() (keyfile.Peer, error) {
+		list, err := peers()
+		if err != nil {
+			return keyfile.Peer{}, err
+		}
+		pub := hs.PeerStatic()
+		p, ok := keyfile.FindPeer(list, pub)
+		if !ok {
+			return keyfile.Peer{}, fmt.Errorf("%w: %s (add it with --add-peer %s)", ErrPeerNotAuthorized, keyfile.PeerFingerprint(pub), keyfile.PublicKeyString(pub))
+		}
+		return p, nil
+	}
+
+	if initiator {
+		var psk []byte
+		var err error
+		if secrets[0] != "" {
+			if psk, err = initiatorPSK(conn, secrets[0]); err != nil {
+				return nil, err
+			}
+		}
+		if hs, err = newHandshake(psk); err != nil {
+			return nil, fmt.Errorf("failed to create noise handshake: %w", err)
+		}
+		// -> (psk,) e
+		msg, _, _, err := hs.WriteMessage(nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("noise handshake write: %w", err)
+		}
+		if err := writeFrame(conn, msg); err != nil {
+			return nil, fmt.Errorf("noise handshake send: %w", err)
+		}
+		// <- e, ee, s, es
+		reply, err := readFrame(conn, noiseMaxHandshakeFrame)
+		if err != nil {
+			return nil, fmt.Errorf("noise handshake recv: %w", err)
+		}
+		if _, _, _, err := hs.ReadMessage(nil, reply); err != nil {
+			return nil, fmt.Errorf("noise handshake failed (identity mode on both ends? do the passphrases match?): %w", err)
+		}
+		peer, err := authorize()
+		if err != nil {
+			return nil, err
+		}
+		// -> s, se
+		msg, cs0, cs1, err := hs.WriteMessage(nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("noise handshake write: %w", err)
+		}
+		if err := writeFrame(conn, msg); err != nil {
+			return nil, fmt.Errorf("noise handshake send: %w", err)
+		}
+		sc := &secureConn{Conn: conn, enc: cs0, dec: cs1, peer: &peer, keyFP: keyFP(secrets[0])}
+		var verdict [1]byte
+		if _, err := sc.Read(verdict[:]); err != nil {
+			return nil, fmt.Errorf("noise handshake recv: %w", err)
+		}
+		if verdict[0] != identityAccepted {
+			return nil, fmt.Errorf("%w: the peer does not list this instance (%s) in its authorized_peers", ErrPeerNotAuthorized, keyfile.PeerFingerprint(local.Public))
+		}
+		return sc.track(), nil
+	}
+
+	// <- (psk,) e
+	first, err := readFirstFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake recv: %w", err)
+	}
+	hs, used, err := readFirstMessage(first, secrets, newHandshake)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake failed (peer passphrase mismatch or identity mode off): %w", err)
+	}
+	// -> e, ee, s, es
+	msg, _, _, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake write: %w", err)
+	}
+	if err := writeFrame(conn, msg); err != nil {
+		return nil, fmt.Errorf("noise handshake send: %w", err)
+	}
+	// <- s, se
+	last, err := readFrame(conn, noiseMaxHandshakeFrame)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake recv: %w", err)
+	}
+	_, cs0, cs1, err := hs.ReadMessage(nil, last)
+	if err != nil {
+		return nil, fmt.Errorf("noise handshake failed: %w", err)
+	}
+	sc := &secureConn{Conn: conn, enc: cs1, dec: cs0, keyFP: keyFP(secrets[used])}
+	if used > 0 {
+		sc.handOver = secrets[0]
+	}
+	peer, authErr := authorize()
+	verdict := identityAccepted
+	if authErr != nil {
+		verdict = identityRejected
+	}
+	if _, err := sc.Write([]byte{verdict}); err != nil {
+		return nil, fmt.Errorf("noise handshake send: %w", err)
+	}
+	if authErr != nil {
+		return nil, authErr
+	}
+	sc.peer = &peer
+	return sc.track(), nil
+}
+
+// track 登记认证过身份的连接，Close 时注销
+func (s *secureConn) track() *secureConn {
+	identityConns.Store(s, struct{}{})
+	return s
+}
+
+func (s *secureConn) Close() error {
+	if s.peer != nil {
+		identityConns.Delete(s)
+	}
+	return s.Conn.Close()
+}
+
+// PeerOf 身份模式下连接握手认证出的对端身份；其他模式（含明文）为 nil
+func PeerOf(conn net.Conn) *keyfile.Peer {
+	if s, ok := conn.(*secureConn); ok {
+		return s.peer
+	}
+	return nil
+}
+
+// DropRevoked 重读授权名单，断开对端已不在名单里的连接。名单读不出时
+// 保持现状（半写的文件不应把所有人踢掉）
+func DropRevoked() {
+	peers, err := authorizedPeers()
+	if err != nil {
+		log.Errorf("authorized peers not reloaded: %v", err)
+		return
+	}
+	identityConns.Range(func(k, _ any) bool {
+		s := k.(*secureConn)
+		if _, ok := keyfile.FindPeer(peers, s.peer.Key); !ok {
+			log.Warnf("closing the session with %s at %s: it was removed from authorized_peers", s.peer, s.RemoteAddr())
+			s.Close()
+		}
+		return true
+	})
+}
@@ -0,0 +1,84 @@
+package network
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"local-mirror/internal/keyfile"
+
+	"github.com/flynn/noise"
+)
+
+// TestSecureIdentityConn 身份模式握手：双方互在名单里才通，且各自拿到对端身份；
+// 任一方不认对方时两边都失败，发起方能明确知道是被拒；口令不一致在 PSK 处失败
+func TestSecureIdentityConn(t *testing.T) {
+	keypair := func() noise.DHKey {
+		k, err := noiseCipherSuite.GenerateKeypair(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	dialer, listener := keypair(), keypair()
+	list := func(peers ...keyfile.Peer) func() ([]keyfile.Peer, error) {
+		return func() ([]keyfile.Peer, error) { return peers, nil }
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	handshake := func(dialerPeers, listenerPeers func() ([]keyfile.Peer, error), dialKeys, listenKeys []string) (result, result) {
+		a, b := net.Pipe()
+		done := make(chan result, 1)
+		go func() {
+			c, err := SecureIdentityConn(b, listener, listenKeys, listenerPeers, false)
+			if err != nil {
+				b.Close()
+			}
+			done <- result{c, err}
+		}()
+		c, err := SecureIdentityConn(a, dialer, dialKeys, dialerPeers, true)
+		if err != nil {
+			a.Close()
+		}
+		return result{c, err}, <-done
+	}
+	knowsListener := list(keyfile.Peer{Key: listener.Public, Label: "vps"})
+	knowsDialer := list(keyfile.Peer{Key: dialer.Public, Label: "laptop"})
+
+	d, l := handshake(knowsListener, knowsDialer, nil, nil)
+	if d.err != nil || l.err != nil {
+		t.Fatalf("mutually authorized handshake failed: dialer %v, listener %v", d.err, l.err)
+	}
+	if p := PeerOf(d.conn); p == nil || p.Label != "vps" {
+		t.Fatalf("dialer should see the listener as vps, got %v", p)
+	}
+	if p := PeerOf(l.conn); p == nil || p.Label != "laptop" {
+		t.Fatalf("listener should see the dialer as laptop, got %v", p)
+	}
+	go d.conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(l.conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("data after handshake: %q %v", buf, err)
+	}
+	d.conn.Close()
+	l.conn.Close()
+
+	d, l = handshake(knowsListener, list(), nil, nil)
+	if !errors.Is(l.err, ErrPeerNotAuthorized) || !errors.Is(d.err, ErrPeerNotAuthorized) {
+		t.Fatalf("listener without the dialer's key: dialer %v, listener %v", d.err, l.err)
+	}
+
+	d, _ = handshake(list(), knowsDialer, nil, nil)
+	if !errors.Is(d.err, ErrPeerNotAuthorized) {
+		t.Fatalf("dialer should refuse an unknown listener: %v", d.err)
+	}
+
+	d, l = handshake(knowsListener, knowsDialer, []string{"a"}, []string{"b"})
+	if d.err == nil || l.err == nil || errors.Is(l.err, ErrPeerNotAuthorized) {
+		t.Fatalf("passphrase mismatch should fail before identities: dialer %v, listener %v", d.err, l.err)
+	}
+}
@@ -0,0 +1,164 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+
+	"local-mirror/internal/keyfile"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// 口令拉伸：-k 接受任意字符串，而 DerivePSK 只是一次 BLAKE3——截获一次握手就能
+// 离线高速猜弱口令。--gen-key 生成的 256 bit key（keyfile.IsGenerated）无从猜起，
+// 照旧走快速路径、线格式不变；其余口令改用 Argon2id 派生 PSK。
+//
+// 参数与盐在 Noise 握手之前的一轮明文交换里取得：发起方先发 kdfRequest，响应方回
+// kdfParams，双方据此派生，之后才是 Noise 第一条消息。响应方看第一帧是不是
+// kdfRequest 来区分，快速路径的发起方不发它、旧版发起方也照常握手（但旧版用弱口令
+// 时 PSK 对不上，须两端都升级）。盐每个监听进程随机一份，不落盘：派生结果两端按
+// (口令, 参数) 缓存，同一进程的重连与并行连接只算一次，未认证的连接也逼不了监听端
+// 反复做内存密集计算。能力位在 Noise 会话之内协商，来不及决定 PSK，故不用它。
+//
+// 这轮交换未经认证：假冒的监听端（或中间人）可以下发任意参数与盐，再拿发起方的
+// 第一条 Noise 消息离线猜口令。所以发起方只接受不弱于 localKDF 的参数
+// （kdfMinTime/kdfMinMemory），猜一次的代价与真监听端下发的相同
+
+// kdfRequest 发起方请求拉伸参数的帧，长度与任何 Noise 第一条消息都不同
+var kdfRequest = []byte("local-mirror-kdf-v1")
+
+const (
+	kdfArgon2id byte = 1
+	kdfSaltLen       = 16
+	// 发起方接受的参数下限，即 localKDF 下发的值：低于它等于替攻击者省掉拉伸
+	kdfMinTime   = 3
+	kdfMinMemory = 64 * 1024 // KiB
+	// 发起方接受的参数上限：恶意监听端不能借此让拨号端耗尽内存或算上几分钟
+	kdfMaxTime   = 16
+	kdfMaxMemory = 256 * 1024 // KiB
+)
+
+// kdfParams 一组 Argon2id 参数（RFC 9106 推荐的第二档：t=3、64 MiB）
+type kdfParams struct {
+	Time    uint32 // 迭代次数
+	Memory  uint32 // 内存（KiB）
+	Threads uint8
+	Salt    [kdfSaltLen]byte
+}
+
+// localKDF 本进程作为响应方下发的参数，首次用到时生成盐
+var localKDF = sync.OnceValue(func() kdfParams {
+	p := kdfParams{Time: kdfMinTime, Memory: kdfMinMemory, Threads: uint8(min(runtime.NumCPU(), 4))}
+	if _, err := rand.Read(p.Salt[:]); err != nil {
+		panic(fmt.Sprintf("failed to gather randomness for the KDF salt: %v", err))
+	}
+	return p
+})
+
+// stretched 已派生的 PSK，按 (口令, 参数) 缓存。派生在锁内进行：同时到来的
+// 多条连接只算一次，其余等结果
+var stretched struct {
+	mu   sync.Mutex
+	psks map[stretchKey][]byte
+}
+
+type stretchKey struct {
+	secret string
+	params kdfParams
+}
+
+// StretchPSK 用 Argon2id 从人选口令派生 32 字节 PSK。域分离前缀与 DerivePSK 同理
+func StretchPSK(secret string, p kdfParams) []byte {
+	stretched.mu.Lock()
+	defer stretched.mu.Unlock()
+	k := stretchKey{secret, p}
+	if psk, ok := stretched.psks[k]; ok {
+		return psk
+	}
+	psk := argon2.IDKey([]byte("local-mirror-noise-psk-v2:"+secret), p.Salt[:], p.Time, p.Memory, p.Threads, 32)
+	if stretched.psks == nil {
+		stretched.psks = make(map[stretchKey][]byte)
+	}
+	stretched.psks[k] = psk
+	return psk
+}
+
+func encodeKDF(p kdfParams) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(kdfArgon2id)
+	_ = binary.Write(buf, binary.BigEndian, p.Time)
+	_ = binary.Write(buf, binary.BigEndian, p.Memory)
+	buf.WriteByte(p.Threads)
+	buf.Write(p.Salt[:])
+	return buf.Bytes()
+}
+
+// decodeKDF 解析并校验响应方下发的参数
+func decodeKDF(data []byte) (kdfParams, error) {
+	var p kdfParams
+	if len(data) != 1+4+4+1+kdfSaltLen {
+		return p, fmt.Errorf("malformed KDF parameters (%d bytes)", len(data))
+	}
+	if data[0] != kdfArgon2id {
+		return p, fmt.Errorf("unsupported KDF %d", data[0])
+	}
+	p.Time = binary.BigEndian.Uint32(data[1:5])
+	p.Memory = binary.BigEndian.Uint32(data[5:9])
+	p.Threads = data[9]
+	copy(p.Salt[:], data[10:])
+	if p.Time < kdfMinTime || p.Memory < kdfMinMemory {
+		return p, fmt.Errorf("KDF parameters weaker than t=%d m=%dKiB (got t=%d m=%dKiB): refusing, the peer may be an impostor collecting handshakes to guess the passphrase",
+			kdfMinTime, kdfMinMemory, p.Time, p.Memory)
+	}
+	if p.Time > kdfMaxTime || p.Memory > kdfMaxMemory || p.Threads < 1 {
+		return p, fmt.Errorf("KDF parameters out of bounds: t=%d m=%dKiB p=%d", p.Time, p.Memory, p.Threads)
+	}
+	return p, nil
+}
+
+// initiatorPSK 发起方的 PSK：生成的 key 直接派生；人选口令先向响应方要参数再拉伸
+func initiatorPSK(conn net.Conn, secret string) ([]byte, error) {
+	if keyfile.IsGenerated(secret) {
+		return DerivePSK(secret), nil
+	}
+	if err := writeFrame(conn, kdfRequest); err != nil {
+		return nil, fmt.Errorf("noise handshake send: %w", err)
+	}
+	frame, err := readFrame(conn, noiseMaxHandshakeFrame)
+	if err != nil {
+		return nil, fmt.Errorf("fetching passphrase stretching parameters (peer older than this version, or encryption disabled there?): %w", err)
+	}
+	p, err := decodeKDF(frame)
+	if err != nil {
+		return nil, err
+	}
+	return StretchPSK(secret, p), nil
+}
+
+// responderPSK 响应方对某把 key 的 PSK
+func responderPSK(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	if keyfile.IsGenerated(key) {
+		return DerivePSK(key)
+	}
+	return StretchPSK(key, localKDF())
+}
+
+// readFirstFrame 响应方读 Noise 第一条消息；对端先要拉伸参数时回给它再读
+func readFirstFrame(conn net.Conn) ([]byte, error) {
+	first, err := readFrame(conn, noiseMaxHandshakeFrame)
+	if err != nil || !bytes.Equal(first, kdfRequest) {
+		return first, err
+	}
+	if err := writeFrame(conn, encodeKDF(localKDF())); err != nil {
+		return nil, err
+	}
+	return readFrame(conn, noiseMaxHandshakeFrame)
+}
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"local-mirror/internal/keyfile"
+)
+
+// TestStretchedHandshake 人选口令先取参数再拉伸，两端一致即通、不一致即败；
+// 生成的 key 不拉伸，PSK 与从前相同（线格式不变）
+func TestStretchedHandshake(t *testing.T) {
+	handshake := func(dialKey, listenKey string) (error, error) {
+		a, b := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			_, err := SecureConn(b, listenKey, false)
+			b.Close()
+			done <- err
+		}()
+		_, err := SecureConn(a, dialKey, true)
+		a.Close()
+		return err, <-done
+	}
+	if derr, lerr := handshake("correct horse", "correct horse"); derr != nil || lerr != nil {
+		t.Fatalf("same passphrase: dialer %v, listener %v", derr, lerr)
+	}
+	if derr, lerr := handshake("correct horse", "battery staple"); derr == nil || lerr == nil {
+		t.Fatal("different passphrases must fail")
+	}
+
+	generated := "5EQ/W0maDo6A/2qdxJR+aD5sKbMxZY1+aoLTHf+m10s="
+	if !keyfile.IsGenerated(generated) || keyfile.IsGenerated("correct horse") {
+		t.Fatal("IsGenerated misclassifies keys")
+	}
+	if !bytes.Equal(responderPSK(generated), DerivePSK(generated)) {
+		t.Fatal("a generated key should keep the fast BLAKE3 path")
+	}
+	if derr, lerr := handshake(generated, generated); derr != nil || lerr != nil {
+		t.Fatalf("generated key: dialer %v, listener %v", derr, lerr)
+	}
+}
+
+// TestDecodeKDFBounds 发起方拒绝越界参数：恶意监听端不能借此耗尽拨号端的内存，
+// 也不能下发弱于本地默认的参数、把截获的握手变成可高速离线猜的靶子
+func TestDecodeKDFBounds(t *testing.T) {
+	p := localKDF()
+	got, err := decodeKDF(encodeKDF(p))
+	if err != nil || got != p {
+		t.Fatalf("round trip: %+v %v", got, err)
+	}
+	for _, bad := range []kdfParams{
+		{Time: 3, Memory: kdfMaxMemory + 1, Threads: 1},
+		{Time: kdfMaxTime + 1, Memory: 64 * 1024, Threads: 1},
+		{Time: 0, Memory: 64 * 1024, Threads: 1},
+		{Time: 3, Memory: 64 * 1024, Threads: 0},
+		{Time: 1, Memory: 8, Threads: 1},
+		{Time: kdfMinTime - 1, Memory: kdfMinMemory, Threads: 4},
+		{Time: kdfMinTime, Memory: kdfMinMemory - 1, Threads: 4},
+	} {
+		if _, err := decodeKDF(encodeKDF(bad)); err == nil {
+			t.Errorf("parameters %+v should be rejected", bad)
+		}
+	}
+}
+
+// TestWeakKDFRefused 假冒的监听端下发弱参数：拨号端拒绝，且不发出第一条 Noise 消息
+func TestWeakKDFRefused(t *testing.T) {
+	a, b := net.Pipe()
+	leaked := make(chan bool, 1)
+	go func() {
+		defer b.Close()
+		if frame, err := readFrame(b, noiseMaxHandshakeFrame); err != nil || !bytes.Equal(frame, kdfRequest) {
+			leaked <- false
+			return
+		}
+		weak := kdfParams{Time: 1, Memory: 8, Threads: 1}
+		if err := writeFrame(b, encodeKDF(weak)); err != nil {
+			leaked <- false
+			return
+		}
+		_, err := readFrame(b, noiseMaxHandshakeFrame)
+		leaked <- err == nil
+	}()
+	_, err := SecureConn(a, "correct horse", true)
+	a.Close()
+	if err == nil {
+		t.Fatal("weak KDF parameters must fail the handshake")
+	}
+	if <-leaked {
+		t.Fatal("the dialer sent a Noise message stretched with weak parameters")
+	}
+}
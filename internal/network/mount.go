@@ -0,0 +1,34 @@
+package network
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// remotePath 把本地同步路径换算成对端树里的路径：去掉 Mount 前缀，
+// Mount 自身对应对端根 "."。Mount 为空时原样返回
+func (c *FileClient) remotePath(local string) string {
+	if c.Mount == "" {
+		return local
+	}
+	if local == c.Mount {
+		return "."
+	}
+	if rest, ok := strings.CutPrefix(local, c.Mount+string(filepath.Separator)); ok {
+		return rest
+	}
+	// 不在 Mount 之下的路径不属于这路上游，原样发出由对端按不存在处理
+	return local
+}
+
+// localPath 把对端树里的路径换算成本地同步路径：挂到 Mount 之下。
+// 对端路径属不可信输入，换算只做拼接，越界校验仍由落盘前的 safety 负责
+func (c *FileClient) localPath(remote string) string {
+	if c.Mount == "" {
+		return remote
+	}
+	if remote == "." || remote == "" {
+		return c.Mount
+	}
+	return c.Mount + string(filepath.Separator) + remote
+}
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestMountPathMapping 多上游汇：本地路径与对端路径在 Mount 前缀上互相换算，
+// Mount 为空时两者恒等
+func TestMountPathMapping(t *testing.T) {
+	c := &FileClient{Mount: "a"}
+	cases := []struct{ local, remote string }{
+		{"a", "."},
+		{filepath.Join("a", "x.txt"), "x.txt"},
+		{filepath.Join("a", "sub", "y"), filepath.Join("sub", "y")},
+	}
+	for _, tc := range cases {
+		if got := c.remotePath(tc.local); got != tc.remote {
+			t.Errorf("remotePath(%q) = %q, want %q", tc.local, got, tc.remote)
+		}
+		if got := c.localPath(tc.remote); got != tc.local {
+			t.Errorf("localPath(%q) = %q, want %q", tc.remote, got, tc.local)
+		}
+	}
+	// 前缀只按整段匹配：ab 不属于 a
+	if got := c.remotePath("ab"); got != "ab" {
+		t.Errorf("remotePath(ab) = %q, want it untouched", got)
+	}
+
+	plain := &FileClient{}
+	if plain.remotePath("x") != "x" || plain.localPath(".") != "." {
+		t.Error("Mount 为空时路径应原样往返")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"local-mirror/internal/status"
 	"net"
 	"path/filepath"
 	"time"
@@ -18,8 +19,9 @@ import (
 // FeatureBits 能力位，会话版本取两区间交集的最高值，交集为空则拒绝
 // （服务端拒绝前回一条 ErrCodeVersionMismatch 错误，让对端日志里有人话）。
 // 当前两端区间均为 [3,3]，行为与严格相等一致；该结构的意义在于未来版本
-// 可以引入真正的跨版本协商而无需再次 flag-day。FeatureBits 当前恒为 0，
-// 非零位留给未来能力声明（压缩、增量传输、PSK 拉伸参数等）。
+// 可以引入真正的跨版本协商而无需再次 flag-day。FeatureBits 声明本端支持的
+// 可选能力（见 Feature* 常量），会话能力取两端位的交集：任一端不认识的位
+// 自然落空，旧端恒发 0 即全部关闭。新能力只能在协商为真时使用。
 //
 // 同版本演进（正式机制）：解码器只读取已知字段、静默忽略消息体尾部的
 // 多余字节。因此**在消息体尾部追加新字段是同版本内的兼容演进方式**：
@@ -35,6 +37,9 @@ import (
 //
 // 交互模型：严格同步请求-响应，单连接单飞行请求（客户端串行化一切）。
 // 该不变量是冻结面的一部分：协议没有请求 ID，无法在一条连接上并发。
+// 并行下载（--parallel）靠连接池实现：客户端对同一服务端另开若干条独立
+// 握手的连接（FileClient.Workers），每条连接上仍是单飞行。服务端按连接
+// 而非 InstanceID 定位会话，同一实例的多条连接互不干扰
 // ===================================================================
 
 // 协议常量定义
@@ -55,6 +60,8 @@ const (
 	MsgTypeTreeResponse         uint16 = 0x0009 // 目录树响应
 	MsgTypeRecentChangeRequest  uint16 = 0x000C // 最近变更请求
 	MsgTypeRecentChangeResponse uint16 = 0x000D // 最近变更响应
+	MsgTypeDeltaRequest         uint16 = 0x0010 // 增量传输请求（需协商 FeatureDelta）
+	MsgTypeDeltaData            uint16 = 0x0011 // 增量传输指令流
 
 	// 头部大小
 	HeaderSize = 12 // 消息头部大小（魔术字4字节 + 类型2字节 + 长度4字节 + 保留字段2字节）
@@ -77,6 +84,21 @@ const (
 	ErrCodeDirectionConflict uint16 = 6 // 数据方向不互补（两端同为 send 或同为 receive，配置错误）
 )
 
+// 能力位（HandshakeMessage.FeatureBits）。与消息类型同理，位一经分配不复用
+const (
+	FeatureDelta    uint64 = 1 << 0 // 块级增量传输：修改过的大文件只传差异块
+	FeatureCompress uint64 = 1 << 1 // 逐消息 deflate 压缩（消息头标志位，见 compress.go）
+	FeatureMetadata uint64 = 1 << 2 // 目录页携带元数据（权限/属主/扩展属性）与符号链接节点
+	FeatureInclude  uint64 = 1 << 3 // 变更请求携带订阅范围（--include），服务端只报范围内的变更目录
+	FeatureJournal  uint64 = 1 << 4 // 变更查询按持久化日志的 (纪元, 序号) 游标，汇端重连后增量追赶而不是全量对账
+	FeatureDigest   uint64 = 1 << 5 // 目录页携带子树摘要（见 tree/digest.go），全量对账跳过两端一致的子树
+	FeatureRekey    uint64 = 1 << 6 // 认得握手消息尾部的 NewKey：用轮换前旧 key 连进来的一端据此换上新 key（见 rotate.go）
+)
+
+// localFeatureBits 本端支持的全部能力位，握手时原样申报。FeatureMetadata 只在
+// 支持 POSIX 元数据的平台上申报（见 fsmeta.Supported）
+const localFeatureBits = FeatureDelta | FeatureCompress | localMetadataFeature | FeatureInclude | FeatureJournal | FeatureDigest | FeatureRekey
+
 // negotiateVersion 计算会话版本：两端 [min, ver] 区间交集的最高值。
 // ok=false 表示交集为空（版本不兼容）
 func negotiateVersion(localVer, localMin, peerVer, peerMin uint16) (uint16, bool) {
@@ -89,7 +111,7 @@ type MessageHeader struct {
 	Magic        uint32 // 魔术字
 	Type         uint16 // 消息类型
 	BodyLength   uint32 // 消息体长度
-	ReservedWord uint16 // 保留字段
+	ReservedWord uint16 // 标志位：bit0 = 消息体经 deflate 压缩（headerFlagDeflate），其余位恒 0
 }
 
 // 握手消息（v3：区间协商 + 能力位）
@@ -98,7 +120,8 @@ type HandshakeMessage struct {
 	MinVersion  uint16 // 支持的最低协议版本
 	UUID        uint32 // 实例标识
 	Role        uint8  // 角色
-	FeatureBits uint64 // 能力位（当前恒 0，非零位留给未来能力协商）
+	FeatureBits uint64 // 能力位（本端支持的 Feature*，会话取交集）
+	NewKey      string // 轮换后的新 key（尾部追加，仅当对端用旧 key 握手时写；空 = 无）
 }
 
 // 文件请求消息
@@ -128,6 +151,30 @@ type FileCompleteMessage struct {
 	FileHash  [32]byte // 文件哈希值
 }
 
+// DeltaRequestMessage 增量传输请求：客户端携带本地旧版本（basis）的逐块签名，
+// 服务端据此只下发与旧版本不同的字节。应答序列为 FileResponse →
+// 若干 DeltaData → FileComplete，与普通下载共用会话与完整性校验
+type DeltaRequestMessage struct {
+	FilePath   string           // 文件路径
+	BlockSize  uint32           // 签名分块大小（最后一块可能更短）
+	BasisSize  uint64           // basis 文件总大小，用于确定最后一块的长度
+	Signatures []BlockSignature // 逐块签名，按块序
+}
+
+// BlockSignature 单块签名：弱校验（滚动和）做快速筛选，强校验（截断的
+// BLAKE3）确认命中。强校验截断到 16 字节足以排除误配，且签名体积减半
+type BlockSignature struct {
+	Weak   uint32
+	Strong [16]byte
+}
+
+// DeltaDataMessage 一批增量指令（见 deltaOp）。指令按重建顺序排列，
+// 客户端顺序执行即得到服务端文件
+type DeltaDataMessage struct {
+	SessionID [16]byte
+	Ops       []deltaOp
+}
+
 // ErrorMessage 结构化错误（v3）：错误码 + 关联路径 + 人读消息。
 // Path 走线格式路径约定（"/" 分隔），无关联路径时为空
 type ErrorMessage struct {
@@ -179,21 +226,30 @@ type TreeResponseMessage struct {
 	ContinueFrom string // 非空 = 有下一页，值为本页最后条目路径
 	DataLength   uint32 // 数据长度
 	Data         []byte // 本页条目的 JSON（[]tree.Node）
+	Digest       string // 所请求目录自身的子树摘要（尾部追加，协商 FeatureDigest 后才写；空 = 未知）
 }
 
 // 最近变更请求消息。查询区间上界由服务端时钟决定，请求不携带
 // （v3 删除了从未被消费的 endTime 字段）
 type RecentChangeRequestMessage struct {
-	ClientID  uint32 // 客户端标识
-	StartTime int64  // 开始时间（秒，服务端时钟系；0=全查窗口）
+	ClientID  uint32   // 客户端标识
+	StartTime int64    // 开始时间（秒，服务端时钟系；0=全查窗口）
+	Include   []string // 订阅范围（尾部追加，协商 FeatureInclude 后才写；空 = 不限）
+	// 日志游标（尾部追加，协商 FeatureJournal 后才写，此时 Include 即使为空也写条数 0
+	// 占位）。Journal 为真时服务端按游标而非 StartTime 查询；Epoch 为 0 表示只取日志末尾
+	Journal bool
+	Epoch   uint64 // 游标所在的日志纪元
+	Since   uint64 // 游标：该序号及之前的条目已应用
 }
 
 // 最近变更响应消息
 type RecentChangeResponseMessage struct {
 	ServerID     uint32   // 服务端标识
 	CoveredUntil int64    // 本次响应已覆盖到的服务端时刻（秒），客户端据此推进游标
-	FullResync   bool     // 变更数超过阈值：列表省略，客户端应全量对账后把游标推进到 CoveredUntil
+	FullResync   bool     // 变更数超过阈值或日志游标接不上：列表省略，客户端应全量对账后把游标推进到 CoveredUntil
 	Changes      []string // 最近变更的目录列表（FullResync 时为空）
+	Epoch        uint64   // 日志游标（尾部追加，仅应答日志查询时写，0 = 无）：本次已覆盖到的纪元
+	Seq          uint64   // 与序号，客户端据此推进日志游标
 }
 
 // encode 系列函数写入 bytes.Buffer，其 Write 方法在内存不足时 panic 而非返回 error，
@@ -235,6 +291,10 @@ func encodeHandshake(msg HandshakeMessage) []byte {
 	_ = binary.Write(buf, binary.BigEndian, msg.UUID)
 	_ = binary.Write(buf, binary.BigEndian, msg.Role)
 	_ = binary.Write(buf, binary.BigEndian, msg.FeatureBits)
+	if msg.NewKey != "" {
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(msg.NewKey)))
+		buf.WriteString(msg.NewKey)
+	}
 	return buf.Bytes()
 }
 
@@ -256,6 +316,17 @@ func decodeHandshake(data []byte) (HandshakeMessage, error) {
 	if err := binary.Read(buf, binary.BigEndian, &msg.FeatureBits); err != nil {
 		return msg, err
 	}
+	if buf.Len() > 0 {
+		var n uint16
+		if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+			return msg, fmt.Errorf("error decoding handshake new key length: %w", err)
+		}
+		key := make([]byte, n)
+		if _, err := io.ReadFull(buf, key); err != nil {
+			return msg, fmt.Errorf("error decoding handshake new key: %w", err)
+		}
+		msg.NewKey = string(key)
+	}
 	return msg, nil
 }
 
@@ -387,6 +458,120 @@ func decodeFileComplete(data []byte) (FileCompleteMessage, error) {
 	return msg, nil
 }
 
+func encodeDeltaRequest(msg DeltaRequestMessage) []byte {
+	buf := new(bytes.Buffer)
+	writeWirePath(buf, msg.FilePath)
+	_ = binary.Write(buf, binary.BigEndian, msg.BlockSize)
+	_ = binary.Write(buf, binary.BigEndian, msg.BasisSize)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(msg.Signatures)))
+	for _, sig := range msg.Signatures {
+		_ = binary.Write(buf, binary.BigEndian, sig.Weak)
+		buf.Write(sig.Strong[:])
+	}
+	return buf.Bytes()
+}
+
+func decodeDeltaRequest(data []byte) (DeltaRequestMessage, error) {
+	var msg DeltaRequestMessage
+	buf := bytes.NewReader(data)
+
+	p, err := readWirePath(buf)
+	if err != nil {
+		return msg, fmt.Errorf("error decoding delta request path: %w", err)
+	}
+	msg.FilePath = p
+	if err := binary.Read(buf, binary.BigEndian, &msg.BlockSize); err != nil {
+		return msg, fmt.Errorf("error decoding delta block size: %w", err)
+	}
+	if err := binary.Read(buf, binary.BigEndian, &msg.BasisSize); err != nil {
+		return msg, fmt.Errorf("error decoding delta basis size: %w", err)
+	}
+	var count uint32
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return msg, fmt.Errorf("error decoding delta signature count: %w", err)
+	}
+	// 边界校验：每条签名固定 20 字节，声明条数超过剩余字节即伪造，先拦再 make
+	if int64(count)*blockSignatureSize > int64(buf.Len()) {
+		return msg, fmt.Errorf("delta signature count %d exceeds remaining %d bytes", count, buf.Len())
+	}
+	msg.Signatures = make([]BlockSignature, count)
+	for i := range msg.Signatures {
+		if err := binary.Read(buf, binary.BigEndian, &msg.Signatures[i].Weak); err != nil {
+			return msg, fmt.Errorf("error decoding delta weak checksum: %w", err)
+		}
+		if _, err := io.ReadFull(buf, msg.Signatures[i].Strong[:]); err != nil {
+			return msg, fmt.Errorf("error decoding delta strong checksum: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+func encodeDeltaData(msg DeltaDataMessage) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(msg.SessionID[:])
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(msg.Ops)))
+	for _, op := range msg.Ops {
+		_ = binary.Write(buf, binary.BigEndian, op.Kind)
+		switch op.Kind {
+		case deltaOpCopy:
+			_ = binary.Write(buf, binary.BigEndian, op.Block)
+			_ = binary.Write(buf, binary.BigEndian, op.Count)
+		case deltaOpLiteral:
+			_ = binary.Write(buf, binary.BigEndian, uint32(len(op.Data)))
+			buf.Write(op.Data)
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeDeltaData(data []byte) (DeltaDataMessage, error) {
+	var msg DeltaDataMessage
+	buf := bytes.NewReader(data)
+
+	if _, err := io.ReadFull(buf, msg.SessionID[:]); err != nil {
+		return msg, fmt.Errorf("error reading delta data session ID: %w", err)
+	}
+	var count uint32
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return msg, fmt.Errorf("error decoding delta op count: %w", err)
+	}
+	// 每条指令至少 5 字节（类型 + 长度），据此拦截伪造的天量条数
+	if int64(count)*5 > int64(buf.Len()) {
+		return msg, fmt.Errorf("delta op count %d exceeds plausible max for %d remaining bytes", count, buf.Len())
+	}
+	msg.Ops = make([]deltaOp, count)
+	for i := range msg.Ops {
+		op := &msg.Ops[i]
+		if err := binary.Read(buf, binary.BigEndian, &op.Kind); err != nil {
+			return msg, fmt.Errorf("error decoding delta op kind: %w", err)
+		}
+		switch op.Kind {
+		case deltaOpCopy:
+			if err := binary.Read(buf, binary.BigEndian, &op.Block); err != nil {
+				return msg, fmt.Errorf("error decoding delta copy block: %w", err)
+			}
+			if err := binary.Read(buf, binary.BigEndian, &op.Count); err != nil {
+				return msg, fmt.Errorf("error decoding delta copy count: %w", err)
+			}
+		case deltaOpLiteral:
+			var n uint32
+			if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+				return msg, fmt.Errorf("error decoding delta literal length: %w", err)
+			}
+			if int64(n) > int64(buf.Len()) {
+				return msg, fmt.Errorf("delta literal length %d exceeds remaining %d bytes", n, buf.Len())
+			}
+			op.Data = make([]byte, n)
+			if _, err := io.ReadFull(buf, op.Data); err != nil {
+				return msg, fmt.Errorf("error reading delta literal: %w", err)
+			}
+		default:
+			return msg, fmt.Errorf("unknown delta op kind %d", op.Kind)
+		}
+	}
+	return msg, nil
+}
+
 func encodeErrorMessage(msg ErrorMessage) []byte {
 	buf := new(bytes.Buffer)
 	_ = binary.Write(buf, binary.BigEndian, msg.Code)
@@ -431,11 +616,17 @@ func decodeErrorMessage(data []byte) (ErrorMessage, error) {
 const sendMessageWriteTimeout = 60 * time.Second
 
 func sendMessage(conn net.Conn, msgType uint16, body []byte) error {
+	return writeMessage(conn, msgType, body, 0, len(body))
+}
+
+// writeMessage 写出一条消息。flags 进消息头 ReservedWord；rawLen 是压缩前的
+// 消息体长度，仅用于线上压缩率统计（未压缩时等于 len(body)）
+func writeMessage(conn net.Conn, msgType uint16, body []byte, flags uint16, rawLen int) error {
 	header := MessageHeader{
 		Magic:        MagicNumber,
 		Type:         msgType,
 		BodyLength:   uint32(len(body)),
-		ReservedWord: 0,
+		ReservedWord: flags,
 	}
 
 	// 头与体拼成一个缓冲一次写出：加密层下小消息从此只付一个 Noise 帧
@@ -450,7 +641,8 @@ func sendMessage(conn net.Conn, msgType uint16, body []byte) error {
 	if _, err := conn.Write(packet); err != nil {
 		return err
 	}
-	log.Debugf("Sent message with type: %d, body length: %d bytes", msgType, len(body))
+	status.RecordWire(uint64(rawLen), uint64(len(body)))
+	log.Debugf("Sent message with type: %d, body length: %d bytes (raw %d)", msgType, len(body), rawLen)
 	return nil
 }
 
@@ -480,6 +672,13 @@ func receiveMessage(conn net.Conn) (uint16, []byte, error) {
 			return 0, nil, fmt.Errorf("error reading message body from %s: %w", conn.RemoteAddr().String(), err)
 		}
 	}
+	wireLen := len(bodyBytes)
+	if header.ReservedWord&headerFlagDeflate != 0 {
+		if bodyBytes, err = inflateBody(bodyBytes); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", conn.RemoteAddr().String(), err)
+		}
+	}
+	status.RecordWire(uint64(len(bodyBytes)), uint64(wireLen))
 
 	return header.Type, bodyBytes, nil
 }
@@ -515,6 +714,10 @@ func encodeTreeResponse(msg TreeResponseMessage) []byte {
 	writeWirePath(buf, msg.ContinueFrom)
 	_ = binary.Write(buf, binary.BigEndian, msg.DataLength)
 	buf.Write(msg.Data)
+	if msg.Digest != "" {
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(msg.Digest)))
+		buf.WriteString(msg.Digest)
+	}
 	return buf.Bytes()
 }
 
@@ -542,6 +745,17 @@ func decodeTreeResponse(data []byte) (TreeResponseMessage, error) {
 		log.Error("Error reading tree response data:", err)
 		return msg, err
 	}
+	if buf.Len() > 0 {
+		var n uint16
+		if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+			return msg, fmt.Errorf("error decoding tree response digest length: %w", err)
+		}
+		digest := make([]byte, n)
+		if _, err := io.ReadFull(buf, digest); err != nil {
+			return msg, fmt.Errorf("error decoding tree response digest: %w", err)
+		}
+		msg.Digest = string(digest)
+	}
 
 	return msg, nil
 }
@@ -550,6 +764,18 @@ func encodeRecentChangeRequest(msg RecentChangeRequestMessage) []byte {
 	buf := new(bytes.Buffer)
 	_ = binary.Write(buf, binary.BigEndian, msg.ClientID)
 	_ = binary.Write(buf, binary.BigEndian, msg.StartTime)
+	if len(msg.Include) > 0 || msg.Journal {
+		_ = binary.Write(buf, binary.BigEndian, uint16(len(msg.Include)))
+		for _, p := range msg.Include {
+			pb := []byte(p) // 订阅模式本就以 / 分隔（config.ParseIncludes）
+			_ = binary.Write(buf, binary.BigEndian, uint16(len(pb)))
+			buf.Write(pb)
+		}
+	}
+	if msg.Journal {
+		_ = binary.Write(buf, binary.BigEndian, msg.Epoch)
+		_ = binary.Write(buf, binary.BigEndian, msg.Since)
+	}
 	return buf.Bytes()
 }
 
@@ -565,6 +791,42 @@ func decodeRecentChangeRequest(data []byte) (RecentChangeRequestMessage, error)
 		log.Error("Error decoding recent change request startTime:", err)
 		return msg, err
 	}
+	// 订阅范围是尾部追加字段：没有即不限
+	if buf.Len() == 0 {
+		return msg, nil
+	}
+	var count uint16
+	if err := binary.Read(buf, binary.BigEndian, &count); err != nil {
+		return msg, err
+	}
+	if int(count) > buf.Len()/2 {
+		return msg, fmt.Errorf("include count %d exceeds plausible max for %d remaining bytes", count, buf.Len())
+	}
+	if count > 0 {
+		msg.Include = make([]string, count)
+	}
+	for i := range msg.Include {
+		var n uint16
+		if err := binary.Read(buf, binary.BigEndian, &n); err != nil {
+			return msg, err
+		}
+		pb := make([]byte, n)
+		if _, err := io.ReadFull(buf, pb); err != nil {
+			return msg, err
+		}
+		msg.Include[i] = string(pb)
+	}
+	// 日志游标同样是尾部追加字段：没有即按时间游标查询
+	if buf.Len() == 0 {
+		return msg, nil
+	}
+	if err := binary.Read(buf, binary.BigEndian, &msg.Epoch); err != nil {
+		return msg, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &msg.Since); err != nil {
+		return msg, err
+	}
+	msg.Journal = true
 	return msg, nil
 }
 
@@ -585,6 +847,10 @@ func encodeRecentChangeResponse(msg RecentChangeResponseMessage) []byte {
 		_ = binary.Write(buf, binary.BigEndian, uint16(len(changeBytes)))
 		buf.Write(changeBytes)
 	}
+	if msg.Epoch != 0 {
+		_ = binary.Write(buf, binary.BigEndian, msg.Epoch)
+		_ = binary.Write(buf, binary.BigEndian, msg.Seq)
+	}
 
 	return buf.Bytes()
 }
@@ -633,6 +899,16 @@ func decodeRecentChangeResponse(data []byte) (RecentChangeResponseMessage, error
 		}
 		msg.Changes[i] = filepath.FromSlash(string(changeBytes))
 	}
+	// 日志游标是尾部追加字段，旧版服务端不写
+	if buf.Len() == 0 {
+		return msg, nil
+	}
+	if err := binary.Read(buf, binary.BigEndian, &msg.Epoch); err != nil {
+		return msg, err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &msg.Seq); err != nil {
+		return msg, err
+	}
 
 	return msg, nil
 }
@@ -3,6 +3,8 @@ package network
 import (
 	"fmt"
 	"local-mirror/internal/tree"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -65,15 +67,19 @@ func TestHandshakeRejectsShortBody(t *testing.T) {
 // TestTrailingAppendTolerated 同版本演进机制（见 protocol.go 约定）：
 // 消息体尾部追加的未知字段必须被现有解码器静默忽略
 func TestTrailingAppendTolerated(t *testing.T) {
-	future := append(encodeHandshake(HandshakeMessage{Version: 3, MinVersion: 3, UUID: 7, Role: 1}),
-		0xFF, 0xEE, 0xDD) // 假想的未来新字段
+	// 握手的 NewKey 本身是尾部追加字段，假想的未来新字段排在它之后
+	future := append(encodeHandshake(HandshakeMessage{Version: 3, MinVersion: 3, UUID: 7, Role: 1, NewKey: "k"}),
+		0xFF, 0xEE, 0xDD)
 	got, err := decodeHandshake(future)
 	if err != nil {
 		t.Fatalf("尾部追加字段导致解码失败（违反演进约定）: %v", err)
 	}
-	if got.UUID != 7 {
+	if got.UUID != 7 || got.NewKey != "k" {
 		t.Errorf("已知字段被尾部数据污染: %+v", got)
 	}
+	if got, err := decodeHandshake(encodeHandshake(HandshakeMessage{Version: 3, MinVersion: 3, UUID: 7})); err != nil || got.NewKey != "" {
+		t.Errorf("不带 NewKey 的握手应照常解码: %+v %v", got, err)
+	}
 
 	futureReq := append(encodeFileRequest(FileRequestMessage{FilePath: "a/b", Offset: 9}), 0x01, 0x02)
 	gotReq, err := decodeFileRequest(futureReq)
@@ -170,3 +176,108 @@ func TestBuildRecentChangeResponse(t *testing.T) {
 		t.Errorf("降级响应往返结果不符: %+v", got)
 	}
 }
+
+// TestRecentChangeRequestInclude 订阅范围是尾部追加字段：带与不带都能解码，
+// 不带时与旧布局逐字节相同
+func TestRecentChangeRequestInclude(t *testing.T) {
+	plain := encodeRecentChangeRequest(RecentChangeRequestMessage{ClientID: 1, StartTime: 42})
+	if len(plain) != 12 {
+		t.Fatalf("无订阅时应保持旧布局（12 字节），得到 %d", len(plain))
+	}
+	got, err := decodeRecentChangeRequest(plain)
+	if err != nil || got.StartTime != 42 || got.Include != nil {
+		t.Fatalf("旧布局解码: %+v %v", got, err)
+	}
+
+	orig := RecentChangeRequestMessage{ClientID: 1, StartTime: 42, Include: []string{"projects/foo/**", "docs/*.pdf"}}
+	got, err = decodeRecentChangeRequest(encodeRecentChangeRequest(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Include) != 2 || got.Include[0] != "projects/foo/**" || got.Include[1] != "docs/*.pdf" {
+		t.Fatalf("订阅范围往返不一致: %+v", got)
+	}
+
+	forged := append(plain, 0xFF, 0xFF) // 谎称 65535 条、后面没有数据
+	if _, err := decodeRecentChangeRequest(forged); err == nil {
+		t.Fatal("谎报条数的请求应被拒")
+	}
+}
+
+// TestRecentChangeJournalCursor 日志游标是请求与响应的尾部追加字段：不带时布局
+// 不变；请求里没有订阅范围也要写条数 0 占位，游标才落在固定位置
+func TestRecentChangeJournalCursor(t *testing.T) {
+	for _, orig := range []RecentChangeRequestMessage{
+		{ClientID: 1, StartTime: 42, Journal: true, Epoch: 0xDEADBEEF, Since: 7},
+		{ClientID: 1, StartTime: 42, Journal: true, Include: []string{"docs/**"}, Epoch: 0xDEADBEEF, Since: 7},
+		{ClientID: 1, Journal: true}, // 只取日志末尾
+	} {
+		got, err := decodeRecentChangeRequest(encodeRecentChangeRequest(orig))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Journal || got.Epoch != orig.Epoch || got.Since != orig.Since || len(got.Include) != len(orig.Include) {
+			t.Errorf("日志游标往返不一致: sent %+v, got %+v", orig, got)
+		}
+	}
+	got, _ := decodeRecentChangeRequest(encodeRecentChangeRequest(RecentChangeRequestMessage{ClientID: 1, Include: []string{"docs/**"}}))
+	if got.Journal {
+		t.Error("不带游标的请求不该被当成日志查询")
+	}
+
+	resp := RecentChangeResponseMessage{ServerID: 9, CoveredUntil: 100, Changes: []string{"a"}, Epoch: 0xDEADBEEF, Seq: 12}
+	back, err := decodeRecentChangeResponse(encodeRecentChangeResponse(resp))
+	if err != nil || back.Epoch != resp.Epoch || back.Seq != resp.Seq || len(back.Changes) != 1 {
+		t.Fatalf("响应游标往返不一致: %+v %v", back, err)
+	}
+	resp.Epoch, resp.Seq = 0, 0
+	if plain := encodeRecentChangeResponse(resp); len(plain) != 4+8+1+4+2+1 {
+		t.Errorf("无游标的响应应保持旧布局，得到 %d 字节", len(plain))
+	}
+}
+
+// TestSubscribedChanges 源端按订阅范围筛变更目录：保留范围内与通向范围的祖先
+func TestSubscribedChanges(t *testing.T) {
+	changes := []string{".", "projects", filepath.Join("projects", "foo", "src"), filepath.Join("projects", "bar"), "music", "docs"}
+	got := subscribedChanges(changes, []string{"projects/foo/**", "docs/*.pdf"})
+	want := []string{".", "projects", filepath.Join("projects", "foo", "src"), "docs"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if got := subscribedChanges(changes, nil); len(got) != len(changes) {
+		t.Fatalf("无订阅应原样返回: %q", got)
+	}
+	if got := subscribedChanges(changes, []string{"**/a/**/b/**/c/**/d/**/e"}); len(got) != len(changes) {
+		t.Fatalf("超出代价上限的模式应放弃筛选: %q", got)
+	}
+}
+
+// TestTreeResponseDigest 目录摘要是树响应的尾部追加字段：不带时布局不变；
+// 未协商 FeatureDigest 的目录页不带子目录摘要
+func TestTreeResponseDigest(t *testing.T) {
+	payload := []byte(`[]`)
+	msg := TreeResponseMessage{DataLength: uint32(len(payload)), Data: payload}
+	plain := encodeTreeResponse(msg)
+	if len(plain) != 2+4+len(payload) {
+		t.Errorf("无摘要的响应应保持旧布局，得到 %d 字节", len(plain))
+	}
+	msg.Digest = strings.Repeat("ab", 32)
+	got, err := decodeTreeResponse(encodeTreeResponse(msg))
+	if err != nil || got.Digest != msg.Digest || string(got.Data) != string(payload) {
+		t.Fatalf("摘要往返不一致: %+v %v", got, err)
+	}
+	if _, err := decodeTreeResponse(append(plain, 0x00, 0x40, 'a')); err == nil {
+		t.Error("谎报长度的摘要应被拒")
+	}
+
+	page := []tree.Node{{Path: "d", IsDir: true, Digest: msg.Digest}}
+	if n := wirePageCopy(page, localFeatureBits); n[0].Digest != msg.Digest {
+		t.Error("协商了 FeatureDigest 应保留子目录摘要")
+	}
+	if n := wirePageCopy(page, localFeatureBits&^FeatureDigest); n[0].Digest != "" {
+		t.Error("未协商 FeatureDigest 不应下发子目录摘要")
+	}
+	if page[0].Digest == "" {
+		t.Error("wirePageCopy 不应改动原切片")
+	}
+}
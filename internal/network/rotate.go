@@ -0,0 +1,155 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/keyfile"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 密钥轮换（--rotate-key，落盘见 keyfile/rotate.go）：宽限期内监听端握手时
+// 新旧两把 key 逐一试（SecureConn 的 previous 参数），用旧 key 握手成功的连接
+// 在协议握手消息尾部（HandshakeMessage.NewKey）拿到新 key——经典格里监听端是
+// 协议服务端、在握手应答里给；汇监听格里监听端是协议客户端、在握手请求里给。
+// 收到的一端（拨号端）换上新 key 并 keyfile.Save 落盘，此后重连即用新 key。
+// 宽限期一过旧 key 退役：新连接不再认它，已建立的会话不受影响
+
+// keyring 当前生效的 key 与宽限期内仍被接受的旧 key。运行中会变：
+// 监听端重读轮换后的密钥文件、拨号端收下对端交来的新 key
+var keyring struct {
+	mu       sync.Mutex
+	current  string
+	previous string
+	until    time.Time
+}
+
+// UseKeys 启动时设置 key 与宽限期内的旧 key（无轮换时 previous 为空）
+func UseKeys(current, previous string, until time.Time) {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	keyring.current, keyring.previous, keyring.until = current, previous, until
+}
+
+// currentKey 本端当前的 key。未经 UseKeys 设置时即 -k 的值
+func currentKey() string {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	if keyring.current == "" {
+		return *config.Secret
+	}
+	return keyring.current
+}
+
+// PreviousKey 轮换宽限期内仍被接受的旧 key 及截止时刻，无则为空
+func PreviousKey() (string, time.Time) {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	return keyring.previous, keyring.until
+}
+
+// acceptedKeys 响应方握手时依次尝试的 key：当前 key 在前，宽限期内再加旧 key。
+// 宽限期已过的旧 key 在这里退役（删文件、记日志），不另起定时器
+func acceptedKeys() []string {
+	keyring.mu.Lock()
+	defer keyring.mu.Unlock()
+	current := keyring.current
+	if current == "" {
+		current = *config.Secret
+	}
+	if keyring.previous == "" {
+		return []string{current}
+	}
+	if time.Now().Before(keyring.until) {
+		return []string{current, keyring.previous}
+	}
+	log.Infof("Grace window over: retired the previous key %s, only %s is accepted now",
+		keyfile.Fingerprint(keyring.previous), keyfile.Fingerprint(current))
+	keyring.previous, keyring.until = "", time.Time{}
+	if config.SecretFromKeyFile {
+		if err := keyfile.RetirePrevious(config.StartPath); err != nil {
+			log.Warn(err)
+		}
+	}
+	return []string{current}
+}
+
+// ReloadKeys 重读密钥文件与 key.previous：运行中的监听端由此接上另一进程里
+// 执行的 --rotate-key。只对 key 来自密钥文件的实例有意义（显式 -k 不跟随文件）
+func ReloadKeys() {
+	if !config.SecretFromKeyFile {
+		return
+	}
+	current, err := keyfile.Load(config.StartPath)
+	if err != nil || current == "" {
+		log.Errorf("key not reloaded, keeping %s: %v", keyfile.Fingerprint(currentKey()), err)
+		return
+	}
+	previous, until, err := keyfile.LoadPrevious(config.StartPath)
+	if err != nil {
+		log.Errorf("previous key not reloaded: %v", err)
+	}
+	keyring.mu.Lock()
+	changed := current != keyring.current
+	keyring.current, keyring.previous, keyring.until = current, previous, until
+	keyring.mu.Unlock()
+	if !changed {
+		return
+	}
+	if previous != "" && time.Now().Before(until) {
+		log.Infof("Key rotated to %s; the previous key %s is still accepted until %s",
+			keyfile.Fingerprint(current), keyfile.Fingerprint(previous), until.Format(time.DateTime))
+	} else {
+		log.Infof("Key changed to %s; peers holding another key can no longer connect", keyfile.Fingerprint(current))
+	}
+}
+
+// handOverKey 对端用旧 key 握手时要交给它的新 key，否则为空
+func handOverKey(conn net.Conn) string {
+	if s, ok := conn.(*secureConn); ok {
+		return s.handOver
+	}
+	return ""
+}
+
+// adoptKey 收下对端交来的新 key：之后的连接都用它，key 落盘（dry-run/verify
+// 不写同步根，只在内存里换）。本端同时也在监听（relay）时，旧 key 照样留一个
+// 默认宽限期，本端自己的拨号端也能顺着换上
+func adoptKey(key string, from string) {
+	keyring.mu.Lock()
+	old := keyring.current
+	if old == "" {
+		old = *config.Secret
+	}
+	if key == old {
+		keyring.mu.Unlock()
+		return
+	}
+	keyring.current = key
+	listens := config.TransportListens()
+	if listens {
+		keyring.previous, keyring.until = old, time.Now().Add(keyfile.DefaultGrace)
+	}
+	until := keyring.until
+	keyring.mu.Unlock()
+
+	log.Infof("Peer %s rotated the key: switched from %s to %s", from, keyfile.Fingerprint(old), keyfile.Fingerprint(key))
+	if !config.SecretFromKeyFile && *config.Secret != "" {
+		log.Warnf("this instance was started with -k; restart it with the new key (see %s) or it stops connecting once the peer's grace window ends",
+			keyfile.Path(config.StartPath))
+	}
+	if *config.DryRun || config.Verify {
+		return
+	}
+	if listens {
+		if err := keyfile.SavePrevious(config.StartPath, old, until); err != nil {
+			log.Warnf("failed to keep the previous key for this instance's own dialers: %v", err)
+		}
+	}
+	if _, err := keyfile.Save(config.StartPath, key); err != nil {
+		log.Warnf("failed to save the new key (it is only held in memory until restart): %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"local-mirror/internal/keyfile"
+)
+
+// TestSecureConnPreviousKey 轮换宽限期：响应方新旧 key 都认，用旧 key 握手的连接
+// 记下要交出的新 key，用新 key 的不交；两把都不是的照旧失败
+func TestSecureConnPreviousKey(t *testing.T) {
+	handshake := func(dialKey string, listenKeys ...string) (net.Conn, net.Conn, error, error) {
+		a, b := net.Pipe()
+		type result struct {
+			conn net.Conn
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			c, err := SecureConn(b, listenKeys[0], false, listenKeys[1:]...)
+			if err != nil {
+				b.Close()
+			}
+			done <- result{c, err}
+		}()
+		d, derr := SecureConn(a, dialKey, true)
+		if derr != nil {
+			a.Close()
+		}
+		l := <-done
+		return d, l.conn, derr, l.err
+	}
+
+	_, l, derr, lerr := handshake("old", "new", "old")
+	if derr != nil || lerr != nil {
+		t.Fatalf("the previous key should still be accepted: dialer %v, listener %v", derr, lerr)
+	}
+	if got := handOverKey(l); got != "new" {
+		t.Fatalf("a session on the previous key should hand over the new key, got %q", got)
+	}
+	if s := l.(*secureConn); s.keyFP != keyfile.Fingerprint("old") {
+		t.Fatal("keyFP should name the key the peer actually used")
+	}
+
+	_, l, derr, lerr = handshake("new", "new", "old")
+	if derr != nil || lerr != nil {
+		t.Fatalf("the current key: dialer %v, listener %v", derr, lerr)
+	}
+	if got := handOverKey(l); got != "" {
+		t.Fatalf("a session on the current key has nothing to hand over, got %q", got)
+	}
+
+	if _, _, derr, lerr = handshake("other", "new", "old"); derr == nil || lerr == nil {
+		t.Fatal("a key that is neither current nor previous must fail")
+	}
+}
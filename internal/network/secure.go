@@ -9,6 +9,8 @@ import (
 	"net"
 	"time"
 
+	"local-mirror/internal/keyfile"
+
 	"github.com/flynn/noise"
 	"github.com/zeebo/blake3"
 )
@@ -45,7 +47,8 @@ func enableKeepAlive(conn net.Conn) {
 	}
 }
 
-// DerivePSK 从用户口令派生 32 字节预共享密钥。
+// DerivePSK 从口令派生 32 字节预共享密钥（--gen-key 生成的 key 走这条快速路径，
+// 人选口令经 StretchPSK 拉伸，见 kdf.go）。
 // 加入固定前缀做域分离，避免口令在其他场景复用时产生相同密钥
 func DerivePSK(secret string) []byte {
 	sum := blake3.Sum256([]byte("local-mirror-noise-psk-v1:" + secret))
@@ -59,6 +62,12 @@ type secureConn struct {
 	enc     *noise.CipherState
 	dec     *noise.CipherState
 	readBuf bytes.Buffer // 已解密但尚未被消费的明文
+	// peer 身份模式下握手认证出的对端（见 identity.go），口令模式为 nil
+	peer *keyfile.Peer
+	// keyFP 握手所用共享密钥的指纹（keyfile.Fingerprint），未用口令为空。供 ACL 指认对端
+	keyFP string
+	// handOver 对端用轮换前的旧 key 握手时本端当前的 key，协议握手时交给对端（见 rotate.go）
+	handOver string
 }
 
 // writeFrame 写入一个带 2 字节长度前缀的帧。
@@ -121,23 +130,31 @@ func (s *secureConn) Write(p []byte) (int, error) {
 
 // SecureConn 在已建立的 TCP 连接上执行 Noise NNpsk0 握手，
 // 返回透明加解密的连接。双方必须使用相同口令，否则握手失败。
-// initiator 为 true 表示客户端（主动发起方）
-func SecureConn(conn net.Conn, secret string, initiator bool) (net.Conn, error) {
+// initiator 为 true 表示客户端（主动发起方）。previous 是响应方在 secret
+// 之外还接受的 key（轮换宽限期内的旧 key），发起方忽略
+func SecureConn(conn net.Conn, secret string, initiator bool, previous ...string) (net.Conn, error) {
 	conn.SetDeadline(time.Now().Add(noiseHandshakeTimeout))
 	defer conn.SetDeadline(time.Time{})
 
-	hs, err := noise.NewHandshakeState(noise.Config{
-		CipherSuite:           noiseCipherSuite,
-		Pattern:               noise.HandshakeNN,
-		Initiator:             initiator,
-		PresharedKey:          DerivePSK(secret),
-		PresharedKeyPlacement: 0,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create noise handshake: %w", err)
+	newHandshake := func(psk []byte) (*noise.HandshakeState, error) {
+		return noise.NewHandshakeState(noise.Config{
+			CipherSuite:           noiseCipherSuite,
+			Pattern:               noise.HandshakeNN,
+			Initiator:             initiator,
+			PresharedKey:          psk,
+			PresharedKeyPlacement: 0,
+		})
 	}
 
 	if initiator {
+		psk, err := initiatorPSK(conn, secret)
+		if err != nil {
+			return nil, err
+		}
+		hs, err := newHandshake(psk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create noise handshake: %w", err)
+		}
 		// -> psk, e
 		msg, _, _, err := hs.WriteMessage(nil, nil)
 		if err != nil {
@@ -156,15 +173,17 @@ func SecureConn(conn net.Conn, secret string, initiator bool) (net.Conn, error)
 			return nil, fmt.Errorf("noise handshake failed (do the passphrases match?): %w", err)
 		}
 		// cs0 固定用于 发起方→响应方 方向
-		return &secureConn{Conn: conn, enc: cs0, dec: cs1}, nil
+		return &secureConn{Conn: conn, enc: cs0, dec: cs1, keyFP: keyfile.Fingerprint(secret)}, nil
 	}
 
 	// <- psk, e
-	first, err := readFrame(conn, noiseMaxHandshakeFrame)
+	first, err := readFirstFrame(conn)
 	if err != nil {
 		return nil, fmt.Errorf("noise handshake recv: %w", err)
 	}
-	if _, _, _, err := hs.ReadMessage(nil, first); err != nil {
+	keys := append([]string{secret}, previous...)
+	hs, used, err := readFirstMessage(first, keys, newHandshake)
+	if err != nil {
 		return nil, fmt.Errorf("noise handshake failed (peer passphrase mismatch or encryption disabled): %w", err)
 	}
 	// -> e, ee
@@ -175,5 +194,28 @@ func SecureConn(conn net.Conn, secret string, initiator bool) (net.Conn, error)
 	if err := writeFrame(conn, msg); err != nil {
 		return nil, fmt.Errorf("noise handshake send: %w", err)
 	}
-	return &secureConn{Conn: conn, enc: cs1, dec: cs0}, nil
+	sc := &secureConn{Conn: conn, enc: cs1, dec: cs0, keyFP: keyfile.Fingerprint(keys[used])}
+	if used > 0 {
+		sc.handOver = secret
+	}
+	return sc, nil
+}
+
+// readFirstMessage 响应方用 keys 逐一尝试解第一条握手消息（psk 在第一条消息里，
+// 口令不对在这一步就失败），返回解开它的握手状态与所用 key 的下标。
+// 每把 key 各用一个新的握手状态：失败的 ReadMessage 会弄脏状态
+func readFirstMessage(first []byte, keys []string, newHandshake func(psk []byte) (*noise.HandshakeState, error)) (*noise.HandshakeState, int, error) {
+	var lastErr error
+	for i, key := range keys {
+		hs, err := newHandshake(responderPSK(key))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create noise handshake: %w", err)
+		}
+		if _, _, _, err := hs.ReadMessage(nil, first); err != nil {
+			lastErr = err
+			continue
+		}
+		return hs, i, nil
+	}
+	return nil, 0, lastErr
 }
@@ -6,6 +6,7 @@ import (
 	"io"
 	"local-mirror/config"
 	"local-mirror/internal/appError"
+	"local-mirror/internal/keyfile"
 	"local-mirror/internal/status"
 	"net"
 	"os"
@@ -63,10 +64,12 @@ type client struct {
 	Role           uint8        // 客户端角色
 	LastActiveTime time.Time    // 最后一次通讯时间
 	Version        uint16       // 客户端协议版本
+	Features       uint64       // 会话能力位（两端 FeatureBits 的交集）
 	Connected      bool         // 当前是否已连接
 	Conn           net.Conn     // 客户端连接
 	SessionMap     sync.Map     // 活跃的会话列表
 	dirCache       *dirSnapshot // 分页遍历的已排序目录快照（PERF-01），仅本客户端消息循环访问
+	names          []string     // 对端在 ACL 里可被指认的名字（见 acl.go），连接建立时定型
 }
 
 func (c *client) UpdateLastActiveTime() {
@@ -113,7 +116,8 @@ const dialFirstMessageTimeout = 15 * time.Second
 // StartDial 源端拨出（四象限的「源拨 → 汇听」格）：向监听中的汇拨号，
 // 连接就绪后在同一套源端消息循环（serveConn）上服务。协议报文与谁拨号
 // 无关——汇在连接建立后仍先说话；Noise initiator 自动跟拨号方（dialConn）。
-// 重连与退避归拨号方，本函数阻塞不返回
+// 重连与退避归拨号方，本函数阻塞不返回。扇出推送时同一个 fileServer 对每个汇
+// 各跑一个 StartDial，逐路连接状态记入 status 的 links
 func (s *fileServer) StartDial(addr string) {
 	const baseDelay, maxDelay = 3 * time.Second, 60 * time.Second
 	delay := baseDelay
@@ -121,6 +125,7 @@ func (s *fileServer) StartDial(addr string) {
 		conn, err := dialConn(addr)
 		if err != nil {
 			log.Warnf("dial sink %s failed: %v (retrying in %v)", addr, err, delay)
+			status.SetLink(addr, false, err.Error())
 			time.Sleep(delay)
 			delay = min(delay*2, maxDelay)
 			continue
@@ -137,6 +142,7 @@ func (s *fileServer) StartDial(addr string) {
 			log.Warnf("sink %s did not speak within %v (a healthy sink handshakes immediately; "+
 				"are both ends configured --send, or is this the wrong peer?): %v",
 				addr, dialFirstMessageTimeout, err)
+			status.SetLink(addr, false, "connected but the sink never spoke")
 			conn.Close()
 			time.Sleep(delay)
 			delay = min(delay*2, maxDelay)
@@ -149,8 +155,10 @@ func (s *fileServer) StartDial(addr string) {
 
 		delay = baseDelay
 		log.Infof("Connected out to sink %s, serving", addr)
+		status.SetLink(addr, true, "serving")
 		s.serveConn(conn, &prereadMessage{msgType: msgType, body: body})
 		log.Warnf("connection to sink %s ended, redialing", addr)
+		status.SetLink(addr, false, "connection ended, redialing")
 	}
 }
 
@@ -188,15 +196,18 @@ func (s *fileServer) handleConnection(conn net.Conn) {
 	clientAddr := conn.RemoteAddr().String()
 	log.Infof("Client connected from %s to local port %s", clientAddr, conn.LocalAddr().String())
 
-	// 配置了口令则先完成 Noise 加密握手，之后的所有协议消息透明加解密；
-	// 口令不一致或对端未加密时在这里直接拒绝
-	if *config.Secret != "" {
-		secured, err := SecureConn(conn, *config.Secret, false)
+	// 配置了口令（或身份模式）则先完成 Noise 加密握手，之后的所有协议消息透明加解密；
+	// 口令不一致、对端身份不在授权名单或对端未加密时在这里直接拒绝
+	if config.Encrypted() {
+		secured, err := secure(conn, false, "")
 		if err != nil {
 			log.Warnf("Rejecting %s: %v", clientAddr, err)
 			conn.Close()
 			return
 		}
+		if p := PeerOf(secured); p != nil {
+			log.Infof("Client %s authenticated as %s", clientAddr, p)
+		}
 		conn = secured
 	}
 	s.serveConn(conn, nil)
@@ -225,6 +236,7 @@ func (s *fileServer) serveConn(conn net.Conn, first *prereadMessage) {
 		Connected:      false,
 		Conn:           conn,
 		SessionMap:     sync.Map{},
+		names:          peerNames(conn),
 	}
 
 	// sessionCounted 确保这条连接在 status 里最多计一次 up/down：握手成功才
@@ -236,6 +248,9 @@ func (s *fileServer) serveConn(conn net.Conn, first *prereadMessage) {
 		}
 		s.removeClientIfCurrent(client.ID, client)
 		if sessionCounted {
+			if p := PeerOf(conn); p != nil {
+				status.PeerIdentityDown(p.String())
+			}
 			status.SessionDown()
 		}
 	}()
@@ -281,22 +296,32 @@ func (s *fileServer) serveConn(conn net.Conn, first *prereadMessage) {
 			client.Alias = ""
 			client.Role = clientBase.Role
 			client.Version = clientBase.Version
+			client.Features = clientBase.FeatureBits & localFeatureBits
 			client.Connected = true
 			s.clientMap.Store(clientBase.UUID, client)
 			if !sessionCounted {
 				sessionCounted = true
-				status.SessionUp(fmt.Sprintf("serving %s", clientAddr))
+				detail := fmt.Sprintf("serving %s", clientAddr)
+				if p := PeerOf(conn); p != nil {
+					detail += " as " + p.String()
+					status.PeerIdentityUp(p.String())
+				}
+				status.SessionUp(detail)
 			}
 		case MsgTypeRecentChangeRequest:
-			if closed := s.dispatchError(conn, client, s.handleRecentChangeRequest(client.ID, bodyBytes)); closed {
+			if closed := s.dispatchError(conn, client, s.handleRecentChangeRequest(client, bodyBytes)); closed {
 				return
 			}
 		case MsgTypeTreeRequest:
-			if closed := s.dispatchError(conn, client, s.handleTreeRequest(client.ID, bodyBytes)); closed {
+			if closed := s.dispatchError(conn, client, s.handleTreeRequest(client, bodyBytes)); closed {
 				return
 			}
 		case MsgTypeFileRequest:
-			if closed := s.dispatchError(conn, client, s.handleFileRequest(client.ID, bodyBytes)); closed {
+			if closed := s.dispatchError(conn, client, s.handleFileRequest(client, bodyBytes)); closed {
+				return
+			}
+		case MsgTypeDeltaRequest:
+			if closed := s.dispatchError(conn, client, s.handleDeltaRequest(client, bodyBytes)); closed {
 				return
 			}
 		default:
@@ -377,16 +402,34 @@ func (s *fileServer) handleHandshake(conn net.Conn, bodyBytes []byte) (*Handshak
 		return nil, fmt.Errorf("%w, direction conflict: both ends declare send (peer %08x)",
 			appError.ErrConnection, handshakeMsg.UUID)
 	}
-	log.Infof("Received handshake message: version: %d (agreed %d), clientID: %d",
-		handshakeMsg.Version, agreed, handshakeMsg.UUID)
+	log.Infof("Received handshake message: version: %d (agreed %d), clientID: %d, features: %#x",
+		handshakeMsg.Version, agreed, handshakeMsg.UUID, handshakeMsg.FeatureBits&localFeatureBits)
 	// Role 承载本连接端点的数据方向：源引擎恒申报 send（relay 的下游侧
-	// 也是送）。老 reality 值恰为 1 = send，对旧客户端零变化
+	// 也是送）。老 reality 值恰为 1 = send，对旧客户端零变化。
+	// 双向同步申报 RoleBoth；来拉取的单向汇照常服务（只读的旁路副本无碍）
+	role := config.RoleSend
+	if config.TwoWay() {
+		role = config.RoleBoth
+	}
 	receiveHandshake := HandshakeMessage{
 		Version:     config.ProtocolVersion,
 		MinVersion:  config.MinProtocolVersion,
 		UUID:        config.InstanceID,
-		Role:        config.RoleSend,
-		FeatureBits: 0,
+		Role:        role,
+		FeatureBits: localFeatureBits,
+	}
+	// 对端用轮换前的旧 key 连进来：在应答里交给它新 key（旧版对端不认，只能提醒）
+	if key := handOverKey(conn); key != "" {
+		if handshakeMsg.FeatureBits&FeatureRekey != 0 {
+			receiveHandshake.NewKey = key
+			log.Infof("Peer %08x connected with the previous key; handing it the new key %s", handshakeMsg.UUID, keyfile.Fingerprint(key))
+		} else {
+			log.Warnf("peer %08x connected with the previous key but is too old to take the new one; it will be cut off when the grace window ends", handshakeMsg.UUID)
+		}
+	}
+	// 汇监听格：作为监听端的汇在握手请求里交来新 key
+	if handshakeMsg.NewKey != "" {
+		adoptKey(handshakeMsg.NewKey, fmt.Sprintf("%08x", handshakeMsg.UUID))
 	}
 	handshakeBytes := encodeHandshake(receiveHandshake)
 	if err := sendMessage(conn, MsgTypeHandshake, handshakeBytes); err != nil {
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"slices"
+	"testing"
+
+	"local-mirror/internal/keyfile"
+)
+
+// tlsPair 两端握手，返回双方的错误与监听端看到的对端名字
+// 走回环 TCP 而非 net.Pipe：无缓冲的管道上，一方拒绝证书时写告警与对方写握手互相卡住
+func tlsPair(t *testing.T, dial, listen TLSSettings, host string) (derr, lerr error, names []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	a, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		c, err := SecureTLSConn(b, listen, false, "")
+		if err == nil {
+			names = peerNames(c)
+			// TLS 1.3 客户端握手先于服务端验完客户端证书返回：写一个字节，客户端读到才算通过
+			c.Write([]byte{1})
+		}
+		b.Close()
+		done <- err
+	}()
+	c, derr := SecureTLSConn(a, dial, true, host)
+	if derr == nil {
+		_, derr = c.Read(make([]byte, 1))
+	}
+	a.Close()
+	lerr = <-done
+	return derr, lerr, names
+}
+
+func loadCert(t *testing.T, cn string) (tls.Certificate, string) {
+	t.Helper()
+	root := t.TempDir()
+	der, err := keyfile.GenerateCert(root, cn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(keyfile.CertPath(root), keyfile.CertKeyPath(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, keyfile.CertFingerprint(der)
+}
+
+// TestTLSPinned 互相钉住指纹即通，ACL 名字是 CN 与完整指纹；任一方没钉住对方都失败
+func TestTLSPinned(t *testing.T) {
+	alpha, alphaFP := loadCert(t, "alpha")
+	beta, betaFP := loadCert(t, "beta")
+
+	derr, lerr, names := tlsPair(t,
+		TLSSettings{Cert: alpha, Pins: []string{betaFP}},
+		TLSSettings{Cert: beta, Pins: []string{alphaFP}}, "127.0.0.1")
+	if derr != nil || lerr != nil {
+		t.Fatalf("pinned both ways: dialer %v, listener %v", derr, lerr)
+	}
+	if !slices.Equal(names, []string{"alpha", alphaFP}) {
+		t.Fatalf("peer names = %v, want CN and fingerprint", names)
+	}
+
+	if derr, _, _ := tlsPair(t,
+		TLSSettings{Cert: alpha, Pins: []string{alphaFP}},
+		TLSSettings{Cert: beta, Pins: []string{alphaFP}}, ""); derr == nil {
+		t.Fatal("dialer accepted a listener it did not pin")
+	}
+	if _, lerr, _ := tlsPair(t,
+		TLSSettings{Cert: alpha, Pins: []string{betaFP}},
+		TLSSettings{Cert: beta, Pins: []string{betaFP}}, ""); lerr == nil {
+		t.Fatal("listener accepted a dialer it did not pin")
+	}
+}
+
+// TestTLSCA 按 CA 验：证书在信任池里即通，拨号方另核对主机名
+func TestTLSCA(t *testing.T) {
+	alpha, _ := loadCert(t, "alpha")
+	beta, _ := loadCert(t, "beta")
+	pool := x509.NewCertPool()
+	for _, c := range []tls.Certificate{alpha, beta} {
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.AddCert(leaf)
+	}
+	dial := TLSSettings{Cert: alpha, Roots: pool}
+	listen := TLSSettings{Cert: beta, Roots: pool}
+	if derr, lerr, _ := tlsPair(t, dial, listen, "beta"); derr != nil || lerr != nil {
+		t.Fatalf("trusted by CA: dialer %v, listener %v", derr, lerr)
+	}
+	if derr, _, _ := tlsPair(t, dial, listen, "gamma"); derr == nil {
+		t.Fatal("dialer accepted a certificate for the wrong host")
+	}
+	if _, lerr, _ := tlsPair(t, dial, TLSSettings{Cert: beta, Roots: x509.NewCertPool()}, "beta"); lerr == nil {
+		t.Fatal("listener accepted a dialer outside its CA pool")
+	}
+}
@@ -0,0 +1,85 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// workerRedialBackoff 附加连接拨建失败后的冷却期。Workers 每个目录都会调用，
+// 对端连接数打满或网络抖动时不冷却会让每个目录都多等一轮拨号超时
+const workerRedialBackoff = 30 * time.Second
+
+// Workers 返回最多 n 个可并行下载的客户端：第一个恒为 c 自身，其余是按需
+// 拨建的附加连接。协议是单连接单飞行请求，并行度只能来自多条连接——每条
+// 附加连接独立握手，服务端视其为同一实例的又一条会话。
+//
+// 附加连接必须握手到与主连接相同的服务端实例（realityID），否则丢弃：
+// 地址背后换了实例时，主连接的目录树与附加连接下发的文件对不上。
+// 入站传输（汇监听格）无从主动拨出，恒只返回 c；拨建失败不报错，
+// 降级为可用的连接数，由 workerRedialBackoff 限制重拨频率
+func (c *FileClient) Workers(n int) []*FileClient {
+	out := []*FileClient{c}
+	if n <= 1 || c.connectionManage == nil || c.connectionManage.connectAddr == "" {
+		return out
+	}
+	c.workersMu.Lock()
+	defer c.workersMu.Unlock()
+
+	// 先剔除已断开的附加连接（下载遇连接错误时 processFileDiff 会关闭它）
+	alive := c.workers[:0]
+	for _, w := range c.workers {
+		if _, err := w.connectionManage.GetConnection(); err == nil && w.State == Online {
+			alive = append(alive, w)
+		} else {
+			w.ConnectionClose()
+		}
+	}
+	c.workers = alive
+
+	for len(c.workers) < n-1 && time.Now().After(c.workersRetryAt) {
+		w, err := c.dialWorker()
+		if err != nil {
+			log.Warnf("Failed to open parallel download connection to %s (continuing with %d): %v",
+				c.RealityAddr, len(c.workers)+1, err)
+			c.workersRetryAt = time.Now().Add(workerRedialBackoff)
+			break
+		}
+		c.workers = append(c.workers, w)
+	}
+	for i := 0; i < len(c.workers) && i < n-1; i++ {
+		out = append(out, c.workers[i])
+	}
+	return out
+}
+
+// dialWorker 拨建一条附加下载连接并完成握手，校验对端与主连接是同一实例
+func (c *FileClient) dialWorker() (*FileClient, error) {
+	w, err := NewFileClient(c.connectionManage.connectAddr, c.Alias)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Handshake(); err != nil {
+		w.ConnectionClose()
+		return nil, err
+	}
+	if w.realityID != c.realityID {
+		w.ConnectionClose()
+		return nil, fmt.Errorf("peer instance changed (%08x, expected %08x)", w.realityID, c.realityID)
+	}
+	w.Mount = c.Mount
+	return w, nil
+}
+
+// closeWorkers 关闭全部附加连接。附加连接自身的 workers 恒为空，不会递归
+func (c *FileClient) closeWorkers() {
+	c.workersMu.Lock()
+	workers := c.workers
+	c.workers = nil
+	c.workersRetryAt = time.Time{}
+	c.workersMu.Unlock()
+	for _, w := range workers {
+		w.ConnectionClose()
+	}
+}
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"local-mirror/config"
+	"local-mirror/internal/appError"
+	"local-mirror/internal/network"
+	"local-mirror/internal/status"
+	"local-mirror/internal/tree"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// --once：连上源跑一轮全量扫描后退出，供 cron/CI 使用。单项失败在常驻模式里
+// 只是记日志、等下一轮自愈；一次性运行没有下一轮，所以扫描期间把没同步成的项
+// 按原因记账（scanTally），结束时据此给出退出码与机器可读的摘要
+
+// --once 的退出码。2 已被用法错误占用
+const (
+	OnceOK         = 0 // 副本与源一致
+	OnceFailed     = 1 // 扫描没能跑完（连不上、握手失败、任务级错误）
+	OnceDiskFull   = 3 // 有文件因磁盘空间不足被跳过
+	OncePermission = 4 // 有项因权限被拒（本地写不进或上游读不出）
+	OnceIncomplete = 5 // 其余没同步成的项或目录（反复失败被拉黑、目录放弃等）
+)
+
+// 没同步成的项的原因
+const (
+	itemDiskFull = iota
+	itemPermission
+	itemBlacklisted
+	itemFailed
+)
+
+// scanTally 本次运行里没同步成的项（按路径去重：目录重试时同一项可能再失败一次）
+// 与放弃的目录。只在 --once 下记：常驻进程没有"结束"可汇报，也不该无限累积
+var scanTally = struct {
+	sync.Mutex
+	items map[string]int
+	dirs  map[string]bool
+}{items: make(map[string]int), dirs: make(map[string]bool)}
+
+func tallyItem(path string, reason int) {
+	if !*config.Once {
+		return
+	}
+	scanTally.Lock()
+	scanTally.items[path] = reason
+	scanTally.Unlock()
+}
+
+// tallyItemError 按 recordItemError 的归类把一次单项失败记账
+func tallyItemError(path string, err error) {
+	switch {
+	case errors.Is(err, appError.ErrDiskFull):
+		tallyItem(path, itemDiskFull)
+	case errors.Is(err, fs.ErrPermission):
+		tallyItem(path, itemPermission)
+	default:
+		tallyItem(path, itemFailed)
+	}
+}
+
+// tallyDir 记下一个本轮放弃对账的目录（--dry-run 时同时记进计划）
+func tallyDir(path string) {
+	if *config.Once {
+		scanTally.Lock()
+		scanTally.dirs[filepath.ToSlash(path)] = true
+		scanTally.Unlock()
+	}
+	if dryRun != nil {
+		dryRun.Unscanned = append(dryRun.Unscanned, filepath.ToSlash(path))
+	}
+}
+
+// OnceSummary --once 结束时打到 stdout 的摘要（一行 JSON）。传输计数取自
+// status 的累计值，进程里只跑了这一轮，即本轮的量
+type OnceSummary struct {
+	Result     string  `json:"result"` // ok / incomplete / failed
+	ExitCode   int     `json:"exit_code"`
+	Source     string  `json:"source,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Seconds    float64 `json:"seconds"`
+	Files      uint64  `json:"files"`
+	Bytes      uint64  `json:"bytes"`
+	DedupFiles uint64  `json:"dedup_files"`
+	DedupBytes uint64  `json:"dedup_bytes"`
+	WireBytes  uint64  `json:"wire_bytes"`
+	Errors     uint64  `json:"errors"`
+
+	DiskFull    int      `json:"disk_full_skipped"`
+	Permission  int      `json:"permission_denied"`
+	Blacklisted int      `json:"blacklisted"`
+	Failed      int      `json:"failed_items"`
+	FailedDirs  []string `json:"failed_dirs,omitempty"`
+}
+
+// Once 执行 --once：按传输格连上源（拨出，或在 ServerListener 上等一条入站）、
+// 跑一轮全量扫描，把摘要写到 w，返回退出码。多上游汇依次拉每一路
+func Once(w io.Writer) int {
+	start := time.Now()
+	sum := OnceSummary{Result: "ok"}
+	if *config.Gitignore {
+		watchGitignores()
+	}
+	err := tree.BuildFileTree(config.StartPath)
+	if err == nil {
+		err = onceScan(&sum)
+	}
+	if err != nil {
+		sum.Result = "failed"
+		sum.Error = err.Error()
+		sum.ExitCode = OnceFailed
+	} else {
+		sum.tally()
+	}
+
+	st := status.Current()
+	sum.Seconds = time.Since(start).Seconds()
+	sum.Files, sum.Bytes = st.Files, st.Bytes
+	sum.DedupFiles, sum.DedupBytes = st.DedupFiles, st.DedupBytes
+	sum.WireBytes = st.WireBytes
+	sum.Errors = st.Errors
+
+	log.Infof("one-shot sync finished: %s (exit %d), %d files / %s transferred in %.1fs",
+		sum.Result, sum.ExitCode, sum.Files, humanBytes(sum.Bytes), sum.Seconds)
+	if err := json.NewEncoder(w).Encode(&sum); err != nil {
+		log.Errorf("failed to write the summary: %v", err)
+	}
+	return sum.ExitCode
+}
+
+// tally 把 scanTally 汇进摘要并定退出码：空间不足与权限问题要人处理，比笼统的
+// "没同步完"更具体，优先报
+func (s *OnceSummary) tally() {
+	scanTally.Lock()
+	for _, reason := range scanTally.items {
+		switch reason {
+		case itemDiskFull:
+			s.DiskFull++
+		case itemPermission:
+			s.Permission++
+		case itemBlacklisted:
+			s.Blacklisted++
+		default:
+			s.Failed++
+		}
+	}
+	for d := range scanTally.dirs {
+		s.FailedDirs = append(s.FailedDirs, d)
+	}
+	scanTally.Unlock()
+	sort.Strings(s.FailedDirs)
+
+	switch {
+	case s.DiskFull > 0:
+		s.ExitCode = OnceDiskFull
+	case s.Permission > 0:
+		s.ExitCode = OncePermission
+	case s.Blacklisted+s.Failed+len(s.FailedDirs) > 0:
+		s.ExitCode = OnceIncomplete
+	}
+	if s.ExitCode != OnceOK {
+		s.Result = "incomplete"
+	}
+}
+
+func onceScan(sum *OnceSummary) error {
+	if config.SinkListens {
+		fileClient, err := acceptOnce()
+		if err != nil {
+			return err
+		}
+		sum.Source = fileClient.RealityAddr
+		return onceSession(fileClient, "")
+	}
+	if len(config.Upstreams) == 0 {
+		return onceDial(sum, func() (*network.FileClient, error) { return InitConn() })
+	}
+	for _, u := range config.Upstreams {
+		if err := onceDial(sum, func() (*network.FileClient, error) {
+			fileClient, err := connectPeer(u.Addr, "")
+			fileClient.Mount = u.Dir
+			return fileClient, err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onceDial 拨一次（不退避重拨：连不上就是这次运行失败，由 cron 的下一次重来）
+func onceDial(sum *OnceSummary, dial func() (*network.FileClient, error)) error {
+	fileClient, err := ensureConnected(dial)
+	if err != nil {
+		return fmt.Errorf("failed to connect%s: %w", mountSuffix(fileClient), err)
+	}
+	if sum.Source != "" {
+		sum.Source += ","
+	}
+	sum.Source += fileClient.RealityAddr
+	return onceSession(fileClient, fmt.Sprintf("connected to %s%s", fileClient.RealityAddr, mountSuffix(fileClient)))
+}
+
+// acceptOnce 汇监听格：等第一条握手成功的入站连接（握手失败的照 MirrorListen 拒掉继续等）
+func acceptOnce() (*network.FileClient, error) {
+	if ServerListener == nil {
+		return nil, fmt.Errorf("server listener not initialized")
+	}
+	log.Infof("Sink listening on %s, waiting for the source to dial in", ServerListener.Addr())
+	for {
+		conn, err := ServerListener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		prepared, err := network.PrepareInboundConn(conn)
+		if err != nil {
+			log.Warnf("Rejecting inbound %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		fileClient := network.NewFileClientFromConn(prepared)
+		if err := fileClient.Handshake(); err != nil {
+			log.Warnf("Inbound source %s handshake failed: %v", conn.RemoteAddr(), err)
+			fileClient.ConnectionClose()
+			continue
+		}
+		return fileClient, nil
+	}
+}
+
+func onceSession(fileClient *network.FileClient, detail string) error {
+	defer fileClient.ConnectionClose()
+	if detail == "" {
+		detail = fmt.Sprintf("source dialed in from %s", fileClient.RealityAddr)
+	}
+	defer sessionUp(detail, fileClient)()
+	// 启动时刚按磁盘建过树，这一轮不必再校准
+	lastLocalRebuild = time.Now()
+	return executeTaskWithClient("one-shot full scan", fileClient, fullScan)
+}
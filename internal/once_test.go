@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"local-mirror/config"
+	"local-mirror/internal/appError"
+)
+
+// TestOnceExitCodes --once 按没同步成的项的原因给退出码：空间不足优先于权限，
+// 权限优先于其余失败；同一路径重复失败只算一次；非 --once 下不记账
+func TestOnceExitCodes(t *testing.T) {
+	save := config.Once
+	defer func() { config.Once = save }()
+	on, off := true, false
+	reset := func() {
+		scanTally.items = make(map[string]int)
+		scanTally.dirs = make(map[string]bool)
+	}
+	defer reset()
+
+	itemFailures, blacklist := make(map[string]int), make(map[string]bool)
+	skipped := 0
+	fail := func(path string, err error) {
+		recordItemError(DiffResult{Path: path}, err, itemFailures, blacklist, &skipped)
+	}
+	summarize := func() OnceSummary {
+		s := OnceSummary{Result: "ok"}
+		s.tally()
+		return s
+	}
+
+	config.Once = &off
+	fail("a", fmt.Errorf("%w: a", appError.ErrDiskFull))
+	if s := summarize(); s.ExitCode != OnceOK {
+		t.Fatalf("非 --once 不应记账: %+v", s)
+	}
+
+	config.Once = &on
+	if s := summarize(); s.ExitCode != OnceOK || s.Result != "ok" {
+		t.Fatalf("没有失败应返回 0: %+v", s)
+	}
+
+	tallyDir("sub")
+	if s := summarize(); s.ExitCode != OnceIncomplete || len(s.FailedDirs) != 1 {
+		t.Fatalf("放弃的目录应返回 %d: %+v", OnceIncomplete, s)
+	}
+
+	fail("b", fmt.Errorf("placing b: %w", fs.ErrPermission))
+	fail("b", fmt.Errorf("placing b: %w", fs.ErrPermission))
+	if s := summarize(); s.ExitCode != OncePermission || s.Permission != 1 {
+		t.Fatalf("权限被拒应返回 %d 且按路径去重: %+v", OncePermission, s)
+	}
+
+	fail("c", fmt.Errorf("%w: c", appError.ErrDiskFull))
+	if s := summarize(); s.ExitCode != OnceDiskFull || s.Result != "incomplete" {
+		t.Fatalf("空间不足应优先返回 %d: %+v", OnceDiskFull, s)
+	}
+
+	reset()
+	for i := 0; i <= maxItemRetries; i++ {
+		fail("d", fmt.Errorf("%w: reset", appError.ErrConnection))
+	}
+	if s := summarize(); s.ExitCode != OnceIncomplete || s.Blacklisted != 1 {
+		t.Fatalf("反复失败被拉黑的项应返回 %d: %+v", OnceIncomplete, s)
+	}
+}
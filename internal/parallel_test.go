@@ -0,0 +1,65 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"local-mirror/internal/appError"
+)
+
+// TestRecordItemErrorIsolation 串行循环与并行下载共用同一套单项失败隔离：
+// 磁盘不足只计数、连接错误累计到阈值才拉黑并要求调用方停用该连接、
+// 其余错误记日志跳过。并行化不能悄悄改变这套语义
+func TestRecordItemErrorIsolation(t *testing.T) {
+	v := DiffResult{Path: "dir/a.bin", Action: "create", Hash: "h"}
+	failures := map[string]int{}
+	blacklist := map[string]bool{}
+	diskFull := 0
+
+	if recordItemError(v, fmt.Errorf("%w: no space", appError.ErrDiskFull), failures, blacklist, &diskFull) {
+		t.Error("磁盘不足不应停用连接")
+	}
+	if diskFull != 1 || failures[v.Path] != 0 {
+		t.Errorf("磁盘不足应只计数: diskFull=%d failures=%d", diskFull, failures[v.Path])
+	}
+
+	if recordItemError(v, errors.New("boom"), failures, blacklist, &diskFull) {
+		t.Error("普通错误不应停用连接")
+	}
+
+	connErr := fmt.Errorf("%w: reset", appError.ErrConnection)
+	for i := 1; i <= maxItemRetries; i++ {
+		if !recordItemError(v, connErr, failures, blacklist, &diskFull) {
+			t.Fatal("连接错误必须要求调用方停用该连接")
+		}
+		if blacklist[v.Path] {
+			t.Fatalf("第 %d 次连接错误就被拉黑，阈值是 %d", i, maxItemRetries)
+		}
+	}
+	recordItemError(v, connErr, failures, blacklist, &diskFull)
+	if !blacklist[v.Path] {
+		t.Errorf("连续 %d 次连接错误后应拉黑", maxItemRetries+1)
+	}
+}
+
+// TestIsFileDownload 只有带哈希的文件 create/modify 才交给并行连接；
+// 目录、删除、retype（先删后建有顺序依赖）与上游不可读项留在串行路径
+func TestIsFileDownload(t *testing.T) {
+	cases := []struct {
+		v    DiffResult
+		want bool
+	}{
+		{DiffResult{Action: "create", Hash: "h"}, true},
+		{DiffResult{Action: "modify", Hash: "h"}, true},
+		{DiffResult{Action: "create", Hash: ""}, false},
+		{DiffResult{Action: "create", IsDir: true}, false},
+		{DiffResult{Action: "delete", Hash: "h"}, false},
+		{DiffResult{Action: "retype", Hash: "h"}, false},
+	}
+	for _, c := range cases {
+		if got := isFileDownload(c.v); got != c.want {
+			t.Errorf("isFileDownload(%s, dir=%v, hash=%q) = %v, want %v", c.v.Action, c.v.IsDir, c.v.Hash, got, c.want)
+		}
+	}
+}
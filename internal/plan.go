@@ -0,0 +1,247 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// --dry-run：连上游走一轮与全量扫描相同的逐层对账（同样的忽略/订阅过滤、摘要
+// 剪枝与改名配对），diff 不交给 processDiffItem，而是记进计划。本地树来自
+// tree.OpenScratchDB 打开的缓存副本，按磁盘校准后的写入都落在副本上，
+// 同步根与 .local-mirror 都不被改动
+
+// PlanItem 计划里的一项改动
+type PlanItem struct {
+	Action string `json:"action"`         // create / overwrite / delete / rename / retype / meta / keep
+	Path   string `json:"path"`           // "/" 分隔的相对路径
+	From   string `json:"from,omitempty"` // rename 的原路径
+	IsDir  bool   `json:"is_dir,omitempty"`
+	Link   bool   `json:"symlink,omitempty"`
+	Size   uint64 `json:"size"`
+}
+
+// Plan 一轮全量对账会对本地做的改动
+type Plan struct {
+	Source     string     `json:"source"`
+	Items      []PlanItem `json:"items"`
+	Creates    int        `json:"creates"`
+	Overwrites int        `json:"overwrites"`
+	Deletes    int        `json:"deletes"`
+	Renames    int        `json:"renames"`
+	Retypes    int        `json:"retypes"`
+	Meta       int        `json:"meta"`
+	Kept       int        `json:"kept"`  // 上游已没有、因未开 --allow-delete 而保留的本地条目
+	Bytes      uint64     `json:"bytes"` // 要从上游取的文件内容总量
+	// Unscanned 没能对账的目录（连接反复失败、本地树读取出错等），非空即计划不完整
+	Unscanned []string `json:"unscanned,omitempty"`
+
+	deleted map[string]int      // 文件 delete 项的路径 → Items 下标，供跨目录移动配对时撤掉
+	pool    map[string][]string // 哈希 → 待删除的本地文件，模拟移动检测池（movepool.go）
+}
+
+// dryRun 非 nil 即处于 --dry-run，getDirectory 把 diff 记到这里
+var dryRun *Plan
+
+// DryRun 执行 --dry-run：按磁盘校准本地树，连上游逐层对账，返回计划。调用方须
+// 先用 tree.OpenScratchDB 打开缓存副本
+func DryRun() (*Plan, error) {
+	if err := tree.BuildFileTree(config.StartPath); err != nil {
+		return nil, fmt.Errorf("failed to scan the local tree: %w", err)
+	}
+	fileClient, err := InitConn()
+	if err != nil {
+		return nil, err
+	}
+	defer fileClient.ConnectionClose()
+
+	plan := newPlan(fileClient.RealityAddr)
+	if err := planScan(fileClient, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func newPlan(source string) *Plan {
+	return &Plan{Source: source, Items: []PlanItem{}, deleted: make(map[string]int), pool: make(map[string][]string)}
+}
+
+func (p *Plan) push(action string, v DiffResult, from string) {
+	it := PlanItem{
+		Action: action,
+		Path:   filepath.ToSlash(v.Path),
+		From:   filepath.ToSlash(from),
+		IsDir:  v.IsDir,
+		Link:   v.IsSymlink(),
+	}
+	if !v.IsDir {
+		it.Size = v.Size
+	}
+	p.Items = append(p.Items, it)
+}
+
+// fetches 该项落地时是否要从上游取文件内容
+func fetches(v DiffResult) bool {
+	return !v.IsDir && !v.IsSymlink() && v.Hash != ""
+}
+
+// add 记入一个目录过滤后的 diff，按 processDiffItem 的取舍分类，返回要继续下钻的
+// 目录项。--allow-delete 下先做与 detectRenames 相同的同目录改名配对
+func (p *Plan) add(diffs []DiffResult) (descend []DiffResult) {
+	if *config.AllowDelete {
+		diffs = matchRenames(diffs, func(oldDiff, newDiff DiffResult) bool {
+			p.push("rename", newDiff, oldDiff.Path)
+			p.Renames++
+			return true
+		})
+	}
+	for _, v := range diffs {
+		switch v.Action {
+		case "delete":
+			if !*config.AllowDelete {
+				p.push("keep", v, "")
+				p.Kept++
+				continue
+			}
+			if !v.IsDir {
+				p.deleted[v.Path] = len(p.Items)
+			}
+			p.push("delete", v, "")
+			p.Deletes++
+			p.stage(v)
+		case "retype":
+			if !*config.AllowDelete {
+				p.push("keep", v, "")
+				p.Kept++
+				continue
+			}
+			p.push("retype", v, "")
+			p.Retypes++
+			if fetches(v) {
+				p.Bytes += v.Size
+			}
+			if v.IsDir {
+				descend = append(descend, v)
+			}
+		case "create", "modify":
+			if v.IsDir {
+				if v.Action == "create" {
+					p.push("create", v, "")
+					p.Creates++
+				}
+				descend = append(descend, v)
+				continue
+			}
+			if v.Action == "create" && p.claim(v) {
+				continue
+			}
+			action := "create"
+			if v.Action == "modify" {
+				action = "overwrite"
+				p.Overwrites++
+			} else {
+				p.Creates++
+			}
+			p.push(action, v, "")
+			if fetches(v) {
+				p.Bytes += v.Size
+			}
+		case "meta":
+			p.push("meta", v, "")
+			p.Meta++
+			if v.IsDir {
+				descend = append(descend, v)
+			}
+		}
+	}
+	return descend
+}
+
+// stage 把要删除的文件（目录则是其下所有文件）按哈希登记，后续目录里内容相同的
+// create 会像移动检测池那样改成本地 rename
+func (p *Plan) stage(v DiffResult) {
+	if v.IsSymlink() {
+		return
+	}
+	if !v.IsDir {
+		if v.Hash != "" {
+			p.pool[v.Hash] = append(p.pool[v.Hash], v.Path)
+		}
+		return
+	}
+	children, err := tree.GetDirContents(v.Path)
+	if err != nil {
+		return
+	}
+	for _, c := range children {
+		p.stage(DiffResult{Path: c.Path, IsDir: c.IsDir, Hash: c.Hash, Meta: c.Meta})
+	}
+}
+
+// claim 用登记过的待删除文件满足一次文件 create（同 claimMove 取最后登记的一份）
+func (p *Plan) claim(v DiffResult) bool {
+	if v.Hash == "" || v.IsSymlink() {
+		return false
+	}
+	cands := p.pool[v.Hash]
+	if len(cands) == 0 {
+		return false
+	}
+	from := cands[len(cands)-1]
+	p.pool[v.Hash] = cands[:len(cands)-1]
+	if i, ok := p.deleted[from]; ok {
+		// 被认领的文件并没有删掉：撤下它的 delete 项
+		p.Items[i].Action = "rename"
+		p.Items[i].From = p.Items[i].Path
+		p.Items[i].Path = filepath.ToSlash(v.Path)
+		delete(p.deleted, from)
+		p.Deletes--
+	} else {
+		p.push("rename", v, from)
+	}
+	p.Renames++
+	return true
+}
+
+// WriteJSON 以 JSON 输出计划
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteTable 以表格输出计划：逐项一行，末尾汇总
+func (p *Plan) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ACTION\tPATH\tSIZE\n")
+	for _, it := range p.Items {
+		path := it.Path
+		if it.IsDir {
+			path += "/"
+		}
+		if it.From != "" {
+			path = it.From + " -> " + path
+		}
+		size := "-"
+		if !it.IsDir && !it.Link {
+			size = humanBytes(it.Size)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", it.Action, path, size)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nplan against %s: %d create, %d overwrite, %d delete, %d rename, %d retype, %d metadata; %s to transfer\n",
+		p.Source, p.Creates, p.Overwrites, p.Deletes, p.Renames, p.Retypes, p.Meta, humanBytes(p.Bytes))
+	if p.Kept > 0 {
+		fmt.Fprintf(w, "%d local entries are gone upstream and kept (--allow-delete would delete them)\n", p.Kept)
+	}
+	if len(p.Unscanned) > 0 {
+		fmt.Fprintf(w, "incomplete: %d directories could not be compared: %v\n", len(p.Unscanned), p.Unscanned)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+)
+
+// TestPlanClassifiesLikeProcessDiffItem 计划按 processDiffItem 的取舍分类：
+// 同目录同哈希配成改名，删掉的目录里的文件被别处 create 认领时记成跨目录移动，
+// 只有没被认领的才算删除；未开 --allow-delete 时删除与改类型都只记为保留
+func TestPlanClassifiesLikeProcessDiffItem(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+	saveDel := config.AllowDelete
+	defer func() { config.AllowDelete = saveDel }()
+	on, off := true, false
+
+	for rel, content := range map[string]string{"old/a.bin": "moved", "old/b.bin": "gone"} {
+		full := filepath.Join(root, rel)
+		os.MkdirAll(filepath.Dir(full), 0o755)
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := tree.GetNodeByPath(filepath.Join("old", "a.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.AllowDelete = &on
+	p := newPlan("test")
+	descend := p.add([]DiffResult{
+		{Path: "old", IsDir: true, Action: "delete"},
+		{Path: "x.txt", Action: "delete", Hash: "h1", Size: 2},
+		{Path: "y.txt", Action: "create", Hash: "h1", Size: 2},
+		{Path: "new", IsDir: true, Action: "create"},
+		{Path: "f.txt", Action: "modify", Hash: "h2", Size: 7},
+	})
+	if len(descend) != 1 || descend[0].Path != "new" {
+		t.Fatalf("只应下钻新目录: %+v", descend)
+	}
+	p.add([]DiffResult{{Path: filepath.Join("new", "a.bin"), Action: "create", Hash: moved.Hash, Size: moved.Size}})
+
+	if p.Renames != 2 || p.Deletes != 1 || p.Creates != 1 || p.Overwrites != 1 {
+		t.Fatalf("计数不对: %+v", p)
+	}
+	if p.Bytes != 7 {
+		t.Fatalf("只有覆盖的 f.txt 需要传输，实际 %d 字节", p.Bytes)
+	}
+	var renamed []string
+	for _, it := range p.Items {
+		if it.Action == "rename" {
+			renamed = append(renamed, it.From+" -> "+it.Path)
+		}
+	}
+	if len(renamed) != 2 || renamed[0] != "x.txt -> y.txt" || renamed[1] != "old/a.bin -> new/a.bin" {
+		t.Fatalf("改名项不对: %v", renamed)
+	}
+
+	config.AllowDelete = &off
+	p = newPlan("test")
+	p.add([]DiffResult{
+		{Path: "x.txt", Action: "delete", Hash: "h1"},
+		{Path: "y.txt", Action: "create", Hash: "h1", Size: 2},
+		{Path: "z", Action: "retype", IsDir: true},
+	})
+	if p.Kept != 2 || p.Renames != 0 || p.Deletes != 0 || p.Creates != 1 {
+		t.Fatalf("未开 --allow-delete 时删除/改类型应只记为保留: %+v", p)
+	}
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"local-mirror/config"
 	"local-mirror/internal/network"
+	"local-mirror/internal/status"
 	"net"
 	"strconv"
 
@@ -27,14 +28,20 @@ func Reality() {
 
 // RealityDial 源拨出格（--send --connect，四象限）：不监听，主动拨向
 // 监听中的汇并在拨出的连接上服务。端口缺省用 DefaultPort——公网部署
-// 钉死单端口，不做端口段扫描（那是局域网发现时代的特性）
+// 钉死单端口，不做端口段扫描（那是局域网发现时代的特性）。
+// --connect 给了多个汇即扇出推送：共用一个文件服务器（同一棵树、同一个
+// watcher），每个汇一路独立的拨号循环，一路断开重拨不影响其他各路
 func RealityDial() {
 	log.Debug("step 3 >> start file server (dial-out)")
-	host, port := network.SplitPeer(*config.RealityIP)
-	if port == 0 {
-		port = config.DefaultPort
+	server := network.NewFileServerDial()
+	for _, target := range config.DialTargets {
+		host, port := network.SplitPeer(target)
+		if port == 0 {
+			port = config.DefaultPort
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		status.SetLink(addr, false, "dialing")
+		// StartDial 阻塞：拨号、服务、断开重拨（退避归拨号方）
+		go server.StartDial(addr)
 	}
-	addr := net.JoinHostPort(host, strconv.Itoa(port))
-	// StartDial 阻塞：拨号、服务、断开重拨（退避归拨号方）
-	network.NewFileServerDial().StartDial(addr)
 }
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+	"local-mirror/internal/watcher"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadDebounce 文件变化到重载的防抖：编辑器保存常是"写临时文件 + rename"
+// 一串事件，合并成一次重载
+const reloadDebounce = 500 * time.Millisecond
+
+var (
+	// reloadMu 串行化重载：SIGHUP、ignore 文件变化、单任务 YAML 变化可能同时触发
+	reloadMu sync.Mutex
+	// ignoreReloads 忽略列表实际变化的次数。汇引擎每轮长轮询后比对它，
+	// 变了就补一轮全量扫描（见 runMirrorTasks）
+	ignoreReloads atomic.Uint64
+	// hupReload SIGHUP 触发的重载，由 App 的信号循环调用（见 SetHUPReload）
+	hupReload = ReloadIgnores
+)
+
+// SetHUPReload 换掉 SIGHUP 的重载动作。单任务 --config 模式要先重读 YAML：由 App
+// 这一处收信号，一个 SIGHUP 只重载一次。须在 App 之前调用
+func SetHUPReload(fn func()) {
+	hupReload = fn
+}
+
+// ReloadIgnores 重新合并忽略列表（-i 旗子 + .local-mirror/ignore，见
+// config.LoadIgnoreList；--gitignore 时各层 .gitignore 一并重读）并就地生效。源端把新忽略的子树移出树、把不再被忽略的
+// 补进树，下游照常经变更日志收到删除与新增；汇端补一轮全量扫描拉取不再被忽略的
+// 路径。新忽略的路径在汇端原样保留——忽略的语义是"不同步"，不是"删除"。
+// 新列表不合法（如未闭合的 "["）时记日志并保留原列表
+func ReloadIgnores() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadIgnoresLocked()
+}
+
+// ReloadIgnoresWith 以新的 -i 值重载，供单任务 --config 模式在 YAML 的
+// ignore 字段变化时调用；旗子的写入与重载在同一把锁内，不与并发重载交错
+func ReloadIgnoresWith(flagValue string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	*config.Ignore = flagValue
+	reloadIgnoresLocked()
+}
+
+func reloadIgnoresLocked() {
+	before := config.CurrentIgnores()
+	if err := config.LoadIgnoreList(config.StartPath); err != nil {
+		log.Errorf("ignore rules not reloaded, keeping the current ones: %v", err)
+		return
+	}
+	now := config.CurrentIgnores()
+	// .gitignore 总是重读：匹配器换成了空缓存的新实例，单比列表看不出它变没变
+	if slices.Equal(before.List, now.List) && before.Git == nil && now.Git == nil {
+		log.Info("ignore rules reloaded, nothing changed")
+		return
+	}
+	log.Infof("ignore rules reloaded: %s", strings.Join(now.List, ", "))
+	walkRoot := ""
+	if dropsPattern(before.List, now.List) || before.Git != nil || now.Git != nil {
+		walkRoot = "."
+	}
+	applyIgnoreChange(before, now, walkRoot)
+	if !config.ServesDownstream() {
+		// 纯汇端的本地树同样按忽略规则建，全量扫描前先按磁盘重建一次
+		SuspectLocalDrift()
+	}
+	ignoreReloads.Add(1)
+}
+
+// gitignoreChanged 某目录下的 .gitignore 变了：重读该目录的规则，本端供数时只在
+// 这个目录下校准树——.gitignore 的规则管不到它所在目录之外。汇端不为此补扫：
+// 新规则对后续 diff 过滤即刻生效，不再被忽略的路径由源端补进树后经变更日志送来，
+// 兜底是下一次全量扫描
+func gitignoreChanged(relDir string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	before, now := config.GitignoreChanged(relDir)
+	if before.Git == nil {
+		return
+	}
+	log.Infof("%s changed, re-applying its rules", filepath.Join(relDir, ".gitignore"))
+	applyIgnoreChange(before, now, relDir)
+}
+
+// noteGitignore 汇引擎应用了一个 .gitignore 的变更（新建、修改或删除）之后调用
+func noteGitignore(relPath string) {
+	if filepath.Base(relPath) == ".gitignore" {
+		gitignoreChanged(filepath.Dir(relPath))
+	}
+}
+
+// watchGitignores 接上 watcher 报来的 .gitignore 事件，按目录防抖后重读
+func watchGitignores() {
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+	watcher.OnGitignoreChange = func(relDir string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := timers[relDir]; ok {
+			t.Stop()
+		}
+		timers[relDir] = time.AfterFunc(reloadDebounce, func() {
+			mu.Lock()
+			delete(timers, relDir)
+			mu.Unlock()
+			gitignoreChanged(relDir)
+		})
+	}
+}
+
+// applyIgnoreChange 规则从 before 变为 now 之后校准本端供数的树，walkRoot 见
+// watcher.ApplyIgnoreChange；纯汇端的树不对外，无需校准
+func applyIgnoreChange(before, now config.IgnoreRules, walkRoot string) {
+	if !config.ServesDownstream() {
+		return
+	}
+	pruned, err := tree.PruneIgnored(before, now)
+	if err != nil {
+		log.Errorf("ignore reload: failed to prune newly ignored paths: %v", err)
+	} else if len(pruned) > 0 {
+		log.Infof("ignore reload: %d newly ignored subtree(s) removed from the tree", len(pruned))
+	}
+	// 中继的树由汇引擎维护、没有 watcher，不再被忽略的路径由汇引擎从上游补齐
+	if *config.Mode != "relay" {
+		watcher.ApplyIgnoreChange(before, pruned, walkRoot)
+	}
+}
+
+// WatchFile 监听单个文件的变化（新建、写入、rename 覆盖），防抖后调用 onChange。
+// 监听的是所在目录：编辑器的原子保存会换掉文件的 inode，直接 watch 文件会在
+// 第一次保存后失效。目录须已存在；返回的函数停止监听
+func WatchFile(path string, onChange func()) (func(), error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Clean(path)
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != path || ev.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDebounce, onChange)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("watching %s: %v", path, err)
+			}
+		}
+	}()
+	return func() { w.Close() }, nil
+}
+
+// dropsPattern 新列表是否去掉了旧列表里的某个模式（只有这时才可能有路径重见天日）
+func dropsPattern(before, now []string) bool {
+	keep := make(map[string]struct{}, len(now))
+	for _, p := range now {
+		keep[p] = struct{}{}
+	}
+	for _, p := range before {
+		if _, ok := keep[p]; !ok {
+			return true
+		}
+	}
+	return false
+}
@@ -88,6 +88,55 @@ func SafeResolve(root, rel string) (string, error) {
 	return full, nil
 }
 
+// SafeResolveEntry 与 SafeResolve 相同，但末段允许是符号链接：只校验父目录链。
+// 用于作用在条目本身、不解引用末段的操作——删除（unlink 删的是链接而非目标）、
+// 以 rename 覆盖、建符号链接。写入文件内容等会跟随末段的操作仍须用 SafeResolve
+func SafeResolveEntry(root, rel string) (string, error) {
+	full, err := SafeJoin(root, rel)
+	if err != nil {
+		return "", err
+	}
+	if err := VerifyNoSymlinkComponents(root, filepath.Dir(filepath.Clean(rel))); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// SymlinkTarget 校验位于 root 下 linkRel 处、指向 target 的符号链接不会指出同步根，
+// 返回清洗后的目标（本平台分隔符）。源端据此决定链接能否入树，汇端在建链接前
+// 对上游下发的目标再校验一遍——target 来自对端，与路径同属不可信输入。
+//
+// 规则：目标必须是相对路径；以链接所在目录为基准做词法清洗后仍须落在根内，
+// 且不得指向或穿过状态目录 .local-mirror。清洗后 ".." 只可能出现在开头，
+// 随后按真实父目录解析；父目录链本身由 VerifyNoSymlinkComponents 保证无链接，
+// 因此「每个链接词法在根内」归纳地保证了链接链整体在根内。调用方须用返回的
+// 清洗结果建链接，而不是原始 target——否则 "x/../../y" 这类目标在 x 本身是
+// 链接时，内核按物理路径解析的结果与这里的词法判断不一致
+func SymlinkTarget(root, linkRel, target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("symlink %s has an empty target", linkRel)
+	}
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" ||
+		strings.HasPrefix(target, "/") || strings.HasPrefix(target, `\`) {
+		return "", fmt.Errorf("symlink %s has an absolute target %q", linkRel, target)
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(target))
+	resolved := filepath.Join(filepath.Dir(filepath.Clean(linkRel)), cleaned)
+	full, err := SafeJoin(root, resolved)
+	if err != nil {
+		return "", fmt.Errorf("symlink %s points outside the sync root: %q", linkRel, target)
+	}
+	if full == filepath.Clean(root) {
+		return cleaned, nil
+	}
+	for _, seg := range strings.Split(filepath.Clean(resolved), string(filepath.Separator)) {
+		if seg == ".local-mirror" {
+			return "", fmt.Errorf("symlink %s points into the state directory: %q", linkRel, target)
+		}
+	}
+	return cleaned, nil
+}
+
 // 关键路径分两类，语义不同：
 //
 // criticalSubtrees：系统管理的目录树，**其内部任意子目录**都算关键路径。
@@ -54,3 +54,37 @@ func TestSafeJoinPrefixNotSubdir(t *testing.T) {
 		t.Error("prefix-but-not-subdir path accepted")
 	}
 }
+
+// TestSymlinkTarget 链接目标按链接所在目录词法解析，必须留在同步根内、不进状态目录；
+// 返回清洗后的目标供建链接
+func TestSymlinkTarget(t *testing.T) {
+	root := filepath.Join("/srv", "sync")
+	okCases := map[[2]string]string{
+		{"a/link", "b.txt"}:        "b.txt",
+		{"a/link", "../c/d"}:       filepath.Join("..", "c", "d"),
+		{"a/b/link", "../../top"}:  filepath.Join("..", "..", "top"),
+		{"a/link", "x/../../y"}:    filepath.Join("..", "y"), // 用清洗结果建链接
+		{"link", "."}:              ".",
+		{"a/link", "./sub/./file"}: filepath.Join("sub", "file"),
+	}
+	for in, want := range okCases {
+		got, err := SymlinkTarget(root, filepath.FromSlash(in[0]), in[1])
+		if err != nil || got != want {
+			t.Errorf("SymlinkTarget(%q, %q) = %q, %v; want %q", in[0], in[1], got, err, want)
+		}
+	}
+	badCases := [][2]string{
+		{"link", "../outside"},
+		{"a/link", "../../outside"},
+		{"a/link", "x/../../../y"},
+		{"link", "/etc/passwd"},
+		{"link", ""},
+		{"link", ".local-mirror/cache.db"},
+		{"a/link", "../.local-mirror"},
+	}
+	for _, in := range badCases {
+		if got, err := SymlinkTarget(root, filepath.FromSlash(in[0]), in[1]); err == nil {
+			t.Errorf("SymlinkTarget(%q, %q) = %q, 应被拒", in[0], in[1], got)
+		}
+	}
+}
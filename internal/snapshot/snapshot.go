@@ -0,0 +1,483 @@
+// Package snapshot 实现汇端的时间点快照（--snapshots）：全量扫描干净结束后，
+// 把副本物化为 <同步根>/.local-mirror/snapshots/<时间戳>/ 下的一棵硬链接树，
+// 另写一份清单 <时间戳>.json 记录每个文件的哈希、大小与修改时间。
+//
+// 未变的文件在相邻快照之间共享同一个 inode，不占额外空间；文件之后被改动时，
+// 同步引擎总是「写新文件 + rename 替换」，从不原地改写，快照里链接着的旧 inode
+// 因此保持原样——就是那一刻的真实副本。副本被外部程序原地改写则不在此保证之内。
+//
+// 清单最后写入：有清单才算完整快照，创建中途崩溃留下的半成品在下次
+// Take/Prune 时清掉。
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"local-mirror/pkg/utils"
+)
+
+// nameLayout 快照目录名：UTC、字典序即时间序、不含冒号（Windows 文件名合法）
+const nameLayout = "2006-01-02T150405Z"
+
+// tmpPrefix 创建中的快照目录前缀，完成后改名为正式名
+const tmpPrefix = ".tmp-"
+
+// Entry 清单中的一项，取自汇端的树索引
+type Entry struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+	Size    uint64    `json:"size,omitempty"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Manifest 快照清单
+type Manifest struct {
+	Name    string    `json:"name"`
+	Taken   time.Time `json:"taken"`
+	Files   int       `json:"files"`
+	Bytes   uint64    `json:"bytes"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Info 一个完整快照的概要（不含清单条目）
+type Info struct {
+	Name  string
+	Taken time.Time
+	Files int
+	Bytes uint64
+}
+
+// Dir 快照库根目录
+func Dir(root string) string {
+	return filepath.Join(root, ".local-mirror", "snapshots")
+}
+
+// TreeDir 某个快照的文件树目录
+func TreeDir(root, name string) string {
+	return filepath.Join(Dir(root), name)
+}
+
+func manifestPath(root, name string) string {
+	return filepath.Join(Dir(root), name+".json")
+}
+
+// Take 以 entries 为准物化一个快照并返回其概要。文件逐个硬链接（文件系统不支持
+// 硬链接时退回复制）；磁盘上已不是清单所记大小的普通文件视为漂移，跳过且不进清单，
+// 保证清单与快照内容一致。skipped 为跳过的文件数
+func Take(root string, entries []Entry, now time.Time) (info Info, skipped int, err error) {
+	cleanupPartial(root)
+	name := now.UTC().Format(nameLayout)
+	if _, err := os.Lstat(TreeDir(root, name)); err == nil {
+		return Info{}, 0, fmt.Errorf("snapshot %s already exists", name)
+	}
+	tmp := TreeDir(root, tmpPrefix+name)
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return Info{}, 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	m := Manifest{Name: name, Taken: now.UTC()}
+	for _, e := range entries {
+		if e.Path == "." || e.Path == "" {
+			continue
+		}
+		dst := filepath.Join(tmp, e.Path)
+		if e.IsDir {
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return Info{}, 0, err
+			}
+			m.Entries = append(m.Entries, e)
+			continue
+		}
+		src := filepath.Join(root, e.Path)
+		st, lerr := os.Lstat(src)
+		if lerr != nil || !st.Mode().IsRegular() || uint64(st.Size()) != e.Size {
+			skipped++
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return Info{}, 0, err
+		}
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return Info{}, 0, fmt.Errorf("snapshotting %s: %w", e.Path, err)
+			}
+		}
+		m.Entries = append(m.Entries, e)
+		m.Files++
+		m.Bytes += e.Size
+	}
+
+	if err := os.Rename(tmp, TreeDir(root, name)); err != nil {
+		return Info{}, 0, err
+	}
+	if err := writeManifest(root, &m); err != nil {
+		os.RemoveAll(TreeDir(root, name))
+		return Info{}, 0, err
+	}
+	return Info{Name: name, Taken: m.Taken, Files: m.Files, Bytes: m.Bytes}, skipped, nil
+}
+
+func writeManifest(root string, m *Manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := manifestPath(root, m.Name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cleanupPartial 清掉创建中途崩溃留下的半成品：临时目录，以及没有清单的快照目录
+func cleanupPartial(root string) {
+	entries, err := os.ReadDir(Dir(root))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".json.tmp") {
+			os.Remove(filepath.Join(Dir(root), name))
+			continue
+		}
+		if !e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(name, tmpPrefix) {
+			os.RemoveAll(filepath.Join(Dir(root), name))
+			continue
+		}
+		if _, err := os.Stat(manifestPath(root, name)); os.IsNotExist(err) {
+			os.RemoveAll(filepath.Join(Dir(root), name))
+		}
+	}
+}
+
+// List 列出全部完整快照，按时间从旧到新
+func List(root string) ([]Info, error) {
+	entries, err := os.ReadDir(Dir(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Info
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(nameLayout, name); err != nil {
+			continue
+		}
+		m, err := Load(root, name)
+		if err != nil {
+			continue
+		}
+		out = append(out, Info{Name: m.Name, Taken: m.Taken, Files: m.Files, Bytes: m.Bytes})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Load 读取快照清单
+func Load(root, name string) (*Manifest, error) {
+	data, err := os.ReadFile(manifestPath(root, name))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("snapshot %s: corrupt manifest: %w", name, err)
+	}
+	return &m, nil
+}
+
+// ErrNotFound 按名称找不到快照
+var ErrNotFound = errors.New("snapshot not found")
+
+// Resolve 把用户给的快照名解析成完整名：完整名、唯一前缀（如日期 "2026-03-01"）
+// 或 "latest"。前缀匹配多个时取其中最新的一个——按天指定时通常就想要那天的最终状态
+func Resolve(root, spec string) (string, error) {
+	all, err := List(root)
+	if err != nil {
+		return "", err
+	}
+	if len(all) == 0 {
+		return "", ErrNotFound
+	}
+	if spec == "latest" {
+		return all[len(all)-1].Name, nil
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		if strings.HasPrefix(all[i].Name, spec) {
+			return all[i].Name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrNotFound, spec)
+}
+
+// Delete 删除一个快照：先删清单（使其不再算完整快照），再删文件树
+func Delete(root, name string) error {
+	if err := os.Remove(manifestPath(root, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.RemoveAll(TreeDir(root, name))
+}
+
+// Change 两个状态之间一个路径的差异：Kind 为 '+'（新增）、'-'（消失）、'M'（内容变化）
+type Change struct {
+	Kind byte
+	Path string
+}
+
+// Diff 比较两份清单（old → new），按路径排序。文件以哈希判定内容变化，
+// 目录只报新增与消失
+func Diff(old, new []Entry) []Change {
+	before := make(map[string]Entry, len(old))
+	for _, e := range old {
+		before[e.Path] = e
+	}
+	var out []Change
+	seen := make(map[string]bool, len(new))
+	for _, e := range new {
+		seen[e.Path] = true
+		b, ok := before[e.Path]
+		switch {
+		case !ok || b.IsDir != e.IsDir:
+			if ok {
+				out = append(out, Change{'-', e.Path})
+			}
+			out = append(out, Change{'+', e.Path})
+		case !e.IsDir && b.Hash != e.Hash:
+			out = append(out, Change{'M', e.Path})
+		}
+	}
+	for _, e := range old {
+		if !seen[e.Path] {
+			out = append(out, Change{'-', e.Path})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// Scan 按磁盘现状生成与清单同构的条目，用于拿快照与当前副本比较。忽略规则与
+// 同步一致（ignored 即 config.IsIgnored），known 中大小与修改时间都未变的
+// 文件直接复用其哈希，其余现算
+func Scan(root string, ignored func(rel string, isDir bool) bool, known []Entry) ([]Entry, error) {
+	cache := make(map[string]Entry, len(known))
+	for _, e := range known {
+		cache[e.Path] = e
+	}
+	var out []Entry
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			out = append(out, Entry{Path: rel, IsDir: true, ModTime: info.ModTime()})
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		e := Entry{Path: rel, Size: uint64(info.Size()), ModTime: info.ModTime()}
+		if k, ok := cache[rel]; ok && k.Size == e.Size && k.ModTime.Equal(e.ModTime) {
+			e.Hash = k.Hash
+		} else {
+			h, err := utils.CalcBlake3(path)
+			if err != nil {
+				return err
+			}
+			e.Hash = fmt.Sprintf("%x", h)
+		}
+		out = append(out, e)
+		return nil
+	})
+	return out, err
+}
+
+// Restore 把快照 name 中 rel 之下（rel 为 "." 即整个快照）的文件复制到 dest 下
+// 对应的相对位置，返回复制的文件数。总是复制而非链接：取回的文件随后被原地编辑，
+// 也不会连带改掉快照。快照里没有的文件原样保留——取回是加法，不删东西
+func Restore(root, name, rel, dest string) (int, error) {
+	base := TreeDir(root, name)
+	start := filepath.Join(base, rel)
+	if _, err := os.Lstat(start); err != nil {
+		return 0, fmt.Errorf("%s is not in snapshot %s", rel, name)
+	}
+	n := 0
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(start, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dest, sub)
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		tmp := dst + ".restore.tmp"
+		if err := copyFile(path, tmp); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Retention 快照保留策略：最近 Hourly 个小时、Daily 天、Weekly 周里各保留
+// 每个时段最新的一个快照，任一档选中即保留。0 表示该档不保留。
+// 字段与 config.Retention（解析后的 --snapshot-retain）一致，可直接转换
+type Retention struct {
+	Hourly, Daily, Weekly int
+}
+
+// bucket 某时刻在给定档位下所属时段的标识（本地时间：「每天」按用户的日历划分）
+func bucket(t time.Time, class string) string {
+	t = t.Local()
+	switch class {
+	case "hourly":
+		return t.Format("2006-01-02T15")
+	case "daily":
+		return t.Format("2006-01-02")
+	default:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	}
+}
+
+// classes 按从细到粗的顺序列出启用的档位及其保留数
+func (r Retention) classes() []struct {
+	name string
+	keep int
+} {
+	all := []struct {
+		name string
+		keep int
+	}{{"hourly", r.Hourly}, {"daily", r.Daily}, {"weekly", r.Weekly}}
+	out := all[:0]
+	for _, c := range all {
+		if c.keep > 0 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Due 是否该拍新快照：最细的启用档位上，最新快照与 now 不在同一时段
+func (r Retention) Due(latest time.Time, now time.Time) bool {
+	cs := r.classes()
+	if latest.IsZero() || len(cs) == 0 {
+		return true
+	}
+	return bucket(latest, cs[0].name) != bucket(now, cs[0].name)
+}
+
+// Keep 从快照列表（旧→新）中选出按策略应保留的名称
+func (r Retention) Keep(snaps []Info) map[string]bool {
+	keep := map[string]bool{}
+	if len(snaps) > 0 {
+		keep[snaps[len(snaps)-1].Name] = true // 最新的一个永远保留
+	}
+	for _, c := range r.classes() {
+		seen := map[string]bool{}
+		for i := len(snaps) - 1; i >= 0 && len(seen) < c.keep; i-- {
+			b := bucket(snaps[i].Taken, c.name)
+			if !seen[b] {
+				seen[b] = true
+				keep[snaps[i].Name] = true
+			}
+		}
+	}
+	return keep
+}
+
+// Prune 按保留策略删除多余快照，返回删除的名称
+func Prune(root string, r Retention) ([]string, error) {
+	cleanupPartial(root)
+	snaps, err := List(root)
+	if err != nil {
+		return nil, err
+	}
+	keep := r.Keep(snaps)
+	var removed []string
+	for _, s := range snaps {
+		if keep[s.Name] {
+			continue
+		}
+		if err := Delete(root, s.Name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, s.Name)
+	}
+	return removed, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
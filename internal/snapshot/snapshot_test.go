@@ -0,0 +1,134 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"local-mirror/pkg/utils"
+)
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func entriesOf(t *testing.T, root string) []Entry {
+	t.Helper()
+	es, err := Scan(root, func(rel string, _ bool) bool { return utils.IsIgnored(rel, []string{".local-mirror"}) }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+// TestTakeSurvivesReplaceByRename 快照是硬链接树：同步引擎以「写新文件 + rename」
+// 覆盖副本后，快照里仍是拍摄时的内容；清单与 Diff 如实反映两次快照之间的变化
+func TestTakeSurvivesReplaceByRename(t *testing.T) {
+	root := t.TempDir()
+	write(t, filepath.Join(root, "a.txt"), "v1")
+	write(t, filepath.Join(root, "dir", "b.txt"), "keep")
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	first, skipped, err := Take(root, entriesOf(t, root), t0)
+	if err != nil || skipped != 0 {
+		t.Fatalf("Take: %v (skipped %d)", err, skipped)
+	}
+	if first.Files != 2 {
+		t.Fatalf("应快照 2 个文件，实际 %d", first.Files)
+	}
+
+	write(t, filepath.Join(root, "a.txt.tmp"), "v2")
+	if err := os.Rename(filepath.Join(root, "a.txt.tmp"), filepath.Join(root, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(root, "dir", "b.txt"))
+	write(t, filepath.Join(root, "c.txt"), "new")
+
+	if b, _ := os.ReadFile(filepath.Join(TreeDir(root, first.Name), "a.txt")); string(b) != "v1" {
+		t.Fatalf("快照内容应保持 v1，实际 %q", b)
+	}
+	second, _, err := Take(root, entriesOf(t, root), t0.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, _ := Load(root, first.Name)
+	m2, _ := Load(root, second.Name)
+	got := map[string]byte{}
+	for _, c := range Diff(m1.Entries, m2.Entries) {
+		got[c.Path] = c.Kind
+	}
+	want := map[string]byte{"a.txt": 'M', filepath.Join("dir", "b.txt"): '-', "c.txt": '+'}
+	if len(got) != len(want) {
+		t.Fatalf("Diff = %v, want %v", got, want)
+	}
+	for p, k := range want {
+		if got[p] != k {
+			t.Errorf("%s: got %c, want %c", p, got[p], k)
+		}
+	}
+
+	// 取回到别处是复制：改动取回的文件不影响快照
+	dest := filepath.Join(t.TempDir(), "out")
+	if n, err := Restore(root, first.Name, ".", dest); err != nil || n != 2 {
+		t.Fatalf("Restore: n=%d err=%v", n, err)
+	}
+	write(t, filepath.Join(dest, "a.txt"), "edited")
+	if b, _ := os.ReadFile(filepath.Join(TreeDir(root, first.Name), "a.txt")); string(b) != "v1" {
+		t.Fatal("取回的文件与快照不应共享存储")
+	}
+}
+
+// TestTakeSkipsDriftAndCleansPartial 与清单不符的文件不进快照；没有清单的半成品
+// 目录不算快照，并在下次 Take 时清掉
+func TestTakeSkipsDriftAndCleansPartial(t *testing.T) {
+	root := t.TempDir()
+	write(t, filepath.Join(root, "a.txt"), "abc")
+	es := []Entry{{Path: "a.txt", Size: 99}, {Path: "gone.txt", Size: 1}}
+	os.MkdirAll(TreeDir(root, "2026-01-01T000000Z"), 0755)
+	info, skipped, err := Take(root, es, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 2 || info.Files != 0 {
+		t.Errorf("漂移文件应全部跳过: skipped=%d files=%d", skipped, info.Files)
+	}
+	snaps, _ := List(root)
+	if len(snaps) != 1 {
+		t.Fatalf("只应有 1 个完整快照，实际 %+v", snaps)
+	}
+	if _, err := os.Stat(TreeDir(root, "2026-01-01T000000Z")); !os.IsNotExist(err) {
+		t.Error("没有清单的半成品应被清掉")
+	}
+	if name, err := Resolve(root, "2026-03"); err != nil || name != info.Name {
+		t.Errorf("前缀应解析到 %s，实际 %q (%v)", info.Name, name, err)
+	}
+}
+
+// TestRetention 各档保留每个时段最新的一个，任一档选中即保留；最新快照永远保留
+func TestRetention(t *testing.T) {
+	r := Retention{Hourly: 2, Daily: 2}
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	var snaps []Info
+	for _, d := range []time.Duration{-49 * time.Hour, -25 * time.Hour, -24 * time.Hour, -2 * time.Hour, -90 * time.Minute, -time.Hour} {
+		ts := base.Add(d)
+		snaps = append(snaps, Info{Name: ts.UTC().Format(nameLayout), Taken: ts})
+	}
+	keep := r.Keep(snaps)
+	// 小时档：最近两个小时时段 = -1h、-90min(10:30)；-2h 与 -90min 同在 10 点，取较新者
+	// 天档：今天最新 = -1h，昨天最新 = -24h
+	for i, want := range []bool{false, false, true, false, true, true} {
+		if keep[snaps[i].Name] != want {
+			t.Errorf("snapshot %d (%v): keep=%v, want %v", i, snaps[i].Taken, keep[snaps[i].Name], want)
+		}
+	}
+
+	if !r.Due(time.Time{}, base) || r.Due(base, base.Add(time.Minute)) || !r.Due(base, base.Add(time.Hour)) {
+		t.Error("Due 应按最细档位（小时）判定")
+	}
+}
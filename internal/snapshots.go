@@ -0,0 +1,66 @@
+package app
+
+import (
+	"local-mirror/config"
+	"local-mirror/internal/snapshot"
+	"local-mirror/internal/tree"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotAfterScan --snapshots 下在全量扫描干净结束后拍快照：此刻本地树刚与上游
+// 逐目录比对过，是副本最接近「上游某一时刻」的状态。最细的保留档位上已有本时段
+// 的快照就不再拍（默认每小时至多一个），随后按保留策略修剪旧快照。
+// 快照失败只告警，不影响同步本身
+func snapshotAfterScan() {
+	if !*config.Snapshots {
+		return
+	}
+	now := time.Now()
+	snaps, err := snapshot.List(config.StartPath)
+	if err != nil {
+		log.Warnf("snapshot: listing %s: %v", snapshot.Dir(config.StartPath), err)
+		return
+	}
+	var latest time.Time
+	if len(snaps) > 0 {
+		latest = snaps[len(snaps)-1].Taken
+	}
+	if !snapshot.Retention(config.SnapshotRetention).Due(latest, now) {
+		return
+	}
+
+	nodes, err := tree.LoadAllNodesByPath()
+	if err != nil {
+		log.Warnf("snapshot: loading the local tree: %v", err)
+		return
+	}
+	entries := make([]snapshot.Entry, 0, len(nodes))
+	for _, n := range nodes {
+		if n.IsSymlink() {
+			continue // 快照只收文件内容，符号链接不进快照
+		}
+		entries = append(entries, snapshot.Entry{
+			Path: n.Path, IsDir: n.IsDir, Hash: n.Hash, Size: n.Size, ModTime: n.ModTime,
+		})
+	}
+	info, skipped, err := snapshot.Take(config.StartPath, entries, now)
+	if err != nil {
+		log.Warnf("snapshot: %v", err)
+		return
+	}
+	if skipped > 0 {
+		// 树索引与磁盘不一致的文件（外部改动、尚未校准）不进快照，下次全量扫描后恢复
+		log.Warnf("snapshot %s: %d files skipped (changed on disk since the last scan)", info.Name, skipped)
+	}
+	log.Infof("snapshot %s taken: %d files, %d bytes", info.Name, info.Files, info.Bytes)
+
+	removed, err := snapshot.Prune(config.StartPath, snapshot.Retention(config.SnapshotRetention))
+	if err != nil {
+		log.Warnf("snapshot: pruning: %v", err)
+	}
+	if len(removed) > 0 {
+		log.Infof("snapshot: pruned %d old snapshots", len(removed))
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -24,8 +25,12 @@ import (
 )
 
 // SchemaVersion status.json 的结构版本，读端据此容错跨版本字段变化。
-// v2：新增进行中传输（current_*）、速率、自采资源（cpu/rss/fd/heap）
-const SchemaVersion = 2
+// v2：新增进行中传输（current_*）、速率、自采资源（cpu/rss/fd/heap）；
+// v3：新增线上压缩统计（wire_*）；v4：新增带宽限制（bwlimit_*）；
+// v5：新增本地去重统计（dedup_*）；v6：新增扇出推送的逐路连接状态（links）；
+// v7：新增身份模式的本端指纹与活跃会话的对端身份（identity、peer_identities）；
+// v8：新增 TLS 传输的本端证书指纹（tls_cert）
+const SchemaVersion = 8
 
 // idleInterval/activeInterval 落盘节奏：连接活跃时 1s（供 --status 实时刷新
 // 看到速率/进度/资源），空闲时 5s。读端以 3×idleInterval 为陈旧判据
@@ -52,6 +57,8 @@ type Snapshot struct {
 	Transport string `json:"transport"` // "listen" / "dial"
 	Peer      string `json:"peer"`      // 对端地址（拨出）或 "inbound"（监听）
 	Encrypted bool   `json:"encrypted"`
+	Identity  string `json:"identity,omitempty"` // 身份模式（--identity）下本实例的公钥指纹
+	TLSCert   string `json:"tls_cert,omitempty"` // TLS 传输（--transport tls）下本端证书的指纹
 
 	StartedUnix int64 `json:"started_unix"`
 
@@ -65,13 +72,33 @@ type Snapshot struct {
 	Bytes        uint64 `json:"bytes"`          // 累计传输字节数
 	Errors       uint64 `json:"errors"`         // 累计连接级错误数
 
-	// 进行中的传输。收方下载严格串行（协议单飞行），故精确；
-	// 发方扇出多下游时为最后写入者（展示近似，不影响累计计数）
+	// 身份模式下活跃会话的对端身份（"标签 (指纹)"），一个会话一项
+	PeerIdentities []string `json:"peer_identities,omitempty"`
+
+	// 源拨出格的逐路连接状态，按目标登记顺序。扇出推送（--connect 多个汇）时
+	// Peers/Detail 只能说"连着几路、最后一路是谁"，哪一路断了看这里
+	Links []Link `json:"links,omitempty"`
+
+	// 进行中的传输。收方串行下载（--parallel 1，每连接单飞行）时精确；
+	// 收方并行下载或发方扇出多下游时为最后写入者（展示近似，不影响累计计数）
 	CurrentFile  string  `json:"current_file"`
 	CurrentDone  uint64  `json:"current_done"`
 	CurrentTotal uint64  `json:"current_total"`
 	RateBps      float64 `json:"rate_bps"` // 滚动传输速率（字节/秒）
 
+	// 线上压缩（FeatureCompress）：收发消息体压缩前与实际上线的累计字节，
+	// 两者之比即压缩率。未协商压缩或内容不可压缩时两者相等
+	WireRawBytes uint64 `json:"wire_raw_bytes"`
+	WireBytes    uint64 `json:"wire_bytes"`
+
+	// 本地去重：汇端用本地已有的同哈希文件复制满足、免于下载的文件数与字节数
+	DedupFiles uint64 `json:"dedup_files"`
+	DedupBytes uint64 `json:"dedup_bytes"`
+
+	// 带宽限制（--bwlimit）：计划原文与落盘时刻生效的上限（字节/秒，0 = 不限速）
+	BwSchedule string `json:"bwlimit_schedule"`
+	BwLimitBps uint64 `json:"bwlimit_bps"`
+
 	// 自采资源占用（常驻进程测自己，读端只显示，保证跨平台）
 	CPUPercent float64 `json:"cpu_percent"`
 	RSSBytes   uint64  `json:"rss_bytes"` // 常驻集（linux 精确当前值；darwin 为峰值近似）
@@ -85,6 +112,14 @@ type Snapshot struct {
 	UpdatedUnix int64 `json:"updated_unix"` // 本快照写盘时刻（陈旧判据）
 }
 
+// Link 一路拨出目标的连接状态
+type Link struct {
+	Target    string `json:"target"`     // 拨号地址 host:port
+	Up        bool   `json:"up"`         // 正在服务这路汇
+	Detail    string `json:"detail"`     // 断开时为最近一次失败原因
+	SinceUnix int64  `json:"since_unix"` // 进入当前连通状态（Up 的取值）的时刻
+}
+
 // rateSample 累计已传字节在某时刻的取样，用于滚动速率
 type rateSample struct {
 	t   time.Time
@@ -118,6 +153,9 @@ var (
 	rateSamples  []rateSample
 	lastSampleAt time.Time
 
+	// bwLimitAt 求当前时刻的限速上限，由 SetBandwidth 注册，落盘时求值
+	bwLimitAt func(time.Time) uint64
+
 	prevCPUSecs float64
 	prevCPUAt   time.Time
 	cpuPrimed   bool
@@ -154,6 +192,15 @@ func RegisterObservedWriter(fn func()) {
 	mu.Unlock()
 }
 
+// SetBandwidth 登记带宽限制计划：schedule 为展示用原文，limitAt 在每次落盘时
+// 求值，时段切换无需额外通知。未限速的进程不调用
+func SetBandwidth(schedule string, limitAt func(time.Time) uint64) {
+	mu.Lock()
+	snap.BwSchedule = schedule
+	bwLimitAt = limitAt
+	mu.Unlock()
+}
+
 // Run 落盘循环，只在被观测时写盘（用户不看就停）。无人观测时阻塞在 fsnotify
 // 事件/停止上——零定时器、零写盘、零唤醒（呼应项目对休眠功耗的关注）；观测进程
 // 往 observe/ 投放心跳即触发 fsnotify 事件唤醒本循环，进入按 activeInterval 落盘
@@ -329,6 +376,59 @@ func SessionDown() {
 	signal()
 }
 
+// SetIdentity 登记本实例的身份指纹（身份模式启动时调用一次）
+func SetIdentity(fingerprint string) {
+	mu.Lock()
+	snap.Identity = fingerprint
+	mu.Unlock()
+}
+
+// SetTLSCert 登记本端证书指纹（TLS 传输启动时调用一次）
+func SetTLSCert(fingerprint string) {
+	mu.Lock()
+	snap.TLSCert = fingerprint
+	mu.Unlock()
+}
+
+// PeerIdentityUp 身份模式下一个会话认证出的对端身份，与 SessionUp 相伴
+func PeerIdentityUp(id string) {
+	mu.Lock()
+	snap.PeerIdentities = append(snap.PeerIdentities, id)
+	mu.Unlock()
+	signal()
+}
+
+// PeerIdentityDown 与 PeerIdentityUp 对偶。同一身份可有多个会话，只摘掉一项
+func PeerIdentityDown(id string) {
+	mu.Lock()
+	if i := slices.Index(snap.PeerIdentities, id); i >= 0 {
+		snap.PeerIdentities = slices.Delete(snap.PeerIdentities, i, i+1)
+	}
+	mu.Unlock()
+	signal()
+}
+
+// SetLink 更新一路拨出目标的连接状态，首次出现即登记（登记顺序即展示顺序）。
+// 只有连通状态翻转才重置 SinceUnix，重拨失败时原因更新而"断了多久"照旧累计
+func SetLink(target string, up bool, detail string) {
+	now := time.Now().Unix()
+	mu.Lock()
+	i := 0
+	for i < len(snap.Links) && snap.Links[i].Target != target {
+		i++
+	}
+	if i == len(snap.Links) {
+		snap.Links = append(snap.Links, Link{Target: target, Up: up, SinceUnix: now})
+	}
+	l := &snap.Links[i]
+	if l.Up != up {
+		l.Up, l.SinceUnix = up, now
+	}
+	l.Detail = detail
+	mu.Unlock()
+	signal()
+}
+
 // RecordProgress 进行中传输的进度上报（收方下载/发方发送循环里节流调用）。
 // 只更新内存态与速率取样，不 poke——落盘由 Run 的活跃节奏（1s）承担，
 // 避免每个数据块都写盘
@@ -345,6 +445,15 @@ func RecordProgress(file string, done, total uint64) {
 	mu.Unlock()
 }
 
+// RecordWire 累计一条收发消息的压缩前/上线字节数（协议层每条消息调用一次）。
+// 只更新内存态，不 poke，落盘随常规节奏
+func RecordWire(raw, wire uint64) {
+	mu.Lock()
+	snap.WireRawBytes += raw
+	snap.WireBytes += wire
+	mu.Unlock()
+}
+
 // RecordFile 一个文件传输完成（收方下载完 / 发方发完）
 func RecordFile(relPath string, n uint64) {
 	mu.Lock()
@@ -360,6 +469,18 @@ func RecordFile(relPath string, n uint64) {
 	signal()
 }
 
+// RecordDedup 一个文件由本地同内容副本满足、没有经网络传输。不计入 Files/Bytes
+// （那是实际传输量），单独累计为省下的下载
+func RecordDedup(relPath string, n uint64) {
+	mu.Lock()
+	snap.DedupFiles++
+	snap.DedupBytes += n
+	snap.LastFile = relPath
+	snap.LastSyncUnix = time.Now().Unix()
+	mu.Unlock()
+	signal()
+}
+
 // addRateSampleLocked 追加一个累计字节取样（调用方须持锁）
 func addRateSampleLocked(t time.Time, cum uint64) {
 	rateSamples = append(rateSamples, rateSample{t: t, cum: cum})
@@ -422,6 +543,16 @@ func RecordError() {
 	// 错误不即时 poke：错误常伴随重连风暴，交给周期刷即可，避免写盘抖动
 }
 
+// Current 返回内存中快照的副本（不落盘），供进程自己汇报累计计数，如 --once 的结束摘要
+func Current() Snapshot {
+	mu.Lock()
+	defer mu.Unlock()
+	s := snap
+	s.Links = append([]Link(nil), snap.Links...)
+	s.PeerIdentities = append([]string(nil), snap.PeerIdentities...)
+	return s
+}
+
 // write 原子落盘：同目录临时文件 + rename，避免读端读到半个 JSON。
 // 落盘前顺带刷新速率与资源采样（每次落盘节奏即采样节奏）
 func write() {
@@ -432,6 +563,9 @@ func write() {
 	}
 	now := time.Now()
 	snap.RateBps = computeRateLocked(now)
+	if bwLimitAt != nil {
+		snap.BwLimitBps = bwLimitAt(now)
+	}
 	sampleResourcesLocked(now)
 	snap.UpdatedUnix = now.Unix()
 	data, err := json.MarshalIndent(&snap, "", "  ")
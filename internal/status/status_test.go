@@ -126,9 +126,12 @@ func TestCounters(t *testing.T) {
 	_ = os.MkdirAll(filepath.Join(root, ".local-mirror"), 0755)
 	Init(root, "v1", "aa", "receive · sink", "dial", "peer", false, time.Now().Unix())
 
+	RecordDedup("vendor/a.txt", 100)
 	RecordFile("a.txt", 100)
 	RecordFile("b/c.bin", 900)
 	RecordError()
+	RecordWire(4000, 1000)
+	RecordWire(500, 500)
 	SessionUp("connected to peer")
 	write()
 
@@ -142,6 +145,12 @@ func TestCounters(t *testing.T) {
 	if s.Errors != 1 {
 		t.Fatalf("errors %d, want 1", s.Errors)
 	}
+	if s.DedupFiles != 1 || s.DedupBytes != 100 {
+		t.Fatalf("dedup files/bytes = %d/%d, want 1/100", s.DedupFiles, s.DedupBytes)
+	}
+	if s.WireRawBytes != 4500 || s.WireBytes != 1500 {
+		t.Fatalf("wire raw/bytes = %d/%d, want 4500/1500", s.WireRawBytes, s.WireBytes)
+	}
 	if !s.Connected || s.Peers != 1 || s.Detail != "connected to peer" {
 		t.Fatalf("session up not reflected: connected=%v peers=%d detail=%q", s.Connected, s.Peers, s.Detail)
 	}
@@ -174,6 +183,46 @@ func TestSessionBalance(t *testing.T) {
 	}
 }
 
+// TestSetLink 逐路连接状态：按登记顺序、原地更新，只有连通翻转才重置时刻
+func TestSetLink(t *testing.T) {
+	reset()
+	SetLink("vps-a:52345", false, "dialing")
+	SetLink("vps-b:52345", false, "dialing")
+	SetLink("vps-a:52345", true, "serving")
+	if len(snap.Links) != 2 || snap.Links[0].Target != "vps-a:52345" || snap.Links[1].Target != "vps-b:52345" {
+		t.Fatalf("links out of order: %+v", snap.Links)
+	}
+	if !snap.Links[0].Up || snap.Links[0].Detail != "serving" || snap.Links[1].Up {
+		t.Fatalf("link states wrong: %+v", snap.Links)
+	}
+
+	snap.Links[1].SinceUnix = 1 // 模拟早已断开
+	SetLink("vps-b:52345", false, "connection refused")
+	if snap.Links[1].SinceUnix != 1 || snap.Links[1].Detail != "connection refused" {
+		t.Fatalf("retry failure should keep the down-since time and update the reason: %+v", snap.Links[1])
+	}
+	SetLink("vps-b:52345", true, "serving")
+	if snap.Links[1].SinceUnix == 1 {
+		t.Fatal("coming up should reset the since time")
+	}
+}
+
+// TestPeerIdentitiesSnapshot Current 返回的快照不随之后的 PeerIdentityDown 变化
+func TestPeerIdentitiesSnapshot(t *testing.T) {
+	reset()
+	PeerIdentityUp("alice")
+	PeerIdentityUp("bob")
+	PeerIdentityUp("carol")
+	held := Current()
+	PeerIdentityDown("alice")
+	if got := held.PeerIdentities; len(got) != 3 || got[0] != "alice" || got[1] != "bob" || got[2] != "carol" {
+		t.Fatalf("held snapshot changed: %v", got)
+	}
+	if got := Current().PeerIdentities; len(got) != 2 || got[0] != "bob" || got[1] != "carol" {
+		t.Fatalf("live identities wrong: %v", got)
+	}
+}
+
 // TestProgressAndRate 进行中传输字段落盘、完成后清空、速率非负
 func TestProgressAndRate(t *testing.T) {
 	reset()
@@ -232,8 +281,8 @@ func TestResourceSampling(t *testing.T) {
 	if s.Goroutines <= 0 {
 		t.Fatalf("goroutines should be positive, got %d", s.Goroutines)
 	}
-	if s.Schema != 2 {
-		t.Fatalf("schema should be 2, got %d", s.Schema)
+	if s.Schema != SchemaVersion {
+		t.Fatalf("schema should be %d, got %d", SchemaVersion, s.Schema)
 	}
 }
 
@@ -1,9 +1,12 @@
 package tree
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/fs"
 	"local-mirror/config"
+	"local-mirror/internal/fsmeta"
 	"local-mirror/pkg/utils"
 	"os"
 	"path/filepath"
@@ -59,31 +62,35 @@ func AddRecentChangedDir(dirPath string) {
 	if addChangeTimerActive {
 		return
 	}
-	time.AfterFunc(2*time.Second, func() {
-		// 回调运行在独立 goroutine，取快照后再落库，避免与并发的 Add 竞争
-		mu.Lock()
-		batch := make([]string, 0, len(recentChangedDirs))
-		for d := range recentChangedDirs {
-			batch = append(batch, d)
-		}
-		recentChangedDirs = make(map[string]struct{})
-		addChangeTimerActive = false
-		mu.Unlock()
-
-		if len(batch) == 0 {
-			return
-		}
-		if err := addChangedDir(batch); err != nil {
-			log.Error("Failed to add changed directories:", err)
-			return
-		}
-		// 落库成功后才广播：客户端查询的是持久化的 changed_dirs，
-		// 广播早于落库会让被唤醒的查询扑空
-		broadcastChange()
-	})
+	time.AfterFunc(2*time.Second, flushRecentChanges)
 	addChangeTimerActive = true
 }
 
+// flushRecentChanges 把当前批次写入变更日志并广播。节流定时器到期时调用；
+// 正常退出时由 CloseJournal 提前调用，批次不随进程丢失
+func flushRecentChanges() {
+	// 取快照后再落库，避免与并发的 Add 竞争
+	mu.Lock()
+	batch := make([]string, 0, len(recentChangedDirs))
+	for d := range recentChangedDirs {
+		batch = append(batch, d)
+	}
+	recentChangedDirs = make(map[string]struct{})
+	addChangeTimerActive = false
+	mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	if err := addChangedDir(batch); err != nil {
+		log.Error("Failed to add changed directories:", err)
+		return
+	}
+	// 落库成功后才广播：客户端查询的是持久化的变更日志，
+	// 广播早于落库会让被唤醒的查询扑空
+	broadcastChange()
+}
+
 // BuildFileTree 遍历磁盘构建目录树并写入数据库。
 // 若数据库中已有上次运行的缓存（见 InitDB），则按校准模式运行：
 // 复用未变化文件（size+mtime 一致）的哈希，只重算变化的文件，
@@ -125,6 +132,9 @@ func BuildFileTree(path string) error {
 		Size:    uint64(rootInfo.Size()),
 		ModTime: rootInfo.ModTime(),
 	}
+	if linfo, err := os.Lstat(path); err == nil {
+		FillMeta(rootNode, path, linfo, "")
+	}
 
 	// 用于存储路径到节点ID的映射
 	pathToID := make(map[string]string)
@@ -134,6 +144,9 @@ func BuildFileTree(path string) error {
 	seen := make(map[string]struct{})
 	seen["."] = struct{}{}
 	reusedHashes := 0
+	// 校准模式下本端若对外供数，与缓存不符的条目要补记变更（见下）。首次建树没有
+	// 可比的缓存，变更日志也是新纪元，下游无论如何都会全量对账
+	journalOffline := len(existing) > 0 && config.ServesDownstream()
 
 	// 使用并发安全的集合
 	var allNodes []*Node
@@ -187,27 +200,32 @@ func BuildFileTree(path string) error {
 
 		// 检查忽略列表
 		relPath := utils.RelPath(config.StartPath, fullPath)
-		if utils.IsIgnored(relPath, config.IgnoreFileList) {
+		if config.IsIgnored(relPath, d.IsDir()) {
 			if d.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		// 跳过符号链接：绝不追踪、绝不解引用。
-		// 否则服务端会把链接目标（可能在同步根目录之外）的内容当作普通文件
-		// 发给客户端，造成信息泄露/路径穿越；且符号链接的删除也无法被可靠检测。
-		// WalkDir 用 Lstat 语义，d.Type() 能识别链接本身而不追踪目标
+		// 符号链接绝不追踪、绝不解引用：否则服务端会把链接目标（可能在同步根目录
+		// 之外）的内容当作普通文件发给客户端，造成信息泄露/路径穿越。链接本身作为
+		// 节点入树（只记目标），但目标必须经 safety 校验落在同步根内，指出根外的
+		// 照旧跳过。WalkDir 用 Lstat 语义，d.Type() 能识别链接本身而不追踪目标
+		target := ""
 		if d.Type()&fs.ModeSymlink != 0 {
-			log.Warnf("skipping symlink (not synced): %s", relPath)
-			return nil
+			t, err := SymlinkTarget(relPath, fullPath)
+			if err != nil {
+				log.Warnf("skipping symlink (not synced): %s: %v", relPath, err)
+				return nil
+			}
+			target = t
 		}
 
 		// 跳过 socket / FIFO / 设备节点等非普通文件：它们没有可复制的字节内容，
 		// 打开只会报 "operation not supported"。若放进树，每次重建都记一条 error，
 		// 还会被登记进不可读列表由恢复循环反复重试——而这类文件永远不会变成可读，
 		// 条目就此永久滞留（实测 git fsmonitor 的 .git/fsmonitor--daemon.ipc）
-		if !d.IsDir() && !d.Type().IsRegular() {
+		if !d.IsDir() && !d.Type().IsRegular() && target == "" {
 			log.Debugf("skipping non-regular file (not synced): %s", relPath)
 			return nil
 		}
@@ -255,6 +273,15 @@ func BuildFileTree(path string) error {
 			Hash:     hash,
 			Depth:    strings.Count(relPath, string(filepath.Separator)),
 		}
+		FillMeta(node, fullPath, info, target)
+
+		// 进程离线期间的改动记入变更日志：下游凭重启前的游标就能追上，不必全量对账
+		if journalOffline {
+			if old, ok := existing[relPath]; !ok || old.IsDir != node.IsDir || old.Size != node.Size ||
+				!old.ModTime.Equal(node.ModTime) || metaChanged(old.Meta, node.Meta) {
+				AddRecentChangedDir(parentPath)
+			}
+		}
 
 		// 记录路径到ID的映射
 		pathToID[relPath] = id
@@ -298,6 +325,16 @@ func BuildFileTree(path string) error {
 		if err := DeleteNodes(stale); err != nil {
 			return fmt.Errorf("failed to prune stale nodes: %w", err)
 		}
+		if journalOffline {
+			for _, p := range stale {
+				AddRecentChangedDir(filepath.Dir(p))
+			}
+		}
+	}
+
+	// 首次建树时所有目录都要算摘要，校准时只重算有变化的分支
+	if err := RefreshDigests(); err != nil {
+		return err
 	}
 
 	log.Infof("file tree build completed, time taken: %d ms (hashes reused %d, computed %d, stale pruned %d)",
@@ -309,3 +346,11 @@ func BuildFileTree(path string) error {
 
 	return nil
 }
+
+// metaChanged 两份元数据是否不同。按 JSON 比较：缓存里的节点经过序列化，空的
+// 扩展属性表已被省略为 nil，逐字段比会把它与新采集的空表判成不同
+func metaChanged(a, b *fsmeta.Meta) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return !bytes.Equal(x, y)
+}
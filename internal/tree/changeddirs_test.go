@@ -1,7 +1,6 @@
 package tree
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"slices"
 	"testing"
@@ -12,18 +11,24 @@ import (
 	"local-mirror/config"
 )
 
-// putChangedDirsAt 绕过 addChangedDir 直接以指定时刻写入记录，
+// putChangedDirsAt 绕过 addChangedDir 直接以指定落库时刻追加一条日志，
 // 用于构造"清理没跑过、陈旧记录还在库里"的状态
 func putChangedDirsAt(t *testing.T, at time.Time, paths []string) {
 	t.Helper()
 	err := DB.Update(func(tx *bolt.Tx) error {
-		key := make([]byte, 8)
-		binary.BigEndian.PutUint64(key, uint64(at.Unix()))
-		data, err := json.Marshal(paths)
+		meta := tx.Bucket([]byte("meta"))
+		seq := metaUint(meta, journalSeqKey) + 1
+		data, err := json.Marshal(journalEntry{At: at.Unix(), Dirs: paths})
 		if err != nil {
 			return err
 		}
-		return tx.Bucket([]byte("changed_dirs")).Put(key, data)
+		if err := tx.Bucket([]byte("changed_dirs")).Put(seqKey(seq), data); err != nil {
+			return err
+		}
+		if err := putMetaUint(meta, journalBytesKey, metaUint(meta, journalBytesKey)+uint64(len(data))); err != nil {
+			return err
+		}
+		return putMetaUint(meta, journalSeqKey, seq)
 	})
 	if err != nil {
 		t.Fatalf("写入 changed_dirs 失败: %v", err)
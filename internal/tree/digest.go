@@ -0,0 +1,212 @@
+package tree
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/blake3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 目录摘要（Merkle 树）：目录的 Digest 是其直接子项按名称排序后逐条
+// (类型, 名称, 文件/链接哈希或子目录摘要) 的 blake3，于是两端同一目录摘要相同
+// 即整棵子树内容一致，全量对账不必再下钻。
+// 元数据（权限/属主/扩展属性/mtime）不计入：汇端树里记的是它落盘后读到的
+// 元数据，属主等本就可能与源端不同，计入会让摘要永远对不上。代价是只有元数据
+// 不同的深层条目要等变更推送或双向模式的全量比对来同步。
+// 写入路径（AddNodes/DeleteNodes）只把受影响的目录记进 digest_dirty 桶，
+// RefreshDigests 再自深而浅批量重算，热路径不必逐级上溯到根
+var digestDirtyBucket = []byte("digest_dirty")
+
+// digestMu 串行化 RefreshDigests：返回时保证调用前标记的脏目录都已重算完毕，
+// 并发调用若各自只处理一部分，先返回的一方可能读到祖先的旧摘要
+var digestMu sync.Mutex
+
+// digestBatchDirs 每个写事务最多重算的目录数，首次建树时全部目录都是脏的，
+// 分批提交避免单个事务积攒过多脏页
+const digestBatchDirs = 5000
+
+// markDigestDirty 标记目录摘要待重算。旧库升级后首次运行前桶可能尚未建立，此时跳过
+func markDigestDirty(tx *bolt.Tx, dir string) error {
+	b := tx.Bucket(digestDirtyBucket)
+	if b == nil {
+		return nil
+	}
+	return b.Put([]byte(dir), []byte{1})
+}
+
+// markParentDigestDirty 标记 p 所在目录的摘要待重算（根目录没有父目录）
+func markParentDigestDirty(tx *bolt.Tx, p string) error {
+	if p == "." {
+		return nil
+	}
+	return markDigestDirty(tx, filepath.Dir(p))
+}
+
+// digestEntryChanged 节点进入父目录摘要的字段是否变化。目录自身的摘要变化
+// 由 RefreshDigests 逐级上传，这里不看
+func digestEntryChanged(old, n *Node) bool {
+	return old.Name != n.Name || old.IsDir != n.IsDir || old.IsSymlink() != n.IsSymlink() ||
+		(!n.IsDir && old.Hash != n.Hash)
+}
+
+func digestDepth(p string) int {
+	if p == "." {
+		return 0
+	}
+	return strings.Count(p, string(filepath.Separator)) + 1
+}
+
+// RefreshDigests 重算所有被标记的目录摘要。先算深层目录，摘要有变化的再标记
+// 其父目录，同一轮里接着处理；没有脏目录时只做一次只读查询
+func RefreshDigests() error {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+
+	levels := make(map[int]map[string]struct{})
+	maxDepth := -1
+	if err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(digestDirtyBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			p := string(k)
+			d := digestDepth(p)
+			if levels[d] == nil {
+				levels[d] = make(map[string]struct{})
+			}
+			levels[d][p] = struct{}{}
+			maxDepth = max(maxDepth, d)
+			return nil
+		})
+	}); err != nil || maxDepth < 0 {
+		return err
+	}
+
+	refreshed := 0
+	for d := maxDepth; d >= 0; d-- {
+		dirs := make([]string, 0, len(levels[d]))
+		for p := range levels[d] {
+			dirs = append(dirs, p)
+		}
+		for i := 0; i < len(dirs); i += digestBatchDirs {
+			batch := dirs[i:min(i+digestBatchDirs, len(dirs))]
+			var parents []string
+			err := DB.Update(func(tx *bolt.Tx) error {
+				parents = parents[:0]
+				for _, dir := range batch {
+					changed, err := refreshDirDigest(tx, dir)
+					if err != nil {
+						return err
+					}
+					if changed && dir != "." {
+						parent := filepath.Dir(dir)
+						if err := markDigestDirty(tx, parent); err != nil {
+							return err
+						}
+						parents = append(parents, parent)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to refresh directory digests: %w", err)
+			}
+			refreshed += len(batch)
+			if d > 0 {
+				if levels[d-1] == nil {
+					levels[d-1] = make(map[string]struct{})
+				}
+				for _, p := range parents {
+					levels[d-1][p] = struct{}{}
+				}
+			}
+		}
+	}
+	log.Debugf("refreshed %d directory digests", refreshed)
+	return nil
+}
+
+// refreshDirDigest 重算一个目录的摘要并清除其脏标记，返回摘要是否变化。
+// 目录已不存在（标记之后被删）或已变成文件时只清标记
+func refreshDirDigest(tx *bolt.Tx, dir string) (bool, error) {
+	if err := tx.Bucket(digestDirtyBucket).Delete([]byte(dir)); err != nil {
+		return false, err
+	}
+	nodesBucket := tx.Bucket([]byte("nodes"))
+	id := tx.Bucket([]byte("path_index")).Get([]byte(dir))
+	if id == nil {
+		return false, nil
+	}
+	nodeData := nodesBucket.Get(id)
+	if nodeData == nil {
+		return false, nil
+	}
+	var node Node
+	if err := json.Unmarshal(nodeData, &node); err != nil {
+		return false, err
+	}
+	if !node.IsDir {
+		return false, nil
+	}
+
+	var children []Node
+	if cd := tx.Bucket([]byte("children")).Get(id); cd != nil {
+		var ch Children
+		if err := json.Unmarshal(cd, &ch); err != nil {
+			return false, err
+		}
+		for _, childID := range ch.ChildIDs {
+			data := nodesBucket.Get([]byte(childID))
+			if data == nil {
+				continue
+			}
+			var child Node
+			if err := json.Unmarshal(data, &child); err != nil {
+				return false, err
+			}
+			children = append(children, child)
+		}
+	}
+	digest := dirDigest(children)
+	if digest == node.Digest {
+		return false, nil
+	}
+	node.Digest = digest
+	data, err := json.Marshal(node)
+	if err != nil {
+		return false, err
+	}
+	return true, nodesBucket.Put(id, data)
+}
+
+// dirDigest 按子项计算目录摘要。有子目录的摘要尚未算出时整个目录记为未知（空），
+// 对端据此只会照常下钻，不会误判为一致
+func dirDigest(children []Node) string {
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	h := blake3.New()
+	for _, c := range children {
+		kind, value := "f", c.Hash
+		switch {
+		case c.IsDir:
+			if c.Digest == "" {
+				return ""
+			}
+			kind, value = "d", c.Digest
+		case c.IsSymlink():
+			kind = "l"
+		}
+		h.WriteString(kind)
+		h.WriteString(c.Name)
+		h.Write([]byte{0})
+		h.WriteString(value)
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,150 @@
+package tree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/pkg/utils"
+)
+
+func writeTreeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// buildDigests 建树（fresh 时丢弃已有缓存）并返回各目录的摘要
+func buildDigests(t *testing.T, root string, fresh bool) map[string]string {
+	t.Helper()
+	config.StartPath = root
+	config.IgnoreFileList = []string{".local-mirror"}
+	if fresh {
+		os.RemoveAll(filepath.Join(root, ".local-mirror"))
+	}
+	InitDB()
+	defer DB.Close()
+	if err := BuildFileTree(root); err != nil {
+		t.Fatalf("BuildFileTree: %v", err)
+	}
+	return dirDigests(t)
+}
+
+func dirDigests(t *testing.T) map[string]string {
+	t.Helper()
+	dirs, err := GetAllDirNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string)
+	for _, d := range dirs {
+		if d.Digest == "" {
+			t.Errorf("目录 %s 的摘要未算出", d.Path)
+		}
+		out[d.Path] = d.Digest
+	}
+	return out
+}
+
+func hashOf(t *testing.T, p string) string {
+	t.Helper()
+	h, err := utils.CalcBlake3(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%x", h)
+}
+
+var digestFixture = map[string]string{
+	"top.txt":       "top",
+	"a/b/f.txt":     "deep",
+	"a/g.txt":       "mid",
+	"c/h.txt":       "other",
+	"c/empty/.keep": "",
+}
+
+// TestDigestsMatchAcrossTrees 内容相同的两棵树各目录摘要一致（与 mtime、所在
+// 位置无关）；改动深层文件后只有它的祖先摘要变化，兄弟子树不变
+func TestDigestsMatchAcrossTrees(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTreeFiles(t, src, digestFixture)
+	writeTreeFiles(t, dst, digestFixture)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dst, "top.txt"), old, old)
+
+	want := buildDigests(t, src, true)
+	got := buildDigests(t, dst, true)
+	for p, d := range want {
+		if got[p] != d {
+			t.Errorf("内容相同的目录 %s 摘要应一致: %s vs %s", p, d, got[p])
+		}
+	}
+
+	writeTreeFiles(t, dst, map[string]string{"a/b/f.txt": "changed"})
+	changed := buildDigests(t, dst, false)
+	for _, p := range []string{".", "a", filepath.Join("a", "b")} {
+		if changed[p] == want[p] {
+			t.Errorf("改动路径上的目录 %s 摘要应变化", p)
+		}
+	}
+	for _, p := range []string{"c", filepath.Join("c", "empty")} {
+		if changed[p] != want[p] {
+			t.Errorf("未改动的子树 %s 摘要不应变化", p)
+		}
+	}
+}
+
+// TestDigestsIncrementalMatchFullBuild 经 AddNodes/DeleteNodes 增量维护后重算的
+// 摘要，与按磁盘现状从头建树的结果一致
+func TestDigestsIncrementalMatchFullBuild(t *testing.T) {
+	root := t.TempDir()
+	writeTreeFiles(t, root, digestFixture)
+	config.StartPath = root
+	config.IgnoreFileList = []string{".local-mirror"}
+	InitDB()
+	if err := BuildFileTree(root); err != nil {
+		t.Fatalf("BuildFileTree: %v", err)
+	}
+
+	// 模拟 watcher：删掉 c/empty 整个目录、新增 a/b/new.txt
+	if err := os.RemoveAll(filepath.Join(root, "c", "empty")); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteNodes([]string{filepath.Join("c", "empty")}); err != nil {
+		t.Fatal(err)
+	}
+	writeTreeFiles(t, root, map[string]string{"a/b/new.txt": "new"})
+	parent, err := GetNodeByPath(filepath.Join("a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddNodes([]*Node{{ID: "new-node-id", Path: filepath.Join("a", "b", "new.txt"), Name: "new.txt",
+		ParentID: parent.ID, Size: 3, Hash: hashOf(t, filepath.Join(root, "a", "b", "new.txt"))}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RefreshDigests(); err != nil {
+		t.Fatal(err)
+	}
+	incremental := dirDigests(t)
+	DB.Close()
+
+	full := buildDigests(t, root, true)
+	if len(incremental) != len(full) {
+		t.Fatalf("目录集合不同: %v vs %v", incremental, full)
+	}
+	for p, d := range full {
+		if incremental[p] != d {
+			t.Errorf("目录 %s 增量维护的摘要与重建不一致", p)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tree
+
+import (
+	"path/filepath"
+	"sort"
+
+	"local-mirror/config"
+)
+
+// PruneIgnored 忽略规则变化后，把按新规则 now 被忽略、按旧规则 before 不被忽略的
+// 节点连同子树移出树，并把它们的父目录记为变更目录，下游据此看到删除。
+// 返回被移除子树的根（已排序），供 watcher 撤掉其下的监听。
+// 被忽略目录的后代必然同样被忽略，故只需删最顶层的那个
+func PruneIgnored(before, now config.IgnoreRules) ([]string, error) {
+	nodes, err := LoadAllNodesByPath()
+	if err != nil {
+		return nil, err
+	}
+	newly := func(p string, isDir bool) bool {
+		return now.Ignored(p, isDir) && !before.Ignored(p, isDir)
+	}
+	var tops []string
+	for p, n := range nodes {
+		if !newly(p, n.IsDir) {
+			continue
+		}
+		if parent := filepath.Dir(p); parent != "." && newly(parent, true) {
+			continue
+		}
+		tops = append(tops, p)
+	}
+	if len(tops) == 0 {
+		return nil, nil
+	}
+	sort.Strings(tops)
+	if err := DeleteNodes(tops); err != nil {
+		return nil, err
+	}
+	for _, p := range tops {
+		AddRecentChangedDir(filepath.Dir(p))
+	}
+	return tops, nil
+}
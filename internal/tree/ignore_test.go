@@ -0,0 +1,59 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"local-mirror/config"
+)
+
+// TestPruneIgnored 重载出新的忽略项后，命中的子树整棵移出树，只报最顶层；
+// 原本就被忽略的、仍不被忽略的都不动
+func TestPruneIgnored(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+
+	for _, d := range []string{"src", filepath.Join("src", "build", "obj"), "docs"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join("src", "main.go"), filepath.Join("src", "build", "obj", "a.o"), filepath.Join("docs", "x.log")} {
+		if err := os.WriteFile(filepath.Join(root, f), []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	InitDB()
+	defer DB.Close()
+	if err := BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+
+	before := config.IgnoreRules{List: []string{".local-mirror"}}
+	now := config.IgnoreRules{List: []string{".local-mirror", "build", "*.log"}}
+	pruned, err := PruneIgnored(before, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join("docs", "x.log"), filepath.Join("src", "build")}
+	if len(pruned) != len(want) || pruned[0] != want[0] || pruned[1] != want[1] {
+		t.Fatalf("pruned = %v, want %v", pruned, want)
+	}
+	for _, gone := range []string{filepath.Join("src", "build"), filepath.Join("src", "build", "obj", "a.o"), filepath.Join("docs", "x.log")} {
+		if ok, _ := HasPath(gone); ok {
+			t.Errorf("%s 应已移出树", gone)
+		}
+	}
+	for _, kept := range []string{"src", filepath.Join("src", "main.go"), "docs"} {
+		if ok, _ := HasPath(kept); !ok {
+			t.Errorf("%s 不该受影响", kept)
+		}
+	}
+
+	// 列表没有新增项：什么都不删
+	if pruned, err := PruneIgnored(now, now); err != nil || len(pruned) != 0 {
+		t.Fatalf("unchanged list pruned %v (err=%v)", pruned, err)
+	}
+}
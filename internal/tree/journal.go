@@ -0,0 +1,267 @@
+package tree
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 变更日志（changed_dirs 桶）：每批落库的变更目录记为一条，key 是单调递增的
+// 序号。下游以 (纪元, 序号) 为游标增量追赶，日志跨源端重启保留，源端重启或下游
+// 断线数小时后重连都只需补上游标之后的条目，不必全量对账。
+// 纪元标识一条连续的日志：建库、旧版缓存升级、上次运行未正常退出（节流中未
+// 落库的变更已丢失，日志有洞）时另起纪元，持旧纪元游标的下游据此改做全量对账
+const (
+	// JournalMaxAge 日志条目最长保留时间；清理只在写入时做，源端空闲时可以更久，
+	// 这不影响正确性——留在库里的条目始终是连续的
+	JournalMaxAge = 7 * 24 * time.Hour
+	// JournalMaxBytes 日志总大小上限（条目序列化后的字节数），超出即从最旧的删起
+	JournalMaxBytes = 64 << 20
+	// ChangedDirRetention 按时间游标查询（未协商 FeatureJournal 的旧版汇端）的窗口。
+	// 这类汇端每做一次全量扫描就把游标归 0，窗口放宽只会让它把更多已同步的
+	// 陈旧路径重放一遍，故保持原来的一小时
+	ChangedDirRetention = time.Hour
+)
+
+// 日志状态存于 meta 桶，与条目在同一事务里更新
+var (
+	journalEpochKey = []byte("journal_epoch") // 当前纪元，非 0
+	journalSeqKey   = []byte("journal_seq")   // 最后一条的序号，空日志为 floor
+	journalFloorKey = []byte("journal_floor") // 已清理掉的最大序号：floor 之后的条目都还在
+	journalBytesKey = []byte("journal_bytes") // 现存条目的总字节数
+	journalOpenKey  = []byte("journal_open")  // 运行中置位，CloseJournal 清除；启动时仍在即上次异常退出
+)
+
+// JournalPos 日志中的一个位置：Seq 号及之前的条目都已被覆盖
+type JournalPos struct {
+	Epoch uint64
+	Seq   uint64
+}
+
+// journalEntry changed_dirs 桶的一条记录
+type journalEntry struct {
+	At   int64    `json:"at"` // 落库时刻（unix 秒），用于按时间清理与旧版时间游标查询
+	Dirs []string `json:"dirs"`
+}
+
+func metaUint(meta *bolt.Bucket, key []byte) uint64 {
+	if v := meta.Get(key); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func putMetaUint(meta *bolt.Bucket, key []byte, v uint64) error {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return meta.Put(key, b)
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// resetJournal 清空日志并另起纪元
+func resetJournal(tx *bolt.Tx) error {
+	if tx.Bucket([]byte("changed_dirs")) != nil {
+		if err := tx.DeleteBucket([]byte("changed_dirs")); err != nil {
+			return fmt.Errorf("failed to reset changed_dirs: %w", err)
+		}
+	}
+	if _, err := tx.CreateBucket([]byte("changed_dirs")); err != nil {
+		return fmt.Errorf("failed to recreate changed_dirs: %w", err)
+	}
+	var epoch uint64
+	for epoch == 0 {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate journal epoch: %w", err)
+		}
+		epoch = binary.BigEndian.Uint64(b)
+	}
+	meta := tx.Bucket([]byte("meta"))
+	for _, kv := range []struct {
+		key []byte
+		v   uint64
+	}{{journalEpochKey, epoch}, {journalSeqKey, 0}, {journalFloorKey, 0}, {journalBytesKey, 0}, {journalOpenKey, 1}} {
+		if err := putMetaUint(meta, kv.key, kv.v); err != nil {
+			return err
+		}
+	}
+	log.Infof("started change journal epoch %016x", epoch)
+	return nil
+}
+
+// resumeJournal 复用缓存时续用上次的日志。没有纪元（旧版缓存的 changed_dirs 是
+// 按时间戳存的）或上次没有正常退出时另起纪元
+func resumeJournal(tx *bolt.Tx) error {
+	meta := tx.Bucket([]byte("meta"))
+	epoch := metaUint(meta, journalEpochKey)
+	switch {
+	case epoch == 0:
+		return resetJournal(tx)
+	case metaUint(meta, journalOpenKey) != 0:
+		log.Warnf("the previous run did not shut down cleanly, changes it had not yet journaled are lost; downstreams will do a full reconciliation")
+		return resetJournal(tx)
+	}
+	log.Infof("resuming change journal epoch %016x at #%d", epoch, metaUint(meta, journalSeqKey))
+	return putMetaUint(meta, journalOpenKey, 1)
+}
+
+// CloseJournal 正常退出前调用：把节流中尚未落库的变更写入日志，并标记本次运行
+// 已完整记录，下次启动可续用同一纪元
+func CloseJournal() error {
+	flushRecentChanges()
+	return DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("meta")).Delete(journalOpenKey)
+	})
+}
+
+// addChangedDir 把一批变更目录追加为一条日志，并按 JournalMaxAge/JournalMaxBytes
+// 从最旧的条目清理。刚写入的一条总是保留
+func addChangedDir(paths []string) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		changedDirsBucket := tx.Bucket([]byte("changed_dirs"))
+		meta := tx.Bucket([]byte("meta"))
+		if changedDirsBucket == nil || meta == nil {
+			log.Error("Database buckets not initialized")
+			return os.ErrNotExist
+		}
+
+		now := time.Now()
+		data, err := json.Marshal(journalEntry{At: now.Unix(), Dirs: paths})
+		if err != nil {
+			return fmt.Errorf("failed to marshal changed dirs: %w", err)
+		}
+		seq := metaUint(meta, journalSeqKey) + 1
+		if err := changedDirsBucket.Put(seqKey(seq), data); err != nil {
+			return err
+		}
+		size := metaUint(meta, journalBytesKey) + uint64(len(data))
+		floor := metaUint(meta, journalFloorKey)
+
+		horizon := now.Add(-JournalMaxAge).Unix()
+		var expired [][]byte
+		c := changedDirsBucket.Cursor()
+		for k, v := c.First(); k != nil && binary.BigEndian.Uint64(k) < seq; k, v = c.Next() {
+			if size <= JournalMaxBytes {
+				var e journalEntry
+				if err := json.Unmarshal(v, &e); err == nil && e.At >= horizon {
+					break
+				}
+			}
+			expired = append(expired, append([]byte(nil), k...))
+			size -= uint64(len(v))
+			floor = binary.BigEndian.Uint64(k)
+		}
+		// 遍历完再删：边遍历边用游标删除会跳过紧随其后的一项
+		for _, k := range expired {
+			if err := changedDirsBucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		if err := putMetaUint(meta, journalSeqKey, seq); err != nil {
+			return err
+		}
+		if err := putMetaUint(meta, journalBytesKey, size); err != nil {
+			return err
+		}
+		return putMetaUint(meta, journalFloorKey, floor)
+	})
+	return err
+}
+
+// JournalHead 日志当前的末尾位置
+func JournalHead() (JournalPos, error) {
+	var head JournalPos
+	err := DB.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte("meta"))
+		head = JournalPos{Epoch: metaUint(meta, journalEpochKey), Seq: metaUint(meta, journalSeqKey)}
+		return nil
+	})
+	return head, err
+}
+
+// ReadJournal 取 from 之后（不含）的变更目录，next 为读到的位置。limit>0 时按条目
+// 分页：累计目录数将超过 limit 即停（首条总是取，单条再大也不拆），more 表示 next
+// 之后还有条目，调用方从 next 接着读。ok=false 表示从 from 接不上：纪元不同（源端
+// 换了库或异常退出过），或 from 之后的条目已被清理——调用方应全量对账，然后从
+// next（即日志末尾）继续
+func ReadJournal(from JournalPos, limit int) (dirs []string, next JournalPos, more, ok bool, err error) {
+	err = DB.View(func(tx *bolt.Tx) error {
+		changedDirsBucket := tx.Bucket([]byte("changed_dirs"))
+		if changedDirsBucket == nil {
+			return fmt.Errorf("changed_dirs bucket not found")
+		}
+		meta := tx.Bucket([]byte("meta"))
+		next = JournalPos{Epoch: metaUint(meta, journalEpochKey), Seq: metaUint(meta, journalSeqKey)}
+		if from.Epoch != next.Epoch || from.Seq < metaUint(meta, journalFloorKey) || from.Seq > next.Seq {
+			return nil
+		}
+		ok = true
+		c := changedDirsBucket.Cursor()
+		for k, v := c.Seek(seqKey(from.Seq + 1)); k != nil; k, v = c.Next() {
+			var e journalEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				log.Error("Failed to unmarshal changed dir:", err)
+				continue
+			}
+			seq := binary.BigEndian.Uint64(k)
+			if limit > 0 && len(dirs) > 0 && len(dirs)+len(e.Dirs) > limit {
+				more = true
+				next.Seq = seq - 1
+				break
+			}
+			dirs = append(dirs, e.Dirs...)
+		}
+		return nil
+	})
+	return dirs, next, more, ok, err
+}
+
+// GetChangedDirs 取 [start, end] 内落库的变更目录，供按时间游标查询的旧版汇端。
+// start 会被抬到 ChangedDirRetention 窗口下沿：这类汇端每做一次全量扫描就把游标
+// 归 0，若照单全收，下一轮变更追踪便会把整个日志的陈旧路径重放一遍——其中已被
+// 删除的目录每轮报一次 "directory not found"，源端空闲整夜就刷屏整夜（生产实测
+// 17 小时、每 35 分钟一轮）。落库时刻随序号递增，从末尾往前读到窗口外即止
+func GetChangedDirs(start int64, end int64) ([]string, error) {
+	if floor := time.Now().Add(-ChangedDirRetention).Unix(); start < floor {
+		start = floor
+	}
+	var batches [][]string
+	err := DB.View(func(tx *bolt.Tx) error {
+		changedDirsBucket := tx.Bucket([]byte("changed_dirs"))
+		if changedDirsBucket == nil {
+			return fmt.Errorf("changed_dirs bucket not found")
+		}
+		c := changedDirsBucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e journalEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				log.Error("Failed to unmarshal changed dir:", err)
+				continue
+			}
+			if e.At < start {
+				break
+			}
+			if e.At <= end {
+				batches = append(batches, e.Dirs)
+			}
+		}
+		return nil
+	})
+	var dirs []string
+	for i := len(batches) - 1; i >= 0; i-- {
+		dirs = append(dirs, batches[i]...)
+	}
+	return dirs, err
+}
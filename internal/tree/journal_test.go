@@ -0,0 +1,189 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+)
+
+// TestJournalSurvivesRestart 正常退出后重启续用同一纪元，重启前的游标照样能接上
+func TestJournalSurvivesRestart(t *testing.T) {
+	config.StartPath = t.TempDir()
+	InitDB()
+	flushRecentChanges() // 同包其它用例留在节流批次里的变更先落掉，免得混进来
+	if err := addChangedDir([]string{"before"}); err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := JournalHead()
+	if err != nil || cursor.Epoch == 0 || cursor.Seq == 0 {
+		t.Fatalf("写入后日志末尾与纪元都应非 0: %+v %v", cursor, err)
+	}
+	if err := CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+	DB.Close()
+
+	InitDB()
+	defer DB.Close()
+	head, err := JournalHead()
+	if err != nil || head != cursor {
+		t.Fatalf("正常重启后日志位置应不变: got %+v, want %+v (%v)", head, cursor, err)
+	}
+	if err := addChangedDir([]string{"after"}); err != nil {
+		t.Fatal(err)
+	}
+	dirs, head, _, ok, err := ReadJournal(cursor, 0)
+	if err != nil || !ok {
+		t.Fatalf("重启前的游标应能接上: ok=%v err=%v", ok, err)
+	}
+	if !slices.Equal(dirs, []string{"after"}) || head.Seq != cursor.Seq+1 {
+		t.Errorf("应只返回游标之后的条目: dirs=%v head=%+v", dirs, head)
+	}
+}
+
+// TestJournalNewEpochAfterUncleanExit 上次没有正常退出（节流中的变更可能已丢失）
+// 时另起纪元，持旧游标的下游被告知接不上
+func TestJournalNewEpochAfterUncleanExit(t *testing.T) {
+	config.StartPath = t.TempDir()
+	InitDB()
+	flushRecentChanges()
+	if err := addChangedDir([]string{"before"}); err != nil {
+		t.Fatal(err)
+	}
+	cursor, _ := JournalHead()
+	DB.Close() // 不调 CloseJournal，模拟崩溃
+
+	InitDB()
+	defer DB.Close()
+	head, _ := JournalHead()
+	if head.Epoch == cursor.Epoch || head.Seq != 0 {
+		t.Fatalf("异常退出后应另起纪元: before %+v, now %+v", cursor, head)
+	}
+	if _, _, _, ok, _ := ReadJournal(cursor, 0); ok {
+		t.Error("旧纪元的游标不该接得上")
+	}
+	// 只取末尾的请求（纪元 0）同样走 ok=false，由调用方区分
+	if _, got, _, ok, _ := ReadJournal(JournalPos{}, 0); ok || got != head {
+		t.Errorf("纪元 0 应拿到当前末尾: ok=%v head=%+v", ok, got)
+	}
+}
+
+// TestJournalTrimsOldEntries 过期条目在写入时清理，游标落在被清理的范围里即接不上；
+// 恰好停在清理边界上的游标仍可续
+func TestJournalTrimsOldEntries(t *testing.T) {
+	config.StartPath = t.TempDir()
+	InitDB()
+	defer DB.Close()
+	flushRecentChanges()
+
+	old := time.Now().Add(-JournalMaxAge - time.Hour)
+	putChangedDirsAt(t, old, []string{"old/one"})
+	putChangedDirsAt(t, old, []string{"old/two"})
+	if err := addChangedDir([]string{"fresh"}); err != nil {
+		t.Fatal(err)
+	}
+	head, _ := JournalHead()
+
+	if _, _, _, ok, _ := ReadJournal(JournalPos{Epoch: head.Epoch, Seq: 1}, 0); ok {
+		t.Error("游标之后的条目已被清理，不该接得上")
+	}
+	dirs, _, _, ok, err := ReadJournal(JournalPos{Epoch: head.Epoch, Seq: 2}, 0)
+	if err != nil || !ok || !slices.Equal(dirs, []string{"fresh"}) {
+		t.Errorf("停在清理边界的游标应能续上: dirs=%v ok=%v err=%v", dirs, ok, err)
+	}
+}
+
+// TestReadJournalPages 按 limit 在条目边界分页，逐页续读不丢不重；单条超过
+// limit 时整条返回
+func TestReadJournalPages(t *testing.T) {
+	config.StartPath = t.TempDir()
+	InitDB()
+	defer DB.Close()
+	flushRecentChanges()
+	cursor, _ := JournalHead()
+	for _, batch := range [][]string{{"a", "b"}, {"c"}, {"d", "e"}, {"f", "g", "h", "i"}} {
+		if err := addChangedDir(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pages [][]string
+	for {
+		dirs, next, more, ok, err := ReadJournal(cursor, 3)
+		if err != nil || !ok {
+			t.Fatalf("ReadJournal: ok=%v err=%v", ok, err)
+		}
+		pages = append(pages, dirs)
+		cursor = next
+		if !more {
+			break
+		}
+	}
+	want := [][]string{{"a", "b", "c"}, {"d", "e"}, {"f", "g", "h", "i"}}
+	if !slices.EqualFunc(pages, want, slices.Equal[[]string]) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+	if head, _ := JournalHead(); cursor != head {
+		t.Errorf("读完最后一页游标应在日志末尾: %+v, head %+v", cursor, head)
+	}
+}
+
+// TestBuildFileTreeJournalsOfflineChanges 源端离线期间的改动在启动校准时补记进日志，
+// 下游凭重启前的游标就能追上；没动过的目录不记
+func TestBuildFileTreeJournalsOfflineChanges(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = []string{".local-mirror"}
+	mode := *config.Mode
+	*config.Mode = "reality"
+	defer func() { *config.Mode = mode }()
+
+	write := func(rel, content string) {
+		t.Helper()
+		p := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join("sub", "a.txt"), "v1")
+	write(filepath.Join("other", "c.txt"), "c")
+	write(filepath.Join("quiet", "e.txt"), "e")
+
+	InitDB()
+	defer DB.Close()
+	if err := BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	flushRecentChanges()
+	cursor, _ := JournalHead()
+
+	write(filepath.Join("sub", "a.txt"), "v2-with-different-length")
+	if err := os.Remove(filepath.Join(root, "other", "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join("new", "d.txt"), "d")
+	if err := BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	flushRecentChanges()
+
+	dirs, _, _, ok, err := ReadJournal(cursor, 0)
+	if err != nil || !ok {
+		t.Fatalf("ReadJournal: ok=%v err=%v", ok, err)
+	}
+	for _, want := range []string{"sub", "other", "new"} {
+		if !slices.Contains(dirs, want) {
+			t.Errorf("离线改动的目录 %s 应记入日志, got %v", want, dirs)
+		}
+	}
+	if slices.Contains(dirs, "quiet") {
+		t.Errorf("没动过的目录不该记入日志, got %v", dirs)
+	}
+}
@@ -0,0 +1,38 @@
+package tree
+
+import (
+	"errors"
+	"io/fs"
+	"local-mirror/config"
+	"local-mirror/internal/fsmeta"
+	"local-mirror/internal/safety"
+	"os"
+)
+
+// errSymlinkUnsupported 本平台不同步符号链接（Windows：建链接需要特权，元数据也不支持）
+var errSymlinkUnsupported = errors.New("symlinks are not synced on this platform")
+
+// SymlinkTarget 判定 fullPath 处的符号链接能否入树：平台支持、目标可读，且经
+// safety 校验不会指出同步根（绝不解引用，只记目标本身）。返回清洗后的目标；
+// 不能入树时返回原因，调用方照旧跳过该链接
+func SymlinkTarget(relPath, fullPath string) (string, error) {
+	if !fsmeta.Supported {
+		return "", errSymlinkUnsupported
+	}
+	target, err := os.Readlink(fullPath)
+	if err != nil {
+		return "", err
+	}
+	return safety.SymlinkTarget(config.StartPath, relPath, target)
+}
+
+// FillMeta 为 node 采集磁盘元数据（info 须是 Lstat 的结果）；符号链接同时以目标
+// 哈希填 Hash、Size 记 0——链接没有可下载的内容，不应计入磁盘预检与传输统计。
+// 建树、watcher 与汇端落库共用，三处口径一致
+func FillMeta(node *Node, fullPath string, info fs.FileInfo, target string) {
+	node.Meta = fsmeta.Read(fullPath, info, target)
+	if target != "" {
+		node.Size = 0
+		node.Hash = fsmeta.LinkHash(target)
+	}
+}
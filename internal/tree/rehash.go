@@ -0,0 +1,120 @@
+package tree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/pkg/utils"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// lastRehashKey meta 桶里上次 Rehash 完成的时刻（unix 秒），跨重启保留，
+// 供汇端的定期 --scrub 判断是否到期
+var lastRehashKey = []byte("last_rehash")
+
+// RehashResult 一次 Rehash 的结果
+type RehashResult struct {
+	Files      int      // 重算了哈希的文件数
+	Bytes      uint64   // 读过的字节数
+	Mismatched []string // 内容与树里记录的哈希不符的文件（已按实际内容改记）
+}
+
+// Rehash 重读树里每个文件的内容、重算哈希，与记录比对。BuildFileTree 校准时
+// size+mtime 未变就沿用旧哈希，悄悄坏掉的文件（位衰减、保留了 mtime 的篡改）
+// 只有这里能发现。不符的节点改记实际哈希（摘要随之变脏），下一轮对账即按与上游
+// 不同处理。读前或读完时 size/mtime 已变的文件（读的过程中被别的进程改了）留给
+// 校准，不在此计——否则读到的半新半旧内容会被当成位衰减记进树里。
+// 汇端的 scrub 不持任务锁跑，写回前逐个核对节点仍是读前那份，期间被同步改过的不覆盖。
+// workers 个并发读
+func Rehash(workers int) (RehashResult, error) {
+	var res RehashResult
+	nodes, err := LoadAllNodesByPath()
+	if err != nil {
+		return res, err
+	}
+	files := make(chan *Node, 1000)
+	var (
+		mu      sync.Mutex
+		changed []*Node
+		wg      sync.WaitGroup
+	)
+	for range max(workers, 1) {
+		wg.Go(func() {
+			for n := range files {
+				full := filepath.Join(config.StartPath, n.Path)
+				info, err := os.Lstat(full)
+				if err != nil || !info.Mode().IsRegular() || uint64(info.Size()) != n.Size || !info.ModTime().Equal(n.ModTime) {
+					continue
+				}
+				h, err := utils.CalcBlake3(full)
+				if err != nil {
+					log.Warnf("rehash: cannot read %s: %v", n.Path, err)
+					continue
+				}
+				if after, err := os.Lstat(full); err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) {
+					continue
+				}
+				hash := fmt.Sprintf("%x", h)
+				mu.Lock()
+				res.Files++
+				res.Bytes += n.Size
+				if hash != n.Hash {
+					updated := *n
+					updated.Hash = hash
+					changed = append(changed, &updated)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	for _, n := range nodes {
+		if !n.IsDir && !n.IsSymlink() && n.Hash != "" {
+			files <- n
+		}
+	}
+	close(files)
+	wg.Wait()
+
+	// 读的这段时间里节点可能已被同步改记（新下载、删除），那份不是这里读到的内容
+	changed = slices.DeleteFunc(changed, func(u *Node) bool {
+		cur, err := GetNodeByPath(u.Path)
+		return err != nil || cur.Size != u.Size || !cur.ModTime.Equal(u.ModTime) || cur.Hash != nodes[u.Path].Hash
+	})
+	for _, u := range changed {
+		res.Mismatched = append(res.Mismatched, u.Path)
+	}
+	sort.Strings(res.Mismatched)
+	if len(changed) > 0 {
+		if err := AddNodes(changed); err != nil {
+			return res, err
+		}
+	}
+	if err := RefreshDigests(); err != nil {
+		return res, err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return putMetaUint(tx.Bucket([]byte("meta")), lastRehashKey, uint64(time.Now().Unix()))
+	})
+	return res, err
+}
+
+// LastRehash 上次 Rehash 完成的时刻，从未做过为零值
+func LastRehash() time.Time {
+	var at uint64
+	_ = DB.View(func(tx *bolt.Tx) error {
+		at = metaUint(tx.Bucket([]byte("meta")), lastRehashKey)
+		return nil
+	})
+	if at == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(at), 0)
+}
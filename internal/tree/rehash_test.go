@@ -0,0 +1,72 @@
+package tree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"local-mirror/config"
+)
+
+// TestRehashFindsSilentChanges size 与 mtime 不变、内容变了的文件校准发现不了，
+// Rehash 能发现并改记实际哈希，祖先摘要随之变化；完成时刻跨重开库保留
+func TestRehashFindsSilentChanges(t *testing.T) {
+	root := t.TempDir()
+	writeTreeFiles(t, root, digestFixture)
+	config.StartPath = root
+	config.IgnoreFileList = []string{".local-mirror"}
+	InitDB()
+	if err := BuildFileTree(root); err != nil {
+		t.Fatalf("BuildFileTree: %v", err)
+	}
+	if !LastRehash().IsZero() {
+		t.Fatal("从未 Rehash 过时应为零值")
+	}
+	before := dirDigests(t)
+
+	rel := filepath.Join("a", "b", "f.txt")
+	full := filepath.Join(root, rel)
+	info, err := os.Stat(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte("DEEP"), 0o644); err != nil { // 与 "deep" 等长
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(full, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := BuildFileTree(root); err != nil {
+		t.Fatalf("BuildFileTree: %v", err)
+	}
+	if n, _ := GetNodeByPath(rel); n.Hash == hashOf(t, full) {
+		t.Fatal("前提不成立：校准不应察觉 size/mtime 都没变的改动")
+	}
+
+	res, err := Rehash(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != len(digestFixture) || len(res.Mismatched) != 1 || res.Mismatched[0] != rel {
+		t.Fatalf("应重读全部 %d 个文件并只报出 %s: %+v", len(digestFixture), rel, res)
+	}
+	if n, _ := GetNodeByPath(rel); n.Hash != hashOf(t, full) {
+		t.Fatal("不符的节点应改记实际哈希")
+	}
+	after := dirDigests(t)
+	for _, p := range []string{".", "a", filepath.Join("a", "b")} {
+		if after[p] == before[p] {
+			t.Errorf("祖先目录 %s 的摘要应变化", p)
+		}
+	}
+	if after["c"] != before["c"] {
+		t.Error("无关子树的摘要不应变化")
+	}
+	DB.Close()
+
+	InitDB()
+	defer DB.Close()
+	if LastRehash().IsZero() {
+		t.Fatal("Rehash 完成时刻应跨重开库保留")
+	}
+}
@@ -0,0 +1,177 @@
+package tree
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BaseDir sync_base 里目录的取值。目录没有内容哈希，只记"两端都有"；
+// 哈希是十六进制串，不会与之相撞
+const BaseDir = "/"
+
+// 共同祖先（sync_base 桶）：双向同步里两端最后一次一致时每个路径的状态。
+// 本地与对端不一致时，拿它判断是哪一侧变了——本地仍等于祖先即对端改的，
+// 对端等于祖先即本地改的，两侧都不等于则是冲突。
+// 只记本端看到的"两端一致"，由汇引擎在比对时维护（见 internal/twoway.go）
+
+// SyncBase 返回 path 的共同祖先取值；没有记录时 ok 为 false
+func SyncBase(path string) (value string, ok bool, err error) {
+	err = DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		if b == nil {
+			return fmt.Errorf("sync base bucket not found")
+		}
+		if v := b.Get([]byte(path)); v != nil {
+			value, ok = string(v), true
+		}
+		return nil
+	})
+	return value, ok, err
+}
+
+// SetSyncBase 把 path 的共同祖先记为 value（文件为哈希，目录为 BaseDir）
+func SetSyncBase(path, value string) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		if b == nil {
+			return fmt.Errorf("sync base bucket not found")
+		}
+		return b.Put([]byte(path), []byte(value))
+	})
+}
+
+// DeleteSyncBase 删除 path 及其子树的共同祖先记录
+func DeleteSyncBase(path string) error {
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		if b == nil {
+			return fmt.Errorf("sync base bucket not found")
+		}
+		return deleteBaseSubtree(b, path)
+	})
+}
+
+func deleteBaseSubtree(b *bolt.Bucket, path string) error {
+	if err := b.Delete([]byte(path)); err != nil {
+		return err
+	}
+	prefix := []byte(path + string(filepath.Separator))
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordSyncBase 按一次目录比对的结果维护 dir 的直接子项：common 是两端一致的项
+// （路径 → 取值），present 是两端任一侧仍存在的项。common 写入（已相同的跳过），
+// 不在 present 里的旧记录连同子树删除——两端都没了的路径留着祖先记录，
+// 日后有同内容的新文件出现时会被误判成"本地删过"。
+// 全量扫描每个目录都会调用，无需改动时不开写事务（bbolt 每次提交都要落盘）
+func RecordSyncBase(dir string, common map[string]string, present map[string]bool) error {
+	var puts []string
+	var stale []string
+	err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		if b == nil {
+			return fmt.Errorf("sync base bucket not found")
+		}
+		for p, v := range common {
+			if string(b.Get([]byte(p))) != v {
+				puts = append(puts, p)
+			}
+		}
+		prefix := []byte{}
+		if dir != "." {
+			prefix = []byte(dir + string(filepath.Separator))
+		}
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			rest := string(k[len(prefix):])
+			if rest == "" || strings.ContainsRune(rest, filepath.Separator) {
+				continue // 只管直接子项，更深的由子目录自己的比对负责
+			}
+			if !present[string(k)] {
+				stale = append(stale, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil || (len(puts) == 0 && len(stale) == 0) {
+		return err
+	}
+	return DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		for _, p := range stale {
+			if err := deleteBaseSubtree(b, p); err != nil {
+				return err
+			}
+		}
+		for _, p := range puts {
+			if err := b.Put([]byte(p), []byte(common[p])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SubtreeMatchesSyncBase 报告本地树里 dir 整棵子树（含 dir 自身）是否都与共同祖先
+// 一致：每个目录都有记录、每个文件的哈希都等于记录。对端删了整个目录时据此判断
+// 本地在这期间有没有往里新增或改动东西
+func SubtreeMatchesSyncBase(dir string) (bool, error) {
+	match := true
+	err := DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("sync_base"))
+		nodesBucket := tx.Bucket([]byte("nodes"))
+		childrenBucket := tx.Bucket([]byte("children"))
+		pathIndexBucket := tx.Bucket([]byte("path_index"))
+		if b == nil {
+			return fmt.Errorf("sync base bucket not found")
+		}
+		rootID := pathIndexBucket.Get([]byte(dir))
+		if rootID == nil {
+			return fmt.Errorf("%w: %s", ErrDirNotFound, dir)
+		}
+		queue := []string{string(rootID)}
+		for len(queue) > 0 && match {
+			id := queue[0]
+			queue = queue[1:]
+			data := nodesBucket.Get([]byte(id))
+			if data == nil {
+				continue
+			}
+			var node Node
+			if err := json.Unmarshal(data, &node); err != nil {
+				return err
+			}
+			want := node.Hash
+			if node.IsDir {
+				want = BaseDir
+			}
+			if base := b.Get([]byte(node.Path)); base == nil || string(base) != want || want == "" {
+				match = false
+				break
+			}
+			if !node.IsDir {
+				continue
+			}
+			if raw := childrenBucket.Get([]byte(id)); raw != nil {
+				var children Children
+				if err := json.Unmarshal(raw, &children); err != nil {
+					return err
+				}
+				queue = append(queue, children.ChildIDs...)
+			}
+		}
+		return nil
+	})
+	return match, err
+}
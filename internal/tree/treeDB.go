@@ -1,11 +1,13 @@
 package tree
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"local-mirror/config"
+	"local-mirror/internal/fsmeta"
 	"os"
 	"path/filepath"
 	"slices"
@@ -31,25 +33,41 @@ var ErrDirNotFound = errors.New("directory not found")
    - key: 完整路径 (Path)
    - value: 节点ID (UUID)
 4. meta: 存储元数据，如文件和目录计数
-   - key: 元数据键 (如 "file_count", "dir_count")
+   - key: 元数据键 (如 "file_count", "dir_count"，变更日志的 "journal_*")
    - value: uint64类型的计数值
-5. changed_dirs: 存储目录变动信息，支持按时间范围查询
-   - key: unix秒时间戳
-   - value: 目录路径数组
+5. changed_dirs: 变更日志，每批落库的变更目录一条（见 journal.go）
+   - key: 单调递增的序号 (uint64 大端)
+   - value: JSON序列化的journalEntry（落库时刻 + 目录路径数组）
+6. hash_index: 存储内容哈希到普通文件路径的映射（同内容多份即多条）
+   - key: 哈希 + "\x00" + 完整路径
+   - value: 空
+7. sync_base: 双向同步的共同祖先（两端最后一次一致时的状态，见 syncbase.go）
+   - key: 完整路径
+   - value: 文件/符号链接为哈希，目录为 "/"
+8. digest_dirty: 摘要待重算的目录（见 digest.go）
+   - key: 目录完整路径
+   - value: 占位
 */
 
 var DB *bolt.DB
 
 type Node struct {
-	ID       string    `json:"id"`        // UUID
-	Path     string    `json:"path"`      // 完整路径
-	Name     string    `json:"name"`      // 文件/目录名
-	ParentID string    `json:"parent_id"` // 父目录ID
-	IsDir    bool      `json:"is_dir"`    // 是否为目录
-	Size     uint64    `json:"size"`
-	ModTime  time.Time `json:"mod_time"`
-	Hash     string    `json:"hash"`
-	Depth    int       `json:"depth"` // 目录深度
+	ID       string       `json:"id"`        // UUID
+	Path     string       `json:"path"`      // 完整路径
+	Name     string       `json:"name"`      // 文件/目录名
+	ParentID string       `json:"parent_id"` // 父目录ID
+	IsDir    bool         `json:"is_dir"`    // 是否为目录
+	Size     uint64       `json:"size"`
+	ModTime  time.Time    `json:"mod_time"`
+	Hash     string       `json:"hash"`
+	Depth    int          `json:"depth"`            // 目录深度
+	Meta     *fsmeta.Meta `json:"meta,omitempty"`   // 权限/属主/扩展属性/链接目标，平台不支持时为空
+	Digest   string       `json:"digest,omitempty"` // 目录的子树摘要（见 digest.go），尚未算出时为空
+}
+
+// IsSymlink 节点是否是符号链接（Hash 为目标的哈希，见 fsmeta.LinkHash）
+func (n *Node) IsSymlink() bool {
+	return n.Meta != nil && n.Meta.Link != ""
 }
 
 type Children struct {
@@ -59,9 +77,9 @@ type Children struct {
 
 // SchemaVersion 数据库结构版本。节点序列化格式或桶结构变化时递增，
 // 旧版本缓存直接重建，避免读到不兼容的数据
-const SchemaVersion = "1"
+const SchemaVersion = "4"
 
-var allBuckets = []string{"nodes", "children", "path_index", "meta", "changed_dirs"}
+var allBuckets = []string{"nodes", "children", "path_index", "meta", "changed_dirs", "hash_index", "sync_base"}
 
 func InitDB() {
 	// 状态目录位于同步根目录下（支持 -p 从任意 CWD 启动）
@@ -71,11 +89,37 @@ func InitDB() {
 		os.Exit(1)
 	}
 
+	openDB(filepath.Join(stateDir, "cache.db"))
+}
+
+// OpenScratchDB 供 --dry-run 使用：把同步根下的 cache.db（若有）拷成 dir 里的
+// 一份副本再按 InitDB 的规则打开，之后建树、算摘要等写入都落在副本上，原库与
+// 状态目录都不被改动。原库被运行中的实例锁着时返回错误
+func OpenScratchDB(dir string) error {
+	scratch := filepath.Join(dir, "cache.db")
+	cache := filepath.Join(config.StartPath, ".local-mirror", "cache.db")
+	if _, err := os.Stat(cache); err == nil {
+		src, err := bolt.Open(cache, 0600, &bolt.Options{ReadOnly: true, Timeout: 3 * time.Second})
+		if err != nil {
+			return fmt.Errorf("failed to open %s (a local-mirror instance may be running on this directory): %w", cache, err)
+		}
+		err = src.View(func(tx *bolt.Tx) error { return tx.CopyFile(scratch, 0600) })
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to copy %s: %w", cache, err)
+		}
+	}
+	openDB(scratch)
+	return nil
+}
+
+// openDB 打开 path 处的数据库并按缓存可复用与否续用或重建，失败即退出进程
+func openDB(path string) {
 	var err error
 	// 必须设置 Timeout：bbolt 依赖文件锁，同一目录再启动一个实例时
 	// 不带超时的 Open 会无限期阻塞，进程看起来像卡死。
 	// 这个锁同时充当"每目录单实例"的互斥量，与模式组合无关
-	DB, err = bolt.Open(filepath.Join(stateDir, "cache.db"), 0600, &bolt.Options{Timeout: 3 * time.Second})
+	DB, err = bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
 	if err != nil {
 		log.Errorf("failed to open database (another local-mirror instance may be running on this directory): %v", err)
 		os.Exit(1)
@@ -98,13 +142,9 @@ func InitDB() {
 
 		if reuse {
 			log.Info("reusing directory tree cache from the previous run")
-			// 变更记录属于上一个实例的时间线：服务端重启后 InstanceID 变化，
-			// 客户端会重建会话并全量扫描，旧记录只会造成误导，直接清空
-			if err := tx.DeleteBucket([]byte("changed_dirs")); err != nil {
-				return fmt.Errorf("failed to reset changed_dirs: %w", err)
-			}
-			if _, err := tx.CreateBucket([]byte("changed_dirs")); err != nil {
-				return fmt.Errorf("failed to recreate changed_dirs: %w", err)
+			// 变更日志跨重启续用，下游凭游标增量追赶；接不上时另起纪元
+			if err := resumeJournal(tx); err != nil {
+				return err
 			}
 		} else {
 			log.Info("cache not reusable (first run / root changed / schema upgrade), rebuilding database")
@@ -126,6 +166,20 @@ func InitDB() {
 			if err := metaBucket.Put([]byte("file_count"), zero); err != nil {
 				return err
 			}
+			if err := resetJournal(tx); err != nil {
+				return err
+			}
+		}
+
+		// digest_dirty 不在 allBuckets 里：旧版缓存缺它不必整库重建，补建后目录摘要
+		// 为空的节点会在校准时被标记，随即算出
+		if !reuse && tx.Bucket(digestDirtyBucket) != nil {
+			if err := tx.DeleteBucket(digestDirtyBucket); err != nil {
+				return fmt.Errorf("failed to delete bucket %s: %w", digestDirtyBucket, err)
+			}
+		}
+		if _, err := tx.CreateBucketIfNotExists(digestDirtyBucket); err != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", digestDirtyBucket, err)
 		}
 
 		metaBucket := tx.Bucket([]byte("meta"))
@@ -187,7 +241,8 @@ func AddNodes(nodes []*Node) error {
 		childrenBucket := tx.Bucket([]byte("children"))
 		pathIndexBucket := tx.Bucket([]byte("path_index"))
 		metaBucket := tx.Bucket([]byte("meta"))
-		if nodesBucket == nil || childrenBucket == nil || pathIndexBucket == nil || metaBucket == nil {
+		hashIndexBucket := tx.Bucket([]byte("hash_index"))
+		if nodesBucket == nil || childrenBucket == nil || pathIndexBucket == nil || metaBucket == nil || hashIndexBucket == nil {
 			log.Error("Database buckets not initialized")
 			return os.ErrNotExist // 确保所有必要的桶都存在
 		}
@@ -198,10 +253,35 @@ func AddNodes(nodes []*Node) error {
 			// 避免 nodes 桶残留孤儿节点、children 列表出现重复引用
 			if existingID := pathIndexBucket.Get([]byte(node.Path)); existingID != nil {
 				node.ID = string(existingID)
+				var old *Node
 				if oldData := nodesBucket.Get(existingID); oldData != nil {
-					var old Node
-					if err := json.Unmarshal(oldData, &old); err == nil && old.ParentID != "" {
-						node.ParentID = old.ParentID
+					if err := json.Unmarshal(oldData, &old); err == nil {
+						if old.ParentID != "" {
+							node.ParentID = old.ParentID
+						}
+						if hashIndexed(old) {
+							if err := hashIndexBucket.Delete(hashIndexKey(old.Hash, old.Path)); err != nil {
+								return err
+							}
+						}
+					} else {
+						old = nil
+					}
+				}
+				// 目录沿用已算出的摘要，子树变化另由脏标记驱动重算；
+				// 自身进入父目录摘要的字段变了才标记父目录
+				node.Digest = ""
+				if old != nil && old.IsDir && node.IsDir {
+					node.Digest = old.Digest
+				}
+				if old == nil || digestEntryChanged(old, node) {
+					if err := markParentDigestDirty(tx, node.Path); err != nil {
+						return err
+					}
+				}
+				if node.IsDir && node.Digest == "" {
+					if err := markDigestDirty(tx, node.Path); err != nil {
+						return err
 					}
 				}
 				// 父链接核验修复：节点可能是历史缺陷留下的孤儿——存在于
@@ -241,9 +321,23 @@ func AddNodes(nodes []*Node) error {
 				if err := nodesBucket.Put(existingID, nodeData); err != nil {
 					return err
 				}
+				if hashIndexed(node) {
+					if err := hashIndexBucket.Put(hashIndexKey(node.Hash, node.Path), nil); err != nil {
+						return err
+					}
+				}
 				continue
 			}
 
+			node.Digest = ""
+			if err := markParentDigestDirty(tx, node.Path); err != nil {
+				return err
+			}
+			if node.IsDir {
+				if err := markDigestDirty(tx, node.Path); err != nil {
+					return err
+				}
+			}
 			nodeData, err := json.Marshal(*node)
 			if err != nil {
 				log.Error("Failed to marshal node:", err)
@@ -255,6 +349,11 @@ func AddNodes(nodes []*Node) error {
 			if err := pathIndexBucket.Put([]byte(node.Path), []byte(node.ID)); err != nil {
 				return err
 			}
+			if hashIndexed(node) {
+				if err := hashIndexBucket.Put(hashIndexKey(node.Hash, node.Path), nil); err != nil {
+					return err
+				}
+			}
 			// bool 类型直接用 if/else，switch bool 在 Go 中不惯用
 			if node.IsDir {
 				dirCount++
@@ -337,8 +436,9 @@ func DeleteNodes(nodePaths []string) error {
 		childrenBucket := tx.Bucket([]byte("children"))
 		pathIndexBucket := tx.Bucket([]byte("path_index"))
 		metaBucket := tx.Bucket([]byte("meta"))
+		hashIndexBucket := tx.Bucket([]byte("hash_index"))
 
-		if nodesBucket == nil || childrenBucket == nil || pathIndexBucket == nil || metaBucket == nil {
+		if nodesBucket == nil || childrenBucket == nil || pathIndexBucket == nil || metaBucket == nil || hashIndexBucket == nil {
 			log.Error("Database buckets not initialized")
 			return os.ErrNotExist
 		}
@@ -371,6 +471,10 @@ func DeleteNodes(nodePaths []string) error {
 				return err
 			}
 
+			if err := markParentDigestDirty(tx, rootNode.Path); err != nil {
+				return err
+			}
+
 			// 收集父节点更新信息
 			if rootNode.ParentID != "" {
 				if _, exists := parentUpdates[rootNode.ParentID]; !exists {
@@ -444,8 +548,11 @@ func DeleteNodes(nodePaths []string) error {
 				} else {
 					totalFileCount++
 				}
-				// 删除路径索引
+				// 删除路径索引与哈希索引
 				pathIndexBucket.Delete([]byte(node.Path))
+				if hashIndexed(&node) {
+					hashIndexBucket.Delete(hashIndexKey(node.Hash, node.Path))
+				}
 			}
 
 			// 删除节点数据
@@ -529,6 +636,38 @@ func DeleteNode(nodePath string) error {
 	return DeleteNodes([]string{nodePath})
 }
 
+// hashIndexed 节点是否收进 hash_index：只收有哈希的普通文件（符号链接的哈希
+// 是目标的哈希，见 fsmeta.LinkHash，不代表文件内容）
+func hashIndexed(n *Node) bool {
+	return !n.IsDir && !n.IsSymlink() && n.Hash != ""
+}
+
+func hashIndexKey(hash, path string) []byte {
+	return []byte(hash + "\x00" + path)
+}
+
+// PathsByHash 返回树里内容哈希为 hash 的普通文件路径，供汇端用本地已有的
+// 同内容文件满足下载。索引只反映入树时的哈希，调用方使用前须自行校验磁盘现状
+func PathsByHash(hash string) ([]string, error) {
+	var paths []string
+	if hash == "" {
+		return nil, nil
+	}
+	prefix := []byte(hash + "\x00")
+	err := DB.View(func(tx *bolt.Tx) error {
+		hashIndexBucket := tx.Bucket([]byte("hash_index"))
+		if hashIndexBucket == nil {
+			return fmt.Errorf("hash index bucket not found")
+		}
+		c := hashIndexBucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			paths = append(paths, string(k[len(prefix):]))
+		}
+		return nil
+	})
+	return paths, err
+}
+
 func HasPath(path string) (bool, error) {
 	var exists bool
 	err := DB.View(func(tx *bolt.Tx) error {
@@ -624,85 +763,3 @@ func GetAllDirNodes() ([]*Node, error) {
 	})
 	return dirNodes, err
 }
-
-// ChangedDirRetention changed_dirs 的保留窗口。写入与读取两侧都按它设限：
-// 清理只能在写入事务里做，而源端空闲时根本没有写入，桶里的记录会无限期停留
-const ChangedDirRetention = time.Hour
-
-// addChangedDir 把一批变更目录写入 changed_dirs 桶。
-// 必须以落库时刻做 key：若用事件发生时间，节流延迟会让记录"出现在过去"，
-// 客户端按时间窗游标查询时正好跳过它们
-func addChangedDir(paths []string) error {
-	err := DB.Update(func(tx *bolt.Tx) error {
-		changedDirsBucket := tx.Bucket([]byte("changed_dirs"))
-		if changedDirsBucket == nil {
-			log.Error("Database buckets not initialized")
-			return os.ErrNotExist
-		}
-
-		horizon := uint64(time.Now().Add(-ChangedDirRetention).Unix())
-		c := changedDirsBucket.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			if binary.BigEndian.Uint64(k) < horizon {
-				if err := changedDirsBucket.Delete(k); err != nil {
-					log.Warnf("Failed to delete old changed dir record: %v", err)
-				}
-			} else {
-				break
-			}
-		}
-
-		timeStampBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(timeStampBytes, uint64(time.Now().Unix()))
-
-		// 同一秒内可能已有记录，合并而不是覆盖
-		if existing := changedDirsBucket.Get(timeStampBytes); existing != nil {
-			var existingPaths []string
-			if err := json.Unmarshal(existing, &existingPaths); err == nil {
-				paths = append(existingPaths, paths...)
-			}
-		}
-
-		dirData, err := json.Marshal(paths)
-		if err != nil {
-			return fmt.Errorf("failed to marshal changed dirs: %w", err)
-		}
-		return changedDirsBucket.Put(timeStampBytes, dirData)
-	})
-	return err
-}
-
-// GetChangedDirs 取 [start, end] 内落库的变更目录。
-// start 会被抬到保留窗口下沿：清理只发生在写入事务里，源端一旦空闲就不再有
-// 写入，过期记录会永久留在桶中。而客户端每做一次全量扫描就把游标归 0，
-// 下一轮变更追踪便会把整桶陈旧路径重放一遍——其中已被删除的目录每轮报一次
-// "directory not found"，源端空闲整夜就刷屏整夜（生产实测 17 小时、每 35 分钟一轮）。
-// 完整性不依赖这里：客户端连接时、唤醒后、以及每个冷却周期都会全量扫描兜底
-func GetChangedDirs(start int64, end int64) ([]string, error) {
-	if floor := time.Now().Add(-ChangedDirRetention).Unix(); start < floor {
-		start = floor
-	}
-	var dirs []string
-	err := DB.View(func(tx *bolt.Tx) error {
-		changedDirsBucket := tx.Bucket([]byte("changed_dirs"))
-		if changedDirsBucket == nil {
-			return fmt.Errorf("changed_dirs bucket not found")
-		}
-		c := changedDirsBucket.Cursor()
-		startStamp := uint64(start)
-		endStamp := uint64(end)
-		startStampBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(startStampBytes, startStamp)
-
-		for k, v := c.Seek(startStampBytes); k != nil && binary.BigEndian.Uint64(k) <= endStamp; k, v = c.Next() {
-			var _dirs []string
-			if err := json.Unmarshal(v, &_dirs); err != nil {
-				log.Error("Failed to unmarshal changed dir:", err)
-				continue
-			}
-			dirs = append(dirs, _dirs...)
-		}
-		return nil
-	})
-	return dirs, err
-}
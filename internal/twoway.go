@@ -0,0 +1,305 @@
+package app
+
+import (
+	"fmt"
+	"local-mirror/config"
+	"local-mirror/internal/safety"
+	"local-mirror/internal/tree"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 双向同步（--bidirectional）：两端各跑一套源引擎与汇引擎，互相拉取。汇引擎
+// 照常按"对端树 vs 本地树"算出 diff，但 diff 只说明两侧不同，不说明哪一侧变了：
+// 单向镜像里对端恒为准，这里则要拿共同祖先（tree.SyncBase，两端最后一次一致时
+// 的状态）来判定——
+//
+//	本地 == 祖先，对端 != 祖先   对端改的：取对端
+//	对端 == 祖先，本地 != 祖先   本地改的：不动，等对端来拉
+//	两侧都 != 祖先（或无祖先）    冲突：两份都留
+//
+// 冲突的取舍两端必须算出同一个结论，否则各自把对方的版本挪走、两边互换：
+// 修改时间较新的一份保住原名，时间相同比哈希。输的一侧由持有它的那端
+// 自己改名为 name.conflict-<本机别名>-<时刻>.ext 再取对端版本，赢的一侧
+// 什么都不做，稍后把改名出的冲突副本当新文件拉过来。
+//
+// 元数据（权限/属主/扩展属性）不参与双向：没有祖先可比，两端同时拉取时会互相回滚
+
+// twoWayAction 一项 diff 在双向同步下的处置
+type twoWayAction int
+
+const (
+	takeRemote twoWayAction = iota // 照常应用：对端的改动
+	keepLocal                      // 跳过：本地的改动，由对端来拉
+	descendDir                     // 本端删过的目录：不建目录，只下钻取对端在其中新增/改动的内容
+	conflict                       // 两侧都改了：按 remoteWins 决定谁挪作冲突副本
+)
+
+// recordCommonBase 把一次目录比对里两端一致的项记为共同祖先。diffs 须是未经
+// 忽略过滤的原始比对结果：不在 diffs 里（或只有元数据不同）的对端条目即两端一致
+func recordCommonBase(dir string, remote []tree.Node, diffs []DiffResult) {
+	differs := make(map[string]bool, len(diffs))
+	present := make(map[string]bool, len(remote)+len(diffs))
+	for _, v := range diffs {
+		if v.Action != "meta" {
+			differs[v.Path] = true
+		}
+		present[v.Path] = true
+	}
+	common := make(map[string]string)
+	for _, n := range remote {
+		present[n.Path] = true
+		if differs[n.Path] {
+			continue
+		}
+		if n.IsDir {
+			common[n.Path] = tree.BaseDir
+		} else if n.Hash != "" {
+			common[n.Path] = n.Hash
+		}
+	}
+	if err := tree.RecordSyncBase(dir, common, present); err != nil {
+		log.Warnf("recording the common state of %s: %v", dir, err)
+	}
+}
+
+// reconcileTwoWay 按共同祖先改写一个目录的 diff（已过忽略过滤）：返回照常应用的项，
+// 以及需要下钻但不建目录的项（本端删过的目录，见 descendDir）。
+// 冲突在这里就地处理——本地输了的先挪成冲突副本，该项随即按取对端应用
+func reconcileTwoWay(diffs []DiffResult) (kept, descend []DiffResult) {
+	kept = diffs[:0:0]
+	for _, v := range diffs {
+		switch decideTwoWay(v) {
+		case takeRemote:
+			if v.Action == "create" {
+				// 对端往本端删过的目录里加了东西：父目录得先回来
+				if err := ensureLocalParents(v.Path); err != nil {
+					log.Errorf("restoring the parents of %s: %v", v.Path, err)
+					continue
+				}
+			}
+			kept = append(kept, v)
+		case descendDir:
+			descend = append(descend, v)
+		case conflict:
+			if v, ok := resolveConflict(v); ok {
+				kept = append(kept, v)
+			}
+		default:
+			log.Debugf("two-way: keeping the local side of %s (%s upstream), the peer picks it up", v.Path, v.Action)
+		}
+	}
+	return kept, descend
+}
+
+// decideTwoWay 判定单项 diff 的处置。拿不准（读祖先/本地节点失败）时一律不动本地：
+// 保留的改动下一轮还能再比，取错一次就是覆盖或删除
+func decideTwoWay(v DiffResult) twoWayAction {
+	if v.Action == "meta" {
+		return keepLocal
+	}
+	base, hasBase, err := tree.SyncBase(v.Path)
+	if err != nil {
+		log.Warnf("reading the common state of %s: %v", v.Path, err)
+		return keepLocal
+	}
+	switch v.Action {
+	case "delete":
+		// 对端没有、本地有：本地没祖先即本地新建的，有祖先且本地未变才是对端删的
+		if !hasBase {
+			return keepLocal
+		}
+		if v.IsDir {
+			// 整个目录被对端删了：本地在里面新增或改过东西就整个留下，
+			// 对端下钻进来时只会取回这些新东西（见 descendDir）
+			if same, err := tree.SubtreeMatchesSyncBase(v.Path); err != nil || !same {
+				return keepLocal
+			}
+			return takeRemote
+		}
+		if v.Hash != "" && v.Hash == base {
+			return takeRemote
+		}
+		return keepLocal // 对端删了、本地又改过：改动优先，会回流到对端
+
+	case "create":
+		// 对端有、本地没有：无祖先即对端新建的，有祖先说明本地删过
+		if !hasBase {
+			return takeRemote
+		}
+		if v.IsDir {
+			return descendDir
+		}
+		if v.Hash != "" && v.Hash == base {
+			return keepLocal // 本地删了、对端没动：删除会回流到对端
+		}
+		return takeRemote // 本地删了、对端又改过：改动优先
+
+	case "modify":
+		if v.IsDir {
+			return takeRemote
+		}
+		local, err := tree.GetNodeByPath(v.Path)
+		if err != nil || local.Hash == "" || v.Hash == "" {
+			return keepLocal
+		}
+		switch {
+		case hasBase && local.Hash == base:
+			return takeRemote
+		case hasBase && v.Hash == base:
+			return keepLocal
+		}
+		return conflict
+
+	case "retype":
+		// 类型互换没有"两份都留"的合理落点：本地仍是祖先状态才取对端，
+		// 否则保留本地并告警，交给人处理
+		local, err := tree.GetNodeByPath(v.Path)
+		if err != nil || !hasBase {
+			return keepLocal
+		}
+		unchanged := local.Hash != "" && local.Hash == base
+		if local.IsDir {
+			unchanged, _ = tree.SubtreeMatchesSyncBase(v.Path)
+		}
+		if unchanged {
+			return takeRemote
+		}
+		log.Warnf("%s changed type on the peer but was also changed here; keeping the local one", v.Path)
+		return keepLocal
+	}
+	return takeRemote
+}
+
+// resolveConflict 处理两侧都改了的文件。对端赢：本地版本挪成冲突副本，返回原项
+// 照常下载；本地赢：什么都不做，对端那边会把它的版本挪成冲突副本
+func resolveConflict(v DiffResult) (DiffResult, bool) {
+	local, err := tree.GetNodeByPath(v.Path)
+	if err != nil {
+		return v, false
+	}
+	if !remoteWins(local, v) {
+		log.Warnf("conflict on %s: both ends changed it; keeping this version, the peer keeps its own as a conflict copy", v.Path)
+		return v, false
+	}
+	aside, err := moveAside(v.Path, local)
+	if err != nil {
+		log.Errorf("conflict on %s: setting the local version aside failed, keeping it: %v", v.Path, err)
+		return v, false
+	}
+	log.Warnf("conflict on %s: both ends changed it; the peer's version wins, the local one is kept as %s", v.Path, aside)
+	return v, true
+}
+
+// remoteWins 冲突双方谁保住原名：修改时间较新者胜，相同则哈希较大者胜。
+// 两端拿同一对版本各算一次，结论必须互补——对端算出的恰是本函数取反
+func remoteWins(local *tree.Node, v DiffResult) bool {
+	if !v.ModTime.Equal(local.ModTime) {
+		return v.ModTime.After(local.ModTime)
+	}
+	return v.Hash > local.Hash
+}
+
+// moveAside 把本地的 rel 改名为同目录下的冲突副本并更新树，返回副本的相对路径。
+// 副本是个新路径，两端都没有祖先记录，随后作为普通新文件同步到对端
+func moveAside(rel string, local *tree.Node) (string, error) {
+	full, err := safety.SafeResolveEntry(config.StartPath, rel)
+	if err != nil {
+		return "", err
+	}
+	name := conflictName(filepath.Base(rel), config.AliasName, time.Now())
+	asideRel := filepath.Join(filepath.Dir(rel), name)
+	asideFull, err := safety.SafeResolveEntry(config.StartPath, asideRel)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(asideFull); err == nil {
+		return "", fmt.Errorf("%s already exists", asideRel)
+	}
+	if err := os.Rename(full, asideFull); err != nil {
+		return "", err
+	}
+	if err := tree.DeleteNode(rel); err != nil {
+		return asideRel, err
+	}
+	copyDiff := DiffResult{Path: asideRel, Name: name, Size: local.Size, Meta: local.Meta}
+	if err := tree.AddNodes([]*tree.Node{createNodeFromDiff(copyDiff, local.Hash)}); err != nil {
+		return asideRel, err
+	}
+	recordChangedDir(asideRel)
+	return asideRel, nil
+}
+
+// conflictName 冲突副本的文件名：name.conflict-<host>-<时刻>.ext。
+// 扩展名保留在最后，副本仍能用原来的程序打开；点文件（.bashrc）整个当主干
+func conflictName(name, host string, at time.Time) string {
+	host = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, host)
+	if host == "" {
+		host = "local-mirror"
+	}
+	stem, ext := name, filepath.Ext(name)
+	if ext != "" && ext != name {
+		stem = strings.TrimSuffix(name, ext)
+	} else {
+		ext = ""
+	}
+	return fmt.Sprintf("%s.conflict-%s-%s%s", stem, host, at.Format("20060102-150405"), ext)
+}
+
+// ensureLocalParents 补建 rel 在本地树里缺失的各级父目录（本端删过、对端又往里
+// 加了东西的情形）。已存在的目录不动
+func ensureLocalParents(rel string) error {
+	var missing []string
+	for dir := filepath.Dir(rel); dir != "." && dir != ""; dir = filepath.Dir(dir) {
+		exists, err := tree.HasPath(dir)
+		if err != nil {
+			return err
+		}
+		if exists {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		dir := missing[i]
+		if err := processDirectoryDiff(DiffResult{Path: dir, Name: filepath.Base(dir), IsDir: true, Action: "create"}); err != nil {
+			return err
+		}
+		recordChangedDir(dir)
+	}
+	return nil
+}
+
+// noteSynced 一项 diff 应用成功后更新共同祖先：本地此刻与对端一致的记下，
+// 本地已不存在的（删除生效）连同子树清掉。应用"成功"可能只是按策略跳过
+// （如未开 --allow-delete 的删除），所以看的是树里的现状而不是动作本身
+func noteSynced(v DiffResult) {
+	if !config.TwoWay() {
+		return
+	}
+	exists, err := tree.HasPath(v.Path)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = tree.DeleteSyncBase(v.Path)
+	} else if node, nerr := tree.GetNodeByPath(v.Path); nerr != nil {
+		return
+	} else if node.IsDir {
+		err = tree.SetSyncBase(v.Path, tree.BaseDir)
+	} else if node.Hash != "" && node.Hash == v.Hash {
+		err = tree.SetSyncBase(v.Path, node.Hash)
+	}
+	if err != nil {
+		log.Warnf("recording the common state of %s: %v", v.Path, err)
+	}
+}
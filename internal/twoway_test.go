@@ -0,0 +1,204 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+)
+
+// setupTwoWay 建一棵双向同步用的本地树：a.txt、d/b.txt，返回同步根
+func setupTwoWay(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	config.StartPath = root
+	config.IgnoreFileList = nil
+	config.AliasName = "ws-a"
+	mode := *config.Mode
+	*config.Mode = "bidirectional"
+	t.Cleanup(func() { *config.Mode = mode })
+
+	os.MkdirAll(filepath.Join(root, "d"), 0o755)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("local a"), 0o644)
+	os.WriteFile(filepath.Join(root, "d", "b.txt"), []byte("local b"), 0o644)
+	tree.InitDB()
+	t.Cleanup(func() { tree.DB.Close() })
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func localHash(t *testing.T, rel string) string {
+	t.Helper()
+	n, err := tree.GetNodeByPath(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n.Hash
+}
+
+// TestDecideTwoWay 共同祖先决定每处差异取哪一侧
+func TestDecideTwoWay(t *testing.T) {
+	setupTwoWay(t)
+	a := localHash(t, "a.txt")
+	b := localHash(t, filepath.Join("d", "b.txt"))
+
+	// 无祖先：对端新建的取、本端新建的留、两侧各有一份的算冲突
+	if got := decideTwoWay(DiffResult{Path: "new.txt", Action: "create", Hash: "r1"}); got != takeRemote {
+		t.Errorf("对端新建应取对端, got %v", got)
+	}
+	if got := decideTwoWay(DiffResult{Path: "a.txt", Action: "delete", Hash: a}); got != keepLocal {
+		t.Errorf("本端新建（对端没有）应保留, got %v", got)
+	}
+	if got := decideTwoWay(DiffResult{Path: "a.txt", Action: "modify", Hash: "r1"}); got != conflict {
+		t.Errorf("无祖先的两份不同内容应算冲突, got %v", got)
+	}
+
+	tree.SetSyncBase("a.txt", a)
+	tree.SetSyncBase("d", tree.BaseDir)
+	tree.SetSyncBase(filepath.Join("d", "b.txt"), b)
+	cases := []struct {
+		name string
+		v    DiffResult
+		want twoWayAction
+	}{
+		{"对端改、本地未动", DiffResult{Path: "a.txt", Action: "modify", Hash: "r1"}, takeRemote},
+		{"对端删、本地未动", DiffResult{Path: "a.txt", Action: "delete", Hash: a}, takeRemote},
+		{"对端删整个目录、本地未动", DiffResult{Path: "d", IsDir: true, Action: "delete"}, takeRemote},
+		{"只有元数据不同", DiffResult{Path: "a.txt", Action: "meta", Hash: a}, keepLocal},
+	}
+	for _, c := range cases {
+		if got := decideTwoWay(c.v); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// 本地改过：对端仍是祖先则保留本地，对端也改了则冲突，对端删了则改动优先
+	tree.SetSyncBase("a.txt", "old")
+	if got := decideTwoWay(DiffResult{Path: "a.txt", Action: "modify", Hash: "old"}); got != keepLocal {
+		t.Errorf("本地改、对端未动应保留本地, got %v", got)
+	}
+	if got := decideTwoWay(DiffResult{Path: "a.txt", Action: "modify", Hash: "r2"}); got != conflict {
+		t.Errorf("两侧都改应算冲突, got %v", got)
+	}
+	if got := decideTwoWay(DiffResult{Path: "a.txt", Action: "delete", Hash: a}); got != keepLocal {
+		t.Errorf("对端删、本地改应保留本地, got %v", got)
+	}
+
+	// 本地删过：对端未动的不取回，对端改过的取回；删过的目录只下钻
+	tree.SetSyncBase("gone.txt", "g")
+	if got := decideTwoWay(DiffResult{Path: "gone.txt", Action: "create", Hash: "g"}); got != keepLocal {
+		t.Errorf("本地删、对端未动不应取回, got %v", got)
+	}
+	if got := decideTwoWay(DiffResult{Path: "gone.txt", Action: "create", Hash: "g2"}); got != takeRemote {
+		t.Errorf("本地删、对端改应取回, got %v", got)
+	}
+	tree.SetSyncBase("gonedir", tree.BaseDir)
+	if got := decideTwoWay(DiffResult{Path: "gonedir", IsDir: true, Action: "create"}); got != descendDir {
+		t.Errorf("本地删过的目录应只下钻, got %v", got)
+	}
+
+	// 对端删了目录，但本地往里加了新文件：整个留下
+	os.WriteFile(filepath.Join(config.StartPath, "d", "c.txt"), []byte("new"), 0o644)
+	if err := tree.BuildFileTree(config.StartPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := decideTwoWay(DiffResult{Path: "d", IsDir: true, Action: "delete"}); got != keepLocal {
+		t.Errorf("目录里有本地新增时不应整个删除, got %v", got)
+	}
+}
+
+// TestResolveConflictKeepsBoth 冲突的两端结论互补：对端较新时本地版本挪成冲突副本，
+// 本地较新时原样保留
+func TestResolveConflictKeepsBoth(t *testing.T) {
+	root := setupTwoWay(t)
+	local, _ := tree.GetNodeByPath("a.txt")
+
+	older := DiffResult{Path: "a.txt", Name: "a.txt", Action: "modify", Hash: "r1", ModTime: local.ModTime.Add(-time.Hour)}
+	if _, ok := resolveConflict(older); ok {
+		t.Fatal("本地较新时不应取对端")
+	}
+	if _, err := os.Stat(filepath.Join(root, "a.txt")); err != nil {
+		t.Fatal("本地较新时原文件应原样保留")
+	}
+
+	newer := older
+	newer.ModTime = local.ModTime.Add(time.Hour)
+	if _, ok := resolveConflict(newer); !ok {
+		t.Fatal("对端较新时应取对端")
+	}
+	if _, err := os.Lstat(filepath.Join(root, "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("本地版本应已挪走，腾出原名给对端版本")
+	}
+	entries, _ := os.ReadDir(root)
+	var aside string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "a.conflict-ws-a-") && strings.HasSuffix(e.Name(), ".txt") {
+			aside = e.Name()
+		}
+	}
+	if aside == "" {
+		t.Fatalf("没有找到冲突副本: %v", entries)
+	}
+	if b, _ := os.ReadFile(filepath.Join(root, aside)); string(b) != "local a" {
+		t.Fatalf("冲突副本内容不对: %q", b)
+	}
+	if n, err := tree.GetNodeByPath(aside); err != nil || n.Hash != local.Hash {
+		t.Fatalf("冲突副本应以原哈希入树: %v %v", n, err)
+	}
+	if ok, _ := tree.HasPath("a.txt"); ok {
+		t.Fatal("原路径的旧节点应已移除")
+	}
+}
+
+// TestRecordCommonBase 两端一致的项记为祖先，两端都没了的旧记录连同子树清掉
+func TestRecordCommonBase(t *testing.T) {
+	setupTwoWay(t)
+	a := localHash(t, "a.txt")
+	tree.SetSyncBase("old", tree.BaseDir)
+	tree.SetSyncBase(filepath.Join("old", "x.txt"), "x")
+
+	remote := []tree.Node{
+		{Path: "a.txt", Hash: a},
+		{Path: "d", IsDir: true},
+		{Path: "r.txt", Hash: "r"},
+	}
+	diffs := []DiffResult{{Path: "r.txt", Action: "create", Hash: "r"}}
+	recordCommonBase(".", remote, diffs)
+
+	if v, ok, _ := tree.SyncBase("a.txt"); !ok || v != a {
+		t.Errorf("一致的文件应记为祖先: %q %v", v, ok)
+	}
+	if v, ok, _ := tree.SyncBase("d"); !ok || v != tree.BaseDir {
+		t.Errorf("一致的目录应记为祖先: %q %v", v, ok)
+	}
+	if _, ok, _ := tree.SyncBase("r.txt"); ok {
+		t.Error("不一致的项不应记为祖先")
+	}
+	if _, ok, _ := tree.SyncBase(filepath.Join("old", "x.txt")); ok {
+		t.Error("两端都没了的旧记录应连同子树清掉")
+	}
+}
+
+func TestConflictName(t *testing.T) {
+	at := time.Date(2026, 3, 1, 9, 30, 5, 0, time.Local)
+	cases := map[string]string{
+		"report.docx": "report.conflict-ws-a-20260301-093005.docx",
+		"Makefile":    "Makefile.conflict-ws-a-20260301-093005",
+		".bashrc":     ".bashrc.conflict-ws-a-20260301-093005",
+		"a.tar.gz":    "a.tar.conflict-ws-a-20260301-093005.gz",
+	}
+	for in, want := range cases {
+		if got := conflictName(in, "ws-a", at); got != want {
+			t.Errorf("%s: got %q, want %q", in, got, want)
+		}
+	}
+	if got := conflictName("x.txt", "my host/1", at); got != "x.conflict-my-host-1-20260301-093005.txt" {
+		t.Errorf("主机名里的分隔符应替换: %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"local-mirror/config"
+	"local-mirror/internal/network"
+	"local-mirror/internal/tree"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// `local-mirror verify`：全量扫描信任 size+mtime 未变的缓存哈希，副本是否与源逐位
+// 一致只能靠重读内容来证明。verify 先按磁盘校准本地树，再重读每个文件重算哈希
+// （tree.Rehash，内容悄悄变了的节点改记实际哈希），然后与 --dry-run 一样逐层对账
+// 出计划。--repair 时接着跑一轮正常的全量扫描，差异经常规下载路径修复
+
+// VerifyReport verify 的结果
+type VerifyReport struct {
+	Source  string  `json:"source"`
+	Files   int     `json:"files"` // 重读过的文件数
+	Bytes   uint64  `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	// Corrupt size 与 mtime 都没变、内容却与上次记录不同的本地文件（位衰减或篡改）
+	Corrupt  []string `json:"corrupt"`
+	Plan     *Plan    `json:"plan"` // 与源的差异（重算哈希之后）
+	Repaired bool     `json:"repaired"`
+}
+
+// Clean 副本与源一致：没有本地损坏、没有要做的改动、每个目录都比对到了。
+// 未开 --allow-delete 时上游已没有的本地条目（Kept）不算不一致
+func (r *VerifyReport) Clean() bool {
+	return len(r.Corrupt) == 0 && r.Plan.changes() == 0 && len(r.Plan.Unscanned) == 0
+}
+
+// changes 计划里会改动本地的项数
+func (p *Plan) changes() int {
+	return p.Creates + p.Overwrites + p.Deletes + p.Renames + p.Retypes + p.Meta
+}
+
+// Verify 执行 verify。调用方先打开缓存库：只读校验用 tree.OpenScratchDB 的副本，
+// --repair 用 InitDB 取得目录锁
+func Verify(repair bool) (*VerifyReport, error) {
+	start := time.Now()
+	if err := tree.BuildFileTree(config.StartPath); err != nil {
+		return nil, fmt.Errorf("failed to scan the local tree: %w", err)
+	}
+	lastLocalRebuild = time.Now()
+	rh, err := tree.Rehash(runtime.NumCPU())
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-hash the replica: %w", err)
+	}
+	for _, p := range rh.Mismatched {
+		log.Warnf("verify: %s changed on disk without a new size or mtime", p)
+	}
+
+	fileClient, err := ensureConnected(InitConn)
+	if err != nil {
+		return nil, err
+	}
+	defer fileClient.ConnectionClose()
+
+	report := &VerifyReport{
+		Source:  fileClient.RealityAddr,
+		Files:   rh.Files,
+		Bytes:   rh.Bytes,
+		Corrupt: append([]string{}, rh.Mismatched...),
+		Plan:    newPlan(fileClient.RealityAddr),
+	}
+	if err := planScan(fileClient, report.Plan); err != nil {
+		return nil, err
+	}
+	if repair && !report.Clean() {
+		if err := executeTaskWithClient("verify repair", fileClient, fullScan); err != nil {
+			return nil, err
+		}
+		report.Repaired = true
+	}
+	report.Seconds = time.Since(start).Seconds()
+	return report, nil
+}
+
+// planScan 以 dryRun 模式从根逐层对账，把差异记进 plan
+func planScan(fileClient *network.FileClient, plan *Plan) error {
+	dryRun = plan
+	defer func() { dryRun = nil }()
+	NextLevel.Clear()
+	NextLevel.Push(DiffResult{Path: ".", IsDir: true, Action: "create", Name: "root"})
+	return drainNextLevel(fileClient, true)
+}
+
+// WriteJSON 以 JSON 输出报告
+func (r *VerifyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable 以表格输出报告：先列本地损坏的文件，再列与源的差异
+func (r *VerifyReport) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "re-hashed %d files (%s) in %.1fs\n", r.Files, humanBytes(r.Bytes), r.Seconds)
+	if len(r.Corrupt) > 0 {
+		fmt.Fprintf(w, "\n%d files changed on disk without a new size or mtime (bit rot or tampering):\n", len(r.Corrupt))
+		for _, p := range r.Corrupt {
+			fmt.Fprintf(w, "  %s\n", p)
+		}
+	}
+	if r.Plan.changes() > 0 || r.Plan.Kept > 0 || len(r.Plan.Unscanned) > 0 {
+		fmt.Fprintln(w)
+		if err := r.Plan.WriteTable(w); err != nil {
+			return err
+		}
+	}
+	switch {
+	case r.Repaired:
+		fmt.Fprintf(w, "\nrepaired through the normal download path; run verify again to confirm\n")
+	case r.Clean():
+		fmt.Fprintf(w, "\nreplica matches %s\n", r.Source)
+	default:
+		fmt.Fprintf(w, "\nreplica differs from %s; --repair re-downloads the differences\n", r.Source)
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+// Package versions 实现汇端的版本化回收站（--keep-versions）：同步引擎每次
+// 覆盖或删除本地文件前，把旧副本留存到
+// <同步根>/.local-mirror/versions/<相对路径>/<UTC 时间戳>，
+// 按数量与时长保留，并可用 `local-mirror restore` 取回。
+//
+// 覆盖走硬链接留存（随后的 rename 换的是目录项、不动旧 inode，链接即完整快照，
+// 零拷贝）；删除直接把文件 rename 进版本目录。两者都只处理普通文件：目录
+// 逐个留存其下文件，符号链接等特殊文件不留存。
+package versions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stampLayout 版本文件名：UTC、字典序即时间序、不含冒号（Windows 文件名合法）
+const stampLayout = "20060102T150405.000Z"
+
+// Policy 保留策略。Keep 为每个路径最多保留的版本数，MaxAge 为版本最长保留时长；
+// 任一为 0 表示该维度不限
+type Policy struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+// NewPolicy 按 --versions-keep / --versions-max-age 的原始值（个数、天数）构造策略
+func NewPolicy(keep, maxAgeDays int) Policy {
+	return Policy{Keep: keep, MaxAge: time.Duration(maxAgeDays) * 24 * time.Hour}
+}
+
+// Version 一个留存的旧版本
+type Version struct {
+	Path string    // 版本文件的绝对路径
+	Time time.Time // 留存时刻（UTC）
+	Size int64
+}
+
+// Dir 版本库根目录
+func Dir(root string) string {
+	return filepath.Join(root, ".local-mirror", "versions")
+}
+
+// versionDir 某个相对路径的版本目录
+func versionDir(root, rel string) string {
+	return filepath.Join(Dir(root), filepath.Clean(rel))
+}
+
+// Preserve 在 rel 即将被覆盖前留存其当前内容（硬链接，跨设备等失败时退回复制）。
+// 目标不存在或不是普通文件时什么都不做
+func Preserve(root, rel string, now time.Time, p Policy) error {
+	full := filepath.Join(root, rel)
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	dst, err := reserve(root, rel, now)
+	if err != nil {
+		return err
+	}
+	if err := os.Link(full, dst); err != nil {
+		if err := copyFile(full, dst); err != nil {
+			os.Remove(dst)
+			return fmt.Errorf("preserving %s: %w", rel, err)
+		}
+	}
+	return prunePath(versionDir(root, rel), p, now)
+}
+
+// Stash 在 rel 即将被删除前把它移入版本库：普通文件整体移入，目录则逐个移入
+// 其下的普通文件（目录骨架与特殊文件留给调用方随后的 RemoveAll 清理）。
+// 任一文件移入失败即返回错误，调用方应放弃这次删除
+func Stash(root, rel string, now time.Time, p Policy) error {
+	full := filepath.Join(root, rel)
+	info, err := os.Lstat(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return stashFile(root, rel, info, now, p)
+	}
+	return filepath.WalkDir(full, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sub, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return stashFile(root, sub, info, now, p)
+	})
+}
+
+func stashFile(root, rel string, info os.FileInfo, now time.Time, p Policy) error {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	return Adopt(root, rel, filepath.Join(root, rel), now, p)
+}
+
+// Adopt 把已离开原位的文件 src（如跨目录移动检测暂存区里没被认领的文件）
+// 收为 rel 的一个版本。src 须与版本库在同一文件系统上
+func Adopt(root, rel, src string, now time.Time, p Policy) error {
+	dst, err := reserve(root, rel, now)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return fmt.Errorf("stashing %s: %w", rel, err)
+	}
+	return prunePath(versionDir(root, rel), p, now)
+}
+
+// reserve 建好 rel 的版本目录并返回一个未被占用的版本文件路径。
+// 同一毫秒内的多次留存顺延 1ms，保证文件名唯一且仍按时间排序
+func reserve(root, rel string, now time.Time) (string, error) {
+	dir := versionDir(root, rel)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	t := now.UTC()
+	for {
+		dst := filepath.Join(dir, t.Format(stampLayout))
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			return dst, nil
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// List 列出 rel 的全部版本，按时间从旧到新
+func List(root, rel string) ([]Version, error) {
+	dir := versionDir(root, rel)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []Version
+	for _, e := range entries {
+		t, err := time.Parse(stampLayout, e.Name())
+		if err != nil || !e.Type().IsRegular() {
+			continue // 版本目录下也可能是子路径的版本目录（rel 曾是目录）
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Version{Path: filepath.Join(dir, e.Name()), Time: t, Size: info.Size()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out, nil
+}
+
+// ErrNoVersion 没有符合条件的版本
+var ErrNoVersion = errors.New("no matching version")
+
+// Pick 选出 at 时刻在位的内容。版本的时间戳是它被覆盖/删除的时刻，所以
+// at 时刻在位的是时间戳晚于 at 的最早一个版本；没有则说明 at 之后没再变过
+// （在位的就是现存文件），返回 ErrNoVersion。at 为零值取最新版本
+func Pick(versions []Version, at time.Time) (Version, error) {
+	if len(versions) == 0 {
+		return Version{}, ErrNoVersion
+	}
+	if at.IsZero() {
+		return versions[len(versions)-1], nil
+	}
+	for _, v := range versions {
+		if v.Time.After(at) {
+			return v, nil
+		}
+	}
+	return Version{}, ErrNoVersion
+}
+
+// Paths 列出 rel（文件或目录）之下留有版本的全部文件相对路径，按字典序。
+// 目录整棵被删时逐个留存了其下文件，取回目录即取回这些文件
+func Paths(root, rel string) ([]string, error) {
+	base := Dir(root)
+	start := versionDir(root, rel)
+	seen := map[string]bool{}
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == start {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if _, err := time.Parse(stampLayout, d.Name()); err != nil {
+			return nil
+		}
+		if r, err := filepath.Rel(base, filepath.Dir(path)); err == nil {
+			seen[r] = true
+		}
+		return nil
+	})
+	out := make([]string, 0, len(seen))
+	for r := range seen {
+		out = append(out, r)
+	}
+	sort.Strings(out)
+	return out, err
+}
+
+// Restore 把版本 v 复制到 dst（原子：同目录临时文件 + rename），mtime 还原为
+// 该版本自身的修改时间。dst 即 rel 在同步根内的原位时，先按 Preserve 留存
+// 被顶替的当前内容，取回操作本身也可撤销。版本文件保持不动
+func Restore(root, rel string, v Version, dst string, now time.Time, p Policy) error {
+	if filepath.Clean(dst) == filepath.Join(root, rel) {
+		if err := Preserve(root, rel, now, p); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".restore.tmp"
+	if err := copyFile(v.Path, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Prune 对整个版本库执行保留策略，返回删除的版本数。删空的版本目录一并移除
+func Prune(root string, p Policy, now time.Time) (int, error) {
+	if p.Keep == 0 && p.MaxAge == 0 {
+		return 0, nil
+	}
+	base := Dir(root)
+	removed := 0
+	var dirs []string
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == base {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, dir := range dirs {
+		n, err := pruneDir(dir, p, now)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	// 由深到浅删空目录（WalkDir 按字典序先父后子，逆序即先子后父）
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i] != base {
+			os.Remove(dirs[i]) // 非空时失败，正是期望的
+		}
+	}
+	return removed, nil
+}
+
+// prunePath 留存后就地执行一次该路径的保留策略
+func prunePath(dir string, p Policy, now time.Time) error {
+	_, err := pruneDir(dir, p, now)
+	return err
+}
+
+func pruneDir(dir string, p Policy, now time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var stamps []string
+	for _, e := range entries {
+		if _, err := time.Parse(stampLayout, e.Name()); err == nil && e.Type().IsRegular() {
+			stamps = append(stamps, e.Name())
+		}
+	}
+	sort.Strings(stamps) // 旧 → 新
+	removed := 0
+	for i, name := range stamps {
+		t, _ := time.Parse(stampLayout, name)
+		tooMany := p.Keep > 0 && len(stamps)-i > p.Keep
+		tooOld := p.MaxAge > 0 && now.Sub(t) > p.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
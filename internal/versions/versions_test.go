@@ -0,0 +1,154 @@
+package versions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestPreserveSurvivesOverwrite 覆盖走「写临时文件 + rename」，硬链接留存的旧 inode
+// 不受影响：每次覆盖都留下一份当时的内容，按时间排序
+func TestPreserveSurvivesOverwrite(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "docs", "a.txt")
+	base := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i, content := range []string{"v1", "v2", "v3"} {
+		writeFile(t, full+".tmp", content)
+		if i > 0 {
+			if err := Preserve(root, "docs/a.txt", base.Add(time.Duration(i)*time.Hour), Policy{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Rename(full+".tmp", full); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs, err := List(root, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 2 || readFile(t, vs[0].Path) != "v1" || readFile(t, vs[1].Path) != "v2" {
+		t.Fatalf("应按时间留存 v1、v2，实际 %+v", vs)
+	}
+	if err := Preserve(root, "docs/missing.txt", base, Policy{}); err != nil {
+		t.Errorf("不存在的文件应无事可做: %v", err)
+	}
+}
+
+// TestStashDirectoryAndRestore 删除整个目录时逐个移入其下文件；取回目录即取回
+// 这些文件，原位取回前被顶替的当前内容同样留存
+func TestStashDirectoryAndRestore(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "proj", "a.txt"), "A")
+	writeFile(t, filepath.Join(root, "proj", "sub", "b.txt"), "B")
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	if err := Stash(root, "proj", now, Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "proj", "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("stash 后原文件应已移走")
+	}
+	paths, err := Paths(root, "proj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join("proj", "a.txt"), filepath.Join("proj", "sub", "b.txt")}
+	if len(paths) != 2 || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("Paths = %v, want %v", paths, want)
+	}
+
+	// 上游又建了同名文件；取回旧版本时新内容先被留存
+	dst := filepath.Join(root, "proj", "a.txt")
+	writeFile(t, dst, "A-new")
+	vs, _ := List(root, want[0])
+	v, err := Pick(vs, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(root, want[0], v, dst, now.Add(time.Minute), Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	if readFile(t, dst) != "A" {
+		t.Fatal("取回内容不对")
+	}
+	if vs, _ := List(root, want[0]); len(vs) != 2 || readFile(t, vs[1].Path) != "A-new" {
+		t.Fatalf("被顶替的内容应留存为新版本，实际 %+v", vs)
+	}
+}
+
+// TestPickAt 版本时间戳是被替换的时刻：at 时刻在位的是时间戳晚于 at 的最早版本
+func TestPickAt(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	vs := []Version{{Path: "1", Time: t0.Add(time.Hour)}, {Path: "2", Time: t0.Add(3 * time.Hour)}}
+	cases := []struct {
+		at   time.Time
+		want string
+	}{
+		{time.Time{}, "2"},
+		{t0, "1"},
+		{t0.Add(2 * time.Hour), "2"},
+		{t0.Add(3 * time.Hour), ""}, // 之后没再变过，在位的就是现存文件
+	}
+	for _, c := range cases {
+		v, err := Pick(vs, c.at)
+		if c.want == "" {
+			if err != ErrNoVersion {
+				t.Errorf("at=%v 应无可取回版本，得到 %v", c.at, v.Path)
+			}
+			continue
+		}
+		if err != nil || v.Path != c.want {
+			t.Errorf("at=%v 选中 %q (%v)，期望 %q", c.at, v.Path, err, c.want)
+		}
+	}
+}
+
+// TestPruneByCountAndAge 每次留存就地按数量修剪；全库修剪再按时长清掉过期版本，
+// 删空的版本目录一并移除
+func TestPruneByCountAndAge(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "a.txt")
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := Policy{Keep: 2}
+	for i := 0; i < 4; i++ {
+		writeFile(t, full, string(rune('a'+i)))
+		if err := Stash(root, "a.txt", t0.Add(time.Duration(i)*time.Hour), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs, _ := List(root, "a.txt")
+	if len(vs) != 2 || readFile(t, vs[0].Path) != "c" {
+		t.Fatalf("应只留最新两个版本，实际 %+v", vs)
+	}
+
+	n, err := Prune(root, Policy{MaxAge: 24 * time.Hour}, t0.Add(48*time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("过期版本应全部清掉: n=%d err=%v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(Dir(root), "a.txt")); !os.IsNotExist(err) {
+		t.Error("删空的版本目录应被移除")
+	}
+	if n, err := Prune(t.TempDir(), p, t0); err != nil || n != 0 {
+		t.Errorf("没有版本库时应无事可做: n=%d err=%v", n, err)
+	}
+}
@@ -41,21 +41,22 @@ var (
 )
 
 // syncableEntry 判定一个磁盘条目是否会真正进入同步（即 eventFilter 对它不会丢弃）。
-// 判据与建树 / eventFilter 的接受逻辑一致：非忽略、非符号链接、目录或普通文件。
+// 判据与建树 / eventFilter 的接受逻辑一致：非忽略、目录、普通文件或目标在根内的符号链接。
 // 供冷目录轮询（hasDirectoryChanged）在置 changed=true 前预判——忽略项与特殊文件
 // 永不进 DB，若把它们也算作"变化"，tier2 退避会被永不消失的"新增"反复打回最短间隔
 // （PERF-03）。这里独立 Lstat，与 eventFilter 内的 Lstat 是两次调用但互不影响正确性。
 func syncableEntry(relPath, fullPath string) bool {
-	if utils.IsIgnored(relPath, config.IgnoreFileList) {
-		return false
-	}
 	linfo, err := os.Lstat(fullPath)
 	if err != nil {
 		return false
 	}
-	if linfo.Mode()&os.ModeSymlink != 0 {
+	if config.IsIgnored(relPath, linfo.IsDir()) {
 		return false
 	}
+	if linfo.Mode()&os.ModeSymlink != 0 {
+		_, err := tree.SymlinkTarget(relPath, fullPath)
+		return err == nil
+	}
 	if !linfo.IsDir() && !linfo.Mode().IsRegular() {
 		return false
 	}
@@ -64,9 +65,14 @@ func syncableEntry(relPath, fullPath string) bool {
 
 func eventFilter(event fsnotify.Event) {
 	relPath := utils.RelPath(config.StartPath, event.Name)
-	if utils.IsIgnored(relPath, config.IgnoreFileList) {
+	// 先按非目录判：只有 .gitignore 里以 / 结尾的规则在乎是不是目录，
+	// 放行的目录在拿到 Lstat 后再复核（见 Create/Chmod 分支），不为此多一次 stat
+	if config.IsIgnored(relPath, false) {
 		return
 	}
+	if filepath.Base(relPath) == ".gitignore" && OnGitignoreChange != nil {
+		OnGitignoreChange(filepath.Dir(relPath))
+	}
 	nodeDir := filepath.Dir(relPath)
 	fatherNode, err := tree.GetNodeByPath(nodeDir)
 	if err != nil {
@@ -90,8 +96,8 @@ func eventFilter(event fsnotify.Event) {
 		// 结束后 DeleteNodes 按路径查到的是重建后的新节点，会连同其子树
 		// 一起误删（对称于 Remove 分支撤销 pendingHashes）
 		cancelPendingDelete(relPath)
-		// 用 Lstat 而非 Stat：先判断是不是符号链接，若是则跳过不追踪
-		// （与 buildFileTree 一致，防止解引用泄露链接目标内容）
+		// 用 Lstat 而非 Stat：先判断是不是符号链接，绝不追踪。与 buildFileTree
+		// 一致，目标在根内的链接作为节点入树（走文件的防抖落库），其余跳过
 		linfo, err := os.Lstat(event.Name)
 		if err != nil {
 			// 事件到手时文件已消失是常态而非故障：编辑器的原子保存、git 的
@@ -105,7 +111,11 @@ func eventFilter(event fsnotify.Event) {
 			return
 		}
 		if linfo.Mode()&os.ModeSymlink != 0 {
-			log.Warnf("skipping symlink (not synced): %s", relPath)
+			if _, err := tree.SymlinkTarget(relPath, event.Name); err != nil {
+				log.Warnf("skipping symlink (not synced): %s: %v", relPath, err)
+				return
+			}
+			scheduleFileChange(event.Name)
 			return
 		}
 		// 与 buildFileTree 一致：socket / FIFO / 设备节点没有可复制的内容，
@@ -114,6 +124,9 @@ func eventFilter(event fsnotify.Event) {
 			log.Debugf("skipping non-regular file (not synced): %s", relPath)
 			return
 		}
+		if linfo.IsDir() && config.IsIgnored(relPath, true) {
+			return
+		}
 		if !linfo.IsDir() {
 			// 文件：防抖后在定时器 goroutine 里哈希落库，不阻塞事件主循环
 			scheduleFileChange(event.Name)
@@ -131,6 +144,7 @@ func eventFilter(event fsnotify.Event) {
 			Hash:     "",
 			Depth:    strings.Count(relPath, string(filepath.Separator)),
 		}
+		tree.FillMeta(newLeaf, event.Name, linfo, "")
 		if event.Has(fsnotify.Create) {
 			GlobalScoreWatch.addHeat(newLeaf.Path, newLeaf)
 		}
@@ -151,9 +165,6 @@ func eventFilter(event fsnotify.Event) {
 		// 从树里静默删掉（投产首日实锤：24 个跨构建同名产物全部丢树）。
 		// 存在即转为内容变更处理
 		if linfo, statErr := os.Lstat(event.Name); statErr == nil {
-			if linfo.Mode()&os.ModeSymlink != 0 {
-				return
-			}
 			if linfo.IsDir() {
 				scanNewDirContents(event.Name)
 			} else {
@@ -180,12 +191,20 @@ func eventFilter(event fsnotify.Event) {
 		eventMu.Unlock()
 		// 变更日志由 flushDeleteEvents 在删除落库后记录
 	case event.Has(fsnotify.Chmod):
-		// 权限/属性变化：文件可能此前因无读权限而哈希缺失（客户端确定性
-		// 跳过这类文件），chmod 修复后必须重算哈希同步才能自动恢复——
-		// 复用写事件的防抖流水线。内容未变时重算得到相同哈希，只是一次
-		// 幂等 upsert，代价可忽略；目录与符号链接的属性变化无需处理
+		// 权限/属性变化：元数据（权限位、属主、扩展属性）随节点同步，必须落库。
+		// 文件还可能此前因无读权限而哈希缺失（客户端确定性跳过这类文件），
+		// chmod 修复后必须重算哈希同步才能自动恢复——复用写事件的防抖流水线。
+		// 内容未变时重算得到相同哈希，只是一次幂等 upsert，代价可忽略；
+		// 目录只更新自身节点的元数据，不重扫内容
 		linfo, err := os.Lstat(event.Name)
-		if err != nil || linfo.IsDir() || linfo.Mode()&os.ModeSymlink != 0 {
+		if err != nil {
+			return
+		}
+		if linfo.IsDir() {
+			if config.IsIgnored(relPath, true) {
+				return
+			}
+			scheduleDirMetaChange(relPath, event.Name, fatherNode.ID, linfo)
 			return
 		}
 		scheduleFileChange(event.Name)
@@ -261,9 +280,19 @@ func finalizeFileChange(absPath string) {
 	if err != nil {
 		return // 防抖期间已删除，Remove 事件自会处理
 	}
-	if linfo.Mode()&os.ModeSymlink != 0 || linfo.IsDir() {
+	if linfo.IsDir() {
 		return
 	}
+	target := ""
+	if linfo.Mode()&os.ModeSymlink != 0 {
+		// 防抖期间普通文件可能被换成链接（或链接目标被改指根外）：现查现判
+		t, err := tree.SymlinkTarget(relPath, absPath)
+		if err != nil {
+			log.Warnf("skipping symlink (not synced): %s: %v", relPath, err)
+			return
+		}
+		target = t
+	}
 	fatherNode, err := tree.GetNodeByPath(filepath.Dir(relPath))
 	if err != nil {
 		// 与 eventFilter 同源的竞态：父目录节点缺失即重建缺失链。
@@ -273,7 +302,9 @@ func finalizeFileChange(absPath string) {
 		return
 	}
 	hash := ""
-	if h, hashErr := utils.CalcBlake3(absPath); hashErr != nil {
+	if target != "" {
+		// 链接没有内容可哈希，Hash 由 FillMeta 按目标填写
+	} else if h, hashErr := utils.CalcBlake3(absPath); hashErr != nil {
 		// 与 buildFileTree 语义一致：空哈希节点照常落库（客户端确定性跳过、
 		// 不误删镜像侧副本），登记进不可读列表由恢复循环定期探测
 		tree.MarkUnreadable(absPath)
@@ -294,6 +325,7 @@ func finalizeFileChange(absPath string) {
 		Hash:     hash,
 		Depth:    strings.Count(relPath, string(filepath.Separator)),
 	}
+	tree.FillMeta(newLeaf, absPath, linfo, target)
 
 	eventMu.Lock()
 	createEventCache = append(createEventCache, newLeaf)
@@ -306,6 +338,32 @@ func finalizeFileChange(absPath string) {
 	// 变更日志由 flushCreateEvents 在节点落库后记录（先记后写会丢变更）
 }
 
+// scheduleDirMetaChange 目录的权限/属性变化：按磁盘现状重建目录节点（复用 ID 由
+// AddNodes 的按路径更新保证）并并入创建批次落库，变更日志随批次记录
+func scheduleDirMetaChange(relPath, absPath, parentID string, linfo os.FileInfo) {
+	uuid, _ := utils.RandomString(16)
+	node := &tree.Node{
+		ID:       uuid,
+		Path:     relPath,
+		Name:     filepath.Base(absPath),
+		ParentID: parentID,
+		IsDir:    true,
+		Size:     uint64(linfo.Size()),
+		ModTime:  linfo.ModTime(),
+		Depth:    strings.Count(relPath, string(filepath.Separator)),
+	}
+	tree.FillMeta(node, absPath, linfo, "")
+
+	eventMu.Lock()
+	createEventCache = append(createEventCache, node)
+	if createTimerActive {
+		createTimer.Stop()
+	}
+	createTimer = time.AfterFunc(1*time.Second, flushCreateEvents)
+	createTimerActive = true
+	eventMu.Unlock()
+}
+
 // repairMissingChain 当事件的父目录节点不在树中时，从最近的已存在祖先
 // 开始按磁盘现状重建缺失的目录链：对链上第一个缺失的目录合成 Create
 // 事件，目录分支落库后会递归扫描其内容，缺失的整条链（含触发本次修复
@@ -367,6 +425,19 @@ func scanNewDirContents(fullPath string) {
 }
 
 func flushCreateEvents() {
+	// 移动（mv a b）= 旧路径 Remove + 新路径 Create。删除有 1 秒合并窗口，新目录却是
+	// 立即落库：若不先落删除，下游会先看到新路径、把内容全部下载一遍，稍后才看到
+	// 删除，汇端的跨目录移动检测配不上对。故先把未落库的删除落掉，两者进同一变更窗口
+	eventMu.Lock()
+	pendingDelete := deleteTimerActive
+	if pendingDelete {
+		deleteTimer.Stop()
+	}
+	eventMu.Unlock()
+	if pendingDelete {
+		flushDeleteEvents()
+	}
+
 	eventMu.Lock()
 	batch := createEventCache
 	createEventCache = nil
@@ -410,11 +481,17 @@ func flushDeleteEvents() {
 	for _, p := range batch {
 		abs := filepath.Join(config.StartPath, p)
 		linfo, err := os.Lstat(abs)
-		if err != nil || linfo.Mode()&os.ModeSymlink != 0 {
-			kept = append(kept, p) // 确实没了（或是不追踪的符号链接残留节点），照删
+		if err != nil {
+			kept = append(kept, p) // 确实没了，照删
 			continue
 		}
-		if linfo.IsDir() {
+		if linfo.Mode()&os.ModeSymlink != 0 {
+			if _, err := tree.SymlinkTarget(p, abs); err != nil {
+				kept = append(kept, p) // 指出根外、不追踪的链接：残留节点照删
+				continue
+			}
+			scheduleFileChange(abs)
+		} else if linfo.IsDir() {
 			scanNewDirContents(abs)
 		} else {
 			scheduleFileChange(abs)
@@ -0,0 +1,70 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"local-mirror/config"
+	"local-mirror/pkg/utils"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// OnGitignoreChange 某目录下的 .gitignore 出现事件时调用，relDir 为其所在目录
+// （--gitignore 时由 app 挂上）。在事件循环里同步调用，实现须立即返回
+var OnGitignoreChange func(relDir string)
+
+// ApplyIgnoreChange 忽略规则变化后校准监听与树。pruned 是已由 tree.PruneIgnored
+// 移出树的子树根，这里撤掉其下的监听；before 是变化前的规则，按它被忽略、
+// 按现行规则不再被忽略的路径从未进过树，也没人给它们发过事件，这里逐个合成
+// Create 事件补进来（目录走 eventFilter 的递归扫描，变更日志照常记录）。
+// 只在 walkRoot（同步根相对路径）下找这类路径，为空表示不可能有，不遍历磁盘
+func ApplyIgnoreChange(before config.IgnoreRules, pruned []string, walkRoot string) {
+	if GlobalScoreWatch != nil {
+		for _, p := range pruned {
+			GlobalScoreWatch.forgetSubtree(p)
+		}
+	}
+	if walkRoot == "" {
+		return
+	}
+
+	now := config.CurrentIgnores()
+	start := filepath.Join(config.StartPath, walkRoot)
+	revealed := 0
+	err := filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 读不了的目录跳过即可，与建树一致不让一处失败拖垮整次校准
+			if d != nil && d.IsDir() && path != start {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == config.StartPath {
+			return nil
+		}
+		rel := utils.RelPath(config.StartPath, path)
+		if now.Ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !before.Ignored(rel, d.IsDir()) {
+			return nil // 本就在树里，由事件与轮询维护
+		}
+		revealed++
+		eventFilter(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		if d.IsDir() {
+			return filepath.SkipDir // 子树由 eventFilter 的目录分支递归补齐
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("ignore reload: scanning for newly un-ignored paths failed: %v", err)
+	}
+	if revealed > 0 {
+		log.Infof("ignore reload: %d newly un-ignored path(s) added to the tree", revealed)
+	}
+}
@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"local-mirror/config"
+	"local-mirror/internal/tree"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// TestApplyIgnoreChange 忽略规则重载后：不再被忽略的子树从磁盘补进树（内容走
+// 常规的防抖落库），移出树的子树连同后代一起撤掉热度与监听
+func TestApplyIgnoreChange(t *testing.T) {
+	root := t.TempDir()
+	config.StartPath = root
+	for _, d := range []string{filepath.Join("src", "build"), filepath.Join("docs", "a")} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join("src", "build", "out.bin")
+	if err := os.WriteFile(filepath.Join(root, out), []byte("bin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config.IgnoreFileList = []string{".local-mirror", "build"}
+	before := config.CurrentIgnores()
+	tree.InitDB()
+	defer tree.DB.Close()
+	if err := tree.BuildFileTree(root); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	GlobalScoreWatch = &ScoreWatch{Watcher: w, heatMap: map[string]*HeatScore{
+		"src":                      {Path: "src"},
+		"docs":                     {Path: "docs"},
+		filepath.Join("docs", "a"): {Path: filepath.Join("docs", "a")},
+	}, tier1Limit: 100}
+	GlobalScoreWatch.tier1 = []*HeatScore{GlobalScoreWatch.heatMap["docs"]}
+	defer func() { GlobalScoreWatch = nil }()
+
+	config.IgnoreFileList = []string{".local-mirror"}
+	ApplyIgnoreChange(before, []string{"docs"}, ".")
+
+	if ok, _ := tree.HasPath(filepath.Join("src", "build")); !ok {
+		t.Fatal("不再被忽略的目录应立即补进树")
+	}
+	deadline := time.Now().Add(hashDebounce + 3*time.Second)
+	for {
+		if ok, _ := tree.HasPath(out); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("不再被忽略的目录里的文件应在防抖后落库")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, p := range []string{"docs", filepath.Join("docs", "a")} {
+		if _, ok := GlobalScoreWatch.heatMap[p]; ok {
+			t.Errorf("%s 的热度应已撤掉", p)
+		}
+	}
+	for _, h := range GlobalScoreWatch.tier1 {
+		if h.Path == "docs" {
+			t.Error("移出树的目录不应再占 tier1")
+		}
+	}
+	if _, ok := GlobalScoreWatch.heatMap["src"]; !ok {
+		t.Error("无关目录的热度不该受影响")
+	}
+}
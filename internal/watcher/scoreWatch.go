@@ -509,3 +509,41 @@ func (sw *ScoreWatch) removeHeat(path string) {
 		}
 	}
 }
+
+// forgetSubtree 撤掉 path 及其所有后代目录的热度与实时监听。
+// 用于忽略规则重载后被移出树、但磁盘上仍在的子树：它们不会再有删除事件替我们清理
+func (sw *ScoreWatch) forgetSubtree(path string) {
+	prefix := path + string(filepath.Separator)
+	under := func(p string) bool { return p == path || strings.HasPrefix(p, prefix) }
+
+	sw.mu.Lock()
+	var unwatch []string
+	for p := range sw.heatMap {
+		if under(p) {
+			delete(sw.heatMap, p)
+		}
+	}
+	kept := sw.tier1[:0]
+	for _, h := range sw.tier1 {
+		if under(h.Path) {
+			unwatch = append(unwatch, h.Path)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	sw.tier1 = kept
+	kept = sw.tier2[:0]
+	for _, h := range sw.tier2 {
+		if !under(h.Path) {
+			kept = append(kept, h)
+		}
+	}
+	sw.tier2 = kept
+	sw.mu.Unlock()
+
+	for _, p := range unwatch {
+		if err := sw.Watcher.Remove(filepath.Join(config.StartPath, p)); err != nil {
+			log.Debugf("Failed to remove path %s from watcher: %v", p, err)
+		}
+	}
+}
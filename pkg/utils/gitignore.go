@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// GitIgnore 按 .gitignore 语义判定路径是否被忽略（--gitignore）。
+// 各层目录的 .gitignore 在首次用到时读取并缓存，规则相对其所在目录；根目录另读
+// .git/info/exclude（优先级低于根 .gitignore）。同一路径依次过一遍从根到父目录的
+// 所有规则，以最后命中的一条为准，! 开头的规则即重新纳入。与 git 一致，父目录
+// 一旦被排除，其下条目无法再用 ! 找回。并发安全；零值不可用，用 NewGitIgnore 构造
+type GitIgnore struct {
+	root  string
+	mu    sync.Mutex
+	rules map[string][]gitRule // 目录相对路径（根为 "."）→ 该目录的规则，nil 表示没有
+}
+
+// gitRule 一条解析后的 .gitignore 规则
+type gitRule struct {
+	segs     []string // 按 / 切分的模式段，段内为 path.Match 语法
+	negate   bool     // ! 开头：命中即重新纳入
+	dirOnly  bool     // / 结尾：只匹配目录
+	anchored bool     // 开头或中间有 /：相对所在目录整条匹配；否则只比最后一段
+}
+
+// NewGitIgnore 以同步根 root 构造匹配器，不预读任何文件
+func NewGitIgnore(root string) *GitIgnore {
+	return &GitIgnore{root: root, rules: make(map[string][]gitRule)}
+}
+
+// Without 返回丢掉目录 dir（同步根相对路径）缓存规则的副本，下次用到时重读。
+// 原匹配器不变，调用方可拿新旧两个对比，找出 .gitignore 改动前后判定不同的路径
+func (g *GitIgnore) Without(dir string) *GitIgnore {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	next := &GitIgnore{root: g.root, rules: make(map[string][]gitRule, len(g.rules))}
+	for d, r := range g.rules {
+		if d != dir {
+			next.rules[d] = r
+		}
+	}
+	return next
+}
+
+// Ignored 判定同步根相对路径 rel 是否被忽略；isDir 决定以 / 结尾的规则是否适用
+func (g *GitIgnore) Ignored(rel string, isDir bool) bool {
+	segs := splitSegments(filepath.ToSlash(rel))
+	for i := 1; i < len(segs); i++ {
+		if g.verdict(segs[:i], true) {
+			return true // 父目录被排除，子项不可能再被纳入
+		}
+	}
+	return len(segs) > 0 && g.verdict(segs, isDir)
+}
+
+// verdict 只看路径自身（不管祖先）：从根到父目录逐层过规则，最后命中的一条说了算
+func (g *GitIgnore) verdict(segs []string, isDir bool) bool {
+	ignored := false
+	for depth := 0; depth < len(segs); depth++ {
+		dir := "."
+		if depth > 0 {
+			dir = strings.Join(segs[:depth], "/")
+		}
+		for _, r := range g.load(dir) {
+			if r.match(segs[depth:], isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func (g *GitIgnore) load(dir string) []gitRule {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if r, ok := g.rules[dir]; ok {
+		return r
+	}
+	var rules []gitRule
+	base := filepath.Join(g.root, filepath.FromSlash(dir))
+	if dir == "." {
+		if data, err := os.ReadFile(filepath.Join(base, ".git", "info", "exclude")); err == nil {
+			rules = parseGitIgnore(data)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(base, ".gitignore")); err == nil {
+		rules = append(rules, parseGitIgnore(data)...)
+	}
+	g.rules[dir] = rules
+	return rules
+}
+
+// parseGitIgnore 解析 .gitignore 内容。空行与 # 注释跳过，\# 与 \! 转义首字符，
+// 行尾未转义的空格去掉；非法模式（如未闭合的 "["）与 git 一样静默丢弃
+func parseGitIgnore(data []byte) []gitRule {
+	var rules []gitRule
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r gitRule
+		if rest, ok := strings.CutPrefix(line, "!"); ok {
+			r.negate, line = true, rest
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+		if rest, ok := strings.CutSuffix(line, "/"); ok {
+			r.dirOnly, line = true, rest
+		}
+		r.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		valid := true
+		for _, seg := range strings.Split(line, "/") {
+			if seg == "" {
+				continue // "a//b" 与 "a/b" 同义
+			}
+			// git 的字符类取反写 [!...]，path.Match 只认 [^...]
+			seg = strings.ReplaceAll(seg, "[!", "[^")
+			if _, err := path.Match(seg, ""); err != nil {
+				valid = false
+				break
+			}
+			r.segs = append(r.segs, seg)
+		}
+		if valid && len(r.segs) > 0 {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// match 规则对路径（相对规则所在目录，已切段）是否命中
+func (r gitRule) match(segs []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.segs[0], segs[len(segs)-1])
+		return ok
+	}
+	// 结尾的 /** 只匹配目录里面的东西，不含目录自身
+	if n := len(r.segs); r.segs[n-1] == "**" {
+		for k := 0; k < len(segs); k++ {
+			if matchSegments(r.segs[:n-1], segs[:k], false) {
+				return true
+			}
+		}
+		return false
+	}
+	return matchSegments(r.segs, segs, false)
+}
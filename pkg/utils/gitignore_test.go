@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeGitIgnore(t *testing.T, root, dir, content string) {
+	t.Helper()
+	d := filepath.Join(root, filepath.FromSlash(dir))
+	if err := os.MkdirAll(d, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d, ".gitignore"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeGitIgnore(t, root, ".", `# 注释
+/build
+logs/
+**/tmp/*.o
+*.log
+!keep.log
+docs/**
+\#hash
+trailing   
+[!a]x
+bad[
+`)
+	writeGitIgnore(t, root, "pkg", `gen/
+!/vendor.log
+out
+`)
+	g := NewGitIgnore(root)
+
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// /build 锚定在所在目录
+		{"build", true, true},
+		{"build/x.c", false, true},
+		{"src/build", true, false},
+		// logs/ 只匹配目录
+		{"logs", true, true},
+		{"logs", false, false},
+		{"a/logs/x", false, true},
+		// **/tmp/*.o 任意深度
+		{"tmp/a.o", false, true},
+		{"a/b/tmp/c.o", false, true},
+		{"a/tmp/sub/c.o", false, false},
+		// 取反：同一文件重新纳入
+		{"x.log", false, true},
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		// 结尾 /** 只管里面，不含目录自身
+		{"docs", true, false},
+		{"docs/a/b.md", false, true},
+		// 转义与行尾空格
+		{"#hash", false, true},
+		{"trailing", false, true},
+		// [!a] 字符类取反
+		{"bx", false, true},
+		{"ax", false, false},
+		// 非法模式被丢弃，不影响其余规则
+		{"bad[", false, false},
+		// 嵌套 .gitignore：相对所在目录，深层规则排在后面
+		{"pkg/gen", true, true},
+		{"gen", true, false},
+		{"pkg/vendor.log", false, false},
+		{"pkg/sub/vendor.log", false, true},
+		{"pkg/a/out", false, true},
+		{"src/main.go", false, false},
+	}
+	for _, c := range cases {
+		if got := g.Ignored(c.path, c.isDir); got != c.want {
+			t.Errorf("Ignored(%q, dir=%v) = %v, want %v", c.path, c.isDir, got, c.want)
+		}
+	}
+}
+
+// TestGitIgnoreParentExcluded 与 git 一致：父目录被排除后，子项无法再被 ! 纳入
+func TestGitIgnoreParentExcluded(t *testing.T) {
+	root := t.TempDir()
+	writeGitIgnore(t, root, ".", "cache/\n!cache/keep.txt\n")
+	g := NewGitIgnore(root)
+	if !g.Ignored("cache/keep.txt", false) {
+		t.Error("父目录被排除时 ! 不应重新纳入子项")
+	}
+
+	// 改用 cache/* 排除内容而非目录本身，取反才生效
+	writeGitIgnore(t, root, ".", "cache/*\n!cache/keep.txt\n")
+	g = g.Without(".")
+	if g.Ignored("cache/keep.txt", false) || !g.Ignored("cache/other.txt", false) {
+		t.Error("cache/* 之后的 !cache/keep.txt 应只纳入 keep.txt")
+	}
+}
+
+// TestGitIgnoreWithout Without 只让指定目录重读，原匹配器保持旧规则
+func TestGitIgnoreWithout(t *testing.T) {
+	root := t.TempDir()
+	writeGitIgnore(t, root, "a", "x\n")
+	old := NewGitIgnore(root)
+	if !old.Ignored("a/x", false) {
+		t.Fatal("a/x 应被 a/.gitignore 忽略")
+	}
+	writeGitIgnore(t, root, "a", "y\n")
+	if !old.Ignored("a/x", false) {
+		t.Error("原匹配器应沿用缓存的旧规则")
+	}
+	next := old.Without("a")
+	if next.Ignored("a/x", false) || !next.Ignored("a/y", false) {
+		t.Error("新匹配器应按改后的 a/.gitignore 判定")
+	}
+}
+
+// TestGitIgnoreInfoExclude 根目录还读 .git/info/exclude，优先级低于根 .gitignore
+func TestGitIgnoreInfoExclude(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, ".git", "info"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".git", "info", "exclude"), []byte("*.bak\nlocal.cfg\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeGitIgnore(t, root, ".", "!local.cfg\n")
+	g := NewGitIgnore(root)
+	if !g.Ignored("x.bak", false) {
+		t.Error("info/exclude 的规则应生效")
+	}
+	if g.Ignored("local.cfg", false) {
+		t.Error("根 .gitignore 应能覆盖 info/exclude")
+	}
+}
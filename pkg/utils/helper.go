@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -117,6 +118,80 @@ func IsIgnored(relPath string, patterns []string) bool {
 	return false
 }
 
+// IsIncluded 判断路径是否落在订阅范围（--include）内；patterns 为空即不限。
+// 与忽略列表不同，订阅模式锚定在同步根、按整条路径匹配：段内支持 * ? []，
+// 独占一段的 ** 匹配零到多段（"projects/foo/**"、"**/*.pdf"）。
+// 命中的路径连同其整棵子树都在范围内（"projects/foo" 等同 "projects/foo/**"）。
+// isDir 为真时另算"下钻才能到达命中项"的目录：docs 不匹配 "docs/*.pdf"，
+// 但必须进去才拿得到其中的 pdf。模式须已由 config.ParseIncludes 规整为 / 分隔
+func IsIncluded(relPath string, isDir bool, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	segs := splitSegments(filepath.ToSlash(relPath))
+	if len(segs) == 0 {
+		return true // 同步根恒在范围内
+	}
+	for _, p := range patterns {
+		pat := splitSegments(p)
+		if matchSegments(append(pat, "**"), segs, false) {
+			return true
+		}
+		if isDir && matchSegments(pat, segs, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxIncludeGlobstars 单个订阅模式里 ** 段的上限。每个 ** 都要尝试吞掉任意段数，
+// 匹配代价随其个数指数增长；正常订阅用到一两个，上限只挡构造出来的模式
+const MaxIncludeGlobstars = 4
+
+// IncludeGlobstars 模式里独占一段的 ** 个数
+func IncludeGlobstars(pattern string) int {
+	n := 0
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "**" {
+			n++
+		}
+	}
+	return n
+}
+
+func splitSegments(p string) []string {
+	var segs []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" && seg != "." {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// matchSegments 逐段匹配模式与路径。prefix 为真时路径先耗尽也算命中
+// （路径是某个命中项的祖先目录）
+func matchSegments(pat, segs []string, prefix bool) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pat[1:], segs[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return prefix
+		}
+		if ok, _ := path.Match(pat[0], segs[0]); !ok {
+			return false
+		}
+		pat, segs = pat[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
 func UniqueStrings(input []string) []string {
 	seen := make(map[string]struct{})
 	result := make([]string, 0, len(input))
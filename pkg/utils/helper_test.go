@@ -44,3 +44,53 @@ func TestIsIgnored(t *testing.T) {
 		t.Error("empty pattern list matched")
 	}
 }
+
+func TestIsIncluded(t *testing.T) {
+	patterns := []string{"projects/foo/**", "docs/*.pdf", "photos/2024"}
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// 同步根与空订阅
+		{".", true, true},
+		// ** 覆盖整棵子树，也含目录自身
+		{"projects/foo", true, true},
+		{"projects/foo/a/b.go", false, true},
+		{"projects/foobar/x", false, false},
+		{"projects/bar/x", false, false},
+		// 锚定在根：docs/*.pdf 不命中更深的 pdf
+		{"docs/a.pdf", false, true},
+		{"docs/old/a.pdf", false, false},
+		{"docs/a.txt", false, false},
+		{"x/docs/a.pdf", false, false},
+		// 命中项的祖先目录要下钻，同名文件不算
+		{"projects", true, true},
+		{"docs", true, true},
+		{"docs", false, false},
+		{"music", true, false},
+		// 不带 ** 的目录模式同样带上子树
+		{"photos/2024/jan/1.jpg", false, true},
+		{"photos/2023/1.jpg", false, false},
+	}
+	for _, c := range cases {
+		if got := IsIncluded(c.path, c.isDir, patterns); got != c.want {
+			t.Errorf("IsIncluded(%q, dir=%v) = %v, want %v", c.path, c.isDir, got, c.want)
+		}
+	}
+
+	// 开头的 ** 匹配任意深度，目录一律可能通向命中项
+	anywhere := []string{"**/notes.md"}
+	for path, want := range map[string]bool{"notes.md": true, "a/b/notes.md": true, "a/b/other.md": false} {
+		if got := IsIncluded(path, false, anywhere); got != want {
+			t.Errorf("IsIncluded(%q) with **/notes.md = %v, want %v", path, got, want)
+		}
+	}
+	if !IsIncluded("a/b", true, anywhere) {
+		t.Error("with a leading ** every directory may lead to a match")
+	}
+
+	if !IsIncluded("anything/at/all", false, nil) {
+		t.Error("no patterns means everything is included")
+	}
+}